@@ -41,11 +41,18 @@ const (
 
 const LUA_MINSTACK = 20
 const LUAI_MAXSTACK = 1000000
+const LUAI_MAXCALLS = 200000 // 调用栈的最大深度, 避免耗尽Go的栈
 const LUA_REGISTRYINDEX = -LUAI_MAXSTACK - 1000
 const LUA_RIDX_MAINTHREAD = 1
 const LUA_RIDX_GLOBALS int64 = 2
 const LUA_MULTRET = -1
 
+/* predefined references */
+const (
+	LUA_NOREF  = -2
+	LUA_REFNIL = -1
+)
+
 const (
 	LUA_MAXINTEGER = 1<<63 - 1
 	LUA_MININTEGER = -1 << 63
@@ -62,3 +69,35 @@ const (
 	LUA_ERRERR
 	LUA_ERRFILE
 )
+
+/* Event codes */
+const (
+	LUA_HOOKCALL = iota
+	LUA_HOOKRET
+	LUA_HOOKLINE
+	LUA_HOOKCOUNT
+	LUA_HOOKTAILCALL
+)
+
+/* Event masks */
+const (
+	LUA_MASKCALL  = 1 << LUA_HOOKCALL
+	LUA_MASKRET   = 1 << LUA_HOOKRET
+	LUA_MASKLINE  = 1 << LUA_HOOKLINE
+	LUA_MASKCOUNT = 1 << LUA_HOOKCOUNT
+)
+
+/* garbage-collection options */
+const (
+	LUA_GCSTOP       = 0
+	LUA_GCRESTART    = 1
+	LUA_GCCOLLECT    = 2
+	LUA_GCCOUNT      = 3
+	LUA_GCCOUNTB     = 4
+	LUA_GCSTEP       = 5
+	LUA_GCSETPAUSE   = 6
+	LUA_GCSETSTEPMUL = 7
+	LUA_GCISRUNNING  = 9
+	LUA_GCGEN        = 10
+	LUA_GCINC        = 11
+)
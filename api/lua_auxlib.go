@@ -7,6 +7,7 @@ type AuxLib interface {
 	/* Error-report functions */
 	Error2(fmt string, a ...interface{}) int
 	ArgError(arg int, extraMsg string) int
+	Where(level int)
 	/* Argument check functions */
 	CheckStack2(sz int, msg string)
 	ArgCheck(cond bool, arg int, extraMsg string)
@@ -18,6 +19,9 @@ type AuxLib interface {
 	OptInteger(arg int, d int64) int64
 	OptNumber(arg int, d float64) float64
 	OptString(arg int, d string) string
+	CheckOption(arg int, def string, lst []string) int
+	CheckUdata(arg int, tname string) interface{}
+	TestUdata(arg int, tname string) interface{}
 	/* Load functions */
 	DoFile(filename string) bool
 	DoString(str string) bool
@@ -31,9 +35,31 @@ type AuxLib interface {
 	GetSubTable(idx int, fname string) bool
 	GetMetafield(obj int, e string) LuaType
 	CallMeta(obj int, e string) bool
+	FileResult(err error, fname string) int
+	ExecResult(err error) int
+	NewMetatable(tname string) bool
+	GetMetatableByName(tname string) LuaType
+	SetMetatableByName(tname string)
+	Traceback(l1 LuaState, msg string, level int)
+	Ref(t int) int
+	Unref(t, ref int)
 	OpenLibs()
+	OpenLibsWithOptions(opts LibOptions)
+	NewSandbox(opts LibOptions)
 	RequireF(modname string, openf GoFunction, glb bool)
 	NewLib(l FuncReg)
 	NewLibTable(l FuncReg)
 	SetFuncs(l FuncReg, nup int)
 }
+
+// OpenLibsWithOptions和NewSandbox的选项
+type LibOptions struct {
+	// 要打开的库和每个库中允许的函数, 函数列表为nil时允许库中的所有函数.
+	// 基础库的名字是"_G", require属于"package"库. Libs为nil时打开所有的库
+	Libs map[string][]string
+	// load, loadfile和dofile只能加载文本代码块
+	TextOnly bool
+	// require能加载的模块: 模块名 -> 源代码. 不为nil时代替按package.path搜索文件,
+	// 沙箱中总是只能加载这些模块
+	Modules map[string]string
+}
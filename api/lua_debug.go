@@ -0,0 +1,25 @@
+package api
+
+// 活动记录, 由GetStack和GetInfo填充
+// http://www.lua.org/manual/5.3/manual.html#lua_Debug
+type LuaDebug struct {
+	Event           int
+	Name            string /* (n) */
+	NameWhat        string /* (n) 'global', 'local', 'field', 'method' */
+	What            string /* (S) 'Lua', 'C', 'main', 'tail' */
+	Source          string /* (S) */
+	CurrentLine     int    /* (l) */
+	LineDefined     int    /* (S) */
+	LastLineDefined int    /* (S) */
+	NUps            int    /* (u) number of upvalues */
+	NParams         int    /* (u) number of parameters */
+	IsVararg        bool   /* (u) */
+	IsTailCall      bool   /* (t) */
+	ShortSrc        string /* (S) */
+	/* private part */
+	CallInfo interface{} /* active function */
+}
+
+// 钩子函数, 在事件发生时调用
+// http://www.lua.org/manual/5.3/manual.html#lua_Hook
+type LuaHook func(ls LuaState, ar *LuaDebug)
@@ -1,5 +1,7 @@
 package api
 
+import "context"
+
 type LuaType = int
 type ArithOp = int
 type CompareOp = int
@@ -41,12 +43,16 @@ type BasicAPI interface {
 	ToStringX(idx int) (string, bool)
 	ToThread(idx int) LuaState
 	ToPointer(idx int) interface{}
+	IsUserdata(idx int) bool
+	IsLightUserdata(idx int) bool
+	ToUserdata(idx int) interface{}
 	/* push functions (Go -> stack) */
 	PushNil()
 	PushBoolean(b bool)
 	PushInteger(n int64)
 	PushNumber(n float64)
 	PushString(s string)
+	PushLightUserdata(p interface{})
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
 	Concat(n int)
@@ -57,12 +63,16 @@ type BasicAPI interface {
 	GetTable(idx int) LuaType
 	GetField(idx int, k string) LuaType
 	GetI(idx int, i int64) LuaType
+	GetUserValue(idx int) LuaType
 	/* set functions (stack -> Lua) */
 	SetTable(idx int)
 	SetField(idx int, k string)
 	SetI(idx int, i int64)
+	SetUserValue(idx int)
 	Load(chunk []byte, chunkName, mode string) int
+	Dump(strip bool) []byte
 	Call(nArgs, nResults int)
+	CallK(nArgs, nResults int, ctx KContext, k KFunction)
 	PushGoFunction(f GoFunction)
 	IsGoFunction(idx int) bool
 	ToGoFunction(idx int) GoFunction
@@ -81,16 +91,42 @@ type BasicAPI interface {
 	RawGetI(idx int, i int64) LuaType
 	RawSetI(idx int, i int64)
 	Next(idx int) bool
+	ToClose(idx int)
+	CloseSlot(idx int)
 	Error() int
 	PCall(nArgs, nResults, msgh int) int
+	PCallK(nArgs, nResults, msgh int, ctx KContext, k KFunction) int
 	StringToNumber(s string) bool
 	IsFunction(idx int) bool
 	NewThread() LuaState
 	Resume(from LuaState, nArgs int) int
 	Yield(nResults int) int
+	YieldK(nResults int, ctx KContext, k KFunction) int
 	Status() int
 	IsYieldable() bool
-	GetStack() bool // debug
+	CloseThread(from LuaState) int
+	/* debug API */
+	GetStack(level int, ar *LuaDebug) bool
+	GetInfo(what string, ar *LuaDebug) bool
+	GetLocal(ar *LuaDebug, n int) string
+	SetLocal(ar *LuaDebug, n int) string
+	GetUpvalue(funcIdx, n int) (string, bool)
+	SetUpvalue(funcIdx, n int) (string, bool)
+	UpvalueId(funcIdx, n int) interface{}
+	UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int)
+	SetHook(f LuaHook, mask, count int)
+	GetHook() LuaHook
+	GetHookMask() int
+	GetHookCount() int
+	NewUserdata(data interface{})
+	GC(what, data int) int
+	Close()
+	/* execution limits */
+	SetLimits(limits Limits)
+	GetLimits() Limits
+	CheckMemory(size int64)
+	SetContext(ctx context.Context)
+	Context() context.Context
 }
 
 type LuaState interface {
@@ -99,3 +135,21 @@ type LuaState interface {
 }
 
 type GoFunction func(LuaState) int
+
+// 延续函数, Go函数调用的Lua代码让出后恢复执行时代替原来的Go函数继续执行
+// http://www.lua.org/manual/5.3/manual.html#lua_KFunction
+type KFunction func(ls LuaState, status int, ctx KContext) int
+
+// http://www.lua.org/manual/5.3/manual.html#lua_KContext
+type KContext int
+
+// 状态的执行限制, 所有线程共享, 字段为0时表示不限制
+// 超出限制时抛出可以被pcall捕获的错误, 超出内存限制时状态码为LUA_ERRMEM.
+// 不设置MaxMemory时只有超过1GB的单次分配会被拒绝, 脚本仍然可以通过许多较小的分配
+// 耗尽进程的内存, 这时Go运行时会直接结束进程; 执行不可信的脚本时必须设置MaxMemory
+type Limits struct {
+	MaxInstructions int64 // 最多执行的虚拟机指令数
+	MaxCallDepth    int   // 调用栈的最大深度(包括恢复当前协程的线程), 不会超过LUAI_MAXCALLS
+	MaxStackSlots   int   // 每个线程的所有调用帧最多占用的栈槽数
+	MaxMemory       int64 // 表和字符串大约能占用的字节数
+}
@@ -11,4 +11,5 @@ type LuaVM interface {
 	LoadVararg(n int)
 	LoadProto(idx int)
 	CloseUpvalues(a int)
+	TailCall(nArgs int) bool // 尾调用, 被调函数是Go函数时返回false
 }
@@ -76,6 +76,15 @@ func Undump(data []byte) *Prototype {
 	return reader.readProto("") // 读取函数原型
 }
 
+// 把函数原型序列化为和luac一致的二进制chunk, strip为true时去掉调试信息
+func Dump(proto *Prototype, strip bool) []byte {
+	writer := &writer{strip: strip}
+	writer.writeHeader()                        // 写入头部
+	writer.writeByte(byte(len(proto.Upvalues))) // 主函数Upvalue数量
+	writer.writeProto(proto, "")                // 写入函数原型
+	return writer.buf
+}
+
 func IsBinaryChunk(data []byte) bool {
 	return len(data) > 4 && string(data[:4]) == LUA_SIGNATURE
 }
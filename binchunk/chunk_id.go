@@ -0,0 +1,40 @@
+package binchunk
+
+import "strings"
+
+const LUA_IDSIZE = 60 // 源文件描述的最大长度
+
+// 把源文件名转换成适合在错误信息中显示的形式
+// lua-5.3.4/src/lobject.c#luaO_chunkid()
+func ChunkID(source string) string {
+	if source == "" {
+		return "?"
+	}
+
+	switch source[0] {
+	case '=': // 'literal' source
+		if len(source) <= LUA_IDSIZE {
+			return source[1:]
+		}
+		return source[1:LUA_IDSIZE] // truncate it
+	case '@': // file name
+		if len(source) <= LUA_IDSIZE {
+			return source[1:]
+		}
+		return "..." + source[len(source)-(LUA_IDSIZE-4):] // add '...' before rest of name
+	default: // string; format as [string "source"]
+		const PRE, POS, RETS = `[string "`, `"]`, "..."
+		bufflen := LUA_IDSIZE - len(PRE+RETS+POS) - 1 // save space for prefix+suffix+'\0'
+		nl := strings.IndexByte(source, '\n')
+		if len(source) < bufflen && nl < 0 { // small one-line source?
+			return PRE + source + POS
+		}
+		if nl >= 0 {
+			source = source[:nl] // stop at first newline
+		}
+		if len(source) > bufflen {
+			source = source[:bufflen]
+		}
+		return PRE + source + RETS + POS
+	}
+}
@@ -0,0 +1,167 @@
+package binchunk
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+const LUAI_MAXSHORTLEN = 40 // 短字符串的最大长度
+
+type writer struct {
+	buf   []byte
+	strip bool // 是否去掉调试信息
+}
+
+func (w *writer) writeByte(b byte) {
+	w.buf = append(w.buf, b)
+}
+
+func (w *writer) writeBytes(bytes []byte) {
+	w.buf = append(w.buf, bytes...)
+}
+
+func (w *writer) writeUint32(i uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, i)
+}
+
+func (w *writer) writeUint64(i uint64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, i)
+}
+
+func (w *writer) writeLuaInteger(i int64) {
+	w.writeUint64(uint64(i))
+}
+
+func (w *writer) writeLuaNumber(f float64) {
+	w.writeUint64(math.Float64bits(f))
+}
+
+func (w *writer) writeString(s string) {
+	size := uint64(len(s)) + 1 // 长度包含结尾的'\0'
+	if size < 0xFF {
+		w.writeByte(byte(size))
+	} else {
+		w.writeByte(0xFF)
+		w.writeUint64(size)
+	}
+	w.writeBytes([]byte(s))
+}
+
+func (w *writer) writeNilString() {
+	w.writeByte(0)
+}
+
+func (w *writer) writeHeader() {
+	w.writeBytes([]byte(LUA_SIGNATURE))
+	w.writeByte(LUAC_VERSION)
+	w.writeByte(LUAC_FORMAT)
+	w.writeBytes([]byte(LUAC_DATA))
+	w.writeByte(CINT_SIZE)
+	w.writeByte(CSIZET_SIZE)
+	w.writeByte(INSTRUCTION_SIZE)
+	w.writeByte(LUA_INTEGER_SIZE)
+	w.writeByte(LUA_NUMBER_SIZE)
+	w.writeLuaInteger(LUAC_INT)
+	w.writeLuaNumber(LUAC_NUM)
+}
+
+func (w *writer) writeProto(proto *Prototype, parentSource string) {
+	// 和父函数相同的源文件名不再重复写入
+	if w.strip || proto.Source == parentSource {
+		w.writeNilString()
+	} else {
+		w.writeString(proto.Source)
+	}
+	w.writeUint32(proto.LineDefined)
+	w.writeUint32(proto.LastLineDefined)
+	w.writeByte(proto.NumParams)
+	w.writeByte(proto.IsVararg)
+	w.writeByte(proto.MaxStackSize)
+	w.writeCode(proto.Code)
+	w.writeConstants(proto.Constants)
+	w.writeUpvalues(proto.Upvalues)
+	w.writeProtos(proto.Protos, proto.Source)
+	w.writeDebug(proto)
+}
+
+func (w *writer) writeCode(code []uint32) {
+	w.writeUint32(uint32(len(code)))
+	for _, inst := range code {
+		w.writeUint32(inst)
+	}
+}
+
+func (w *writer) writeConstants(constants []interface{}) {
+	w.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		w.writeConstant(k)
+	}
+}
+
+func (w *writer) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		w.writeByte(TAG_NIL)
+	case bool:
+		w.writeByte(TAG_BOOLEAN)
+		if x {
+			w.writeByte(1)
+		} else {
+			w.writeByte(0)
+		}
+	case int64:
+		w.writeByte(TAG_INTEGER)
+		w.writeLuaInteger(x)
+	case float64:
+		w.writeByte(TAG_NUMBER)
+		w.writeLuaNumber(x)
+	case string:
+		if len(x) <= LUAI_MAXSHORTLEN {
+			w.writeByte(TAG_SHORT_STR)
+		} else {
+			w.writeByte(TAG_LONG_STR)
+		}
+		w.writeString(x)
+	default:
+		panic("unreachable!")
+	}
+}
+
+func (w *writer) writeUpvalues(upvalues []Upvalue) {
+	w.writeUint32(uint32(len(upvalues)))
+	for _, upval := range upvalues {
+		w.writeByte(upval.Instack)
+		w.writeByte(upval.Idx)
+	}
+}
+
+func (w *writer) writeProtos(protos []*Prototype, parentSource string) {
+	w.writeUint32(uint32(len(protos)))
+	for _, proto := range protos {
+		w.writeProto(proto, parentSource)
+	}
+}
+
+func (w *writer) writeDebug(proto *Prototype) {
+	if w.strip {
+		w.writeUint32(0) // lineinfo
+		w.writeUint32(0) // locvars
+		w.writeUint32(0) // upvalue names
+		return
+	}
+
+	w.writeUint32(uint32(len(proto.LineInfo)))
+	for _, line := range proto.LineInfo {
+		w.writeUint32(line)
+	}
+	w.writeUint32(uint32(len(proto.LocVars)))
+	for _, locVar := range proto.LocVars {
+		w.writeString(locVar.VarName)
+		w.writeUint32(locVar.StartPC)
+		w.writeUint32(locVar.EndPC)
+	}
+	w.writeUint32(uint32(len(proto.UpvalueNames)))
+	for _, name := range proto.UpvalueNames {
+		w.writeString(name)
+	}
+}
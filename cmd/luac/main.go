@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"lua_go/binchunk"
+	"lua_go/compiler"
+	"os"
+	"strings"
+)
+
+const (
+	PROGNAME  = "luac"     // default program name
+	OUTPUT    = "luac.out" // default output file
+	COPYRIGHT = "Lua 5.3.4  Copyright (C) 1994-2017 Lua.org, PUC-Rio"
+)
+
+var (
+	listing   = 0     // list bytecodes?
+	dumping   = true  // dump bytecodes?
+	stripping = false // strip debug information?
+	output    = OUTPUT
+	progname  = PROGNAME
+)
+
+func main() {
+	files := doArgs(os.Args)
+	if len(files) == 0 {
+		usage("no input files given")
+	}
+
+	protos := make([]*binchunk.Prototype, len(files))
+	for i, file := range files {
+		protos[i] = load(file)
+	}
+	f := combine(protos)
+
+	if listing > 0 {
+		printFunction(f, listing > 1)
+	}
+	if dumping {
+		data := binchunk.Dump(f, stripping)
+		if output == "-" {
+			if _, err := os.Stdout.Write(data); err != nil {
+				fatal("cannot write to stdout")
+			}
+		} else if err := os.WriteFile(output, data, 0644); err != nil {
+			fatal(fmt.Sprintf("cannot open %s", output))
+		}
+	}
+}
+
+// 解析命令行选项, 返回输入文件列表
+// lua-5.3.4/src/luac.c#doargs()
+func doArgs(args []string) []string {
+	version := 0
+	if len(args) > 0 && args[0] != "" {
+		progname = args[0]
+	}
+
+	i := 1
+	for ; i < len(args); i++ {
+		arg := args[i]
+		if arg == "" || arg[0] != '-' { // end of options; keep it
+			break
+		} else if arg == "--" { // end of options; skip it
+			i++
+			if version > 0 {
+				version++
+			}
+			break
+		} else if arg == "-" { // end of options; use stdin
+			break
+		} else if arg == "-l" { // list
+			listing++
+		} else if arg == "-o" { // output file
+			i++
+			if i >= len(args) || args[i] == "" ||
+				(args[i][0] == '-' && args[i] != "-") {
+				usage("'-o' needs argument")
+			}
+			output = args[i]
+		} else if arg == "-p" { // parse only
+			dumping = false
+		} else if arg == "-s" { // strip debug information
+			stripping = true
+		} else if arg == "-v" { // show version
+			version++
+		} else { // unknown option
+			usage(arg)
+		}
+	}
+
+	files := args[i:]
+	if len(files) == 0 && (listing > 0 || !dumping) {
+		dumping = false
+		files = []string{OUTPUT}
+	}
+	if version > 0 {
+		fmt.Println(COPYRIGHT)
+		if version == len(args)-1 {
+			os.Exit(0)
+		}
+	}
+	return files
+}
+
+func usage(message string) {
+	if message[0] == '-' {
+		fmt.Fprintf(os.Stderr, "%s: unrecognized option '%s'\n", progname, message)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", progname, message)
+	}
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [filenames]
+Available options are:
+  -l       list (use -l -l for full listing)
+  -o name  output to file 'name' (default is "%s")
+  -p       parse only
+  -s       strip debug information
+  -v       show version information
+  --       stop handling options
+  -        stop handling options and process stdin
+`, progname, OUTPUT)
+	os.Exit(1)
+}
+
+func fatal(message string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progname, message)
+	os.Exit(1)
+}
+
+// 加载源文件或预编译的chunk, 出错时直接退出
+func load(file string) (proto *binchunk.Prototype) {
+	var data []byte
+	var err error
+	chunkName := "@" + file
+	if file == "-" {
+		chunkName = "=stdin"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		fatal(fmt.Sprintf("cannot open %s", file))
+	}
+
+	if binchunk.IsBinaryChunk(data) {
+		defer func() {
+			if r := recover(); r != nil {
+				fatal(fmt.Sprint(r))
+			}
+		}()
+		return binchunk.Undump(data)
+	}
+	proto, err = compiler.Compile(string(skipComment(data)), chunkName)
+	if err != nil {
+		fatal(err.Error())
+	}
+	return proto
+}
+
+// 和luaL_loadfile一样跳过以'#'开头的第一行, 保留换行以免行号错位
+func skipComment(data []byte) []byte {
+	if len(data) > 0 && data[0] == '#' {
+		for i, b := range data {
+			if b == '\n' {
+				return data[i:]
+			}
+		}
+		return nil
+	}
+	return data
+}
+
+// 多个文件时生成一个依次调用各个文件主函数的主函数
+// lua-5.3.4/src/luac.c#combine()
+func combine(protos []*binchunk.Prototype) *binchunk.Prototype {
+	if len(protos) == 1 {
+		return protos[0]
+	}
+
+	src := strings.Repeat("(function()end)();\n", len(protos))
+	f, _ := compiler.Compile(src, "=("+PROGNAME+")")
+	for i, proto := range protos {
+		f.Protos[i] = proto
+		if len(proto.Upvalues) > 0 {
+			proto.Upvalues[0].Instack = 0
+		}
+	}
+	f.LineInfo = nil
+	return f
+}
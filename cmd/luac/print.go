@@ -0,0 +1,271 @@
+package main
+
+import (
+	"fmt"
+	"lua_go/binchunk"
+	"lua_go/vm"
+	"strconv"
+	"strings"
+)
+
+// lua-5.3.4/src/luac.c#PrintFunction()
+func printFunction(f *binchunk.Prototype, full bool) {
+	printHeader(f)
+	printCode(f)
+	if full {
+		printDebug(f)
+	}
+	for _, p := range f.Protos {
+		printFunction(p, full)
+	}
+}
+
+func printHeader(f *binchunk.Prototype) {
+	funcType := "main"
+	if f.LineDefined > 0 {
+		funcType = "function"
+	}
+
+	varargFlag := ""
+	if f.IsVararg > 0 {
+		varargFlag = "+"
+	}
+
+	fmt.Printf("\n%s <%s:%d,%d> (%d instruction%s at %p)\n",
+		funcType, sourceName(f.Source), f.LineDefined, f.LastLineDefined,
+		len(f.Code), s(len(f.Code)), f)
+	fmt.Printf("%d%s param%s, %d slot%s, %d upvalue%s, ",
+		f.NumParams, varargFlag, s(int(f.NumParams)),
+		f.MaxStackSize, s(int(f.MaxStackSize)),
+		len(f.Upvalues), s(len(f.Upvalues)))
+	fmt.Printf("%d local%s, %d constant%s, %d function%s\n",
+		len(f.LocVars), s(len(f.LocVars)),
+		len(f.Constants), s(len(f.Constants)),
+		len(f.Protos), s(len(f.Protos)))
+}
+
+func sourceName(source string) string {
+	if source == "" {
+		return "?" // "=?"
+	}
+	switch source[0] {
+	case '@', '=':
+		return source[1:]
+	case binchunk.LUA_SIGNATURE[0]:
+		return "(bstring)"
+	default:
+		return "(string)"
+	}
+}
+
+func s(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func printCode(f *binchunk.Prototype) {
+	for pc := 0; pc < len(f.Code); pc++ {
+		i := vm.Instruction(f.Code[pc])
+		line := "-"
+		if len(f.LineInfo) > 0 {
+			line = strconv.Itoa(int(f.LineInfo[pc]))
+		}
+		fmt.Printf("\t%d\t[%s]\t%-9s\t", pc+1, line, i.OpName())
+		printOperands(i)
+		pc = printComment(f, pc, i)
+		fmt.Println()
+	}
+}
+
+// lua-5.3.4/src/lopcodes.h#ISK()
+func isK(x int) bool {
+	return x&0x100 != 0
+}
+
+func myK(x int) int {
+	return -1 - x
+}
+
+func printOperands(i vm.Instruction) {
+	switch i.OpMode() {
+	case vm.IABC:
+		a, b, c := i.ABC()
+		fmt.Printf("%d", a)
+		if i.BMode() != vm.OpArgN {
+			if isK(b) {
+				fmt.Printf(" %d", myK(b&0xFF))
+			} else {
+				fmt.Printf(" %d", b)
+			}
+		}
+		if i.CMode() != vm.OpArgN {
+			if isK(c) {
+				fmt.Printf(" %d", myK(c&0xFF))
+			} else {
+				fmt.Printf(" %d", c)
+			}
+		}
+	case vm.IABx:
+		a, bx := i.ABx()
+		fmt.Printf("%d", a)
+		if i.BMode() == vm.OpArgK {
+			fmt.Printf(" %d", myK(bx))
+		} else if i.BMode() == vm.OpArgU {
+			fmt.Printf(" %d", bx)
+		}
+	case vm.IAsBx:
+		a, sbx := i.AsBx()
+		fmt.Printf("%d %d", a, sbx)
+	case vm.IAx:
+		fmt.Printf("%d", myK(i.Ax()))
+	}
+}
+
+// 打印指令后面的注释, 返回(可能跳过EXTRAARG后的)pc
+func printComment(f *binchunk.Prototype, pc int, i vm.Instruction) int {
+	a, b, c := i.ABC()
+	_, bx := i.ABx()
+	_, sbx := i.AsBx()
+
+	switch i.Opcode() {
+	case vm.OP_LOADK:
+		fmt.Printf("\t; %s", constantToString(f.Constants[bx]))
+	case vm.OP_GETUPVAL, vm.OP_SETUPVAL:
+		fmt.Printf("\t; %s", upvalName(f, b))
+	case vm.OP_GETTABUP:
+		fmt.Printf("\t; %s", upvalName(f, b))
+		if isK(c) {
+			fmt.Printf(" %s", constantToString(f.Constants[c&0xFF]))
+		}
+	case vm.OP_SETTABUP:
+		fmt.Printf("\t; %s", upvalName(f, a))
+		if isK(b) {
+			fmt.Printf(" %s", constantToString(f.Constants[b&0xFF]))
+		}
+		if isK(c) {
+			fmt.Printf(" %s", constantToString(f.Constants[c&0xFF]))
+		}
+	case vm.OP_GETTABLE, vm.OP_SELF:
+		if isK(c) {
+			fmt.Printf("\t; %s", constantToString(f.Constants[c&0xFF]))
+		}
+	case vm.OP_SETTABLE, vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD,
+		vm.OP_POW, vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND, vm.OP_BOR,
+		vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR, vm.OP_EQ, vm.OP_LT, vm.OP_LE:
+		if isK(b) || isK(c) {
+			fmt.Printf("\t; ")
+			if isK(b) {
+				fmt.Print(constantToString(f.Constants[b&0xFF]))
+			} else {
+				fmt.Print("-")
+			}
+			fmt.Print(" ")
+			if isK(c) {
+				fmt.Print(constantToString(f.Constants[c&0xFF]))
+			} else {
+				fmt.Print("-")
+			}
+		}
+	case vm.OP_JMP, vm.OP_FORLOOP, vm.OP_FORPREP, vm.OP_TFORLOOP:
+		fmt.Printf("\t; to %d", sbx+pc+2)
+	case vm.OP_CLOSURE:
+		fmt.Printf("\t; %p", f.Protos[bx])
+	case vm.OP_SETLIST:
+		if c == 0 {
+			pc++
+			fmt.Printf("\t; %d", f.Code[pc])
+		} else {
+			fmt.Printf("\t; %d", c)
+		}
+	case vm.OP_EXTRAARG:
+		fmt.Printf("\t; %s", constantToString(f.Constants[i.Ax()]))
+	}
+	return pc
+}
+
+func upvalName(f *binchunk.Prototype, idx int) string {
+	if idx < len(f.UpvalueNames) && f.UpvalueNames[idx] != "" {
+		return f.UpvalueNames[idx]
+	}
+	return "-"
+}
+
+// lua-5.3.4/src/luac.c#PrintConstant()
+func constantToString(k interface{}) string {
+	switch x := k.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(x)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		str := fmt.Sprintf("%.14g", x)
+		if strings.Trim(str, "-0123456789") == "" {
+			str += ".0" // 看起来像整数
+		}
+		return str
+	case string:
+		return quoteString(x)
+	default:
+		return "?"
+	}
+}
+
+// lua-5.3.4/src/luac.c#PrintString()
+func quoteString(str string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(str); i++ {
+		switch c := str[i]; c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\v':
+			sb.WriteString(`\v`)
+		default:
+			if c >= 0x20 && c < 0x7F {
+				sb.WriteByte(c)
+			} else {
+				fmt.Fprintf(&sb, `\%03d`, c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// lua-5.3.4/src/luac.c#PrintDebug()
+func printDebug(f *binchunk.Prototype) {
+	fmt.Printf("constants (%d) for %p:\n", len(f.Constants), f)
+	for i, k := range f.Constants {
+		fmt.Printf("\t%d\t%s\n", i+1, constantToString(k))
+	}
+
+	fmt.Printf("locals (%d) for %p:\n", len(f.LocVars), f)
+	for i, locVar := range f.LocVars {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n",
+			i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
+	}
+
+	fmt.Printf("upvalues (%d) for %p:\n", len(f.Upvalues), f)
+	for i, upval := range f.Upvalues {
+		fmt.Printf("\t%d\t%s\t%d\t%d\n",
+			i, upvalName(f, i), upval.Instack, upval.Idx)
+	}
+}
@@ -9,10 +9,12 @@ type BreakStat struct { // break
 }
 
 type LabelStat struct { // `::` Name `::`
+	Line int
 	Name string
 }
 
 type GotoStat struct { // goto Name
+	Line int
 	Name string
 }
 
@@ -48,16 +50,18 @@ type ForNumStat struct {
 }
 
 type ForInStat struct {
-	LineOfDo int
-	NameList []string
-	ExpList  []Exp
-	Block    *Block
+	LineOfFor int
+	LineOfDo  int
+	NameList  []string
+	ExpList   []Exp
+	Block     *Block
 }
 
 type LocalVarDeclStat struct {
-	LastLine int
-	NameList []string
-	ExpList  []Exp
+	LastLine   int
+	NameList   []string
+	AttribList []string // 和NameList一一对应, "const", "close"或""; 都没有属性时为nil
+	ExpList    []Exp
 }
 
 type AssignStat struct {
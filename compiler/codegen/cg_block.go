@@ -3,21 +3,50 @@ package codegen
 import "lua_go/compiler/ast"
 
 func cgBlock(fi *funcInfo, node *ast.Block) {
-	for _, stat := range node.Stats {
-		cgStat(fi, stat)
+	cgBlockStats(fi, node, true)
+}
+
+// closable: 块的结尾即作用域的结尾(repeat块之后还有until表达式)
+func cgBlockStats(fi *funcInfo, node *ast.Block, closable bool) {
+	for i, stat := range node.Stats {
+		if label, ok := stat.(*ast.LabelStat); ok {
+			atBlockEnd := closable && node.RetExps == nil &&
+				onlyLabelsFollow(node.Stats[i+1:])
+			cgLabelStat(fi, label, atBlockEnd)
+		} else {
+			cgStat(fi, stat)
+		}
 	}
 
 	if node.RetExps != nil {
-		cgRetStat(fi, node.RetExps)
+		cgRetStat(fi, node.RetExps, node.LastLine)
+	}
+}
+
+func onlyLabelsFollow(stats []ast.Stat) bool {
+	for _, stat := range stats {
+		if _, ok := stat.(*ast.LabelStat); !ok {
+			return false
+		}
 	}
+	return true
 }
 
-func cgRetStat(fi *funcInfo, exps []ast.Exp) {
+func cgRetStat(fi *funcInfo, exps []ast.Exp, lastLine int) {
 	nExps := len(exps)
 	if nExps == 0 {
-		fi.emitReturn(0, 0)
+		fi.emitReturn(lastLine, 0, 0)
 		return
 	}
+	if nExps == 1 && !fi.hasToCloseVars() { // 返回前要关闭<close>变量, 不能是尾调用
+		if call, ok := exps[0].(*ast.FuncCallExp); ok { // return f(args) 是尾调用
+			r := fi.allocReg()
+			cgTailCallExp(fi, call, r)
+			fi.freeReg()
+			fi.emitReturn(lastLine, r, -1)
+			return
+		}
+	}
 	multRet := isVarargOrFuncCall(exps[nExps-1])
 	for i, exp := range exps {
 		r := fi.allocReg()
@@ -30,9 +59,9 @@ func cgRetStat(fi *funcInfo, exps []ast.Exp) {
 	fi.freeRegs(nExps)
 	a := fi.usedRegs
 	if multRet {
-		fi.emitReturn(a, -1)
+		fi.emitReturn(lastLine, a, -1)
 	} else {
-		fi.emitReturn(a, nExps)
+		fi.emitReturn(lastLine, a, nExps)
 	}
 }
 
@@ -19,17 +19,17 @@ const (
 func cgExp(fi *funcInfo, node ast.Exp, a, n int) {
 	switch exp := node.(type) {
 	case *ast.NilExp:
-		fi.emitLoadNil(a, n)
+		fi.emitLoadNil(exp.Line, a, n)
 	case *ast.FalseExp:
-		fi.emitLoadBool(a, 0, 0)
+		fi.emitLoadBool(exp.Line, a, 0, 0)
 	case *ast.TrueExp:
-		fi.emitLoadBool(a, 1, 0)
+		fi.emitLoadBool(exp.Line, a, 1, 0)
 	case *ast.IntegerExp:
-		fi.emitLoadK(a, exp.Val)
+		fi.emitLoadK(exp.Line, a, exp.Val)
 	case *ast.FloatExp:
-		fi.emitLoadK(a, exp.Val)
+		fi.emitLoadK(exp.Line, a, exp.Val)
 	case *ast.StringExp:
-		fi.emitLoadK(a, exp.Str)
+		fi.emitLoadK(exp.Line, a, exp.Str)
 	case *ast.ParensExp:
 		cgExp(fi, exp.Exp, a, 1)
 	case *ast.VarargExp:
@@ -55,9 +55,10 @@ func cgExp(fi *funcInfo, node ast.Exp, a, n int) {
 
 func cgVarargExp(fi *funcInfo, node *ast.VarargExp, a, n int) {
 	if !fi.isVararg {
-		panic("cannot use '...' outside a vararg function")
+		panic(&lexer.SyntaxError{Line: node.Line, Token: "...",
+			Msg: "cannot use '...' outside a vararg function"})
 	}
-	fi.emitVararg(a, n)
+	fi.emitVararg(node.Line, a, n)
 }
 
 func cgFuncDefExp(fi *funcInfo, node *ast.FuncDefExp, a int) {
@@ -65,14 +66,14 @@ func cgFuncDefExp(fi *funcInfo, node *ast.FuncDefExp, a int) {
 	fi.subFuncs = append(fi.subFuncs, subFI)
 
 	for _, param := range node.ParList {
-		subFI.addLocVar(param)
+		subFI.addLocVar(param, 0)
 	}
 	cgBlock(subFI, node.Block)
+	subFI.emitReturn(node.LastLine, 0, 0)
 	subFI.exitScope()
-	subFI.emitReturn(0, 0)
 
 	bx := len(fi.subFuncs) - 1
-	fi.emitClosure(a, bx)
+	fi.emitClosure(node.LastLine, a, bx)
 }
 
 func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
@@ -86,7 +87,7 @@ func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
 	multRet := nExps > 0 &&
 		isVarargOrFuncCall(node.ValExps[nExps-1])
 
-	fi.emitNewTable(a, nArr, nExps-nArr)
+	fi.emitNewTable(node.Line, a, nArr, nExps-nArr)
 
 	arrIdx := 0
 	for i, keyExp := range node.KeyExps {
@@ -109,9 +110,9 @@ func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
 				fi.freeRegs(n)
 				c := (arrIdx-1)/50 + 1 // todo: c > 0xFF
 				if i == nExps-1 && multRet {
-					fi.emitSetList(a, 0, c)
+					fi.emitSetList(node.LastLine, a, 0, c)
 				} else {
-					fi.emitSetList(a, n, c)
+					fi.emitSetList(lastLineOf(valExp), a, n, c)
 				}
 			}
 
@@ -124,7 +125,7 @@ func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
 		cgExp(fi, valExp, c, 1)
 		fi.freeRegs(2)
 
-		fi.emitSetTable(a, b, c)
+		fi.emitSetTable(lastLineOf(valExp), a, b, c)
 	}
 }
 
@@ -132,7 +133,7 @@ func cgTableConstructorExp(fi *funcInfo, node *ast.TableConstructorExp, a int) {
 func cgUnopExp(fi *funcInfo, node *ast.UnopExp, a int) {
 	oldRegs := fi.usedRegs
 	b, _ := expToOpArg(fi, node.Exp, ARG_REG)
-	fi.emitUnaryOp(node.Op, a, b)
+	fi.emitUnaryOp(node.Line, node.Op, a, b)
 	fi.usedRegs = oldRegs
 }
 
@@ -145,7 +146,7 @@ func cgConcatExp(fi *funcInfo, node *ast.ConcatExp, a int) {
 	c := fi.usedRegs - 1
 	b := c - len(node.Exps) + 1
 	fi.freeRegs(c - b + 1)
-	fi.emitABC(vm.OP_CONCAT, a, b, c)
+	fi.emitABC(node.Line, vm.OP_CONCAT, a, b, c)
 }
 
 // r[a] := exp1 op exp2
@@ -157,34 +158,35 @@ func cgBinopExp(fi *funcInfo, node *ast.BinopExp, a int) {
 		b, _ := expToOpArg(fi, node.Exp1, ARG_REG)
 		fi.usedRegs = oldRegs
 		if node.Op == lexer.TOKEN_OP_AND {
-			fi.emitTestSet(a, b, 0)
+			fi.emitTestSet(node.Line, a, b, 0)
 		} else {
-			fi.emitTestSet(a, b, 1)
+			fi.emitTestSet(node.Line, a, b, 1)
 		}
-		pcOfJmp := fi.emitJmp(0, 0)
+		pcOfJmp := fi.emitJmp(node.Line, 0, 0)
 
 		b, _ = expToOpArg(fi, node.Exp2, ARG_REG)
 		fi.usedRegs = oldRegs
-		fi.emitMove(a, b)
+		fi.emitMove(node.Line, a, b)
 		fi.fixSbx(pcOfJmp, fi.pc()-pcOfJmp)
 	default:
 		oldRegs := fi.usedRegs
 		b, _ := expToOpArg(fi, node.Exp1, ARG_RK)
 		c, _ := expToOpArg(fi, node.Exp2, ARG_RK)
-		fi.emitBinaryOp(node.Op, a, b, c)
+		fi.emitBinaryOp(node.Line, node.Op, a, b, c)
 		fi.usedRegs = oldRegs
 	}
 }
 
 func cgNameExp(fi *funcInfo, node *ast.NameExp, a int) {
 	if r := fi.slotOfLocVar(node.Name); r >= 0 {
-		fi.emitMove(a, r)
+		fi.emitMove(node.Line, a, r)
 	} else if idx := fi.indexOfUpval(node.Name); idx >= 0 {
-		fi.emitGetUpval(a, idx)
+		fi.emitGetUpval(node.Line, a, idx)
 	} else { // x => _ENV["x"]
 		taExp := &ast.TableAccessExp{
-			PrefixExp: &ast.NameExp{Line: 0, Name: "_ENV"},
-			KeyExp:    &ast.StringExp{Line: 0, Str: node.Name},
+			LastLine:  node.Line,
+			PrefixExp: &ast.NameExp{Line: node.Line, Name: "_ENV"},
+			KeyExp:    &ast.StringExp{Line: node.Line, Str: node.Name},
 		}
 
 		cgTableAccessExp(fi, taExp, a)
@@ -199,15 +201,20 @@ func cgTableAccessExp(fi *funcInfo, node *ast.TableAccessExp, a int) {
 	fi.usedRegs = oldRegs
 
 	if kindB == ARG_UPVAL {
-		fi.emitGetTabUp(a, b, c)
+		fi.emitGetTabUp(node.LastLine, a, b, c)
 	} else {
-		fi.emitGetTable(a, b, c)
+		fi.emitGetTable(node.LastLine, a, b, c)
 	}
 }
 
 func cgFuncCallExp(fi *funcInfo, node *ast.FuncCallExp, a, n int) {
 	nArgs := prepFuncCall(fi, node, a)
-	fi.emitCall(a, nArgs, n)
+	fi.emitCall(node.Line, a, nArgs, n)
+}
+
+func cgTailCallExp(fi *funcInfo, node *ast.FuncCallExp, a int) {
+	nArgs := prepFuncCall(fi, node, a)
+	fi.emitTailCall(node.Line, a, nArgs)
 }
 
 func prepFuncCall(fi *funcInfo, node *ast.FuncCallExp, a int) int {
@@ -218,7 +225,7 @@ func prepFuncCall(fi *funcInfo, node *ast.FuncCallExp, a int) int {
 	if node.NameExp != nil {
 		fi.allocReg()
 		c, k := expToOpArg(fi, node.NameExp, ARG_RK)
-		fi.emitSelf(a, a, c)
+		fi.emitSelf(node.Line, a, a, c)
 		if k == ARG_REG {
 			fi.freeRegs(1)
 		}
@@ -235,6 +242,7 @@ func prepFuncCall(fi *funcInfo, node *ast.FuncCallExp, a int) int {
 	fi.freeRegs(nArgs)
 
 	if node.NameExp != nil {
+		fi.freeReg() // self
 		nArgs++
 	}
 	if lastArgIsVarargOrFuncCall {
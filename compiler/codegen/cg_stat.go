@@ -26,13 +26,13 @@ func cgStat(fi *funcInfo, node ast.Stat) {
 		cgLocalVarDeclStat(fi, stat)
 	case *ast.LocalFuncDefStat:
 		cgLocalFuncDefStat(fi, stat)
-	case *ast.LabelStat, *ast.GotoStat:
-		panic("label and goto statements are not supported!")
+	case *ast.GotoStat:
+		cgGotoStat(fi, stat)
 	}
 }
 
 func cgLocalFuncDefStat(fi *funcInfo, node *ast.LocalFuncDefStat) {
-	r := fi.addLocVar(node.Name)
+	r := fi.addLocVar(node.Name, fi.pc()+2)
 	cgFuncDefExp(fi, node.Exp, r)
 }
 
@@ -43,21 +43,35 @@ func cgFuncCallStat(fi *funcInfo, node *ast.FuncCallStat) {
 }
 
 func cgBreakStat(fi *funcInfo, node *ast.BreakStat) {
-	pc := fi.emitJmp(0, 0)
-	fi.addBreakJmp(pc)
+	pc := fi.emitJmp(node.Line, 0, 0)
+	fi.addBreakJmp(pc, node.Line)
+}
+
+func cgGotoStat(fi *funcInfo, node *ast.GotoStat) {
+	pc := fi.emitJmp(node.Line, 0, 0)
+	fi.addGoto(node.Name, node.Line, pc)
+}
+
+// atBlockEnd: 标签之后只有空语句, 此时认为块中的局部变量已经离开作用域
+func cgLabelStat(fi *funcInfo, node *ast.LabelStat, atBlockEnd bool) {
+	nActVars := fi.usedRegs
+	if atBlockEnd {
+		nActVars = fi.blocks[fi.scopeLv].nActVars
+	}
+	fi.addLabel(node.Name, node.Line, nActVars)
 }
 
 func cgDoStat(fi *funcInfo, node *ast.DoStat) {
 	fi.enterScope(false) // 非循环块
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
+	fi.closeOpenUpvals(node.Block.LastLine)
 	fi.exitScope()
 }
 
-func (fi *funcInfo) closeOpenUpvals() {
+func (fi *funcInfo) closeOpenUpvals(line int) {
 	a := fi.getJmpArgA()
 	if a > 0 {
-		fi.emitJmp(a, 0)
+		fi.emitJmp(line, a, 0)
 	}
 }
 
@@ -67,7 +81,7 @@ func (fi *funcInfo) getJmpArgA() int {
 	for _, locVar := range fi.locNames {
 		if locVar.scopeLv == fi.scopeLv {
 			for v := locVar; v != nil && v.scopeLv == fi.scopeLv; v = v.prev {
-				if v.captured {
+				if v.captured || v.toClose {
 					hasCapturedLocVars = true
 				}
 				if v.slot < minSlotOfLocVars && v.name[0] != '(' {
@@ -99,13 +113,14 @@ func cgWhileStat(fi *funcInfo, node *ast.WhileStat) {
 	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
 	fi.usedRegs = oldRegs
 
-	fi.emitTest(a, 0)
-	pcJmpToEnd := fi.emitJmp(0, 0)
+	line := lastLineOf(node.Exp)
+	fi.emitTest(line, a, 0)
+	pcJmpToEnd := fi.emitJmp(line, 0, 0)
 
 	fi.enterScope(true)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
-	fi.emitJmp(0, pcBeforeExp-fi.pc()-1)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.emitJmp(node.Block.LastLine, 0, pcBeforeExp-fi.pc()-1)
 	fi.exitScope()
 
 	fi.fixSbx(pcJmpToEnd, fi.pc()-pcJmpToEnd)
@@ -121,15 +136,16 @@ func cgRepeatStat(fi *funcInfo, node *ast.RepeatStat) {
 	fi.enterScope(true)
 
 	pcBeforeBlock := fi.pc()
-	cgBlock(fi, node.Block)
+	cgBlockStats(fi, node.Block, false)
 
 	oldRegs := fi.usedRegs
 	a, _ := expToOpArg(fi, node.Exp, ARG_REG)
 	fi.usedRegs = oldRegs
 
-	fi.emitTest(a, 0)
-	fi.emitJmp(fi.getJmpArgA(), pcBeforeBlock-fi.pc()-1)
-	fi.closeOpenUpvals()
+	line := lastLineOf(node.Exp)
+	fi.emitTest(line, a, 0)
+	fi.emitJmp(line, fi.getJmpArgA(), pcBeforeBlock-fi.pc()-1)
+	fi.closeOpenUpvals(line)
 
 	fi.exitScope()
 }
@@ -156,16 +172,17 @@ func cgIfStat(fi *funcInfo, node *ast.IfStat) {
 		a, _ := expToOpArg(fi, exp, ARG_REG)
 		fi.usedRegs = oldRegs
 
-		fi.emitTest(a, 0)
-		pcJmpToNextExp = fi.emitJmp(0, 0)
+		line := lastLineOf(exp)
+		fi.emitTest(line, a, 0)
+		pcJmpToNextExp = fi.emitJmp(line, 0, 0)
 
 		block := node.Blocks[i]
 		fi.enterScope(false)
 		cgBlock(fi, block)
-		fi.closeOpenUpvals()
+		fi.closeOpenUpvals(block.LastLine)
 		fi.exitScope()
 		if i < len(node.Exps)-1 {
-			pcJmpToEnds[i] = fi.emitJmp(0, 0)
+			pcJmpToEnds[i] = fi.emitJmp(block.LastLine, 0, 0)
 		} else {
 			pcJmpToEnds[i] = pcJmpToNextExp
 		}
@@ -180,16 +197,22 @@ func cgForNumStat(fi *funcInfo, node *ast.ForNumStat) {
 	fi.enterScope(true)
 
 	cgLocalVarDeclStat(fi, &ast.LocalVarDeclStat{
+		LastLine: node.LineOfDo,
 		NameList: []string{"(for index)", "(for limit)", "(for step)"},
 		ExpList:  []ast.Exp{node.InitExp, node.LimitExp, node.StepExp},
 	})
-	fi.addLocVar(node.VarName)
 
-	a := fi.usedRegs - 4
-	pcForPrep := fi.emitForPrep(a, 0)
+	a := fi.usedRegs - 3
+	pcForPrep := fi.emitForPrep(node.LineOfDo, a, 0)
+
+	// 循环变量和循环体在内层作用域中
+	fi.enterScope(false)
+	fi.addLocVar(node.VarName, fi.pc()+1)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
-	pcForLoop := fi.emitForLoop(a, 0)
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.exitScope()
+
+	pcForLoop := fi.emitForLoop(node.LineOfFor, a, 0)
 
 	fi.fixSbx(pcForPrep, pcForLoop-pcForPrep-1)
 	fi.fixSbx(pcForLoop, pcForPrep-pcForLoop)
@@ -201,22 +224,26 @@ func cgForInStat(fi *funcInfo, node *ast.ForInStat) {
 	fi.enterScope(true)
 
 	cgLocalVarDeclStat(fi, &ast.LocalVarDeclStat{
+		LastLine: node.LineOfDo,
 		NameList: []string{"(for generator)", "(for state)", "(for control)"},
 		ExpList:  node.ExpList,
 	})
 
+	pcJmpToTFC := fi.emitJmp(node.LineOfDo, 0, 0)
+
+	// 循环变量和循环体在内层作用域中
+	fi.enterScope(false)
 	for _, name := range node.NameList {
-		fi.addLocVar(name)
+		fi.addLocVar(name, fi.pc()+1)
 	}
-
-	pcJmpToTFC := fi.emitJmp(0, 0)
 	cgBlock(fi, node.Block)
-	fi.closeOpenUpvals()
+	fi.closeOpenUpvals(node.Block.LastLine)
+	fi.exitScope()
 	fi.fixSbx(pcJmpToTFC, fi.pc()-pcJmpToTFC)
 
 	rGenerator := fi.slotOfLocVar("(for generator)")
-	fi.emitTForCall(rGenerator, len(node.NameList))
-	fi.emitTForLoop(rGenerator+2, pcJmpToTFC-fi.pc()-1)
+	fi.emitTForCall(node.LineOfFor, rGenerator, len(node.NameList))
+	fi.emitTForLoop(node.LineOfFor, rGenerator+2, pcJmpToTFC-fi.pc()-1)
 
 	fi.exitScope()
 }
@@ -256,12 +283,17 @@ func cgLocalVarDeclStat(fi *funcInfo, node *ast.LocalVarDeclStat) {
 		if !multRet {
 			n := nNames - nExps
 			a := fi.allocRegs(n)
-			fi.emitLoadNil(a, n)
+			fi.emitLoadNil(node.LastLine, a, n)
 		}
 	}
 	fi.usedRegs = oldRegs
-	for _, name := range node.NameList {
-		fi.addLocVar(name)
+	startPC := fi.pc() + 1
+	for i, name := range node.NameList {
+		slot := fi.addLocVar(name, startPC)
+		if node.AttribList != nil && node.AttribList[i] == "close" {
+			fi.locNames[name].toClose = true
+			fi.emitTBC(node.LastLine, slot)
+		}
 	}
 }
 
@@ -313,24 +345,25 @@ func cgAssignStat(fi *funcInfo, node *ast.AssignStat) {
 		if !multRet {
 			n := nVars - nExps
 			a := fi.allocRegs(n)
-			fi.emitLoadNil(a, n)
+			fi.emitLoadNil(node.LastLine, a, n)
 		}
 	}
 
+	lastLine := node.LastLine
 	for i, exp := range node.VarList {
 		if nameExp, ok := exp.(*ast.NameExp); ok {
 			varName := nameExp.Name
 			if a := fi.slotOfLocVar(varName); a >= 0 {
-				fi.emitMove(a, vRegs[i])
+				fi.emitMove(lastLine, a, vRegs[i])
 			} else if b := fi.indexOfUpval(varName); b >= 0 {
-				fi.emitSetUpval(vRegs[i], b)
+				fi.emitSetUpval(lastLine, vRegs[i], b)
 			} else { // global var
 				a := fi.indexOfUpval("_ENV")
 				b := 0x100 + fi.indexOfConstant(varName)
-				fi.emitSetTabUp(a, b, vRegs[i])
+				fi.emitSetTabUp(lastLine, a, b, vRegs[i])
 			}
 		} else {
-			fi.emitSetTable(tRegs[i], kRegs[i], vRegs[i])
+			fi.emitSetTable(lastLine, tRegs[i], kRegs[i], vRegs[i])
 		}
 	}
 	fi.usedRegs = oldRegs
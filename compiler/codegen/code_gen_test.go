@@ -0,0 +1,88 @@
+package codegen
+
+import (
+	"lua_go/binchunk"
+	"lua_go/compiler/parser"
+	"lua_go/vm"
+	"reflect"
+	"testing"
+)
+
+func TestLineInfo(t *testing.T) {
+	chunk := `local a = 1
+for i = 1, 2 do
+  a = a + i
+end
+return a`
+	proto := GenProto(parser.Parse(chunk, "test"))
+
+	expectedLines := []uint32{1, 2, 2, 2, 2, 3, 3, 2, 5, 5, 5}
+	if !reflect.DeepEqual(proto.LineInfo, expectedLines) {
+		t.Fatalf("expected line info %v got %v", expectedLines, proto.LineInfo)
+	}
+
+	expectedLocVars := []binchunk.LocVar{
+		{VarName: "a", StartPC: 1, EndPC: 11},
+		{VarName: "(for index)", StartPC: 4, EndPC: 8},
+		{VarName: "(for limit)", StartPC: 4, EndPC: 8},
+		{VarName: "(for step)", StartPC: 4, EndPC: 8},
+		{VarName: "i", StartPC: 5, EndPC: 7},
+	}
+	if !reflect.DeepEqual(proto.LocVars, expectedLocVars) {
+		t.Fatalf("expected locals %v got %v", expectedLocVars, proto.LocVars)
+	}
+}
+
+func TestLineDefined(t *testing.T) {
+	chunk := `local x = 1
+local function f()
+  return x
+end
+print(f())`
+	proto := GenProto(parser.Parse(chunk, "test"))
+	if proto.LineDefined != 0 || proto.LastLineDefined != 0 {
+		t.Fatalf("main function: got %d-%d", proto.LineDefined, proto.LastLineDefined)
+	}
+
+	f := proto.Protos[0]
+	if f.LineDefined != 2 || f.LastLineDefined != 4 {
+		t.Fatalf("expected 2-4 got %d-%d", f.LineDefined, f.LastLineDefined)
+	}
+	if !reflect.DeepEqual(f.UpvalueNames, []string{"x"}) {
+		t.Fatalf("expected upvalue names [x] got %v", f.UpvalueNames)
+	}
+	if !reflect.DeepEqual(proto.UpvalueNames, []string{"_ENV"}) {
+		t.Fatalf("expected upvalue names [_ENV] got %v", proto.UpvalueNames)
+	}
+}
+
+// 方法调用之后self占用的寄存器要释放, 否则(o:m())的结果会从错误的寄存器返回
+func TestMethodCallFreesSelf(t *testing.T) {
+	proto := GenProto(parser.Parse("local o = ... return (o:m())", "test"))
+
+	call, ret := vm.Instruction(proto.Code[3]), vm.Instruction(proto.Code[4])
+	if call.Opcode() != vm.OP_CALL || ret.Opcode() != vm.OP_RETURN {
+		t.Fatalf("expected CALL and RETURN got %s and %s", call.OpName(), ret.OpName())
+	}
+	callA, _, _ := call.ABC()
+	retA, retB, _ := ret.ABC()
+	if retA != callA || retB != 2 {
+		t.Fatalf("expected RETURN %d 2 got RETURN %d %d", callA, retA, retB)
+	}
+}
+
+func TestConstFolding(t *testing.T) {
+	chunk := `local N <const> = 10
+local S <const> = "x"
+local function f() return N * 2 + 1, S end
+return f`
+	proto := GenProto(parser.Parse(chunk, "test"))
+
+	f := proto.Protos[0]
+	if !reflect.DeepEqual(f.Constants, []interface{}{int64(21), "x"}) {
+		t.Fatalf("expected constants [21 x] got %v", f.Constants)
+	}
+	if len(f.Upvalues) != 0 {
+		t.Fatalf("expected no upvalues got %v", f.UpvalueNames)
+	}
+}
@@ -0,0 +1,67 @@
+package codegen
+
+import "lua_go/compiler/ast"
+
+// 表达式的起始行号
+func lineOf(exp ast.Exp) int {
+	switch x := exp.(type) {
+	case *ast.NilExp:
+		return x.Line
+	case *ast.TrueExp:
+		return x.Line
+	case *ast.FalseExp:
+		return x.Line
+	case *ast.IntegerExp:
+		return x.Line
+	case *ast.FloatExp:
+		return x.Line
+	case *ast.StringExp:
+		return x.Line
+	case *ast.VarargExp:
+		return x.Line
+	case *ast.NameExp:
+		return x.Line
+	case *ast.FuncDefExp:
+		return x.Line
+	case *ast.FuncCallExp:
+		return x.Line
+	case *ast.TableConstructorExp:
+		return x.Line
+	case *ast.ParensExp:
+		return lineOf(x.Exp)
+	case *ast.TableAccessExp:
+		return lineOf(x.PrefixExp)
+	case *ast.ConcatExp:
+		return lineOf(x.Exps[0])
+	case *ast.BinopExp:
+		return lineOf(x.Exp1)
+	case *ast.UnopExp:
+		return x.Line
+	default:
+		panic("unreachable!")
+	}
+}
+
+// 表达式的结束行号
+func lastLineOf(exp ast.Exp) int {
+	switch x := exp.(type) {
+	case *ast.FuncDefExp:
+		return x.LastLine
+	case *ast.FuncCallExp:
+		return x.LastLine
+	case *ast.TableConstructorExp:
+		return x.LastLine
+	case *ast.TableAccessExp:
+		return x.LastLine
+	case *ast.ParensExp:
+		return lastLineOf(x.Exp)
+	case *ast.ConcatExp:
+		return lastLineOf(x.Exps[len(x.Exps)-1])
+	case *ast.BinopExp:
+		return lastLineOf(x.Exp2)
+	case *ast.UnopExp:
+		return lastLineOf(x.Exp)
+	default:
+		return lineOf(exp)
+	}
+}
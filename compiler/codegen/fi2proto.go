@@ -4,17 +4,25 @@ import "lua_go/binchunk"
 
 func toProto(fi *funcInfo) *binchunk.Prototype {
 	proto := &binchunk.Prototype{
-		NumParams:    byte(fi.numParams),
-		MaxStackSize: byte(fi.maxRegs),
-		Code:         fi.insts,
-		Constants:    getConstants(fi),
-		Upvalues:     getUpvalues(fi),
-		Protos:       toProtos(fi.subFuncs),
-		LineInfo:     []uint32{},
-		LocVars:      []binchunk.LocVar{},
-		UpvalueNames: []string{},
+		LineDefined:     uint32(fi.line),
+		LastLineDefined: uint32(fi.lastLine),
+		NumParams:       byte(fi.numParams),
+		MaxStackSize:    byte(fi.maxRegs),
+		Code:            fi.insts,
+		Constants:       getConstants(fi),
+		Upvalues:        getUpvalues(fi),
+		Protos:          toProtos(fi.subFuncs),
+		LineInfo:        fi.lineNums,
+		LocVars:         getLocVars(fi),
+		UpvalueNames:    getUpvalueNames(fi),
 	}
 
+	if fi.line == 0 { // 主函数
+		proto.LastLineDefined = 0
+	}
+	if proto.MaxStackSize < 2 {
+		proto.MaxStackSize = 2 // 和luac一样, 至少两个寄存器
+	}
 	if fi.isVararg {
 		proto.IsVararg = 1
 	}
@@ -51,3 +59,23 @@ func getUpvalues(fi *funcInfo) []binchunk.Upvalue {
 	}
 	return upvals
 }
+
+func getLocVars(fi *funcInfo) []binchunk.LocVar {
+	locVars := make([]binchunk.LocVar, len(fi.locVars))
+	for i, locVar := range fi.locVars {
+		locVars[i] = binchunk.LocVar{
+			VarName: locVar.name,
+			StartPC: uint32(locVar.startPC),
+			EndPC:   uint32(locVar.endPC),
+		}
+	}
+	return locVars
+}
+
+func getUpvalueNames(fi *funcInfo) []string {
+	names := make([]string, len(fi.upvalues))
+	for name, uv := range fi.upvalues {
+		names[uv.index] = name
+	}
+	return names
+}
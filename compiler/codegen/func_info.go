@@ -1,6 +1,7 @@
 package codegen
 
 import (
+	"fmt"
 	"lua_go/compiler/ast"
 	"lua_go/compiler/lexer"
 	"lua_go/vm"
@@ -26,7 +27,32 @@ type locVarInfo struct {
 	name     string
 	scopeLv  int
 	slot     int
+	startPC  int
+	endPC    int
 	captured bool
+	toClose  bool // <close>变量, 离开作用域时和被捕获的变量一样需要关闭
+}
+
+type labelInfo struct {
+	name     string
+	line     int
+	pc       int
+	scopeLv  int
+	nActVars int // 标签处活跃的局部变量数量
+}
+
+type gotoInfo struct {
+	name     string
+	line     int
+	jmpPC    int
+	scopeLv  int
+	nActVars int // goto处活跃的局部变量数量
+	closeA   int // 跳转时需要关闭的upvalue(JMP指令的A操作数)
+}
+
+type blockInfo struct {
+	breakable bool
+	nActVars  int // 进入块时活跃的局部变量数量
 }
 
 type upvalInfo struct {
@@ -42,28 +68,40 @@ type funcInfo struct {
 	scopeLv   int
 	locVars   []*locVarInfo
 	locNames  map[string]*locVarInfo
-	breaks    [][]int
+	blocks    []*blockInfo
+	labels    []*labelInfo
+	gotos     []*gotoInfo
 	parent    *funcInfo
 	upvalues  map[string]upvalInfo
 	insts     []uint32
+	lineNums  []uint32
+	line      int
+	lastLine  int
 	subFuncs  []*funcInfo
 	numParams int
 	isVararg  bool
 }
 
 func newFuncInfo(parent *funcInfo, fd *ast.FuncDefExp) *funcInfo {
-	return &funcInfo{
+	fi := &funcInfo{
 		parent:    parent,
 		subFuncs:  []*funcInfo{},
 		constants: map[interface{}]int{},
 		upvalues:  map[string]upvalInfo{},
 		locNames:  map[string]*locVarInfo{},
 		locVars:   make([]*locVarInfo, 0, 8),
-		breaks:    make([][]int, 1),
+		blocks:    []*blockInfo{{}},
 		insts:     make([]uint32, 0, 8),
+		lineNums:  make([]uint32, 0, 8),
+		line:      fd.Line,
+		lastLine:  fd.LastLine,
 		isVararg:  fd.IsVararg,
 		numParams: len(fd.ParList),
 	}
+	if parent != nil && parent.parent == nil {
+		fi.indexOfUpval("_ENV") // 和luac一样, 主函数总是以_ENV作为第一个upvalue
+	}
+	return fi
 }
 
 func (fi *funcInfo) indexOfConstant(k interface{}) int {
@@ -80,7 +118,11 @@ func (fi *funcInfo) indexOfConstant(k interface{}) int {
 func (fi *funcInfo) allocReg() int {
 	fi.usedRegs++
 	if fi.usedRegs >= 255 {
-		panic("function or expression needs too many registers")
+		line := fi.line
+		if len(fi.lineNums) > 0 {
+			line = int(fi.lineNums[len(fi.lineNums)-1])
+		}
+		semError(line, "function or expression needs too many registers")
 	}
 
 	if fi.usedRegs > fi.maxRegs {
@@ -115,35 +157,47 @@ func (fi *funcInfo) freeRegs(n int) {
 
 func (fi *funcInfo) enterScope(breakable bool) {
 	fi.scopeLv++
-	if breakable {
-		fi.breaks = append(fi.breaks, []int{})
-	} else {
-		fi.breaks = append(fi.breaks, nil)
-	}
+	fi.blocks = append(fi.blocks, &blockInfo{
+		breakable: breakable,
+		nActVars:  fi.usedRegs,
+	})
 }
 
-func (fi *funcInfo) addBreakJmp(pc int) {
+func (fi *funcInfo) addBreakJmp(pc, line int) {
 	for i := fi.scopeLv; i >= 0; i-- {
-		if fi.breaks[i] != nil { // 循环块
-			fi.breaks[i] = append(fi.breaks[i], pc)
+		if fi.blocks[i].breakable { // 循环块
+			fi.addGoto("break", line, pc)
 			return
 		}
 	}
-	panic("<break> at line ? not inside a loop!")
+	semError(line, "<break> at line %d not inside a loop", line)
 }
 
-func (fi *funcInfo) addLocVar(name string) int {
+func (fi *funcInfo) addLocVar(name string, startPC int) int {
 	newVar := &locVarInfo{
 		name:    name,
 		prev:    fi.locNames[name],
 		scopeLv: fi.scopeLv,
 		slot:    fi.allocReg(), // 分配寄存器
+		startPC: startPC,
 	}
 	fi.locVars = append(fi.locVars, newVar)
 	fi.locNames[name] = newVar
 	return newVar.slot
 }
 
+// 是否有活跃的<close>变量
+func (fi *funcInfo) hasToCloseVars() bool {
+	for _, locVar := range fi.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.toClose {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (fi *funcInfo) slotOfLocVar(name string) int {
 	if locVar, found := fi.locNames[name]; found {
 		return locVar.slot
@@ -152,23 +206,132 @@ func (fi *funcInfo) slotOfLocVar(name string) int {
 }
 
 func (fi *funcInfo) exitScope() {
-	pendingBreakJmps := fi.breaks[len(fi.breaks)-1]
-	fi.breaks = fi.breaks[:len(fi.breaks)-1]
-	a := fi.getJmpArgA()
-	for _, pc := range pendingBreakJmps {
-		sBx := fi.pc() - pc
-		i := (sBx+vm.MAXARG_sBx)<<14 | a<<6 | vm.OP_JMP
-		fi.insts[pc] = uint32(i)
-	}
+	block := fi.blocks[len(fi.blocks)-1]
+	fi.blocks = fi.blocks[:len(fi.blocks)-1]
+	hasUpvals := fi.getJmpArgA() > 0
+
 	fi.scopeLv--
 	for _, locVar := range fi.locNames {
 		if locVar.scopeLv > fi.scopeLv { // 离开作用域
 			fi.removeLocVar(locVar)
 		}
 	}
+	fi.removeLabels()
+	fi.moveGotosOut(block, hasUpvals)
+	if fi.scopeLv < 0 && len(fi.gotos) > 0 {
+		gt := fi.gotos[0]
+		semError(gt.line, "no visible label '%s' for <goto> at line %d",
+			gt.name, gt.line)
+	}
+}
+
+func (fi *funcInfo) addLabel(name string, line, nActVars int) {
+	for _, label := range fi.labels {
+		if label.scopeLv == fi.scopeLv && label.name == name {
+			semError(line, "label '%s' already defined on line %d",
+				name, label.line)
+		}
+	}
+
+	label := &labelInfo{
+		name:     name,
+		line:     line,
+		pc:       fi.pc() + 1,
+		scopeLv:  fi.scopeLv,
+		nActVars: nActVars,
+	}
+	fi.labels = append(fi.labels, label)
+
+	// 解析当前块中等待该标签的goto
+	for i := 0; i < len(fi.gotos); {
+		gt := fi.gotos[i]
+		if gt.scopeLv == fi.scopeLv && gt.name == name {
+			fi.closeGoto(i, label)
+		} else {
+			i++
+		}
+	}
+}
+
+func (fi *funcInfo) addGoto(name string, line, pc int) {
+	fi.gotos = append(fi.gotos, &gotoInfo{
+		name:     name,
+		line:     line,
+		jmpPC:    pc,
+		scopeLv:  fi.scopeLv,
+		nActVars: fi.usedRegs,
+	})
+	fi.findLabel(len(fi.gotos) - 1)
+}
+
+// 在当前块中查找goto的目标标签, 找到则回填跳转
+func (fi *funcInfo) findLabel(g int) bool {
+	gt := fi.gotos[g]
+	for _, label := range fi.labels {
+		if label.scopeLv == fi.scopeLv && label.name == gt.name {
+			if gt.nActVars > label.nActVars {
+				gt.closeA = label.nActVars + 1
+			}
+			fi.closeGoto(g, label)
+			return true
+		}
+	}
+	return false
+}
+
+func (fi *funcInfo) closeGoto(g int, label *labelInfo) {
+	gt := fi.gotos[g]
+	if gt.nActVars < label.nActVars {
+		varName := "?"
+		if locVar := fi.locVarOfSlot(gt.nActVars); locVar != nil {
+			varName = locVar.name
+		}
+		semError(label.line, "<goto %s> at line %d jumps into the scope of local '%s'",
+			gt.name, gt.line, varName)
+	}
+	fi.fixJmp(gt.jmpPC, gt.closeA, label.pc-gt.jmpPC-1)
+	fi.gotos = append(fi.gotos[:g], fi.gotos[g+1:]...)
+}
+
+func (fi *funcInfo) removeLabels() {
+	n := 0
+	for _, label := range fi.labels {
+		if label.scopeLv <= fi.scopeLv {
+			fi.labels[n] = label
+			n++
+		}
+	}
+	fi.labels = fi.labels[:n]
+}
+
+// 将离开的块中未解析的goto移交给外层块, 必要时关闭upvalue;
+// 如果离开的是循环块, 则把其中的break回填到循环之后
+func (fi *funcInfo) moveGotosOut(block *blockInfo, hasUpvals bool) {
+	for i := 0; i < len(fi.gotos); {
+		gt := fi.gotos[i]
+		if gt.scopeLv > fi.scopeLv {
+			if gt.nActVars > block.nActVars {
+				if hasUpvals {
+					gt.closeA = block.nActVars + 1
+				}
+				gt.nActVars = block.nActVars
+			}
+			gt.scopeLv = fi.scopeLv
+			if block.breakable && gt.name == "break" {
+				fi.fixJmp(gt.jmpPC, gt.closeA, fi.pc()-gt.jmpPC)
+				fi.gotos = append(fi.gotos[:i], fi.gotos[i+1:]...)
+				continue
+			}
+			if fi.findLabel(i) {
+				continue
+			}
+		}
+		i++
+	}
 }
 
 func (fi *funcInfo) removeLocVar(locVar *locVarInfo) {
+	locVar.endPC = fi.pc() + 1
 	fi.freeReg()
 	if locVar.prev == nil {
 		delete(fi.locNames, locVar.name)
@@ -179,6 +342,17 @@ func (fi *funcInfo) removeLocVar(locVar *locVarInfo) {
 	}
 }
 
+func (fi *funcInfo) locVarOfSlot(slot int) *locVarInfo {
+	for _, locVar := range fi.locNames {
+		for v := locVar; v != nil; v = v.prev {
+			if v.slot == slot {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
 func (fi *funcInfo) indexOfUpval(name string) int {
 	if upval, ok := fi.upvalues[name]; ok {
 		return upval.index
@@ -209,30 +383,39 @@ func (fi *funcInfo) indexOfUpval(name string) int {
 	return -1
 }
 
-func (fi *funcInfo) emitABC(opcode, a, b, c int) {
+func (fi *funcInfo) emitABC(line, opcode, a, b, c int) {
 	i := b<<23 | c<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(line))
 }
 
-func (fi *funcInfo) emitABx(opcode, a, bx int) {
+func (fi *funcInfo) emitABx(line, opcode, a, bx int) {
 	i := bx<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(line))
 }
 
-func (fi *funcInfo) emitAsBx(opcode, a, b int) {
+func (fi *funcInfo) emitAsBx(line, opcode, a, b int) {
 	i := (b+vm.MAXARG_sBx)<<14 | a<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(line))
 }
 
-func (fi *funcInfo) emitAx(opcode, ax int) {
+func (fi *funcInfo) emitAx(line, opcode, ax int) {
 	i := ax<<6 | opcode
 	fi.insts = append(fi.insts, uint32(i))
+	fi.lineNums = append(fi.lineNums, uint32(line))
 }
 
 func (fi *funcInfo) pc() int {
 	return len(fi.insts) - 1
 }
 
+func (fi *funcInfo) fixJmp(pc, a, sBx int) {
+	i := (sBx+vm.MAXARG_sBx)<<14 | a<<6 | vm.OP_JMP
+	fi.insts[pc] = uint32(i)
+}
+
 func (fi *funcInfo) fixSbx(pc, sBx int) {
 	i := fi.insts[pc]
 	i = i << 18 >> 18                     // 清除sBx操作数
@@ -241,165 +424,181 @@ func (fi *funcInfo) fixSbx(pc, sBx int) {
 }
 
 // return r[a], ... ,r[a+b-2]
-func (fi *funcInfo) emitReturn(a, n int) {
-	fi.emitABC(vm.OP_RETURN, a, n+1, 0)
+func (fi *funcInfo) emitReturn(line, a, n int) {
+	fi.emitABC(line, vm.OP_RETURN, a, n+1, 0)
 }
 
 // if not (r[a] <=> c) then pc++
-func (fi *funcInfo) emitTest(a, c int) {
-	fi.emitABC(vm.OP_TEST, a, 0, c)
+func (fi *funcInfo) emitTest(line, a, c int) {
+	fi.emitABC(line, vm.OP_TEST, a, 0, c)
 }
 
 // pc+=sBx; if (a) close all upvalues >= r[a - 1]
-func (fi *funcInfo) emitJmp(a, sBx int) int {
-	fi.emitAsBx(vm.OP_JMP, a, sBx)
+func (fi *funcInfo) emitJmp(line, a, sBx int) int {
+	fi.emitAsBx(line, vm.OP_JMP, a, sBx)
 	return len(fi.insts) - 1
 }
 
-func (fi *funcInfo) emitTForCall(a, c int) {
-	fi.emitABC(vm.OP_TFORCALL, a, 0, c)
+func (fi *funcInfo) emitTForCall(line, a, c int) {
+	fi.emitABC(line, vm.OP_TFORCALL, a, 0, c)
 }
 
-func (fi *funcInfo) emitTForLoop(a, sBx int) {
-	fi.emitAsBx(vm.OP_TFORLOOP, a, sBx)
+func (fi *funcInfo) emitTForLoop(line, a, sBx int) {
+	fi.emitAsBx(line, vm.OP_TFORLOOP, a, sBx)
 }
 
-func (fi *funcInfo) emitNewTable(a, nArr, nRec int) {
-	fi.emitABC(vm.OP_NEWTABLE, a, vm.Int2fb(nArr), vm.Int2fb(nRec))
+func (fi *funcInfo) emitNewTable(line, a, nArr, nRec int) {
+	fi.emitABC(line, vm.OP_NEWTABLE, a, vm.Int2fb(nArr), vm.Int2fb(nRec))
 }
 
 // r[a] = op r[b]
-func (fi *funcInfo) emitUnaryOp(op, a, b int) {
+func (fi *funcInfo) emitUnaryOp(line, op, a, b int) {
 	switch op {
 	case lexer.TOKEN_OP_NOT:
-		fi.emitABC(vm.OP_NOT, a, b, 0)
+		fi.emitABC(line, vm.OP_NOT, a, b, 0)
 	case lexer.TOKEN_OP_BNOT:
-		fi.emitABC(vm.OP_BNOT, a, b, 0)
+		fi.emitABC(line, vm.OP_BNOT, a, b, 0)
 	case lexer.TOKEN_OP_LEN:
-		fi.emitABC(vm.OP_LEN, a, b, 0)
+		fi.emitABC(line, vm.OP_LEN, a, b, 0)
 	case lexer.TOKEN_OP_UNM:
-		fi.emitABC(vm.OP_UNM, a, b, 0)
+		fi.emitABC(line, vm.OP_UNM, a, b, 0)
 	}
 }
 
 // r[a] = rk[b] op rk[c]
 // arith & bitwise & relational
-func (fi *funcInfo) emitBinaryOp(op, a, b, c int) {
+func (fi *funcInfo) emitBinaryOp(line, op, a, b, c int) {
 	if opcode, found := arithAndBitwiseBinops[op]; found {
-		fi.emitABC(opcode, a, b, c)
+		fi.emitABC(line, opcode, a, b, c)
 	} else {
 		switch op {
 		case lexer.TOKEN_OP_EQ:
-			fi.emitABC(vm.OP_EQ, 1, b, c)
+			fi.emitABC(line, vm.OP_EQ, 1, b, c)
 		case lexer.TOKEN_OP_NE:
-			fi.emitABC(vm.OP_EQ, 0, b, c)
+			fi.emitABC(line, vm.OP_EQ, 0, b, c)
 		case lexer.TOKEN_OP_LT:
-			fi.emitABC(vm.OP_LT, 1, b, c)
+			fi.emitABC(line, vm.OP_LT, 1, b, c)
 		case lexer.TOKEN_OP_GT:
-			fi.emitABC(vm.OP_LT, 1, c, b)
+			fi.emitABC(line, vm.OP_LT, 1, c, b)
 		case lexer.TOKEN_OP_LE:
-			fi.emitABC(vm.OP_LE, 1, b, c)
+			fi.emitABC(line, vm.OP_LE, 1, b, c)
 		case lexer.TOKEN_OP_GE:
-			fi.emitABC(vm.OP_LE, 1, c, b)
+			fi.emitABC(line, vm.OP_LE, 1, c, b)
 		}
-		fi.emitJmp(0, 1)
-		fi.emitLoadBool(a, 0, 1)
-		fi.emitLoadBool(a, 1, 0)
+		fi.emitJmp(line, 0, 1)
+		fi.emitLoadBool(line, a, 0, 1)
+		fi.emitLoadBool(line, a, 1, 0)
 	}
 }
 
 // r[a][rk(b)] = rk(c)
-func (fi *funcInfo) emitSetTable(a, b, c int) {
-	fi.emitABC(vm.OP_SETTABLE, a, b, c)
+func (fi *funcInfo) emitSetTable(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_SETTABLE, a, b, c)
 }
 
 // r[a] := r[b][rk(c)]
-func (fi *funcInfo) emitGetTable(a, b, c int) {
-	fi.emitABC(vm.OP_GETTABLE, a, b, c)
+func (fi *funcInfo) emitGetTable(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_GETTABLE, a, b, c)
 }
 
 // r[a] = (bool)b; if (c) pc++
-func (fi *funcInfo) emitLoadBool(a, b, c int) {
-	fi.emitABC(vm.OP_LOADBOOL, a, b, c)
+func (fi *funcInfo) emitLoadBool(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_LOADBOOL, a, b, c)
 }
 
 // r[a], r[a+1], ..., r[a+b] = nil
-func (fi *funcInfo) emitLoadNil(a, n int) {
-	fi.emitABC(vm.OP_LOADNIL, a, n-1, 0)
+func (fi *funcInfo) emitLoadNil(line, a, n int) {
+	fi.emitABC(line, vm.OP_LOADNIL, a, n-1, 0)
 }
 
 // r[a] = r[b]
-func (fi *funcInfo) emitMove(a, b int) {
-	fi.emitABC(vm.OP_MOVE, a, b, 0)
+func (fi *funcInfo) emitMove(line, a, b int) {
+	fi.emitABC(line, vm.OP_MOVE, a, b, 0)
 }
 
 // upval[b] = r[a]
-func (fi *funcInfo) emitSetUpval(a, b int) {
-	fi.emitABC(vm.OP_SETUPVAL, a, b, 0)
+func (fi *funcInfo) emitSetUpval(line, a, b int) {
+	fi.emitABC(line, vm.OP_SETUPVAL, a, b, 0)
 }
 
 // r[a] = upval[b]
-func (fi *funcInfo) emitGetUpval(a, b int) {
-	fi.emitABC(vm.OP_GETUPVAL, a, b, 0)
+func (fi *funcInfo) emitGetUpval(line, a, b int) {
+	fi.emitABC(line, vm.OP_GETUPVAL, a, b, 0)
 }
 
 // upval[a][rk(b)] = rk(c)
-func (fi *funcInfo) emitSetTabUp(a, b, c int) {
-	fi.emitABC(vm.OP_SETTABUP, a, b, c)
+func (fi *funcInfo) emitSetTabUp(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_SETTABUP, a, b, c)
 }
 
-func (fi *funcInfo) emitForPrep(a, sBx int) int {
-	fi.emitAsBx(vm.OP_FORPREP, a, sBx)
+func (fi *funcInfo) emitForPrep(line, a, sBx int) int {
+	fi.emitAsBx(line, vm.OP_FORPREP, a, sBx)
 	return len(fi.insts) - 1
 }
 
-func (fi *funcInfo) emitForLoop(a, sBx int) int {
-	fi.emitAsBx(vm.OP_FORLOOP, a, sBx)
+func (fi *funcInfo) emitForLoop(line, a, sBx int) int {
+	fi.emitAsBx(line, vm.OP_FORLOOP, a, sBx)
 	return len(fi.insts) - 1
 }
 
+// mark r[a] as to-be-closed
+func (fi *funcInfo) emitTBC(line, a int) {
+	fi.emitABC(line, vm.OP_TBC, a, 0, 0)
+}
+
 // r[a] = kst[bx]
-func (fi *funcInfo) emitLoadK(a int, k interface{}) {
+func (fi *funcInfo) emitLoadK(line, a int, k interface{}) {
 	idx := fi.indexOfConstant(k)
 	if idx < (1 << 18) {
-		fi.emitABx(vm.OP_LOADK, a, idx)
+		fi.emitABx(line, vm.OP_LOADK, a, idx)
 	} else {
-		fi.emitABx(vm.OP_LOADKX, a, 0)
-		fi.emitAx(vm.OP_EXTRAARG, idx)
+		fi.emitABx(line, vm.OP_LOADKX, a, 0)
+		fi.emitAx(line, vm.OP_EXTRAARG, idx)
 	}
 }
 
 // r[a], r[a+1], ..., r[a+b-2] = vararg
-func (fi *funcInfo) emitVararg(a, n int) {
-	fi.emitABC(vm.OP_VARARG, a, n+1, 0)
+func (fi *funcInfo) emitVararg(line, a, n int) {
+	fi.emitABC(line, vm.OP_VARARG, a, n+1, 0)
 }
 
 // r[a] = emitClosure(proto[bx])
-func (fi *funcInfo) emitClosure(a, bx int) {
-	fi.emitABx(vm.OP_CLOSURE, a, bx)
+func (fi *funcInfo) emitClosure(line, a, bx int) {
+	fi.emitABx(line, vm.OP_CLOSURE, a, bx)
 }
 
 // r[a][(c-1)*FPF+i] := r[a+i], 1 <= i <= b
-func (fi *funcInfo) emitSetList(a, b, c int) {
-	fi.emitABC(vm.OP_SETLIST, a, b, c)
+func (fi *funcInfo) emitSetList(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_SETLIST, a, b, c)
 }
 
 // if (r[b] <=> c) then r[a] := r[b] else pc++
-func (fi *funcInfo) emitTestSet(a, b, c int) {
-	fi.emitABC(vm.OP_TESTSET, a, b, c)
+func (fi *funcInfo) emitTestSet(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_TESTSET, a, b, c)
 }
 
 // r[a], ..., r[a+c-2] = r[a](r[a+1], ..., r[a+b-1])
-func (fi *funcInfo) emitCall(a, nArgs, nRet int) {
-	fi.emitABC(vm.OP_CALL, a, nArgs+1, nRet+1)
+func (fi *funcInfo) emitCall(line, a, nArgs, nRet int) {
+	fi.emitABC(line, vm.OP_CALL, a, nArgs+1, nRet+1)
+}
+
+// return r[a](r[a+1], ... ,r[a+nArgs])
+func (fi *funcInfo) emitTailCall(line, a, nArgs int) {
+	fi.emitABC(line, vm.OP_TAILCALL, a, nArgs+1, 0)
 }
 
 // r[a+1] := r[b]; r[a] := r[b][rk(c)]
-func (fi *funcInfo) emitSelf(a, b, c int) {
-	fi.emitABC(vm.OP_SELF, a, b, c)
+func (fi *funcInfo) emitSelf(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_SELF, a, b, c)
 }
 
 // r[a] = upval[b][rk(c)]
-func (fi *funcInfo) emitGetTabUp(a, b, c int) {
-	fi.emitABC(vm.OP_GETTABUP, a, b, c)
+func (fi *funcInfo) emitGetTabUp(line, a, b, c int) {
+	fi.emitABC(line, vm.OP_GETTABUP, a, b, c)
+}
+
+// 报告语义错误, 和luac一样错误信息中不带near
+// lua-5.3.4/src/lparser.c#semerror()
+func semError(line int, f string, a ...interface{}) {
+	panic(&lexer.SyntaxError{Line: line, Msg: fmt.Sprintf(f, a...)})
 }
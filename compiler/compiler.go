@@ -3,10 +3,35 @@ package compiler
 import (
 	"lua_go/binchunk"
 	"lua_go/compiler/codegen"
+	"lua_go/compiler/lexer"
 	"lua_go/compiler/parser"
 )
 
-func Compile(chunk, chunkName string) *binchunk.Prototype {
+// 编译错误, 可以通过errors.As取出出错的位置和token
+type CompileError = lexer.SyntaxError
+
+// 把源代码编译成函数原型, 语法错误以*CompileError返回
+func Compile(chunk, chunkName string) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ce, ok := r.(*CompileError)
+			if !ok {
+				panic(r)
+			}
+			ce.ChunkName = chunkName // 代码生成器不知道源文件名
+			proto, err = nil, ce
+		}
+	}()
+
 	ast := parser.Parse(chunk, chunkName)
-	return codegen.GenProto(ast)
+	proto = codegen.GenProto(ast)
+	setSource(proto, chunkName)
+	return proto, nil
+}
+
+func setSource(proto *binchunk.Prototype, chunkName string) {
+	proto.Source = chunkName
+	for _, f := range proto.Protos {
+		setSource(f, chunkName)
+	}
 }
@@ -0,0 +1,29 @@
+package lexer
+
+import (
+	"fmt"
+	"lua_go/binchunk"
+)
+
+// 词法或语法错误, 词法分析器, 语法分析器和代码生成器都以此为参数panic
+type SyntaxError struct {
+	ChunkName string // 源文件名, 如"@test.lua"或"=stdin"
+	Line      int    // 出错的行号
+	Column    int    // 出错的列号(按字节计算, 从1开始), 未知时为0
+	Token     string // 出错位置附近的token, 为空时错误信息中不出现near
+	Msg       string // 不带位置信息的错误描述
+}
+
+// 格式和luac一致: chunkname:line: message near 'token'
+// lua-5.3.4/src/llex.c#lexerror()
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("%s:%d: %s", binchunk.ChunkID(e.ChunkName), e.Line, e.Msg)
+	switch e.Token {
+	case "":
+		return msg
+	case "<eof>":
+		return msg + " near <eof>"
+	default:
+		return msg + " near '" + e.Token + "'"
+	}
+}
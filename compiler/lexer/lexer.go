@@ -3,6 +3,7 @@ package lexer
 import (
 	"bytes"
 	"fmt"
+	"lua_go/number"
 	"regexp"
 	"strconv"
 	"strings"
@@ -19,16 +20,19 @@ var reHexEscapeSeq = regexp.MustCompile(`^\\x[0-9a-fA-F]{2}`)
 var reUnicodeEscapeSeq = regexp.MustCompile(`^\\u\{[0-9a-fA-F]+\}`)
 
 type Lexer struct {
-	chunk         string // 源代码
-	chunkName     string // 源文件名
-	line          int    // 当前行号
-	nextToken     string
-	nextTokenKind int
-	nextTokenLine int
+	source         string // 完整的源代码, 用于计算列号和截取出错的token
+	chunk          string // 尚未分析的源代码
+	chunkName      string // 源文件名
+	line           int    // 当前行号
+	tokenStart     int    // 当前token在source中的起始位置
+	nextToken      string
+	nextTokenKind  int
+	nextTokenLine  int
+	nextTokenStart int
 }
 
 func NewLexer(chunk, chunkName string) *Lexer {
-	return &Lexer{chunk, chunkName, 1, "", 0, 0}
+	return &Lexer{source: chunk, chunk: chunk, chunkName: chunkName, line: 1}
 }
 
 func (lex *Lexer) NextToken() (line, kind int, token string) {
@@ -37,14 +41,19 @@ func (lex *Lexer) NextToken() (line, kind int, token string) {
 		kind = lex.nextTokenKind
 		token = lex.nextToken
 		lex.line = lex.nextTokenLine
+		lex.tokenStart = lex.nextTokenStart
 		lex.nextTokenLine = 0
 		return
 	}
 
 	lex.skipWhiteSpaces()
+	lex.tokenStart = lex.pos()
+	return lex.scanToken()
+}
 
+func (lex *Lexer) scanToken() (line, kind int, token string) {
 	if len(lex.chunk) == 0 {
-		return lex.line, TOKEN_EOF, "EOF"
+		return lex.line, TOKEN_EOF, "<eof>"
 	}
 
 	switch lex.chunk[0] {
@@ -184,7 +193,10 @@ func (lex *Lexer) NextToken() (line, kind int, token string) {
 		}
 	}
 
-	lex.error("unexpected symbol near %q", c)
+	if c < 0x20 || c == 0x7F { // 控制字符
+		lex.error(fmt.Sprintf("<\\%d>", c), "unexpected symbol")
+	}
+	lex.error(string(c), "unexpected symbol")
 	return
 }
 
@@ -194,8 +206,11 @@ func (lex *Lexer) LookAhead() int {
 	}
 
 	currentLine := lex.line
+	currentStart := lex.tokenStart
 	line, kind, token := lex.NextToken()
+	lex.nextTokenStart = lex.tokenStart
 	lex.line = currentLine
+	lex.tokenStart = currentStart
 	lex.nextTokenLine = line
 	lex.nextTokenKind = kind
 	lex.nextToken = token
@@ -203,10 +218,10 @@ func (lex *Lexer) LookAhead() int {
 }
 
 func (lex *Lexer) NextTokenOfKind(kind int) (line int, token string) {
-	line, _kind, token := lex.NextToken()
-	if kind != _kind {
-		lex.error("syntax error near '%s'", token)
+	if lex.LookAhead() != kind {
+		lex.SyntaxError("%s expected", tokenToStr(kind))
 	}
+	line, _, token = lex.NextToken()
 	return line, token
 }
 
@@ -218,6 +233,56 @@ func (lex *Lexer) Line() int {
 	return lex.line
 }
 
+// 在下一个token附近报告语法错误, 用于语法分析器
+// lua-5.3.4/src/llex.c#luaX_syntaxerror()
+func (lex *Lexer) SyntaxError(f string, a ...interface{}) {
+	lex.LookAhead()
+	token := "<eof>"
+	if lex.nextTokenKind != TOKEN_EOF {
+		token = lex.rawToken(lex.nextTokenStart)
+	}
+	panic(&SyntaxError{
+		ChunkName: lex.chunkName,
+		Line:      lex.nextTokenLine,
+		Column:    lex.column(lex.nextTokenStart),
+		Token:     token,
+		Msg:       fmt.Sprintf(f, a...),
+	})
+}
+
+// 报告词法错误, token是出错位置附近的源码
+// lua-5.3.4/src/llex.c#lexerror()
+func (lex *Lexer) error(token, f string, a ...interface{}) {
+	panic(&SyntaxError{
+		ChunkName: lex.chunkName,
+		Line:      lex.line,
+		Column:    lex.column(lex.tokenStart),
+		Token:     token,
+		Msg:       fmt.Sprintf(f, a...),
+	})
+}
+
+// 已经分析过的源代码长度
+func (lex *Lexer) pos() int {
+	return len(lex.source) - len(lex.chunk)
+}
+
+// 把source中的位置转换成列号(从1开始)
+func (lex *Lexer) column(pos int) int {
+	return pos - strings.LastIndexAny(lex.source[:pos], "\r\n")
+}
+
+// 截取从start开始的原始token文本, 重新扫描一遍以免受转义和长字符串的影响
+func (lex *Lexer) rawToken(start int) string {
+	tmp := &Lexer{source: lex.source, chunk: lex.source[start:],
+		chunkName: lex.chunkName, line: lex.line}
+	func() {
+		defer func() { recover() }()
+		tmp.scanToken()
+	}()
+	return lex.source[start:tmp.pos()]
+}
+
 func isLatter(c byte) bool {
 	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
 }
@@ -231,7 +296,23 @@ func (lex *Lexer) scanIdentifier() string {
 }
 
 func (lex *Lexer) scanNumber() string {
-	return lex.scan(reNumber)
+	token := lex.scan(reNumber)
+	if len(lex.chunk) > 0 && (lex.chunk[0] == '.' || lex.chunk[0] == '_' ||
+		isDigit(lex.chunk[0]) || isLatter(lex.chunk[0])) {
+		// 和luac一样把紧跟着的字母数字也算进来, 如'3x', '0x'
+		n := 0
+		for n < len(lex.chunk) && (lex.chunk[n] == '.' || lex.chunk[n] == '_' ||
+			isDigit(lex.chunk[n]) || isLatter(lex.chunk[n])) {
+			n++
+		}
+		lex.error(token+lex.chunk[:n], "malformed number")
+	}
+	if _, ok := number.ParseInteger(token); !ok {
+		if _, ok := number.ParseFloat(token); !ok {
+			lex.error(token, "malformed number")
+		}
+	}
+	return token
 }
 
 func (lex *Lexer) scan(re *regexp.Regexp) string {
@@ -298,14 +379,13 @@ func (lex *Lexer) skipComment() {
 func (lex *Lexer) scanLongString() string {
 	openingLongBracket := reOpeningLongBracket.FindString(lex.chunk)
 	if openingLongBracket == "" {
-		lex.error("invalid long string delimiter near '%s'",
-			lex.chunk[0:2])
+		lex.error(lex.chunk[0:2], "invalid long string delimiter")
 	}
 
 	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
 	closingLongBracketIdx := strings.Index(lex.chunk, closingLongBracket)
 	if closingLongBracketIdx < 0 {
-		lex.error("unfinished long string or comment")
+		lex.error("<eof>", "unfinished long string or comment")
 	}
 
 	str := lex.chunk[len(openingLongBracket):closingLongBracketIdx]
@@ -320,12 +400,6 @@ func (lex *Lexer) scanLongString() string {
 	return str
 }
 
-func (lex *Lexer) error(f string, a ...interface{}) {
-	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", lex.chunkName, lex.line, err)
-	panic(err)
-}
-
 func (lex *Lexer) scanShortString() string {
 	if str := reShortStr.FindString(lex.chunk); str != "" {
 		lex.next(len(str))
@@ -336,7 +410,11 @@ func (lex *Lexer) scanShortString() string {
 		}
 		return str
 	}
-	lex.error("unfinished string")
+	end := strings.IndexAny(lex.chunk, "\r\n")
+	if end < 0 {
+		lex.error("<eof>", "unfinished string")
+	}
+	lex.error(lex.chunk[:end], "unfinished string")
 	return ""
 }
 
@@ -351,7 +429,7 @@ func (lex *Lexer) escape(str string) string {
 		}
 
 		if len(str) == 1 {
-			lex.error("unfinished string")
+			lex.error(str, "unfinished string")
 		}
 
 		switch str[1] {
@@ -403,7 +481,7 @@ func (lex *Lexer) escape(str string) string {
 					str = str[len(found):]
 					continue
 				}
-				lex.error("decimal escape too large near '%s'", found)
+				lex.error(found, "decimal escape too large")
 			}
 		case 'x': // \xXX
 			if found := reHexEscapeSeq.FindString(str); found != "" {
@@ -420,7 +498,7 @@ func (lex *Lexer) escape(str string) string {
 					str = str[len(found):]
 					continue
 				}
-				lex.error("UTF-8 value too large near '%s'", found)
+				lex.error(found, "UTF-8 value too large")
 			}
 		case 'z':
 			str = str[2:]
@@ -429,7 +507,7 @@ func (lex *Lexer) escape(str string) string {
 			}
 			continue
 		}
-		lex.error("invalid escape sequence near '\\%c'", str[1])
+		lex.error(str[:2], "invalid escape sequence")
 	}
 
 	return buf.String()
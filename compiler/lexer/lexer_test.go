@@ -17,7 +17,7 @@ func TestLexer(t *testing.T) {
 				"[separator] (",
 				"[string] Hello, World!",
 				"[separator] )",
-				"[other] EOF",
+				"[other] <eof>",
 			},
 		},
 	}
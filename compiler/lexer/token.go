@@ -91,3 +91,37 @@ var keywords = map[string]int{
 	"until":    TOKEN_KW_UNTIL,
 	"while":    TOKEN_KW_WHILE,
 }
+
+var tokenNames = map[int]string{
+	TOKEN_EOF:        "<eof>",
+	TOKEN_VARARG:     "'...'",
+	TOKEN_SEP_SEMI:   "';'",
+	TOKEN_SEP_COMMA:  "','",
+	TOKEN_SEP_DOT:    "'.'",
+	TOKEN_SEP_COLON:  "':'",
+	TOKEN_SEP_LABEL:  "'::'",
+	TOKEN_SEP_LPAREN: "'('",
+	TOKEN_SEP_RPAREN: "')'",
+	TOKEN_SEP_LBRACK: "'['",
+	TOKEN_SEP_RBRACK: "']'",
+	TOKEN_SEP_LCURLY: "'{'",
+	TOKEN_SEP_RCURLY: "'}'",
+	TOKEN_OP_ASSIGN:  "'='",
+	TOKEN_IDENTIFIER: "<name>",
+	TOKEN_NUMBER:     "<number>",
+	TOKEN_STRING:     "<string>",
+}
+
+// 把token种类转换成错误信息中的形式, 如"'end'", "<name>"
+// lua-5.3.4/src/llex.c#luaX_token2str()
+func tokenToStr(kind int) string {
+	if name, found := tokenNames[kind]; found {
+		return name
+	}
+	for keyword, k := range keywords {
+		if k == kind {
+			return "'" + keyword + "'"
+		}
+	}
+	return "?"
+}
@@ -0,0 +1,221 @@
+package parser
+
+import (
+	"fmt"
+	"lua_go/compiler/ast"
+	"lua_go/compiler/lexer"
+)
+
+/*
+语法分析之后处理局部变量的属性:
+给<const>和<close>变量赋值是编译错误;
+初始值是常量的<const>变量是编译期常量, 对它的引用被替换为常量本身, 然后重新做常量折叠.
+*/
+
+type attribVar struct {
+	name   string
+	attrib string  // "const", "close"或""
+	value  ast.Exp // 编译期常量的值, 不是编译期常量时为nil
+}
+
+type attribResolver struct {
+	vars []*attribVar // 当前可见的局部变量, 内层的在后面
+}
+
+func resolveLocalAttribs(block *ast.Block) {
+	r := &attribResolver{}
+	r.block(block)
+}
+
+func (r *attribResolver) declare(name, attrib string, value ast.Exp) {
+	r.vars = append(r.vars, &attribVar{name: name, attrib: attrib, value: value})
+}
+
+func (r *attribResolver) lookup(name string) *attribVar {
+	for i := len(r.vars) - 1; i >= 0; i-- {
+		if r.vars[i].name == name {
+			return r.vars[i]
+		}
+	}
+	return nil /* global */
+}
+
+func (r *attribResolver) block(block *ast.Block) {
+	nVars := len(r.vars)
+	r.stats(block)
+	r.vars = r.vars[:nVars]
+}
+
+// 块中的语句和返回值, 不离开块的作用域
+func (r *attribResolver) stats(block *ast.Block) {
+	for _, stat := range block.Stats {
+		r.stat(stat)
+	}
+	r.exps(block.RetExps)
+}
+
+func (r *attribResolver) stat(node ast.Stat) {
+	switch stat := node.(type) {
+	case *ast.FuncCallStat:
+		r.funcCallExp(stat)
+	case *ast.DoStat:
+		r.block(stat.Block)
+	case *ast.WhileStat:
+		stat.Exp = r.exp(stat.Exp)
+		r.block(stat.Block)
+	case *ast.RepeatStat: /* the condition sees the locals of the block */
+		nVars := len(r.vars)
+		r.stats(stat.Block)
+		stat.Exp = r.exp(stat.Exp)
+		r.vars = r.vars[:nVars]
+	case *ast.IfStat:
+		r.exps(stat.Exps)
+		for _, block := range stat.Blocks {
+			r.block(block)
+		}
+	case *ast.ForNumStat:
+		stat.InitExp = r.exp(stat.InitExp)
+		stat.LimitExp = r.exp(stat.LimitExp)
+		stat.StepExp = r.exp(stat.StepExp)
+		r.scope([]string{stat.VarName}, stat.Block)
+	case *ast.ForInStat:
+		r.exps(stat.ExpList)
+		r.scope(stat.NameList, stat.Block)
+	case *ast.AssignStat:
+		for i, exp := range stat.VarList {
+			if nameExp, ok := exp.(*ast.NameExp); ok {
+				if v := r.lookup(nameExp.Name); v != nil && v.attrib != "" {
+					panic(&lexer.SyntaxError{Line: nameExp.Line,
+						Msg: fmt.Sprintf("attempt to assign to const variable '%s'", nameExp.Name)})
+				}
+			} else {
+				stat.VarList[i] = r.exp(exp)
+			}
+		}
+		r.exps(stat.ExpList)
+	case *ast.LocalVarDeclStat:
+		r.exps(stat.ExpList)
+		for i, name := range stat.NameList {
+			attrib, value := "", ast.Exp(nil)
+			if stat.AttribList != nil {
+				attrib = stat.AttribList[i]
+			}
+			if attrib == "const" && len(stat.ExpList) == len(stat.NameList) &&
+				isConstExp(stat.ExpList[i]) {
+				value = stat.ExpList[i]
+			}
+			r.declare(name, attrib, value)
+		}
+	case *ast.LocalFuncDefStat:
+		r.declare(stat.Name, "", nil)
+		r.funcDefExp(stat.Exp)
+	}
+}
+
+// 在新的作用域中声明names, 然后处理block
+func (r *attribResolver) scope(names []string, block *ast.Block) {
+	nVars := len(r.vars)
+	for _, name := range names {
+		r.declare(name, "", nil)
+	}
+	r.block(block)
+	r.vars = r.vars[:nVars]
+}
+
+func (r *attribResolver) exps(exps []ast.Exp) {
+	for i, exp := range exps {
+		exps[i] = r.exp(exp)
+	}
+}
+
+func (r *attribResolver) exp(node ast.Exp) ast.Exp {
+	switch exp := node.(type) {
+	case *ast.NameExp:
+		if v := r.lookup(exp.Name); v != nil && v.value != nil {
+			return copyConstExp(v.value, exp.Line)
+		}
+	case *ast.ParensExp:
+		exp.Exp = r.exp(exp.Exp)
+		if isConstExp(exp.Exp) {
+			return exp.Exp
+		}
+	case *ast.UnopExp:
+		exp.Exp = r.exp(exp.Exp)
+		return optimizeUnaryOp(exp)
+	case *ast.BinopExp:
+		exp.Exp1 = r.exp(exp.Exp1)
+		exp.Exp2 = r.exp(exp.Exp2)
+		return optimizeBinop(exp)
+	case *ast.ConcatExp:
+		r.exps(exp.Exps)
+	case *ast.TableConstructorExp:
+		for i, keyExp := range exp.KeyExps {
+			if keyExp != nil {
+				exp.KeyExps[i] = r.exp(keyExp)
+			}
+		}
+		r.exps(exp.ValExps)
+	case *ast.FuncDefExp:
+		r.funcDefExp(exp)
+	case *ast.TableAccessExp:
+		exp.PrefixExp = r.exp(exp.PrefixExp)
+		exp.KeyExp = r.exp(exp.KeyExp)
+	case *ast.FuncCallExp:
+		r.funcCallExp(exp)
+	}
+	return node
+}
+
+func (r *attribResolver) funcCallExp(exp *ast.FuncCallExp) {
+	exp.PrefixExp = r.exp(exp.PrefixExp)
+	r.exps(exp.Args)
+}
+
+func (r *attribResolver) funcDefExp(exp *ast.FuncDefExp) {
+	r.scope(exp.ParList, exp.Block)
+}
+
+// 和语法分析时一样按运算符折叠常量
+func optimizeBinop(exp *ast.BinopExp) ast.Exp {
+	switch exp.Op {
+	case lexer.TOKEN_OP_OR:
+		return optimizeLogicalOr(exp)
+	case lexer.TOKEN_OP_AND:
+		return optimizeLogicalAnd(exp)
+	case lexer.TOKEN_OP_BOR, lexer.TOKEN_OP_BXOR, lexer.TOKEN_OP_BAND,
+		lexer.TOKEN_OP_SHL, lexer.TOKEN_OP_SHR:
+		return optimizeBitwiseBinaryOp(exp)
+	case lexer.TOKEN_OP_ADD, lexer.TOKEN_OP_SUB, lexer.TOKEN_OP_MUL, lexer.TOKEN_OP_DIV,
+		lexer.TOKEN_OP_IDIV, lexer.TOKEN_OP_MOD, lexer.TOKEN_OP_POW:
+		return optimizeArithBinaryOp(exp)
+	}
+	return exp
+}
+
+// lua-5.4.6/src/lcode.c#luaK_exp2const()
+func isConstExp(exp ast.Exp) bool {
+	switch exp.(type) {
+	case *ast.NilExp, *ast.TrueExp, *ast.FalseExp,
+		*ast.IntegerExp, *ast.FloatExp, *ast.StringExp:
+		return true
+	}
+	return false
+}
+
+// 每次引用都使用新的节点, 因为常量折叠会修改节点
+func copyConstExp(exp ast.Exp, line int) ast.Exp {
+	switch x := exp.(type) {
+	case *ast.NilExp:
+		return &ast.NilExp{Line: line}
+	case *ast.TrueExp:
+		return &ast.TrueExp{Line: line}
+	case *ast.FalseExp:
+		return &ast.FalseExp{Line: line}
+	case *ast.IntegerExp:
+		return &ast.IntegerExp{Line: line, Val: x.Val}
+	case *ast.FloatExp:
+		return &ast.FloatExp{Line: line, Val: x.Val}
+	default:
+		return &ast.StringExp{Line: line, Str: x.(*ast.StringExp).Str}
+	}
+}
@@ -230,7 +230,8 @@ func parseNumberExp(lex *lexer.Lexer) ast.Exp {
 	} else if f, ok := number.ParseFloat(token); ok {
 		return &ast.FloatExp{Line: line, Val: f}
 	} else {
-		panic("not a number: " + token)
+		panic("unreachable!") // 词法分析器已经检查过
+
 	}
 }
 
@@ -330,8 +331,10 @@ func parsePrefixExp(lex *lexer.Lexer) ast.Exp {
 	if lex.LookAhead() == lexer.TOKEN_IDENTIFIER {
 		line, name := lex.NextIdentifier() // Name
 		exp = &ast.NameExp{Line: line, Name: name}
-	} else { // `(` exp `)`
+	} else if lex.LookAhead() == lexer.TOKEN_SEP_LPAREN { // `(` exp `)`
 		exp = parseParensExp(lex)
+	} else {
+		lex.SyntaxError("unexpected symbol")
 	}
 
 	return _finishPrefixExp(lex, exp)
@@ -399,9 +402,11 @@ func _parseArgs(lex *lexer.Lexer) (args []ast.Exp) {
 		lex.NextTokenOfKind(lexer.TOKEN_SEP_RPAREN)
 	case lexer.TOKEN_SEP_LCURLY: // `{` [fieldlist] `}`
 		args = []ast.Exp{parseTableConstructorExp(lex)}
-	default: // LiteralString
-		line, str := lex.NextTokenOfKind(lexer.TOKEN_STRING)
+	case lexer.TOKEN_STRING: // LiteralString
+		line, _, str := lex.NextToken()
 		args = []ast.Exp{&ast.StringExp{Line: line, Str: str}}
+	default:
+		lex.SyntaxError("function arguments expected")
 	}
 	return
 }
@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"fmt"
 	"lua_go/compiler/ast"
 	"lua_go/compiler/lexer"
 )
@@ -19,7 +20,7 @@ stat ::=  ‘;’
 	| for namelist in explist do block end
 	| function funcname funcbody
 	| local function Name funcbody
-	| local namelist [‘=’ explist]
+	| local attnamelist [‘=’ explist]
 	| varlist ‘=’ explist
 	| functioncall
 */
@@ -64,15 +65,15 @@ func parseBreakStat(lex *lexer.Lexer) *ast.BreakStat {
 
 func parseLabelStat(lex *lexer.Lexer) *ast.LabelStat {
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LABEL) // `::`
-	_, name := lex.NextIdentifier()            // Name
+	line, name := lex.NextIdentifier()         // Name
 	lex.NextTokenOfKind(lexer.TOKEN_SEP_LABEL) // `::`
-	return &ast.LabelStat{Name: name}
+	return &ast.LabelStat{Line: line, Name: name}
 }
 
 func parseGotoStat(lex *lexer.Lexer) *ast.GotoStat {
-	lex.NextTokenOfKind(lexer.TOKEN_KW_GOTO) // goto
-	_, name := lex.NextIdentifier()          // Name
-	return &ast.GotoStat{Name: name}
+	line, _ := lex.NextTokenOfKind(lexer.TOKEN_KW_GOTO) // goto
+	_, name := lex.NextIdentifier()                     // Name
+	return &ast.GotoStat{Line: line, Name: name}
 }
 
 func parseDoStat(lex *lexer.Lexer) *ast.DoStat {
@@ -132,7 +133,7 @@ func parseForStat(lex *lexer.Lexer) ast.Stat {
 	if lex.LookAhead() == lexer.TOKEN_OP_ASSIGN {
 		return _finishForNumStat(lex, lineOfFor, name)
 	} else {
-		return _finishForInStat(lex, name)
+		return _finishForInStat(lex, lineOfFor, name)
 	}
 }
 
@@ -165,7 +166,7 @@ func _finishForNumStat(lex *lexer.Lexer, lineOfFor int, varName string) *ast.For
 	}
 }
 
-func _finishForInStat(lex *lexer.Lexer, name0 string) *ast.ForInStat {
+func _finishForInStat(lex *lexer.Lexer, lineOfFor int, name0 string) *ast.ForInStat {
 	nameList := _finishNameList(lex, name0)               // for namelist
 	lex.NextTokenOfKind(lexer.TOKEN_KW_IN)                // in
 	expList := parseExpList(lex)                          // explist
@@ -173,7 +174,13 @@ func _finishForInStat(lex *lexer.Lexer, name0 string) *ast.ForInStat {
 	block := parseBlock(lex)                              // block
 	lex.NextTokenOfKind(lexer.TOKEN_KW_END)               // end
 
-	return &ast.ForInStat{LineOfDo: lineOfDo, NameList: nameList, ExpList: expList, Block: block}
+	return &ast.ForInStat{
+		LineOfFor: lineOfFor,
+		LineOfDo:  lineOfDo,
+		NameList:  nameList,
+		ExpList:   expList,
+		Block:     block,
+	}
 }
 
 func _finishNameList(lex *lexer.Lexer, name0 string) []string {
@@ -203,24 +210,69 @@ func _finishLocalFuncDefStat(lex *lexer.Lexer) *ast.LocalFuncDefStat {
 }
 
 func _finishLocalVarDeclStat(lex *lexer.Lexer) *ast.LocalVarDeclStat {
-	_, name0 := lex.NextIdentifier()        // local name
-	nameList := _finishNameList(lex, name0) // {`,` Name}
+	nameList, attribList := _finishAttNameList(lex) // local attnamelist
 	var expList []ast.Exp = nil
 	if lex.LookAhead() == lexer.TOKEN_OP_ASSIGN { // [
 		lex.NextToken()             // `=`
 		expList = parseExpList(lex) // explist
 	}
 	lastLine := lex.Line()
-	return &ast.LocalVarDeclStat{LastLine: lastLine, NameList: nameList, ExpList: expList}
+	return &ast.LocalVarDeclStat{LastLine: lastLine, NameList: nameList, AttribList: attribList, ExpList: expList}
+}
+
+// attnamelist ::=  Name attrib {`,` Name attrib}
+// lua-5.4.6/src/lparser.c#localstat()
+func _finishAttNameList(lex *lexer.Lexer) (names, attribs []string) {
+	hasAttrib, hasClose := false, false
+	for {
+		_, name := lex.NextIdentifier() // Name
+		attrib := _parseAttrib(lex)     // attrib
+		if attrib == "close" {
+			if hasClose { /* one already present? */
+				panic(&lexer.SyntaxError{Line: lex.Line(),
+					Msg: "multiple to-be-closed variables in local list"})
+			}
+			hasClose = true
+		}
+		hasAttrib = hasAttrib || attrib != ""
+		names = append(names, name)
+		attribs = append(attribs, attrib)
+		if lex.LookAhead() != lexer.TOKEN_SEP_COMMA {
+			break
+		}
+		lex.NextToken() // `,`
+	}
+	if !hasAttrib {
+		attribs = nil
+	}
+	return
+}
+
+// attrib ::= [`<` Name `>`]
+// lua-5.4.6/src/lparser.c#getlocalattribute()
+func _parseAttrib(lex *lexer.Lexer) string {
+	if lex.LookAhead() != lexer.TOKEN_OP_LT {
+		return ""
+	}
+	lex.NextToken()                   // `<`
+	_, attrib := lex.NextIdentifier() // Name
+	lex.NextTokenOfKind(lexer.TOKEN_OP_GT)
+	if attrib != "const" && attrib != "close" {
+		panic(&lexer.SyntaxError{Line: lex.Line(),
+			Msg: fmt.Sprintf("unknown attribute '%s'", attrib)})
+	}
+	return attrib
 }
 
 func parseAssignOrFuncCallStat(lex *lexer.Lexer) ast.Stat {
 	prefixExp := parsePrefixExp(lex)
 	if fc, ok := prefixExp.(*ast.FuncCallExp); ok {
 		return fc
-	} else {
-		return parseAssignStat(lex, prefixExp)
 	}
+	if kind := lex.LookAhead(); kind != lexer.TOKEN_OP_ASSIGN && kind != lexer.TOKEN_SEP_COMMA {
+		lex.SyntaxError("syntax error")
+	}
+	return parseAssignStat(lex, prefixExp)
 }
 
 func parseAssignStat(lex *lexer.Lexer, var0 ast.Exp) *ast.AssignStat {
@@ -246,7 +298,7 @@ func _checkVar(lex *lexer.Lexer, exp ast.Exp) ast.Exp {
 	case *ast.NameExp, *ast.TableAccessExp:
 		return exp
 	}
-	lex.NextTokenOfKind(-1) // trigger error
+	lex.SyntaxError("syntax error")
 	panic("unreachable!")
 }
 
@@ -9,5 +9,6 @@ func Parse(chunk, chunkName string) *ast.Block {
 	lex := lexer.NewLexer(chunk, chunkName)
 	block := parseBlock(lex)
 	lex.NextTokenOfKind(lexer.TOKEN_EOF)
+	resolveLocalAttribs(block)
 	return block
 }
@@ -3,6 +3,7 @@ package parser
 import (
 	"encoding/json"
 	"lua_go/compiler/ast"
+	"lua_go/compiler/lexer"
 	"testing"
 )
 
@@ -52,3 +53,34 @@ func TestParser(t *testing.T) {
 		}
 	}
 }
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected lexer.SyntaxError
+		msg      string
+	}{
+		{"x = ", lexer.SyntaxError{ChunkName: "@t.lua", Line: 1, Column: 5, Token: "<eof>", Msg: "unexpected symbol"},
+			"t.lua:1: unexpected symbol near <eof>"},
+		{"local t = {1,\n  2 3}", lexer.SyntaxError{ChunkName: "@t.lua", Line: 2, Column: 5, Token: "3", Msg: "'}' expected"},
+			"t.lua:2: '}' expected near '3'"},
+		{"if x then\n\tprint('a\n')", lexer.SyntaxError{ChunkName: "@t.lua", Line: 2, Column: 8, Token: "'a", Msg: "unfinished string"},
+			"t.lua:2: unfinished string near ''a'"},
+		{"f(3x)", lexer.SyntaxError{ChunkName: "@t.lua", Line: 1, Column: 3, Token: "3x", Msg: "malformed number"},
+			"t.lua:1: malformed number near '3x'"},
+	}
+
+	for _, tt := range tests {
+		actual := func() (err *lexer.SyntaxError) {
+			defer func() { err, _ = recover().(*lexer.SyntaxError) }()
+			Parse(tt.chunk, "@t.lua")
+			return
+		}()
+		if actual == nil || *actual != tt.expected {
+			t.Fatalf("expected %+v got %+v", tt.expected, actual)
+		}
+		if actual.Error() != tt.msg {
+			t.Fatalf("expected %q got %q", tt.msg, actual.Error())
+		}
+	}
+}
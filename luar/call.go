@@ -0,0 +1,195 @@
+package luar
+
+import (
+	"errors"
+	"lua_go/api"
+	"reflect"
+	"runtime"
+	"sync"
+)
+
+// 没有具体的函数类型时Lua函数被转换为这个类型, 参数和返回值的个数都不固定
+var anyFuncType = reflect.TypeOf(func(...interface{}) []interface{} { return nil })
+
+// 把Go函数包装成Lua函数压入栈顶
+func pushFunc(ls api.LuaState, fn reflect.Value) {
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		return callFunc(ls, fn, 1)
+	})
+}
+
+// 用栈上first开始的参数调用fn, 把返回值压入栈, 返回返回值的个数.
+// 最后一个返回值是error时不压入栈, 非nil的error被转换为Lua错误
+func callFunc(ls api.LuaState, fn reflect.Value, first int) int {
+	t := fn.Type()
+	nIn := t.NumIn()
+	if t.IsVariadic() {
+		nIn--
+	}
+	args := make([]reflect.Value, 0, nIn)
+	for i := 0; i < nIn; i++ {
+		args = append(args, checkArg(ls, first+i, t.In(i)))
+	}
+	if t.IsVariadic() {
+		et := t.In(nIn).Elem()
+		for arg := first + nIn; arg <= ls.GetTop(); arg++ {
+			args = append(args, checkArg(ls, arg, et))
+		}
+	}
+
+	results := fn.Call(args)
+	if n := len(results); n > 0 && t.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			return ls.Error2("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+	ls.CheckStack2(len(results), "too many results")
+	for _, r := range results {
+		pushValue(ls, r)
+	}
+	return len(results)
+}
+
+func checkArg(ls api.LuaState, arg int, t reflect.Type) reflect.Value {
+	v, err := toValue(ls, arg, t, 0)
+	if err != nil {
+		ls.ArgError(arg, err.Error())
+	}
+	return v
+}
+
+// 引用队列在注册表中的名字
+const REF_QUEUE = "luar.refs"
+
+// 转换得到的Go函数被回收后要释放的注册表引用.
+// 终结器在另外的goroutine中执行, 不能操作状态, 所以只把引用放进队列,
+// 下次转换或者调用Lua函数时再由使用状态的goroutine释放
+type refQueue struct {
+	mu   sync.Mutex
+	refs []int
+}
+
+func (q *refQueue) push(ref int) {
+	q.mu.Lock()
+	q.refs = append(q.refs, ref)
+	q.mu.Unlock()
+}
+
+// 释放队列中所有的引用
+func (q *refQueue) release(ls api.LuaState) {
+	q.mu.Lock()
+	refs := q.refs
+	q.refs = nil
+	q.mu.Unlock()
+	for _, ref := range refs {
+		ls.Unref(api.LUA_REGISTRYINDEX, ref)
+	}
+}
+
+// 返回状态的引用队列, 第一次使用时创建
+func getRefQueue(ls api.LuaState) *refQueue {
+	if ls.GetField(api.LUA_REGISTRYINDEX, REF_QUEUE) == api.LUA_TLIGHTUSERDATA {
+		q := ls.ToUserdata(-1).(*refQueue)
+		ls.Pop(1)
+		return q
+	}
+	ls.Pop(1)
+	q := &refQueue{}
+	ls.PushLightUserdata(q)
+	ls.SetField(api.LUA_REGISTRYINDEX, REF_QUEUE)
+	return q
+}
+
+// Go函数持有的Lua函数的引用, 它被回收时引用进入队列
+type funcRef struct {
+	ref int
+}
+
+// 把idx处的Lua函数转换为t类型的Go函数.
+// Lua函数保存在注册表中, Go函数被回收后引用才会被释放.
+// 函数类型的最后一个返回值是error时在保护模式下调用Lua函数, 错误通过error返回,
+// 否则Lua错误会以panic的形式传播给调用者
+func makeFunc(ls api.LuaState, idx int, t reflect.Type) reflect.Value {
+	idx = ls.AbsIndex(idx)
+	q := getRefQueue(ls)
+	q.release(ls)
+	ls.PushValue(idx)
+	fr := &funcRef{ls.Ref(api.LUA_REGISTRYINDEX)}
+	runtime.SetFinalizer(fr, func(fr *funcRef) { q.push(fr.ref) })
+	nOut := t.NumOut()
+	withErr := nOut > 0 && t.Out(nOut-1) == errorType
+	if withErr {
+		nOut--
+	}
+
+	return reflect.MakeFunc(t, func(args []reflect.Value) []reflect.Value {
+		q.release(ls)
+		top := ls.GetTop()
+		ls.RawGetI(api.LUA_REGISTRYINDEX, int64(fr.ref))
+		if t.IsVariadic() { /* expand the variadic arguments */
+			last := args[len(args)-1]
+			args = args[:len(args)-1]
+			for i := 0; i < last.Len(); i++ {
+				args = append(args, last.Index(i))
+			}
+		}
+		ls.CheckStack2(len(args), "too many arguments")
+		for _, arg := range args {
+			pushValue(ls, arg)
+		}
+
+		nResults := nOut
+		if t == anyFuncType {
+			nResults = api.LUA_MULTRET
+		}
+		results := make([]reflect.Value, 0, t.NumOut())
+		var err error
+		if withErr {
+			if ls.PCall(len(args), nResults, 0) != api.LUA_OK {
+				err = errors.New(ls.ToString2(-1))
+			}
+		} else {
+			ls.Call(len(args), nResults)
+		}
+		if err == nil && t == anyFuncType {
+			var rets []interface{}
+			for i := top + 1; i <= ls.GetTop(); i++ {
+				x, e := toNatural(ls, i, 0)
+				if e != nil {
+					ls.Error2("%s", e.Error())
+				}
+				rets = append(rets, x)
+			}
+			results = append(results, reflect.ValueOf(rets))
+		} else if err == nil {
+			for i := 0; i < nOut; i++ {
+				x, e := toValue(ls, top+1+i, t.Out(i), 0)
+				if e != nil {
+					if !withErr {
+						ls.Error2("result #%d: %s", i+1, e.Error())
+					}
+					err = e
+					break
+				}
+				results = append(results, x)
+			}
+		}
+		ls.SetTop(top)
+
+		if err != nil { /* zero values and the error */
+			results = results[:0]
+			for i := 0; i < nOut; i++ {
+				results = append(results, reflect.Zero(t.Out(i)))
+			}
+		}
+		if withErr {
+			errValue := reflect.Zero(errorType)
+			if err != nil {
+				errValue = reflect.ValueOf(&err).Elem()
+			}
+			results = append(results, errValue)
+		}
+		return results
+	})
+}
@@ -0,0 +1,248 @@
+package luar
+
+import (
+	"fmt"
+	"lua_go/api"
+	"reflect"
+)
+
+const MAX_DEPTH = 100 // 转换嵌套的表时的最大深度, 防止表中有环
+
+// 把idx处的Lua值转换为t类型的Go值
+func toValue(ls api.LuaState, idx int, t reflect.Type, depth int) (reflect.Value, error) {
+	idx = ls.AbsIndex(idx)
+	tp := ls.Type(idx)
+	if depth > MAX_DEPTH {
+		return reflect.Value{}, fmt.Errorf("nesting too deep (possibly a cycle)")
+	}
+	if !ls.CheckStack(3) { /* key, value and a copy */
+		return reflect.Value{}, fmt.Errorf("stack overflow")
+	}
+	if tp == api.LUA_TUSERDATA {
+		if v, ok := proxyValue(ls, idx); ok {
+			if v.Type().AssignableTo(t) {
+				return v, nil
+			}
+			if v.Kind() == reflect.Ptr && v.Elem().Type().AssignableTo(t) {
+				return v.Elem(), nil
+			}
+		}
+	}
+	if tp == api.LUA_TNIL || tp == api.LUA_TNONE {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface, reflect.Func, reflect.Chan:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, typeError(ls, idx, t)
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if tp == api.LUA_TBOOLEAN {
+			v.SetBool(ls.ToBoolean(idx))
+			return v, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if tp == api.LUA_TNUMBER || tp == api.LUA_TSTRING {
+			i, err := toInteger(ls, idx, t)
+			if err == nil && v.OverflowInt(i) {
+				err = fmt.Errorf("number out of range for %s", t)
+			}
+			v.SetInt(i)
+			return v, err
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if tp == api.LUA_TNUMBER || tp == api.LUA_TSTRING {
+			i, err := toInteger(ls, idx, t)
+			if err == nil && (i < 0 || v.OverflowUint(uint64(i))) {
+				err = fmt.Errorf("number out of range for %s", t)
+			}
+			v.SetUint(uint64(i))
+			return v, err
+		}
+	case reflect.Float32, reflect.Float64:
+		if f, ok := ls.ToNumberX(idx); ok {
+			v.SetFloat(f)
+			return v, nil
+		}
+	case reflect.String:
+		if tp == api.LUA_TSTRING || tp == api.LUA_TNUMBER {
+			v.SetString(toString(ls, idx))
+			return v, nil
+		}
+	case reflect.Slice:
+		if tp == api.LUA_TSTRING && t.Elem().Kind() == reflect.Uint8 {
+			return reflect.ValueOf([]byte(ls.ToString(idx))).Convert(t), nil
+		}
+		if tp == api.LUA_TTABLE {
+			n := int(ls.RawLen(idx))
+			v.Set(reflect.MakeSlice(t, n, n))
+			return v, toElems(ls, idx, v, depth)
+		}
+	case reflect.Array:
+		if tp == api.LUA_TTABLE {
+			return v, toElems(ls, idx, v, depth)
+		}
+	case reflect.Map:
+		if tp == api.LUA_TTABLE {
+			v.Set(reflect.MakeMap(t))
+			return v, toMap(ls, idx, v, depth)
+		}
+	case reflect.Struct:
+		if tp == api.LUA_TTABLE {
+			return v, toStruct(ls, idx, v, depth)
+		}
+	case reflect.Ptr:
+		x, err := toValue(ls, idx, t.Elem(), depth+1)
+		if err != nil {
+			return v, err
+		}
+		return copyValue(x), nil
+	case reflect.Interface:
+		if t.NumMethod() == 0 {
+			x, err := toNatural(ls, idx, depth)
+			if x != nil {
+				v.Set(reflect.ValueOf(x))
+			}
+			return v, err
+		}
+	case reflect.Func:
+		if tp == api.LUA_TFUNCTION {
+			return makeFunc(ls, idx, t), nil
+		}
+	}
+	return reflect.Value{}, typeError(ls, idx, t)
+}
+
+func typeError(ls api.LuaState, idx int, t reflect.Type) error {
+	return fmt.Errorf("%s expected, got %s", t, ls.TypeName2(idx))
+}
+
+func toInteger(ls api.LuaState, idx int, t reflect.Type) (int64, error) {
+	if i, ok := ls.ToIntegerX(idx); ok {
+		return i, nil
+	}
+	if ls.IsNumber(idx) {
+		return 0, fmt.Errorf("number has no integer representation")
+	}
+	return 0, typeError(ls, idx, t)
+}
+
+// 和ToString不同, 不会把栈上的数字转换为字符串
+func toString(ls api.LuaState, idx int) string {
+	ls.PushValue(idx)
+	s := ls.ToString(-1)
+	ls.Pop(1)
+	return s
+}
+
+// 把表的1..n元素转换为切片或数组的元素
+func toElems(ls api.LuaState, idx int, v reflect.Value, depth int) error {
+	for i := 0; i < v.Len(); i++ {
+		ls.RawGetI(idx, int64(i+1))
+		x, err := toValue(ls, -1, v.Type().Elem(), depth+1)
+		ls.Pop(1)
+		if err != nil {
+			return fmt.Errorf("element %d: %s", i+1, err)
+		}
+		v.Index(i).Set(x)
+	}
+	return nil
+}
+
+func toMap(ls api.LuaState, idx int, v reflect.Value, depth int) error {
+	t := v.Type()
+	ls.PushNil()
+	for ls.Next(idx) {
+		k, err := toValue(ls, -2, t.Key(), depth+1)
+		if err != nil {
+			ls.Pop(2)
+			return fmt.Errorf("key: %s", err)
+		}
+		x, err := toValue(ls, -1, t.Elem(), depth+1)
+		if err != nil {
+			ls.Pop(2)
+			return fmt.Errorf("field '%v': %s", k, err)
+		}
+		v.SetMapIndex(k, x)
+		ls.Pop(1)
+	}
+	return nil
+}
+
+// 表中和导出字段同名(或者和lua标签同名)的值被转换为字段的值
+func toStruct(ls api.LuaState, idx int, v reflect.Value, depth int) error {
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := fieldName(f)
+		if ls.GetField(idx, name) != api.LUA_TNIL {
+			x, err := toValue(ls, -1, f.Type, depth+1)
+			if err != nil {
+				ls.Pop(1)
+				return fmt.Errorf("field '%s': %s", name, err)
+			}
+			v.FieldByIndex(f.Index).Set(x)
+		}
+		ls.Pop(1)
+	}
+	return nil
+}
+
+// 没有目标类型时的转换: 数字转换为int64或float64; 序列转换为[]interface{},
+// 其他的表转换为map[string]interface{}或map[interface{}]interface{};
+// 函数转换为func(...interface{}) []interface{}
+func toNatural(ls api.LuaState, idx int, depth int) (interface{}, error) {
+	switch ls.Type(idx) {
+	case api.LUA_TNIL, api.LUA_TNONE:
+		return nil, nil
+	case api.LUA_TBOOLEAN:
+		return ls.ToBoolean(idx), nil
+	case api.LUA_TNUMBER:
+		if ls.IsInteger(idx) {
+			return ls.ToInteger(idx), nil
+		}
+		return ls.ToNumber(idx), nil
+	case api.LUA_TSTRING:
+		return ls.ToString(idx), nil
+	case api.LUA_TTABLE:
+		return tableToNatural(ls, idx, depth)
+	case api.LUA_TFUNCTION:
+		return makeFunc(ls, idx, anyFuncType).Interface(), nil
+	case api.LUA_TUSERDATA:
+		if v, ok := proxyValue(ls, idx); ok {
+			return v.Interface(), nil
+		}
+		return ls.ToUserdata(idx), nil
+	case api.LUA_TLIGHTUSERDATA:
+		return ls.ToUserdata(idx), nil
+	default:
+		return ls.ToThread(idx), nil
+	}
+}
+
+func tableToNatural(ls api.LuaState, idx int, depth int) (interface{}, error) {
+	n, count, allStrings := int(ls.RawLen(idx)), 0, true
+	ls.PushNil()
+	for ls.Next(idx) {
+		count++
+		allStrings = allStrings && ls.Type(-2) == api.LUA_TSTRING
+		ls.Pop(1)
+	}
+	var t reflect.Type
+	switch {
+	case n > 0 && n == count: /* a sequence */
+		t = reflect.TypeOf([]interface{}(nil))
+	case allStrings:
+		t = reflect.TypeOf(map[string]interface{}(nil))
+	default:
+		t = reflect.TypeOf(map[interface{}]interface{}(nil))
+	}
+	v, err := toValue(ls, idx, t, depth+1)
+	if err != nil {
+		return nil, err
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,123 @@
+// Package luar 用反射在Go值和Lua值之间转换.
+//
+// 布尔值, 数字和字符串被转换为对应的Lua值; 函数被包装成Lua函数, 调用时自动转换参数和返回值,
+// 最后一个返回值是error时, 非nil的error被转换为Lua错误; 结构体和指针被转换为userdata,
+// 可以读写导出的字段和调用方法; 切片, 数组和map被转换为代理userdata, 支持索引, 赋值, #和pairs.
+// 反过来, Lua的表可以被转换为Go的结构体, map和切片, Lua函数可以被转换为Go函数.
+package luar
+
+import (
+	"errors"
+	"lua_go/api"
+	"reflect"
+)
+
+// 把Go值v转换为Lua值并压入栈顶
+// [-0, +1, m]
+func Push(ls api.LuaState, v interface{}) {
+	if v == nil {
+		ls.PushNil()
+		return
+	}
+	pushValue(ls, reflect.ValueOf(v))
+}
+
+// 把索引处的Lua值转换为Go值, 保存到ptr指向的变量中
+// [-0, +0, m]
+func To(ls api.LuaState, idx int, ptr interface{}) error {
+	p := reflect.ValueOf(ptr)
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return errors.New("luar: To needs a non-nil pointer")
+	}
+	v, err := toValue(ls, idx, p.Elem().Type(), 0)
+	if err != nil {
+		return err
+	}
+	p.Elem().Set(v)
+	return nil
+}
+
+// 把values中的Go值设置为全局变量;
+// table不为空时设置为全局表table的字段, 全局表不存在时会被创建
+// [-0, +0, m]
+func Register(ls api.LuaState, table string, values map[string]interface{}) {
+	if table == "" {
+		ls.PushGlobalTable()
+	} else if ls.GetGlobal(table) != api.LUA_TTABLE {
+		ls.Pop(1)
+		ls.CreateTable(0, len(values))
+		ls.PushValue(-1)
+		ls.SetGlobal(table)
+	}
+	for name, v := range values {
+		Push(ls, v)
+		ls.SetField(-2, name)
+	}
+	ls.Pop(1)
+}
+
+var (
+	errorType      = reflect.TypeOf((*error)(nil)).Elem()
+	goFunctionType = reflect.TypeOf(api.GoFunction(nil))
+)
+
+func pushValue(ls api.LuaState, v reflect.Value) {
+	if !v.IsValid() {
+		ls.PushNil()
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		ls.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		ls.PushInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		ls.PushInteger(int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		ls.PushNumber(v.Float())
+	case reflect.String:
+		ls.PushString(v.String())
+	case reflect.Interface:
+		pushValue(ls, v.Elem())
+	case reflect.Func:
+		if v.IsNil() {
+			ls.PushNil()
+		} else if v.Type().ConvertibleTo(goFunctionType) {
+			ls.PushGoFunction(v.Convert(goFunctionType).Interface().(api.GoFunction))
+		} else {
+			pushFunc(ls, v)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			ls.PushNil()
+			return
+		}
+		switch v.Elem().Kind() {
+		case reflect.Struct:
+			pushProxy(ls, v, STRUCT_META)
+		case reflect.Slice, reflect.Array:
+			pushProxy(ls, v, SLICE_META)
+		default:
+			pushProxy(ls, v, OBJECT_META)
+		}
+	case reflect.Struct: /* copy the value so that its fields can be set */
+		pushProxy(ls, copyValue(v), STRUCT_META)
+	case reflect.Slice, reflect.Array:
+		pushProxy(ls, copyValue(v), SLICE_META)
+	case reflect.Map:
+		if v.IsNil() {
+			ls.PushNil()
+		} else {
+			pushProxy(ls, v, MAP_META)
+		}
+	default: /* chan, complex, unsafe pointer */
+		pushProxy(ls, v, OBJECT_META)
+	}
+}
+
+// 返回指向v的副本的指针
+func copyValue(v reflect.Value) reflect.Value {
+	p := reflect.New(v.Type())
+	p.Elem().Set(v)
+	return p
+}
@@ -0,0 +1,216 @@
+package luar
+
+import (
+	"errors"
+	"fmt"
+	"lua_go/api"
+	"lua_go/state"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+type point struct {
+	X, Y int
+}
+
+func (p *point) Move(dx, dy int) { p.X += dx; p.Y += dy }
+func (p point) String() string   { return fmt.Sprintf("(%d, %d)", p.X, p.Y) }
+
+type base struct {
+	ID int `lua:"id"`
+}
+
+type shape struct {
+	base
+	Name   string
+	Points []point
+	Tags   map[string]int
+	secret int
+}
+
+func (s *shape) Count(kinds ...string) int { return len(s.Points) + len(kinds) }
+
+// 在ls上执行chunk, 返回第一个返回值或者错误信息
+func doString(t *testing.T, ls api.LuaState, chunk string) string {
+	if ls.LoadString(chunk) != api.LUA_OK {
+		t.Fatalf("%s", ls.ToString(-1))
+	}
+	ls.PCall(0, 1, 0)
+	defer ls.SetTop(ls.GetTop() - 1)
+	if ls.IsBoolean(-1) {
+		return fmt.Sprint(ls.ToBoolean(-1))
+	}
+	return ls.ToString2(-1) /* pushes the string */
+}
+
+func TestPush(t *testing.T) {
+	sh := &shape{base: base{ID: 7}, Name: "tri", Points: []point{{1, 2}, {3, 4}}, Tags: map[string]int{"a": 1}}
+	ls := state.New()
+	ls.OpenLibs()
+	Register(ls, "", map[string]interface{}{
+		"sh":   sh,
+		"nums": []int{10, 20, 30},
+		"arr":  [2]string{"x", "y"},
+		"add":  func(a, b int) int { return a + b },
+		"join": func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"div": func(a, b float64) (float64, error) {
+			if b == 0 {
+				return 0, errors.New("division by zero")
+			}
+			return a / b, nil
+		},
+		"swap":   func(a, b string) (string, string) { return b, a },
+		"pt":     point{5, 6},
+		"nilptr": (*point)(nil),
+	})
+	Register(ls, "gomath", map[string]interface{}{"pi": 3.5})
+
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`return add(1, 2)`, "3"},
+		{`return select(2, pcall(add, 1))`, "bad argument #2 to 'add' (int expected, got no value)"},
+		{`return select(2, pcall(add, 1, 2.5))`, "number has no integer representation"},
+		{`return join("-", "a", "b", "c") .. join(",")`, "a-b-c"},
+		{`return div(1, 4)`, "0.25"},
+		{`return select(2, pcall(div, 1, 0))`, "division by zero"},
+		{`local a, b = swap("x", "y") return a .. b`, "yx"},
+		{`return gomath.pi`, "3.5"},
+		{`return sh.Name .. sh.id .. #sh.Points .. sh.Tags.a`, "tri72" + "1"},
+		{`sh.Name = "quad" sh.id = 9 return sh.Name`, "quad"},
+		{`return select(2, pcall(function() sh.Name = {} end))`, "field 'Name': string expected, got table"},
+		{`return select(2, pcall(function() sh.secret = 1 end))`, "no field 'secret'"},
+		{`return tostring(sh.secret)`, "nil"},
+		{`sh.Points[1]:Move(10, 10) return tostring(sh.Points[1])`, "(11, 12)"},
+		{`sh.Points[3] = {X = 1, Y = 1} return #sh.Points`, "3"},
+		{`return select(2, pcall(function() sh.Points[5] = {} end))`, "out of range"},
+		{`return sh:Count("a", "b")`, "5"},
+		{`return select(2, pcall(sh.Count, 1))`, "Go object expected"},
+		{`local s = 0 for i, v in pairs(nums) do s = s + i * v end return s`, "140"},
+		{`nums[1] = 5 return nums[1] .. #nums .. tostring(nums[0])`, "53nil"},
+		{`return arr[2] .. #arr`, "y2"},
+		{`sh.Tags.b = 2 sh.Tags.a = nil local n = 0 for k, v in pairs(sh.Tags) do n = n + v end return n .. #sh.Tags`, "21"},
+		{`return tostring(pt) .. tostring(nilptr)`, "(5, 6)nil"},
+		{`pt:Move(1, 1) return pt.X`, "6"},
+		{`return sh == sh and sh.Points ~= nums`, "true"},
+	}
+
+	for _, tt := range tests {
+		if actual := doString(t, ls, tt.chunk); !strings.Contains(actual, tt.expected) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+	if sh.Name != "quad" || sh.ID != 9 || len(sh.Points) != 3 || sh.Points[0].X != 11 || sh.Tags["b"] != 2 {
+		t.Fatalf("changes not written through: %+v", sh)
+	}
+}
+
+func TestTo(t *testing.T) {
+	type config struct {
+		Name    string
+		Port    uint16 `lua:"port"`
+		Hosts   []string
+		Limits  map[string]float64
+		Parent  *config
+		Handler func(int) (int, error)
+	}
+	ls := state.New()
+	ls.OpenLibs()
+
+	if ls.LoadString(`return {
+		Name = "srv", port = 8080, Hosts = {"a", "b"}, Limits = {cpu = 0.5},
+		Parent = {Name = "root"}, Handler = function(x) if x < 0 then error("negative") end return x * 2 end,
+	}`) != api.LUA_OK || ls.PCall(0, 1, 0) != api.LUA_OK {
+		t.Fatalf("%s", ls.ToString(-1))
+	}
+	var c config
+	if err := To(ls, -1, &c); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if c.Name != "srv" || c.Port != 8080 || !reflect.DeepEqual(c.Hosts, []string{"a", "b"}) ||
+		c.Limits["cpu"] != 0.5 || c.Parent == nil || c.Parent.Name != "root" {
+		t.Fatalf("unexpected %+v", c)
+	}
+	if r, err := c.Handler(21); r != 42 || err != nil {
+		t.Fatalf("expected 42 got %d, %v", r, err)
+	}
+	if _, err := c.Handler(-1); err == nil || !strings.Contains(err.Error(), "negative") {
+		t.Fatalf("expected an error got %v", err)
+	}
+	ls.Pop(1)
+
+	tests := []struct {
+		chunk    string
+		ptr      interface{}
+		expected interface{}
+	}{
+		{`return {1, "two", {x = true}}`, new(interface{}),
+			[]interface{}{int64(1), "two", map[string]interface{}{"x": true}}},
+		{`return {[1] = "a", [3] = "c"}`, new(map[int]string), map[int]string{1: "a", 3: "c"}},
+		{`return "bytes"`, new([]byte), []byte("bytes")},
+		{`return 42`, new(string), "42"},
+		{`return 300`, new(uint8), "number out of range for uint8"},
+		{`return {1, "x"}`, new([]int), "element 2: int expected, got string"},
+		{`local t = {} t.self = t return t`, new(interface{}), "nesting too deep"},
+	}
+	for _, tt := range tests {
+		if ls.LoadString(tt.chunk) != api.LUA_OK || ls.PCall(0, 1, 0) != api.LUA_OK {
+			t.Fatalf("%s", ls.ToString(-1))
+		}
+		err := To(ls, -1, tt.ptr)
+		ls.Pop(1)
+		if msg, ok := tt.expected.(string); ok && err != nil {
+			if !strings.Contains(err.Error(), msg) {
+				t.Fatalf("%s: expected %q got %q", tt.chunk, msg, err)
+			}
+		} else if actual := reflect.ValueOf(tt.ptr).Elem().Interface(); err != nil || !reflect.DeepEqual(actual, tt.expected) {
+			t.Fatalf("%s: expected %#v got %#v (%v)", tt.chunk, tt.expected, actual, err)
+		}
+	}
+
+	ls.DoString(`function sum(...) local s = 0 for _, v in ipairs({...}) do s = s + v end return s, "done" end`)
+	ls.GetGlobal("sum")
+	var sum func(...interface{}) []interface{}
+	if err := To(ls, -1, &sum); err != nil {
+		t.Fatalf("%v", err)
+	}
+	if r := sum(1, 2, 3.5); !reflect.DeepEqual(r, []interface{}{6.5, "done"}) {
+		t.Fatalf("expected [6.5 done] got %v", r)
+	}
+	if ls.GetTop() != 1 {
+		t.Fatalf("unbalanced stack: %d", ls.GetTop())
+	}
+}
+
+func TestFuncRelease(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	doString(t, ls, `local t = setmetatable({}, {__gc = function() collected = true end})
+		f = function() return t end`)
+	ls.GetGlobal("f")
+	var f func() interface{}
+	if err := To(ls, -1, &f); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ls.Pop(1)
+	f()
+	f = nil
+	doString(t, ls, `f = nil`)
+
+	var g func()
+	for i := 0; i < 100 && doString(t, ls, `return collected`) != "true"; i++ {
+		runtime.GC() /* queues the reference held by f */
+		time.Sleep(time.Millisecond)
+		ls.GetGlobal("print")
+		To(ls, -1, &g) /* releases the queued references */
+		ls.Pop(1)
+		ls.GC(api.LUA_GCCOLLECT, 0)
+	}
+	if actual := doString(t, ls, `return collected`); actual != "true" {
+		t.Fatalf("expected the Lua function to be collected")
+	}
+}
@@ -0,0 +1,343 @@
+package luar
+
+import (
+	"fmt"
+	"lua_go/api"
+	"reflect"
+	"sync"
+)
+
+// 代理userdata的元表在注册表中的名字
+const (
+	STRUCT_META = "luar.struct" // 指向结构体的指针
+	SLICE_META  = "luar.slice"  // 指向切片或数组的指针
+	MAP_META    = "luar.map"
+	OBJECT_META = "luar.object" // 其他值, 只能调用方法
+)
+
+var proxyMetas = []string{STRUCT_META, SLICE_META, MAP_META, OBJECT_META}
+
+// 把v包装成userdata压入栈顶, userdata的元表是tname
+func pushProxy(ls api.LuaState, v reflect.Value, tname string) {
+	ls.NewUserdata(v.Interface())
+	if ls.NewMetatable(tname) {
+		ls.SetFuncs(metamethods(tname), 0)
+	}
+	ls.SetMetatable(-2)
+}
+
+func metamethods(tname string) api.FuncReg {
+	funcs := api.FuncReg{
+		"__index":    objectIndex,
+		"__tostring": proxyToString,
+		"__eq":       proxyEq,
+	}
+	switch tname {
+	case STRUCT_META:
+		funcs["__index"] = structIndex
+		funcs["__newindex"] = structNewIndex
+	case SLICE_META:
+		funcs["__index"] = sliceIndex
+		funcs["__newindex"] = sliceNewIndex
+		funcs["__len"] = proxyLen
+		funcs["__pairs"] = slicePairs
+	case MAP_META:
+		funcs["__index"] = mapIndex
+		funcs["__newindex"] = mapNewIndex
+		funcs["__len"] = proxyLen
+		funcs["__pairs"] = mapPairs
+	}
+	return funcs
+}
+
+// 如果idx处是代理userdata, 返回它包装的值
+func proxyValue(ls api.LuaState, idx int) (reflect.Value, bool) {
+	for _, tname := range proxyMetas {
+		if data := ls.TestUdata(idx, tname); data != nil {
+			return reflect.ValueOf(data), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func checkProxy(ls api.LuaState, arg int) reflect.Value {
+	v, ok := proxyValue(ls, arg)
+	if !ok {
+		ls.ArgError(arg, "Go object expected, got "+ls.TypeName2(arg))
+	}
+	return v
+}
+
+/* fields */
+
+var fieldCache sync.Map // reflect.Type -> map[string]reflect.StructField
+
+// 字段名是lua标签的值, 没有标签时是Go的字段名
+func fieldName(f reflect.StructField) string {
+	if name := f.Tag.Get("lua"); name != "" {
+		return name
+	}
+	return f.Name
+}
+
+// 查找结构体类型t中导出的字段, 包括嵌入的结构体提升的字段
+func findField(t reflect.Type, name string) (reflect.StructField, bool) {
+	fields, ok := fieldCache.Load(t)
+	if !ok {
+		m := map[string]reflect.StructField{}
+		for _, f := range reflect.VisibleFields(t) {
+			if f.IsExported() && f.Tag.Get("lua") != "-" {
+				m[fieldName(f)] = f
+			}
+		}
+		fields, _ = fieldCache.LoadOrStore(t, m)
+	}
+	f, ok := fields.(map[string]reflect.StructField)[name]
+	return f, ok
+}
+
+// 和v.FieldByIndex一样, 但是alloc为true时会为路径上为nil的嵌入指针分配空间,
+// 否则遇到nil指针时返回无效值
+func fieldByIndex(v reflect.Value, index []int, alloc bool) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// 结构体和数组保持可寻址, 这样通过Lua对它们的修改会作用到原来的值上
+func pushElem(ls api.LuaState, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Struct, reflect.Array, reflect.Slice:
+		if v.CanAddr() {
+			pushValue(ls, v.Addr())
+			return
+		}
+	}
+	pushValue(ls, v)
+}
+
+/* methods */
+
+// 查找名为name的方法, 找到时压入一个用冒号调用的函数
+func pushMethod(ls api.LuaState, v reflect.Value, name string) int {
+	if _, ok := v.Type().MethodByName(name); ok {
+		ls.PushString(name)
+		ls.PushGoClosure(callMethod, 1)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+func callMethod(ls api.LuaState) int {
+	name := ls.ToString(api.LuaUpvalueIndex(1))
+	m := checkProxy(ls, 1).MethodByName(name)
+	if !m.IsValid() {
+		return ls.ArgError(1, fmt.Sprintf("no method '%s'", name))
+	}
+	return callFunc(ls, m, 2)
+}
+
+/* metamethods */
+
+func objectIndex(ls api.LuaState) int {
+	v := checkProxy(ls, 1)
+	return pushMethod(ls, v, ls.CheckString(2))
+}
+
+func structIndex(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, STRUCT_META))
+	name := ls.CheckString(2)
+	if f, ok := findField(v.Type().Elem(), name); ok {
+		if fv := fieldByIndex(v.Elem(), f.Index, false); fv.IsValid() {
+			pushElem(ls, fv)
+		} else {
+			ls.PushNil()
+		}
+		return 1
+	}
+	return pushMethod(ls, v, name)
+}
+
+func structNewIndex(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, STRUCT_META))
+	name := ls.CheckString(2)
+	f, ok := findField(v.Type().Elem(), name)
+	if !ok {
+		return ls.Error2("no field '%s' in %s", name, v.Type().Elem())
+	}
+	x, err := toValue(ls, 3, f.Type, 0)
+	if err != nil {
+		return ls.Error2("field '%s': %s", name, err.Error())
+	}
+	fieldByIndex(v.Elem(), f.Index, true).Set(x)
+	return 0
+}
+
+// 只有数字键才是下标, 数组下标从1开始
+func sliceIndex(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, SLICE_META))
+	if ls.Type(2) == api.LUA_TNUMBER {
+		if i, ok := ls.ToIntegerX(2); ok && i >= 1 && i <= int64(v.Elem().Len()) {
+			pushElem(ls, v.Elem().Index(int(i-1)))
+			return 1
+		}
+	} else if ls.Type(2) == api.LUA_TSTRING {
+		return pushMethod(ls, v, ls.ToString(2))
+	}
+	ls.PushNil()
+	return 1
+}
+
+// t[#t+1] = v 向切片追加元素
+func sliceNewIndex(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, SLICE_META)).Elem()
+	i, ok := ls.ToIntegerX(2)
+	if ls.Type(2) != api.LUA_TNUMBER || !ok {
+		return ls.Error2("invalid index to %s (number expected, got %s)", v.Type(), ls.TypeName2(2))
+	}
+	x, err := toValue(ls, 3, v.Type().Elem(), 0)
+	if err != nil {
+		return ls.Error2("element %d: %s", i, err.Error())
+	}
+	n := int64(v.Len())
+	switch {
+	case i >= 1 && i <= n:
+		v.Index(int(i - 1)).Set(x)
+	case i == n+1 && v.Kind() == reflect.Slice:
+		v.Set(reflect.Append(v, x))
+	default:
+		return ls.Error2("index %d out of range [1, %d]", i, n)
+	}
+	return 0
+}
+
+func proxyLen(ls api.LuaState) int {
+	v := checkProxy(ls, 1)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	ls.PushInteger(int64(v.Len()))
+	return 1
+}
+
+func slicePairs(ls api.LuaState) int {
+	ls.CheckUdata(1, SLICE_META)
+	ls.PushGoFunction(sliceNext) /* iteration function */
+	ls.PushValue(1)              /* state */
+	ls.PushInteger(0)            /* initial value */
+	return 3
+}
+
+func sliceNext(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, SLICE_META)).Elem()
+	i := ls.CheckInteger(2) + 1
+	if i < 1 || i > int64(v.Len()) {
+		ls.PushNil()
+		return 1
+	}
+	ls.PushInteger(i)
+	pushElem(ls, v.Index(int(i-1)))
+	return 2
+}
+
+// 转换失败的键在map中不存在
+func mapIndex(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, MAP_META))
+	if k, err := toValue(ls, 2, v.Type().Key(), 0); err == nil {
+		if x := v.MapIndex(k); x.IsValid() {
+			pushValue(ls, x)
+			return 1
+		}
+	}
+	if ls.Type(2) == api.LUA_TSTRING {
+		return pushMethod(ls, v, ls.ToString(2))
+	}
+	ls.PushNil()
+	return 1
+}
+
+// 赋值为nil会删除键
+func mapNewIndex(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, MAP_META))
+	k, err := toValue(ls, 2, v.Type().Key(), 0)
+	if err != nil {
+		return ls.Error2("invalid key to %s (%s)", v.Type(), err.Error())
+	}
+	if ls.IsNil(3) {
+		v.SetMapIndex(k, reflect.Value{})
+		return 0
+	}
+	x, err := toValue(ls, 3, v.Type().Elem(), 0)
+	if err != nil {
+		return ls.Error2("field '%v': %s", k, err.Error())
+	}
+	v.SetMapIndex(k, x)
+	return 0
+}
+
+// 迭代开始时取得键的快照, 迭代中删除的键会被跳过
+func mapPairs(ls api.LuaState) int {
+	v := reflect.ValueOf(ls.CheckUdata(1, MAP_META))
+	keys, i := v.MapKeys(), 0
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		for i < len(keys) {
+			k := keys[i]
+			i++
+			if x := v.MapIndex(k); x.IsValid() {
+				pushValue(ls, k)
+				pushValue(ls, x)
+				return 2
+			}
+		}
+		ls.PushNil()
+		return 1
+	})
+	ls.PushValue(1)
+	ls.PushNil()
+	return 3
+}
+
+// 实现了fmt.Stringer或error的值使用它们的字符串表示
+func proxyToString(ls api.LuaState) int {
+	data := checkProxy(ls, 1).Interface()
+	switch x := data.(type) {
+	case fmt.Stringer:
+		ls.PushString(x.String())
+	case error:
+		ls.PushString(x.Error())
+	default:
+		if v := reflect.ValueOf(data); v.Kind() == reflect.Ptr {
+			data = v.Elem().Interface()
+		}
+		ls.PushString(fmt.Sprint(data))
+	}
+	return 1
+}
+
+// 指针, map等引用类型指向同一个对象时相等
+func proxyEq(ls api.LuaState) int {
+	a, okA := proxyValue(ls, 1)
+	b, okB := proxyValue(ls, 2)
+	eq := false
+	if okA && okB && a.Type() == b.Type() {
+		switch a.Kind() {
+		case reflect.Ptr, reflect.Map, reflect.Chan, reflect.Func, reflect.UnsafePointer:
+			eq = a.Pointer() == b.Pointer()
+		default:
+			eq = a.Type().Comparable() && a.Interface() == b.Interface()
+		}
+	}
+	ls.PushBoolean(eq)
+	return 1
+}
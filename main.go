@@ -1,15 +1,506 @@
 package main
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"lua_go/api"
 	"lua_go/state"
 	"os"
+	"strings"
 )
 
+const (
+	LUA_PROGNAME       = "lua"
+	LUA_PROMPT         = "> "
+	LUA_PROMPT2        = ">> "
+	LUA_INIT_VAR       = "LUA_INIT"
+	LUA_INITVARVERSION = LUA_INIT_VAR + "_5_3"
+	LUA_COPYRIGHT      = "Lua 5.3.4  Copyright (C) 1994-2017 Lua.org, PUC-Rio"
+	EOFMARK            = "<eof>" // 不完整的输入会以该标记结尾
+)
+
+/* bits of various argument indicators in 'args' */
+const (
+	has_error = 1  /* bad option */
+	has_i     = 2  /* -i */
+	has_v     = 4  /* -v */
+	has_e     = 8  /* -e */
+	has_E     = 16 /* -E */
+)
+
+var progname = LUA_PROGNAME
+
 func main() {
-	if len(os.Args) > 1 {
-		ls := state.New()
-		ls.OpenLibs()
-		ls.LoadFile(os.Args[1])
-		ls.Call(0, -1)
+	ls := state.New()
+	ok := pmain(ls, os.Args)
+	ls.Close()
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// lua-5.3.4/src/lua.c#pmain()
+func pmain(ls api.LuaState, argv []string) bool {
+	if len(argv) > 0 && argv[0] != "" {
+		progname = argv[0]
+	}
+
+	script := 0
+	args := collectArgs(argv, &script)
+	if args == has_error { /* bad arg? */
+		printUsage(argv[script]) /* 'script' has index of bad arg. */
+		return false
+	}
+	if args&has_v != 0 { /* option '-v'? */
+		printVersion()
+	}
+	if args&has_E != 0 { /* option '-E'? */
+		ls.PushBoolean(true) /* signal for libraries to ignore env. vars. */
+		ls.SetField(api.LUA_REGISTRYINDEX, "LUA_NOENV")
+	}
+	ls.OpenLibs()                    /* open standard libraries */
+	createArgTable(ls, argv, script) /* create table 'arg' */
+	if args&has_E == 0 {             /* no option '-E'? */
+		if !handleLuaInit(ls) { /* run LUA_INIT */
+			return false /* error running LUA_INIT */
+		}
+	}
+	if !runArgs(ls, argv, script) { /* execute arguments -e and -l */
+		return false /* something failed */
+	}
+	if script < len(argv) && /* execute main script (if there is one) */
+		!handleScript(ls, argv, script) {
+		return false
+	}
+	if args&has_i != 0 { /* -i option? */
+		doREPL(ls, os.Stdin) /* do read-eval-print loop */
+	} else if script == len(argv) && args&(has_e|has_v) == 0 { /* no arguments? */
+		if stdinIsTTY() { /* running in interactive mode? */
+			printVersion()
+			doREPL(ls, os.Stdin) /* do read-eval-print loop */
+		} else {
+			return doFile(ls, "") /* executes stdin as a file */
+		}
+	}
+	return true
+}
+
+/*
+** Traverses all arguments from 'argv', returning a mask with those
+** needed before running any Lua code (or an error code if it finds
+** any invalid argument). 'first' returns the first not-handled argument
+** (either the script name or a bad argument in case of error).
+ */
+// lua-5.3.4/src/lua.c#collectargs()
+func collectArgs(argv []string, first *int) int {
+	args := 0
+	i := 1
+	for ; i < len(argv); i++ {
+		*first = i
+		arg := argv[i]
+		if arg == "" || arg[0] != '-' { /* not an option? */
+			return args /* stop handling options */
+		}
+		switch arg[1:] {
+		case "": /* '-' */
+			return args /* script "name" is '-' */
+		case "-": /* '--' */
+			*first = i + 1
+			return args
+		case "E":
+			args |= has_E
+		case "i":
+			args |= has_i | has_v /* (-i implies -v) */
+		case "v":
+			args |= has_v
+		case "e", "l":
+			if arg[1] == 'e' {
+				args |= has_e /* both options need an argument */
+			}
+			i++
+			if i >= len(argv) || strings.HasPrefix(argv[i], "-") {
+				*first = i - 1
+				return has_error /* no next argument or it is another option */
+			}
+		default: /* invalid option; also '-e' or '-l' with extra chars */
+			if arg[1] == 'e' || arg[1] == 'l' {
+				if arg[1] == 'e' {
+					args |= has_e
+				}
+				break /* both options also accept the argument attached */
+			}
+			return has_error
+		}
+	}
+	*first = i /* no script name */
+	return args
+}
+
+func printUsage(badOption string) {
+	fmt.Fprintf(os.Stderr, "%s: ", progname)
+	if len(badOption) > 1 && (badOption[1] == 'e' || badOption[1] == 'l') {
+		fmt.Fprintf(os.Stderr, "'%s' needs argument\n", badOption)
+	} else {
+		fmt.Fprintf(os.Stderr, "unrecognized option '%s'\n", badOption)
+	}
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [script [args]]
+Available options are:
+  -e stat  execute string 'stat'
+  -i       enter interactive mode after executing 'script'
+  -l name  require library 'name'
+  -v       show version information
+  -E       ignore environment variables
+  --       stop handling options
+  -        stop handling options and execute stdin
+`, progname)
+}
+
+func printVersion() {
+	fmt.Println(LUA_COPYRIGHT)
+}
+
+/*
+** Create the 'arg' table, which stores all arguments from the
+** command line ('argv'). It should be aligned so that, at index 0,
+** it has 'argv[script]', which is the script name. The arguments
+** to the script (everything after 'script') go to positive indices;
+** other arguments (before the script name) go to negative indices.
+** If there is no script name, assume interpreter's name as base.
+ */
+// lua-5.3.4/src/lua.c#createargtable()
+func createArgTable(ls api.LuaState, argv []string, script int) {
+	if script == len(argv) {
+		script = 0 /* no script name? */
+	}
+	narg := len(argv) - (script + 1) /* number of positive indices */
+	ls.CreateTable(narg, script+1)
+	for i, arg := range argv {
+		ls.PushString(arg)
+		ls.RawSetI(-2, int64(i-script))
+	}
+	ls.SetGlobal("arg")
+}
+
+/*
+** Prints an error message, adding the program name in front of it
+** (if present)
+ */
+func lMessage(pname, msg string) {
+	if pname != "" {
+		fmt.Fprintf(os.Stderr, "%s: ", pname)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", msg)
+}
+
+/*
+** Check whether 'status' is not OK and, if so, prints the error
+** message on the top of the stack. It assumes that the error object
+** is a string, as it was either generated by Lua or by 'msghandler'.
+ */
+func report(ls api.LuaState, status int) bool {
+	if status != api.LUA_OK {
+		msg, _ := ls.ToStringX(-1)
+		lMessage(progname, msg)
+		ls.Pop(1) /* remove message */
+		return false
+	}
+	return true
+}
+
+/*
+** Message handler used to run all chunks
+ */
+// lua-5.3.4/src/lua.c#msghandler()
+func msgHandler(ls api.LuaState) int {
+	msg, ok := ls.ToStringX(1)
+	if !ok || ls.Type(1) != api.LUA_TSTRING && ls.Type(1) != api.LUA_TNUMBER {
+		/* is error object not a string? */
+		if ls.CallMeta(1, "__tostring") && /* does it have a metamethod */
+			ls.Type(-1) == api.LUA_TSTRING { /* that produces a string? */
+			return 1 /* that is the message */
+		}
+		msg = fmt.Sprintf("(error object is a %s value)", ls.TypeName2(1))
+	}
+	ls.Traceback(ls, msg, 1) /* append a standard traceback */
+	return 1                 /* return the traceback */
+}
+
+/*
+** Interface to 'lua_pcall', which sets appropriate message function
+** and C-signal handler. Used to run all chunks.
+ */
+func doCall(ls api.LuaState, narg, nres int) int {
+	base := ls.GetTop() - narg    /* function index */
+	ls.PushGoFunction(msgHandler) /* push message handler */
+	ls.Insert(base)               /* put it under function and args */
+	status := ls.PCall(narg, nres, base)
+	ls.Remove(base) /* remove message handler from the stack */
+	return status
+}
+
+func doChunk(ls api.LuaState, status int) bool {
+	if status == api.LUA_OK {
+		status = doCall(ls, 0, 0)
+	}
+	return report(ls, status)
+}
+
+// filename为空时执行标准输入
+func doFile(ls api.LuaState, filename string) bool {
+	return doChunk(ls, ls.LoadFile(filename))
+}
+
+func doString(ls api.LuaState, s, name string) bool {
+	return doChunk(ls, ls.Load([]byte(s), name, "bt"))
+}
+
+/*
+** Calls 'require(name)' and stores the result in a global variable
+** with the given name.
+ */
+func doLibrary(ls api.LuaState, name string) bool {
+	ls.GetGlobal("require")
+	ls.PushString(name)
+	status := doCall(ls, 1, 1) /* call 'require(name)' */
+	if status == api.LUA_OK {
+		ls.SetGlobal(name) /* global[name] = require return */
+	}
+	return report(ls, status)
+}
+
+/*
+** Push on the stack the contents of table 'arg' from 1 to #arg
+ */
+func pushArgs(ls api.LuaState) int {
+	if ls.GetGlobal("arg") != api.LUA_TTABLE {
+		ls.Error2("'arg' is not a table")
+	}
+	n := int(ls.Len2(-1))
+	ls.CheckStack2(n+3, "too many arguments to script")
+	for i := 1; i <= n; i++ {
+		ls.RawGetI(-i, int64(i))
+	}
+	ls.Remove(-(n + 1)) /* remove table from the stack */
+	return n
+}
+
+// lua-5.3.4/src/lua.c#handle_script()
+func handleScript(ls api.LuaState, argv []string, script int) bool {
+	fname := argv[script]
+	if fname == "-" && argv[script-1] != "--" {
+		fname = "" /* stdin */
+	}
+	status := ls.LoadFile(fname)
+	if status == api.LUA_OK {
+		n := pushArgs(ls) /* push arguments to script */
+		status = doCall(ls, n, api.LUA_MULTRET)
+	}
+	return report(ls, status)
+}
+
+/*
+** Processes options 'e' and 'l', which involve running Lua code.
+** Returns false if some code raises an error.
+ */
+func runArgs(ls api.LuaState, argv []string, n int) bool {
+	for i := 1; i < n; i++ {
+		option := argv[i][1]
+		if option != 'e' && option != 'l' {
+			continue
+		}
+		extra := argv[i][2:] /* both options need an argument */
+		if extra == "" {
+			i++
+			extra = argv[i]
+		}
+		var ok bool
+		if option == 'e' {
+			ok = doString(ls, extra, "=(command line)")
+		} else {
+			ok = doLibrary(ls, extra)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func handleLuaInit(ls api.LuaState) bool {
+	name := "=" + LUA_INITVARVERSION
+	init, ok := os.LookupEnv(LUA_INITVARVERSION)
+	if !ok {
+		name = "=" + LUA_INIT_VAR
+		init, ok = os.LookupEnv(LUA_INIT_VAR) /* try alternative name */
+	}
+	if !ok {
+		return true
+	} else if init != "" && init[0] == '@' {
+		return doFile(ls, init[1:])
+	} else {
+		return doString(ls, init, name)
+	}
+}
+
+func stdinIsTTY() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+/* REPL */
+
+type repl struct {
+	ls     api.LuaState
+	reader *bufio.Reader
+}
+
+/*
+** Returns the string to be used as a prompt by the interpreter.
+ */
+func (r *repl) getPrompt(firstline bool) string {
+	name, dft := "_PROMPT2", LUA_PROMPT2
+	if firstline {
+		name, dft = "_PROMPT", LUA_PROMPT
+	}
+	r.ls.GetGlobal(name)
+	prompt, ok := r.ls.ToStringX(-1)
+	r.ls.Pop(1)
+	if !ok {
+		return dft
+	}
+	return prompt
+}
+
+/*
+** Check whether 'status' signals a syntax error and the error
+** message at the top of the stack ends with the above mark for
+** incomplete statements.
+ */
+func (r *repl) incomplete(status int) bool {
+	if status == api.LUA_ERRSYNTAX {
+		if msg := r.ls.ToString(-1); strings.HasSuffix(msg, EOFMARK) {
+			r.ls.Pop(1)
+			return true
+		}
+	}
+	return false /* else... */
+}
+
+/*
+** Prompt the user, read a line, and push it into the Lua stack.
+ */
+func (r *repl) pushLine(firstline bool) bool {
+	fmt.Print(r.getPrompt(firstline))
+	line, err := r.reader.ReadString('\n')
+	if err != nil && line == "" {
+		return false /* no input (prompt will be popped by caller) */
+	}
+	line = strings.TrimSuffix(line, "\n")          /* remove it */
+	if firstline && strings.HasPrefix(line, "=") { /* for compatibility with 5.2, ... */
+		line = "return " + line[1:] /* change '=' to 'return' */
+	}
+	r.ls.PushString(line)
+	return true
+}
+
+/*
+** Try to compile line on the stack as 'return <line>;'; on return, stack
+** has either compiled chunk or original line (if compilation failed).
+ */
+func (r *repl) addReturn() int {
+	line := r.ls.ToString(-1) /* original line */
+	retline := "return " + line + ";"
+	status := r.ls.Load([]byte(retline), "=stdin", "t")
+	if status != api.LUA_OK {
+		r.ls.Pop(1) /* pop result from 'luaL_loadbuffer' */
+	}
+	return status
+}
+
+/*
+** Read multiple lines until a complete Lua statement. Returns false
+** if the input ends in the middle of a statement (the error message
+** of the incomplete chunk has already been popped).
+ */
+func (r *repl) multiLine() (int, bool) {
+	for { /* repeat until gets a complete statement */
+		line := r.ls.ToString(1)
+		status := r.ls.Load([]byte(line), "=stdin", "t") /* try it */
+		if !r.incomplete(status) {
+			return status, true /* cannot or should not try to add continuation line */
+		}
+		if !r.pushLine(false) {
+			return status, false /* no more input */
+		}
+		r.ls.PushString("\n") /* add newline... */
+		r.ls.Insert(-2)       /* ...between the two lines */
+		r.ls.Concat(3)        /* join them */
+	}
+}
+
+/*
+** Read a line and try to load (compile) it first as an expression (by
+** adding "return " in front of it) and second as a statement. Return
+** the final status of load/call with the resulting function (if any)
+** in the top of the stack.
+ */
+func (r *repl) loadLine() (int, bool) {
+	r.ls.SetTop(0)
+	if !r.pushLine(true) {
+		return 0, false /* no input */
+	}
+	status := r.addReturn()
+	if status != api.LUA_OK { /* 'return ...' did not work? */
+		var ok bool
+		if status, ok = r.multiLine(); !ok { /* try as command, maybe with continuation lines */
+			return 0, false /* input ended in the middle of a statement */
+		}
+	}
+	r.ls.Remove(1) /* remove line from the stack */
+	return status, true
+}
+
+/*
+** Prints (calling the Lua 'print' function) any values on the stack
+ */
+func (r *repl) print() {
+	n := r.ls.GetTop()
+	if n > 0 { /* any result to be printed? */
+		r.ls.CheckStack2(api.LUA_MINSTACK, "too many results to print")
+		r.ls.GetGlobal("print")
+		r.ls.Insert(1)
+		if r.ls.PCall(n, 0, 0) != api.LUA_OK {
+			lMessage(progname, fmt.Sprintf("error calling 'print' (%s)",
+				r.ls.ToString(-1)))
+		}
+	}
+}
+
+/*
+** Do the REPL: repeatedly read (load) a line, evaluate (call) it, and
+** print any results.
+ */
+// lua-5.3.4/src/lua.c#doREPL()
+func doREPL(ls api.LuaState, in io.Reader) {
+	r := &repl{ls: ls, reader: bufio.NewReader(in)}
+	oldProgname := progname
+	progname = "" /* no 'progname' on errors in interactive mode */
+	for {
+		status, ok := r.loadLine()
+		if !ok {
+			break
+		}
+		if status == api.LUA_OK {
+			status = doCall(ls, 0, api.LUA_MULTRET)
+		}
+		if status == api.LUA_OK {
+			r.print()
+		} else {
+			report(ls, status)
+		}
 	}
+	ls.SetTop(0) /* clear stack */
+	fmt.Println()
+	progname = oldProgname
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"lua_go/state"
+	"strings"
+	"testing"
+)
+
+func TestCollectArgs(t *testing.T) {
+	tests := []struct {
+		argv  []string
+		args  int
+		first int
+	}{
+		{[]string{"lua"}, 0, 1},
+		{[]string{"lua", "script.lua", "-e"}, 0, 1},
+		{[]string{"lua", "-i", "-E"}, has_i | has_v | has_E, 3},
+		{[]string{"lua", "-e", "", "-l", ""}, has_e, 5},
+		{[]string{"lua", "-eprint(1)", "--", "-x"}, has_e, 3},
+		{[]string{"lua", "-e"}, has_error, 1},
+		{[]string{"lua", "-l", "-i"}, has_error, 1},
+		{[]string{"lua", "-x"}, has_error, 1},
+	}
+
+	for _, tt := range tests {
+		first := 0
+		if args := collectArgs(tt.argv, &first); args != tt.args || first != tt.first {
+			t.Fatalf("%q: expected %d, %d got %d, %d", tt.argv, tt.args, tt.first, args, first)
+		}
+	}
+}
+
+func TestREPL(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 1\n", "1"},
+		{"x = 1\nx = x + 1", "2"},
+		{"x = 1\nfor i = 1, 2 do\n", "1"},
+		{"x = 1\nfor i = 1, 2 do\nx = x + i\n", "1"},
+		{"for i = 1, 2 do\nx = i\nend\n", "2"},
+		{"x = 1\nerror('boom')\nx = 3\n", "3"},
+		{"x = 1\nx = = 2\n", "1"},
+	}
+
+	for _, tt := range tests {
+		ls := state.New()
+		ls.OpenLibs()
+		doREPL(ls, strings.NewReader(tt.input))
+		if top := ls.GetTop(); top != 0 {
+			t.Fatalf("%q: expected an empty stack got %d", tt.input, top)
+		}
+		ls.GetGlobal("x")
+		if actual := ls.ToString2(-1); actual != tt.expected {
+			t.Fatalf("%q: expected %q got %q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestEmptyOptionArgument(t *testing.T) {
+	ls := state.New()
+	if !pmain(ls, []string{"lua", "-e", "", "-e", "x = 1"}) {
+		t.Fatal("expected -e '' to be accepted")
+	}
+	ls.GetGlobal("x")
+	if actual := ls.ToString2(-1); actual != "1" {
+		t.Fatalf("expected %q got %q", "1", actual)
+	}
+}
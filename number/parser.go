@@ -1,13 +1,87 @@
 package number
 
-import "strconv"
+import (
+	"strconv"
+	"strings"
+)
 
+// C语言isspace()认为是空白的字符, Lua数字前后可以有这些字符
+const spaceChars = " \f\n\r\t\v"
+
+// lua-5.3.4/src/lobject.c#l_str2int()
 func ParseInteger(str string) (int64, bool) {
+	str = strings.Trim(str, spaceChars) /* skip initial and trailing spaces */
+	if isHex(str) {
+		return parseHexInteger(str)
+	}
 	i, err := strconv.ParseInt(str, 10, 64)
 	return i, err == nil
 }
 
+// 十六进制整数溢出时回绕
+func parseHexInteger(str string) (int64, bool) {
+	neg := false
+	if str[0] == '-' || str[0] == '+' {
+		neg = str[0] == '-'
+		str = str[1:]
+	}
+	str = str[2:] // skip "0x"
+	if str == "" {
+		return 0, false
+	}
+
+	var i int64
+	for _, c := range []byte(str) {
+		var d byte
+		switch {
+		case c >= '0' && c <= '9':
+			d = c - '0'
+		case c >= 'a' && c <= 'f':
+			d = c - 'a' + 10
+		case c >= 'A' && c <= 'F':
+			d = c - 'A' + 10
+		default:
+			return 0, false
+		}
+		i = i*16 + int64(d)
+	}
+	if neg {
+		i = -i
+	}
+	return i, true
+}
+
+// lua-5.3.4/src/lobject.c#l_str2d()
 func ParseFloat(str string) (float64, bool) {
+	str = strings.Trim(str, spaceChars) /* skip initial and trailing spaces */
+	if !isNumeral(str) { /* reject 'inf', 'nan' and Go-only syntax */
+		return 0, false
+	}
+	if isHex(str) && !strings.ContainsAny(str, "pP") {
+		str += "p0" // Go要求十六进制浮点数必须有指数部分
+	}
 	f, err := strconv.ParseFloat(str, 64)
 	return f, err == nil
 }
+
+func isHex(str string) bool {
+	if str != "" && (str[0] == '-' || str[0] == '+') {
+		str = str[1:]
+	}
+	return len(str) >= 2 && str[0] == '0' && (str[1] == 'x' || str[1] == 'X')
+}
+
+// strconv还接受inf, nan和数字之间的下划线等Lua没有的语法, 所以先检查str只包含Lua数字可以使用的字符,
+// 格式再交给strconv检查
+func isNumeral(str string) bool {
+	chars := "0123456789.eE+-"
+	if isHex(str) {
+		chars = "0123456789abcdefABCDEF.xXpP+-"
+	}
+	for i := 0; i < len(str); i++ {
+		if strings.IndexByte(chars, str[i]) < 0 {
+			return false
+		}
+	}
+	return true
+}
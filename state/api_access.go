@@ -64,9 +64,7 @@ func (ls *luaState) IsNumber(idx int) bool {
 }
 
 func (ls *luaState) IsInteger(idx int) bool {
-	val := ls.stack.get(idx)
-	_, ok := val.(int64)
-	return ok
+	return ls.stack.get(idx).isInteger()
 }
 
 func (ls *luaState) ToBoolean(idx int) bool {
@@ -75,14 +73,7 @@ func (ls *luaState) ToBoolean(idx int) bool {
 }
 
 func convertToBoolean(val luaValue) bool {
-	switch x := val.(type) {
-	case nil:
-		return false
-	case bool:
-		return x
-	default:
-		return true
-	}
+	return !val.isFalse()
 }
 
 func (ls *luaState) ToNumber(idx int) float64 {
@@ -107,12 +98,17 @@ func (ls *luaState) ToIntegerX(idx int) (int64, bool) {
 
 func (ls *luaState) ToStringX(idx int) (string, bool) {
 	val := ls.stack.get(idx)
-	switch x := val.(type) {
-	case string:
-		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x)
-		ls.stack.set(idx, s) // 注意这里会修改栈!
+	switch val.tag() {
+	case api.LUA_TSTRING:
+		return val.str()
+	case LUA_TNUMINT, LUA_TNUMFLT:
+		var s string
+		if val.isInteger() {
+			s = fmt.Sprintf("%v", val.integer())
+		} else {
+			s = fmt.Sprintf("%v", val.float())
+		}
+		ls.stack.set(idx, stringValue(s)) // 注意这里会修改栈!
 		return s, true
 	default:
 		return "", false
@@ -125,16 +121,14 @@ func (ls *luaState) ToString(idx int) string {
 }
 
 func (ls *luaState) IsGoFunction(idx int) bool {
-	val := ls.stack.get(idx)
-	if c, ok := val.(*closure); ok {
+	if c := ls.stack.get(idx).closure(); c != nil {
 		return c.goFunc != nil
 	}
 	return false
 }
 
 func (ls *luaState) ToGoFunction(idx int) api.GoFunction {
-	val := ls.stack.get(idx)
-	if c, ok := val.(*closure); ok {
+	if c := ls.stack.get(idx).closure(); c != nil {
 		return c.goFunc
 	}
 	return nil
@@ -142,7 +136,42 @@ func (ls *luaState) ToGoFunction(idx int) api.GoFunction {
 
 func (ls *luaState) ToPointer(idx int) interface{} {
 	// todo
-	return ls.stack.get(idx)
+	val := ls.stack.get(idx)
+	switch val.tag() {
+	case api.LUA_TLIGHTUSERDATA:
+		return val.lightUserdata()
+	case api.LUA_TTABLE, api.LUA_TFUNCTION, api.LUA_TUSERDATA, api.LUA_TTHREAD:
+		return val.object()
+	default:
+		return nil
+	}
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_isuserdata
+func (ls *luaState) IsUserdata(idx int) bool {
+	t := ls.Type(idx)
+	return t == api.LUA_TUSERDATA || t == api.LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_islightuserdata
+func (ls *luaState) IsLightUserdata(idx int) bool {
+	return ls.Type(idx) == api.LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_touserdata
+// 返回完整userdata持有的Go值或者轻量userdata本身, 其他类型返回nil
+func (ls *luaState) ToUserdata(idx int) interface{} {
+	switch val := ls.stack.get(idx); val.tag() {
+	case api.LUA_TUSERDATA:
+		return val.userdata().data
+	case api.LUA_TLIGHTUSERDATA:
+		return val.lightUserdata()
+	default:
+		return nil
+	}
 }
 
 // [-0, +0, –]
@@ -152,11 +181,8 @@ func (ls *luaState) IsFunction(idx int) bool {
 }
 
 func (ls *luaState) ToThread(idx int) api.LuaState {
-	val := ls.stack.get(idx)
-	if val != nil {
-		if ls, ok := val.(*luaState); ok {
-			return ls
-		}
+	if th := ls.stack.get(idx).thread(); th != nil {
+		return th
 	}
 	return nil
 }
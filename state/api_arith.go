@@ -61,8 +61,17 @@ func (ls *luaState) Arith(op api.ArithOp) {
 		a = b
 	}
 
+	if b.isInteger() && b.integer() == 0 && a.isInteger() {
+		switch op {
+		case api.LUA_OPIDIV:
+			ls.runError("attempt to perform 'n//0'")
+		case api.LUA_OPMOD:
+			ls.runError("attempt to perform 'n%%0'")
+		}
+	}
+
 	operator := operators[op]
-	if result := _arith(a, b, operator); result != nil {
+	if result, ok := _arith(a, b, operator); ok {
 		ls.stack.push(result)
 		return
 	}
@@ -73,29 +82,27 @@ func (ls *luaState) Arith(op api.ArithOp) {
 		return
 	}
 
-	panic("arithmetic error!")
+	ls.arithError(a, b, op)
 }
 
-func _arith(a, b luaValue, op operator) luaValue {
+func _arith(a, b luaValue, op operator) (luaValue, bool) {
 	if op.floatFunc == nil { // bitwise
 		if x, ok := convertToInteger(a); ok {
 			if y, ok := convertToInteger(b); ok {
-				return op.integerFunc(x, y)
+				return integerValue(op.integerFunc(x, y)), true
 			}
 		}
 	} else { // arith
 		if op.integerFunc != nil { // add, sub, mul, mod, idiv, unm
-			if x, ok := a.(int64); ok {
-				if y, ok := b.(int64); ok {
-					return op.integerFunc(x, y)
-				}
+			if a.isInteger() && b.isInteger() {
+				return integerValue(op.integerFunc(a.integer(), b.integer())), true
 			}
 		}
 		if x, ok := convertToFloat(a); ok {
 			if y, ok := convertToFloat(b); ok {
-				return op.floatFunc(x, y)
+				return floatValue(op.floatFunc(x, y)), true
 			}
 		}
 	}
-	return nil
+	return nilValue, false
 }
@@ -1,55 +1,171 @@
 package state
 
 import (
+	"errors"
+	"fmt"
 	"lua_go/api"
 	"lua_go/binchunk"
 	"lua_go/compiler"
 	"lua_go/vm"
+	"strings"
 )
 
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_load
 func (ls *luaState) Load(chunk []byte, chunkName, mode string) int {
 	var proto *binchunk.Prototype
+	var err error
 	if binchunk.IsBinaryChunk(chunk) {
-		proto = binchunk.Undump(chunk)
+		if err = checkMode(mode, "binary"); err == nil {
+			proto, err = undump(chunk, chunkName)
+		}
 	} else {
-		proto = compiler.Compile(string(chunk), chunkName)
+		if err = checkMode(mode, "text"); err == nil {
+			proto, err = compiler.Compile(string(chunk), chunkName)
+		}
+	}
+	if err != nil {
+		ls.stack.push(stringValue(err.Error()))
+		return api.LUA_ERRSYNTAX
 	}
 
-	c := newLuaClosure(proto)
-	ls.stack.push(c)
+	c := newLuaClosure(proto, newProtoConsts(proto))
+	ls.stack.push(closureValue(c))
+	for i := range c.upvals { // 其余的upvalue初始化为nil
+		c.upvals[i] = &upvalue{new(luaValue)}
+	}
 	if len(proto.Upvalues) > 0 { // 设置_ENV
-		env := ls.registry.get(api.LUA_RIDX_GLOBALS)
+		env := ls.registry.getInt(api.LUA_RIDX_GLOBALS)
 		c.upvals[0] = &upvalue{&env}
 	}
-	return 0
+	return api.LUA_OK
 }
 
-func (ls *luaState) Call(nArgs, nResults int) {
-	val := ls.stack.get(-(nArgs + 1))
+// lua-5.3.4/src/ldo.c#checkmode()
+func checkMode(mode, x string) error {
+	if mode != "" && !strings.Contains(mode, x[:1]) {
+		return fmt.Errorf("attempt to load a %s chunk (mode is '%s')", x, mode)
+	}
+	return nil
+}
 
-	c, ok := val.(*closure)
-	if !ok {
-		if mf := getMetafield(val, "__call", ls); mf != nil {
-			if c, ok = mf.(*closure); ok {
-				ls.stack.push(val)
-				ls.Insert(-(nArgs + 2))
-				nArgs += 1
+// 把Undump的panic转换成和luac一致的错误信息
+// lua-5.3.4/src/lundump.c#error()
+func undump(chunk []byte, chunkName string) (proto *binchunk.Prototype, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			why := "truncated" // 数据不完整时会越界
+			if msg, ok := r.(string); ok {
+				why = strings.TrimSuffix(strings.TrimSuffix(msg, "!"), " precompiled chunk")
+				if strings.HasSuffix(why, "mismatch") {
+					why += " in"
+				}
 			}
+			name := chunkName
+			if name != "" && (name[0] == '@' || name[0] == '=') {
+				name = name[1:]
+			} else if strings.HasPrefix(name, binchunk.LUA_SIGNATURE) {
+				name = "binary string"
+			}
+			err = fmt.Errorf("%s: %s precompiled chunk", name, why)
 		}
+	}()
+	return binchunk.Undump(chunk), nil
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_dump
+// 栈顶不是Lua函数时返回nil
+func (ls *luaState) Dump(strip bool) []byte {
+	if c := ls.stack.get(-1).closure(); c != nil && c.proto != nil {
+		return binchunk.Dump(c.proto, strip)
 	}
+	return nil
+}
 
-	if ok {
-		if c.proto != nil {
-			ls.callLuaClosure(nArgs, nResults, c)
-		} else {
-			ls.callGoClosure(nArgs, nResults, c)
-		}
+// [-(nargs+1), +nresults, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_call
+func (ls *luaState) Call(nArgs, nResults int) {
+	ls.CallK(nArgs, nResults, 0, nil)
+}
+
+// [-(nargs + 1), +nresults, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_callk
+// lua-5.3.4/src/lapi.c#lua_callk()
+func (ls *luaState) CallK(nArgs, nResults int, ctx api.KContext, k api.KFunction) {
+	frame := ls.stack
+	if frame.isLua() && !frame.hooked { // 虚拟机执行指令时的调用, 恢复时由finishOp完成指令
+		ls.call(nArgs, nResults)
+	} else if k != nil && ls.nny == 0 { /* need to prepare continuation? */
+		frame.k, frame.ctx = k, ctx /* save continuation */
+		ls.call(nArgs, nResults)    /* do the call */
+	} else { /* no continuation or no yieldable */
+		ls.callNoYield(nArgs, nResults) /* just do the call */
+	}
+}
+
+// lua-5.3.4/src/ldo.c#luaD_call()
+func (ls *luaState) call(nArgs, nResults int) {
+	c, nArgs := ls.funcToCall(nArgs)
+	if c.proto != nil {
+		ls.callLuaClosure(nArgs, nResults, c)
 	} else {
-		panic("not function!")
+		ls.callGoClosure(nArgs, nResults, c)
 	}
 }
 
-func (ls *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
+/*
+** Similar to 'luaD_call', but does not allow yields during the call
+ */
+// lua-5.3.4/src/ldo.c#luaD_callnoyield()
+func (ls *luaState) callNoYield(nArgs, nResults int) {
+	ls.nny++
+	ls.call(nArgs, nResults)
+	ls.nny--
+}
+
+// 尾调用Lua函数时用被调函数的调用帧替换当前调用帧, 使尾递归只占用常量空间
+// 被调用的是Go函数时按普通调用处理, 所有结果留在栈顶并返回false
+// lua-5.3.4/src/lvm.c#luaV_execute() OP_TAILCALL
+func (ls *luaState) TailCall(nArgs int) bool {
+	c, nArgs := ls.funcToCall(nArgs)
+	if c.proto == nil {
+		ls.callGoClosure(nArgs, api.LUA_MULTRET, c)
+		return false
+	}
+
+	ls.CloseUpvalues(1) /* close all upvalues from previous call */
+	newStack := ls.newLuaClosureStack(nArgs, c)
+	newStack.isTailCall = true
+	newStack.nResults = ls.stack.nResults
+	ls.popLuaStack() /* remove the frame of the caller */
+	ls.pushLuaStack(newStack)
+	if ls.hookMask&api.LUA_MASKCALL != 0 {
+		ls.callHook(api.LUA_HOOKTAILCALL, -1)
+	}
+	return true
+}
+
+// 取出被调用的函数, 不是函数时尝试__call元方法
+// lua-5.3.4/src/ldo.c#tryfuncTM()
+func (ls *luaState) funcToCall(nArgs int) (*closure, int) {
+	val := ls.stack.get(-(nArgs + 1))
+	if c := val.closure(); c != nil {
+		return c, nArgs
+	}
+	if mf := getMetafield(val, "__call", ls); !mf.isNil() {
+		if c := mf.closure(); c != nil {
+			ls.stack.push(val)
+			ls.Insert(-(nArgs + 2))
+			return c, nArgs + 1
+		}
+	}
+	ls.valueTypeError(val, "call", 0)
+	return nil, 0
+}
+
+// 弹出函数和参数, 创建Lua函数的调用帧
+func (ls *luaState) newLuaClosureStack(nArgs int, c *closure) *luaStack {
 	nRegs := int(c.proto.MaxStackSize)
 	nParams := int(c.proto.NumParams)
 	isVararg := c.proto.IsVararg == 1
@@ -62,40 +178,62 @@ func (ls *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 	if nArgs > nParams && isVararg {
 		newStack.varargs = funcAndArgs[nParams+1:]
 	}
+	return newStack
+}
 
+func (ls *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
+	newStack := ls.newLuaClosureStack(nArgs, c)
+	newStack.nResults = nResults
 	ls.pushLuaStack(newStack)
-	ls.runLuaClosure()
-	ls.popLuaStack()
-
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
-		ls.stack.check(len(results))
-		ls.stack.pushN(results, nResults)
+	if ls.hookMask&api.LUA_MASKCALL != 0 {
+		ls.callHook(api.LUA_HOOKCALL, -1)
 	}
+	ls.execute()
 }
 
 func (ls *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	newStack := newLuaStack(nArgs+20, ls)
 	newStack.closure = c
+	newStack.nResults = nResults
 
 	args := ls.stack.popN(nArgs)
 	newStack.pushN(args, nArgs)
 	ls.stack.pop()
 
 	ls.pushLuaStack(newStack)
-	r := c.goFunc(ls)
-	ls.popLuaStack()
-
-	if nResults != 0 {
-		results := newStack.popN(r)
-		ls.stack.check(len(results))
-		ls.stack.pushN(results, nResults)
+	if ls.hookMask&api.LUA_MASKCALL != 0 {
+		ls.callHook(api.LUA_HOOKCALL, -1)
 	}
+	n := c.goFunc(ls)
+	ls.posCall(n)
+}
+
+// 执行当前的Lua调用帧直到它返回
+func (ls *luaState) execute() {
+	ls.runLuaClosure()
+	// 发生尾调用时当前调用帧已经被替换
+	frame := ls.stack
+	ls.posCall(frame.top - int(frame.closure.proto.MaxStackSize))
 }
 
 func (ls *luaState) runLuaClosure() {
+	g := ls.global
 	for {
+		if g.gcDebt > 0 { /* safe point, the last instruction has finished */
+			ls.checkGC()
+		}
 		inst := vm.Instruction(ls.Fetch())
+		if g.limits.MaxInstructions > 0 {
+			ls.countInstruction()
+		}
+		if g.done != nil {
+			if g.ctxCount--; g.ctxCount <= 0 {
+				ls.checkContext()
+			}
+		}
+		if ls.hookMask&(api.LUA_MASKLINE|api.LUA_MASKCOUNT) != 0 {
+			ls.traceExec()
+		}
 		inst.Execute(ls)
 		if inst.Opcode() == vm.OP_RETURN {
 			break
@@ -103,20 +241,136 @@ func (ls *luaState) runLuaClosure() {
 	}
 }
 
-func (ls *luaState) PCall(nArgs, nResults, msgh int) (status int) {
+// 弹出当前调用帧, 把栈顶的n个返回值按调用者期望的数量移到调用者的栈上
+// lua-5.3.4/src/ldo.c#luaD_poscall()
+func (ls *luaState) posCall(n int) {
+	if len(ls.stack.tbc) > 0 { /* close pending to-be-closed variables */
+		ls.closeTBC(0)
+	}
+	if ls.hookMask&api.LUA_MASKRET != 0 {
+		ls.callHook(api.LUA_HOOKRET, -1)
+	}
+	frame := ls.stack
+	ls.popLuaStack()
+
+	if frame.nResults != 0 {
+		results := frame.popN(n)
+		ls.stack.check(len(results))
+		ls.stack.pushN(results, frame.nResults)
+	}
+}
+
+// [-(nargs + 1), +(nresults|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pcall
+func (ls *luaState) PCall(nArgs, nResults, msgh int) int {
+	return ls.PCallK(nArgs, nResults, msgh, 0, nil)
+}
+
+// [-(nargs + 1), +(nresults|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pcallk
+// lua-5.3.4/src/lapi.c#lua_pcallk()
+func (ls *luaState) PCallK(nArgs, nResults, msgh int, ctx api.KContext, k api.KFunction) int {
+	var handler luaValue
+	if msgh != 0 {
+		handler = ls.stack.get(msgh)
+	}
+	if k == nil || ls.nny > 0 { /* no continuation or no yieldable? */
+		return ls.pcall(nArgs, nResults, handler) /* do a 'conventional' protected call */
+	}
+
+	/* prepare continuation (call is already protected by 'resume') */
+	frame := ls.stack
+	frame.k, frame.ctx = k, ctx /* save continuation */
+	/* save information for error recovery */
+	frame.extra = frame.top - (nArgs + 1)
+	frame.msgh = handler
+	frame.oldAllowHook = ls.allowHook
+	frame.isYieldPCall = true /* function can do error recovery */
+	ls.call(nArgs, nResults)  /* do the call */
+	frame.isYieldPCall = false
+	frame.msgh = nilValue
+	return api.LUA_OK /* if it is here, there were no errors */
+}
+
+// lua-5.3.4/src/ldo.c#luaD_pcall()
+func (ls *luaState) pcall(nArgs, nResults int, handler luaValue) (status int) {
 	caller := ls.stack
-	status = api.LUA_ERRRUN
+	oldTop := caller.top - (nArgs + 1) // 出错时函数和参数都要移除
+	oldAllowHook := ls.allowHook
+	oldNny := ls.nny
 
 	defer func() {
-		if err := recover(); err != nil {
+		if r := recover(); r != nil {
+			var err luaValue
+			err, status = toLuaError(r)
+			if !handler.isNil() && status == api.LUA_ERRRUN {
+				// 在出错的调用栈上执行消息处理函数
+				err, status = ls.callMsgHandler(handler, err)
+			}
+			tbc := ls.pendingTBC(caller, oldTop)
 			for ls.stack != caller {
+				ls.stack.hooked = false
 				ls.popLuaStack()
 			}
-			ls.stack.push(err)
+			ls.allowHook = oldAllowHook
+			ls.nny = oldNny
+			for caller.top > oldTop {
+				caller.pop()
+			}
+			if tbc != nil { /* close them with the error object */
+				err, status = ls.closeProtected(tbc, err, status)
+			}
+			caller.check(1)
+			caller.push(err)
 		}
 	}()
 
-	ls.Call(nArgs, nResults)
-	status = api.LUA_OK
-	return
+	ls.callNoYield(nArgs, nResults)
+	return api.LUA_OK
+}
+
+// 在出错的调用栈上执行消息处理函数, 处理函数本身出错时返回LUA_ERRERR
+// lua-5.3.4/src/ldebug.c#luaG_errormsg()
+func (ls *luaState) callMsgHandler(handler, err luaValue) (msg luaValue, status int) {
+	defer func() {
+		if r := recover(); r != nil { // 消息处理函数本身出错
+			if _, status = toLuaError(r); status != api.LUA_ERRMEM {
+				msg, status = stringValue("error in error handling"), api.LUA_ERRERR
+			} else {
+				msg = stringValue(MEMERRMSG)
+			}
+		}
+	}()
+
+	ls.errorDepth++ /* may use the extra space reserved for errors */
+	defer func() { ls.errorDepth-- }()
+	ls.stack.check(2)
+	ls.stack.push(handler)
+	ls.stack.push(err)
+	ls.Call(1, 1)
+	return ls.stack.pop(), api.LUA_ERRRUN
+}
+
+const MEMERRMSG = "not enough memory"
+
+// 把recover得到的值转换为Lua错误对象和状态码
+// Go运行时错误(空指针, 越界等)转换为字符串, 内存错误(包括被包装的)返回LUA_ERRMEM,
+// __gc元方法中的错误返回LUA_ERRGCMM
+func toLuaError(r interface{}) (luaValue, int) {
+	switch x := r.(type) {
+	case luaValue:
+		return x, api.LUA_ERRRUN
+	case string:
+		return stringValue(x), api.LUA_ERRRUN
+	case error:
+		if errors.As(x, new(memError)) {
+			return stringValue(MEMERRMSG), api.LUA_ERRMEM
+		}
+		if e := gcError(""); errors.As(x, &e) {
+			return stringValue(string(e)), api.LUA_ERRGCMM
+		}
+		return stringValue(x.Error()), api.LUA_ERRRUN
+	default:
+		return stringValue(fmt.Sprint(x)), api.LUA_ERRRUN
+	}
 }
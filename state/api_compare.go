@@ -18,102 +18,106 @@ func (ls *luaState) Compare(idx1, idx2 int, op api.CompareOp) bool {
 }
 
 func _eq(a, b luaValue, ls *luaState) bool {
-	switch x := a.(type) {
-	case nil:
-		return b == nil
-	case *luaTable:
-		if y, ok := b.(*luaTable); ok && x != y && ls != nil {
-			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
-				return convertToBoolean(result)
-			}
+	switch a.tag() {
+	case LUA_TNUMINT:
+		if b.isFloat() {
+			return float64(a.integer()) == b.float()
 		}
 		return a == b
-	case bool:
-		y, ok := b.(bool)
-		return ok && x == y
-	case string:
-		y, ok := b.(string)
-		return ok && x == y
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x == y
-		case float64:
-			return float64(x) == y
+	case LUA_TNUMFLT:
+		switch b.tag() {
+		case LUA_TNUMFLT:
+			return a.float() == b.float()
+		case LUA_TNUMINT:
+			return a.float() == float64(b.integer())
 		default:
 			return false
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x == y
-		case int64:
-			return x == float64(y)
-		default:
-			return false
+	case api.LUA_TTABLE, api.LUA_TUSERDATA:
+		if b.tag() == a.tag() && a.p != b.p && ls != nil {
+			if result, ok := callMetamethod(a, b, "__eq", ls); ok {
+				return convertToBoolean(result)
+			}
 		}
+		return a == b
+	case api.LUA_TSTRING:
+		s1, _ := a.str()
+		s2, ok := b.str()
+		return ok && s1 == s2
+	case api.LUA_TLIGHTUSERDATA:
+		return b.tag() == api.LUA_TLIGHTUSERDATA && a.lightUserdata() == b.lightUserdata()
 	default:
 		return a == b
 	}
 }
 
 func _lt(a, b luaValue, ls *luaState) bool {
-	switch x := a.(type) {
-	case string:
-		if y, ok := b.(string); ok {
-			return x < y
+	switch a.tag() {
+	case api.LUA_TSTRING:
+		if b.tag() == api.LUA_TSTRING {
+			s1, _ := a.str()
+			s2, _ := b.str()
+			return s1 < s2
 		}
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x < y
-		case float64:
-			return float64(x) < y
+	case LUA_TNUMINT:
+		switch b.tag() {
+		case LUA_TNUMINT:
+			return a.integer() < b.integer()
+		case LUA_TNUMFLT:
+			return float64(a.integer()) < b.float()
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x < y
-		case int64:
-			return x < float64(y)
+	case LUA_TNUMFLT:
+		switch b.tag() {
+		case LUA_TNUMFLT:
+			return a.float() < b.float()
+		case LUA_TNUMINT:
+			return a.float() < float64(b.integer())
 		}
 	}
 
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	}
-	panic("comparison error!")
+	ls.orderError(a, b)
+	return false
 }
 
 func _le(a, b luaValue, ls *luaState) bool {
-	switch x := a.(type) {
-	case string:
-		if y, ok := b.(string); ok {
-			return x <= y
+	switch a.tag() {
+	case api.LUA_TSTRING:
+		if b.tag() == api.LUA_TSTRING {
+			s1, _ := a.str()
+			s2, _ := b.str()
+			return s1 <= s2
 		}
-	case int64:
-		switch y := b.(type) {
-		case int64:
-			return x <= y
-		case float64:
-			return float64(x) <= y
+	case LUA_TNUMINT:
+		switch b.tag() {
+		case LUA_TNUMINT:
+			return a.integer() <= b.integer()
+		case LUA_TNUMFLT:
+			return float64(a.integer()) <= b.float()
 		}
-	case float64:
-		switch y := b.(type) {
-		case float64:
-			return x <= y
-		case int64:
-			return x <= float64(y)
+	case LUA_TNUMFLT:
+		switch b.tag() {
+		case LUA_TNUMFLT:
+			return a.float() <= b.float()
+		case LUA_TNUMINT:
+			return a.float() <= float64(b.integer())
 		}
 	}
 
 	if result, ok := callMetamethod(a, b, "__le", ls); ok {
 		return convertToBoolean(result)
-	} else if result, ok := callMetamethod(b, a, "__lt", ls); ok {
+	}
+	frame := ls.stack
+	frame.leq = true /* mark it is doing 'lt' for 'le' */
+	result, ok := callMetamethod(b, a, "__lt", ls)
+	frame.leq = false
+	if ok {
 		return !convertToBoolean(result)
-	} else {
-		panic("comparison error!")
 	}
+	ls.orderError(a, b)
+	return false
 }
 
 func (ls *luaState) RawEqual(idx1, idx2 int) bool {
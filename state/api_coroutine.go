@@ -1,59 +1,283 @@
 package state
 
-import "lua_go/api"
+import (
+	"lua_go/api"
+	"lua_go/vm"
+)
+
+// 协程让出时用panic展开Go的调用栈, 由Resume捕获
+type coYield struct{}
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_newthread
 // lua-5.3.4/src/lstate.c#lua_newthread()
 func (ls *luaState) NewThread() api.LuaState {
-	t := &luaState{registry: ls.registry}
+	t := &luaState{registry: ls.registry, global: ls.global, nny: 1, allowHook: true}
+	t.SetHook(ls.hook, ls.hookMask, ls.baseHookCount) /* inherit hook */
 	t.pushLuaStack(newLuaStack(api.LUA_MINSTACK, t))
-	ls.stack.push(t)
+	ls.stack.push(threadValue(t))
 	return t
 }
 
+// [-?, +?, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_resume
+// lua-5.3.4/src/ldo.c#lua_resume()
 func (ls *luaState) Resume(from api.LuaState, nArgs int) int {
-	lsFrom := from.(*luaState)
-	if lsFrom.coChan == nil {
-		lsFrom.coChan = make(chan int)
+	if ls.coStatus == api.LUA_OK { /* may be starting a coroutine */
+		if ls.stack.prev != nil { /* not in base level? */
+			return ls.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+	} else if ls.coStatus != api.LUA_YIELD {
+		return ls.resumeError("cannot resume dead coroutine", nArgs)
+	}
+	ls.baseCalls = 0
+	if from, ok := from.(*luaState); ok && from != nil {
+		ls.baseCalls = from.baseCalls + from.nCalls
+	}
+	if ls.baseCalls+ls.nCalls >= ls.maxCalls() {
+		return ls.resumeError("C stack overflow", nArgs)
 	}
 
-	if ls.coChan == nil { // start coroutine
-		ls.coChan = make(chan int)
-		ls.coCaller = lsFrom
-		go func() {
-			ls.coStatus = ls.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
-		}()
-	} else { // resume coroutine
-		ls.coStatus = api.LUA_OK
-		ls.coChan <- 1
+	oldNny := ls.nny /* save "number of non-yieldable" calls */
+	ls.nny = 0       /* allow yields */
+	status := ls.runProtected(func() { ls.resume(nArgs) })
+	/* continue running after recoverable errors */
+	for isErrorStatus(status) && ls.recover() {
+		/* unroll continuation */
+		errStatus := status
+		status = ls.runProtected(func() {
+			ls.finishGoCall(errStatus) /* finish 'PCallK' callee */
+			ls.unroll()
+		})
+	}
+	if isErrorStatus(status) { /* unrecoverable error? */
+		ls.coStatus = status /* mark thread as 'dead' */
+		// 错误对象会被移给调用者, 留一份给CloseThread
+		ls.stack.check(1)
+		ls.stack.push(ls.stack.get(-1))
 	}
+	ls.nny = oldNny /* restore 'nny' */
+	return status
+}
 
-	<-lsFrom.coChan // wait coroutine to finish or yield
-	return ls.coStatus
+// lua-5.3.4/src/ldo.c#resume_error()
+func (ls *luaState) resumeError(msg string, nArgs int) int {
+	ls.stack.popN(nArgs)            /* remove args from the stack */
+	ls.stack.push(stringValue(msg)) /* push error message */
+	return api.LUA_ERRRUN
 }
 
+func isErrorStatus(status int) bool {
+	return status > api.LUA_YIELD
+}
+
+// lua-5.3.4/src/ldo.c#resume()
+func (ls *luaState) resume(nArgs int) {
+	if ls.coStatus == api.LUA_OK { /* starting a coroutine? */
+		ls.call(nArgs, api.LUA_MULTRET)
+		return
+	}
+
+	/* resuming from previous yield */
+	ls.coStatus = api.LUA_OK /* mark that it is running (again) */
+	frame := ls.stack
+	if frame.yieldBelow != nil { // 放回让出时保存的栈值
+		args := frame.popN(nArgs)
+		frame.check(len(frame.yieldBelow) + nArgs)
+		frame.pushN(frame.yieldBelow, -1)
+		frame.pushN(args, -1)
+		frame.yieldBelow = nil
+	}
+	n := nArgs
+	if frame.k != nil { /* does it have a continuation function? */
+		n = frame.k(ls, api.LUA_YIELD, frame.ctx) /* call continuation */
+	}
+	ls.posCall(n) /* finish 'luaD_precall' */
+	ls.unroll()   /* run continuation */
+}
+
+/*
+** Executes "full continuation" (everything in the stack) of a
+** previously interrupted coroutine until the stack is empty (or another
+** interruption long-jumps out of the loop).
+ */
+// lua-5.3.4/src/ldo.c#unroll()
+func (ls *luaState) unroll() {
+	for ls.stack.prev != nil { /* something in the stack */
+		if !ls.stack.isLua() { /* Go function? */
+			ls.finishGoCall(api.LUA_YIELD) /* complete its execution */
+		} else { /* Lua function */
+			ls.finishOp() /* finish interrupted instruction */
+			ls.execute()  /* execute down to higher Go 'boundary' */
+		}
+	}
+}
+
+/*
+** Completes the execution of an interrupted Go function, calling its
+** continuation function.
+ */
+// lua-5.3.4/src/ldo.c#finishCcall()
+func (ls *luaState) finishGoCall(status int) {
+	frame := ls.stack
+	if frame.isYieldPCall { /* was inside a pcall? */
+		frame.isYieldPCall = false /* continuation is also inside it */
+		frame.msgh = nilValue
+	}
+	/* finish 'CallK'/'PCallK' */
+	n := frame.k(ls, status, frame.ctx) /* call continuation function */
+	ls.posCall(n)                       /* finish 'luaD_precall' */
+}
+
+// 完成被中断的指令, 被调用函数的结果已经在栈顶
+// lua-5.3.4/src/lvm.c#luaV_finishOp()
+func (ls *luaState) finishOp() {
+	frame := ls.stack
+	if frame.leq { /* "<=" using "<" instead? */
+		frame.leq = false
+		frame.push(boolValue(!convertToBoolean(frame.pop()))) /* negate result */
+	}
+	i := vm.Instruction(frame.closure.proto.Code[frame.pc-1])
+	vm.FinishOp(i, ls)
+}
+
+// 执行f, 把错误和让出转换为状态码, 出错时错误对象留在栈顶
+// lua-5.3.4/src/ldo.c#luaD_rawrunprotected()
+func (ls *luaState) runProtected(f func()) (status int) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(coYield); ok {
+				status = api.LUA_YIELD
+				return
+			}
+			var err luaValue
+			err, status = toLuaError(r)
+			if pf := ls.findPCall(); pf != nil && !pf.msgh.isNil() && status == api.LUA_ERRRUN {
+				err, status = ls.callMsgHandler(pf.msgh, err)
+			}
+			ls.stack.check(1)
+			ls.stack.push(err)
+		}
+	}()
+
+	f()
+	return api.LUA_OK
+}
+
+/*
+** Try to find a suspended protected call (a "recover point") for the
+** given thread.
+ */
+// lua-5.3.4/src/ldo.c#findpcall()
+func (ls *luaState) findPCall() *luaStack {
+	for frame := ls.stack; frame != nil; frame = frame.prev {
+		if frame.isYieldPCall {
+			return frame
+		}
+	}
+	return nil /* no pending pcall */
+}
+
+/*
+** Recovers from an error in a coroutine. Finds a recover point (if
+** there is one) and completes the execution of the interrupted
+** 'luaD_pcall'. If there is no recover point, returns zero.
+ */
+// lua-5.3.4/src/ldo.c#recover()
+func (ls *luaState) recover() bool {
+	pf := ls.findPCall()
+	if pf == nil {
+		return false /* no recovery point */
+	}
+	err := ls.stack.pop()
+	/* "finish" luaD_pcall */
+	tbc := ls.pendingTBC(pf, pf.extra)
+	for ls.stack != pf {
+		ls.stack.hooked = false
+		ls.popLuaStack()
+	}
+	for pf.top > pf.extra {
+		pf.pop()
+	}
+	ls.allowHook = pf.oldAllowHook /* restore original 'allowhook' */
+	if tbc != nil {
+		err, _ = ls.closeProtected(tbc, err, api.LUA_ERRRUN)
+	}
+	pf.check(1)
+	pf.push(err)
+	ls.nny = 0  /* should be zero to be yieldable */
+	return true /* continue running the coroutine */
+}
+
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yield
 func (ls *luaState) Yield(nResults int) int {
+	return ls.YieldK(nResults, 0, nil)
+}
+
+// [-?, +?, e]
+// http://www.lua.org/manual/5.3/manual.html#lua_yieldk
+// lua-5.3.4/src/ldo.c#lua_yieldk()
+func (ls *luaState) YieldK(nResults int, ctx api.KContext, k api.KFunction) int {
+	frame := ls.stack
+	if ls.nny > 0 || frame.isLua() { // 钩子中不能让出
+		if !ls.isMainThread() {
+			ls.runError("attempt to yield across a C-call boundary")
+		} else {
+			ls.runError("attempt to yield from outside a coroutine")
+		}
+	}
 	ls.coStatus = api.LUA_YIELD
-	ls.coCaller.coChan <- 1
-	<-ls.coChan
-	return ls.GetTop()
+	frame.k, frame.ctx = k, ctx           /* save continuation */
+	if n := frame.top - nResults; n > 0 { /* protect stack below results */
+		frame.yieldBelow = make([]luaValue, n)
+		copy(frame.yieldBelow, frame.slots[:n])
+		results := frame.popN(nResults)
+		frame.popN(n)
+		frame.pushN(results, -1)
+	}
+	panic(coYield{})
 }
 
-func (ls *luaState) Status() int {
-	return ls.coStatus
+// 重置线程, 关闭待关闭的变量并丢弃所有调用帧; 线程因出错而终止或者关闭变量出错时返回错误状态码并把错误对象留在栈顶
+// [-0, +?, –]
+// http://www.lua.org/manual/5.4/manual.html#lua_closethread
+// lua-5.4.6/src/lstate.c#luaE_resetthread()
+func (ls *luaState) CloseThread(from api.LuaState) int {
+	status := ls.coStatus
+	var err luaValue
+	if isErrorStatus(status) {
+		err = ls.stack.get(-1)
+	} else {
+		status = api.LUA_OK
+	}
+	base := ls.stack
+	for base.prev != nil {
+		base = base.prev
+	}
+	tbc := ls.pendingTBC(base, 0)
+	ls.stack = nil /* unwind call stack */
+	ls.nCalls, ls.nSlots = 0, 0
+	ls.pushLuaStack(newLuaStack(api.LUA_MINSTACK, ls))
+	ls.coStatus = api.LUA_OK
+	ls.allowHook = true
+	if tbc != nil { /* close pending to-be-closed variables */
+		err, status = ls.closeProtected(tbc, err, status)
+	}
+	if status != api.LUA_OK { /* errors? */
+		ls.stack.push(err)
+	}
+	return status
 }
 
-func (ls *luaState) GetStack() bool {
-	return ls.stack.prev != nil
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_status
+func (ls *luaState) Status() int {
+	return ls.coStatus
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
 func (ls *luaState) IsYieldable() bool {
-	if ls.isMainThread() {
-		return false
-	}
-	return ls.coStatus != api.LUA_YIELD // todo
+	return ls.nny == 0
 }
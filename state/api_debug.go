@@ -0,0 +1,255 @@
+package state
+
+import (
+	"lua_go/api"
+	"lua_go/binchunk"
+	"strings"
+)
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getstack
+// level 0是当前运行的函数, level n+1是调用level n的函数
+// lua-5.3.4/src/ldebug.c#lua_getstack()
+func (ls *luaState) GetStack(level int, ar *api.LuaDebug) bool {
+	if level < 0 {
+		return false /* invalid (negative) level */
+	}
+	frame := ls.stack
+	for ; level > 0 && frame.closure != nil; level-- {
+		frame = frame.prev
+	}
+	if level == 0 && frame.closure != nil { /* level found? */
+		ar.CallInfo = frame
+		return true
+	}
+	return false /* no such level */
+}
+
+// [-(0|1), +(0|1|2), e]
+// http://www.lua.org/manual/5.3/manual.html#lua_getinfo
+// lua-5.3.4/src/ldebug.c#lua_getinfo()
+func (ls *luaState) GetInfo(what string, ar *api.LuaDebug) bool {
+	var frame *luaStack
+	var fn luaValue
+	if strings.HasPrefix(what, ">") {
+		fn = ls.stack.pop()
+		if fn.closure() == nil {
+			panic("function expected")
+		}
+		what = what[1:] /* skip the '>' */
+		ar.CallInfo = nil
+	} else {
+		frame = ar.CallInfo.(*luaStack)
+		fn = closureValue(frame.closure)
+	}
+	c := fn.closure()
+	status := ls.auxGetInfo(what, ar, c, frame)
+	if strings.IndexByte(what, 'f') >= 0 {
+		ls.stack.check(1)
+		ls.stack.push(fn)
+	}
+	if strings.IndexByte(what, 'L') >= 0 {
+		ls.collectValidLines(c)
+	}
+	return status
+}
+
+// lua-5.3.4/src/ldebug.c#auxgetinfo()
+func (ls *luaState) auxGetInfo(what string, ar *api.LuaDebug, c *closure, frame *luaStack) bool {
+	status := true
+	for _, opt := range what {
+		switch opt {
+		case 'S':
+			funcInfo(ar, c)
+		case 'l':
+			if frame != nil && c.proto != nil {
+				ar.CurrentLine = getFuncLine(c.proto, frame.currentPC())
+			} else {
+				ar.CurrentLine = -1
+			}
+		case 'u':
+			ar.NUps = len(c.upvals)
+			if c.proto == nil {
+				ar.IsVararg = true
+				ar.NParams = 0
+			} else {
+				ar.IsVararg = c.proto.IsVararg == 1
+				ar.NParams = int(c.proto.NumParams)
+			}
+		case 't':
+			ar.IsTailCall = frame != nil && frame.isTailCall
+		case 'n':
+			ar.NameWhat, ar.Name = ls.getFuncName(frame)
+		case 'L', 'f': /* handled by GetInfo */
+		default:
+			status = false /* invalid option */
+		}
+	}
+	return status
+}
+
+// lua-5.3.4/src/ldebug.c#funcinfo()
+func funcInfo(ar *api.LuaDebug, c *closure) {
+	if c.proto == nil { // Go函数相当于C函数
+		ar.Source = "=[C]"
+		ar.LineDefined = -1
+		ar.LastLineDefined = -1
+		ar.What = "C"
+	} else {
+		p := c.proto
+		ar.Source = p.Source
+		if ar.Source == "" {
+			ar.Source = "=?"
+		}
+		ar.LineDefined = int(p.LineDefined)
+		ar.LastLineDefined = int(p.LastLineDefined)
+		if ar.LineDefined == 0 {
+			ar.What = "main"
+		} else {
+			ar.What = "Lua"
+		}
+	}
+	ar.ShortSrc = binchunk.ChunkID(ar.Source)
+}
+
+// lua-5.3.4/src/ldebug.c#collectvalidlines()
+func (ls *luaState) collectValidLines(c *closure) {
+	ls.stack.check(1)
+	if c.proto == nil {
+		ls.stack.push(nilValue)
+		return
+	}
+	t := newLuaTable(0, len(c.proto.LineInfo))
+	for _, line := range c.proto.LineInfo {
+		t.put(integerValue(int64(line)), trueValue)
+	}
+	ls.stack.push(tableValue(t))
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getlocal
+// 没有这个局部变量时返回空字符串
+// lua-5.3.4/src/ldebug.c#lua_getlocal()
+func (ls *luaState) GetLocal(ar *api.LuaDebug, n int) string {
+	if ar == nil { /* information about non-active function? */
+		c := ls.stack.get(-1).closure()
+		if c == nil || c.proto == nil { /* not a Lua function? */
+			return ""
+		}
+		/* consider live variables at function start (parameters) */
+		return getLocalName(c.proto, n, 0)
+	}
+
+	name, pos := ls.findLocal(ar.CallInfo.(*luaStack), n)
+	if pos != nil {
+		ls.stack.check(1)
+		ls.stack.push(*pos)
+	}
+	return name
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setlocal
+// lua-5.3.4/src/ldebug.c#lua_setlocal()
+func (ls *luaState) SetLocal(ar *api.LuaDebug, n int) string {
+	name, pos := ls.findLocal(ar.CallInfo.(*luaStack), n)
+	if pos != nil {
+		*pos = ls.stack.pop()
+	}
+	return name
+}
+
+// [-0, +(0|1), –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getupvalue
+// Go函数的upvalue名字为空字符串
+// lua-5.3.4/src/lapi.c#lua_getupvalue()
+func (ls *luaState) GetUpvalue(funcIdx, n int) (string, bool) {
+	name, uv, ok := auxUpvalue(ls.stack.get(funcIdx), n)
+	if ok {
+		ls.stack.check(1)
+		ls.stack.push(*uv.val)
+	}
+	return name, ok
+}
+
+// [-(0|1), +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setupvalue
+// lua-5.3.4/src/lapi.c#lua_setupvalue()
+func (ls *luaState) SetUpvalue(funcIdx, n int) (string, bool) {
+	name, uv, ok := auxUpvalue(ls.stack.get(funcIdx), n)
+	if ok {
+		*uv.val = ls.stack.pop()
+	}
+	return name, ok
+}
+
+// lua-5.3.4/src/lapi.c#aux_upvalue()
+func auxUpvalue(fn luaValue, n int) (string, *upvalue, bool) {
+	c := fn.closure()
+	if c == nil || n < 1 || n > len(c.upvals) {
+		return "", nil, false /* 'n' not in [1, #upvals] */
+	}
+	uv := c.upvals[n-1]
+	if c.proto == nil {
+		return "", uv, true
+	}
+	if n > len(c.proto.UpvalueNames) || c.proto.UpvalueNames[n-1] == "" {
+		return "(*no name)", uv, true
+	}
+	return c.proto.UpvalueNames[n-1], uv, true
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvalueid
+// lua-5.3.4/src/lapi.c#lua_upvalueid()
+func (ls *luaState) UpvalueId(funcIdx, n int) interface{} {
+	c := ls.stack.get(funcIdx).closure()
+	if n < 1 || n > len(c.upvals) {
+		panic("invalid upvalue index")
+	}
+	return c.upvals[n-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_upvaluejoin
+// lua-5.3.4/src/lapi.c#lua_upvaluejoin()
+func (ls *luaState) UpvalueJoin(funcIdx1, n1, funcIdx2, n2 int) {
+	c1 := ls.stack.get(funcIdx1).closure()
+	c2 := ls.stack.get(funcIdx2).closure()
+	if n1 < 1 || n1 > len(c1.upvals) || n2 < 1 || n2 > len(c2.upvals) {
+		panic("invalid upvalue index")
+	}
+	c1.upvals[n1-1] = c2.upvals[n2-1]
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_sethook
+// lua-5.3.4/src/ldebug.c#lua_sethook()
+func (ls *luaState) SetHook(f api.LuaHook, mask, count int) {
+	if f == nil || mask == 0 { /* turn off hooks? */
+		mask = 0
+		f = nil
+	}
+	ls.hook = f
+	ls.baseHookCount = count
+	ls.hookCount = count
+	ls.hookMask = mask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethook
+func (ls *luaState) GetHook() api.LuaHook {
+	return ls.hook
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookmask
+func (ls *luaState) GetHookMask() int {
+	return ls.hookMask
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_gethookcount
+func (ls *luaState) GetHookCount() int {
+	return ls.baseHookCount
+}
@@ -0,0 +1,146 @@
+package state
+
+import (
+	"fmt"
+	"lua_go/api"
+	"runtime"
+)
+
+// 设置元表时如果元表中有__gc字段, 就把表或userdata登记为需要终结的对象
+// lua-5.3.4/src/lgc.c#luaC_checkfinalizer()
+func (ls *luaState) checkFinalizer(o luaValue, mt *luaTable) {
+	if mt == nil || mt.get(stringValue("__gc")).isNil() {
+		return
+	}
+	var fin *bool
+	switch o.tag() {
+	case api.LUA_TTABLE:
+		fin = &o.table().fin
+	case api.LUA_TUSERDATA:
+		fin = &o.userdata().fin
+	}
+	if *fin { /* already marked */
+		return
+	}
+	*fin = true
+	ls.global.finobj = append(ls.global.finobj, o)
+}
+
+// __gc元方法出错时抛出, 被转换为LUA_ERRGCMM
+type gcError string
+
+func (e gcError) Error() string {
+	return string(e)
+}
+
+// 调用对象的__gc元方法, 终结器中不能让出, 不调用钩子;
+// propagateErrors为true时元方法的错误被重新抛出, 否则被忽略.
+// 调用之后对象不再是需要终结的, 除非再次设置带有__gc的元表
+// lua-5.3.4/src/lgc.c#GCTM()
+func (ls *luaState) callFinalizer(o luaValue, propagateErrors bool) {
+	switch o.tag() {
+	case api.LUA_TTABLE:
+		o.table().fin = false
+	case api.LUA_TUSERDATA:
+		o.userdata().fin = false
+	}
+	tm := getMetafield(o, "__gc", ls)
+	if tm.isNil() {
+		return
+	}
+	oldAllowHook := ls.allowHook
+	ls.allowHook = false /* stop debug hooks during GC metamethod */
+	ls.stack.check(2)
+	ls.stack.push(tm)
+	ls.stack.push(o)
+	status := ls.PCall(1, 0, 0)
+	ls.allowHook = oldAllowHook
+	if status == api.LUA_OK {
+		return
+	}
+	err := ls.stack.pop()
+	if !propagateErrors { /* ignore error message */
+		return
+	}
+	msg, ok := err.str()
+	if !ok {
+		msg = "no message"
+	}
+	switch status {
+	case api.LUA_ERRRUN: /* is there an error object? */
+		panic(gcError(fmt.Sprintf("error in __gc metamethod (%s)", msg)))
+	case api.LUA_ERRMEM:
+		panic(memError{})
+	default:
+		panic(gcError(msg)) /* re-throw error */
+	}
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.4/manual.html#lua_gc
+// lua-5.4.6/src/lapi.c#lua_gc()
+// 收集总是完整的, 步长倍率和模式只是记录下来; LUA_GCINC的data是新的间歇率, 为0时不变
+func (ls *luaState) GC(what, data int) int {
+	g := ls.global
+	res := 0
+	switch what {
+	case api.LUA_GCSTOP:
+		g.gcStopped = true
+	case api.LUA_GCRESTART:
+		g.gcDebt = 0
+		g.gcStopped = false
+	case api.LUA_GCCOLLECT:
+		if ls.collect() {
+			runtime.GC() /* let Go free the objects */
+		}
+	case api.LUA_GCCOUNT:
+		/* GC values are expressed in Kbytes: #bytes/2^10 */
+		res = int(ls.totalBytes() >> 10)
+	case api.LUA_GCCOUNTB:
+		res = int(ls.totalBytes() & 0x3ff)
+	case api.LUA_GCSTEP:
+		if data == 0 {
+			g.gcDebt = 0 /* do a full cycle */
+		} else { /* add 'data' to total debt */
+			g.gcDebt += int64(data) * 1024
+		}
+		if g.gcDebt >= 0 && ls.collect() { /* end of cycle? */
+			res = 1 /* signal it */
+		}
+	case api.LUA_GCSETPAUSE:
+		res = g.gcPause
+		g.gcPause = data
+	case api.LUA_GCSETSTEPMUL:
+		res = g.gcStepMul
+		g.gcStepMul = data
+	case api.LUA_GCISRUNNING:
+		if !g.gcStopped {
+			res = 1
+		}
+	case api.LUA_GCGEN:
+		res = g.gcMode
+		g.gcMode = api.LUA_GCGEN
+	case api.LUA_GCINC:
+		res = g.gcMode
+		g.gcMode = api.LUA_GCINC
+		if data != 0 {
+			g.gcPause = data
+		}
+	default:
+		res = -1 /* invalid option */
+	}
+	return res
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_close
+// lua-5.3.4/src/lstate.c#lua_close()
+func (ls *luaState) Close() {
+	g := ls.global
+	g.gcRunning = true /* no more collections */
+	ls.callAllPendingFinalizers(false)
+	for len(g.finobj) > 0 { // 终结器可能会登记新的对象
+		g.separateToBeFnz(newGCMarker(g)) /* separate all objects with finalizers */
+		ls.callAllPendingFinalizers(false)
+	}
+}
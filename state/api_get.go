@@ -1,13 +1,11 @@
 package state
 
-import (
-	"fmt"
-	"lua_go/api"
-)
+import "lua_go/api"
 
 func (ls *luaState) CreateTable(nArr, nRec int) {
+	ls.allocate(SIZE_TABLE + SIZE_TVALUE*int64(nArr) + SIZE_NODE*int64(nRec))
 	t := newLuaTable(nArr, nRec)
-	ls.stack.push(t)
+	ls.stack.push(tableValue(t))
 }
 
 func (ls *luaState) NewTable() {
@@ -20,54 +18,62 @@ func (ls *luaState) GetTable(idx int) api.LuaType {
 	return ls.getTable(t, k, false)
 }
 
+// lua-5.3.4/src/lvm.c#luaV_finishget()
 func (ls *luaState) getTable(t, k luaValue, raw bool) api.LuaType {
-	if tbl, ok := t.(*luaTable); ok {
-		v := tbl.get(k)
-		if raw || v != nil || !tbl.hasMetafield("__index") {
-			ls.stack.push(v)
-			return typeOf(v)
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		if tbl := t.table(); tbl != nil {
+			v := tbl.get(k)
+			if raw || !v.isNil() || !tbl.hasMetafield("__index") {
+				ls.stack.push(v)
+				return typeOf(v)
+			}
+		} else if raw {
+			panic("table expected!")
 		}
-	}
 
-	if !raw {
-		if mf := getMetafield(t, "__index", ls); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				return ls.getTable(x, k, false)
-			case *closure:
-				ls.stack.push(mf)
-				ls.stack.push(t)
-				ls.stack.push(k)
-				ls.Call(2, 1)
-				v := ls.stack.get(-1)
-				return typeOf(v)
+		mf := getMetafield(t, "__index", ls)
+		if mf.isNil() {
+			// 只有最初被索引的值才对应指令的操作数
+			operand := 0
+			if loop > 0 {
+				operand = -1
 			}
+			ls.valueTypeError(t, "index", operand) /* no metamethod */
+		}
+		if mf.tag() == api.LUA_TFUNCTION { /* is metamethod a function? */
+			ls.stack.push(mf)
+			ls.stack.push(t)
+			ls.stack.push(k)
+			ls.Call(2, 1)
+			v := ls.stack.get(-1)
+			return typeOf(v)
 		}
+		t = mf /* else try to access 'mf[k]' */
 	}
-
-	panic(fmt.Sprintf("Cannot read properties of %s (reading '%s')", ls.TypeName(typeOf(t)), k))
+	ls.runError("'__index' chain too long; possibly a loop")
+	return api.LUA_TNIL
 }
 
 func (ls *luaState) GetField(idx int, k string) api.LuaType {
 	t := ls.stack.get(idx)
-	return ls.getTable(t, k, false)
+	return ls.getTable(t, stringValue(k), false)
 }
 
 func (ls *luaState) GetI(idx int, i int64) api.LuaType {
 	t := ls.stack.get(idx)
-	return ls.getTable(t, i, false)
+	return ls.getTable(t, integerValue(i), false)
 }
 
 func (ls *luaState) GetGlobal(name string) api.LuaType {
-	t := ls.registry.get(api.LUA_RIDX_GLOBALS)
-	return ls.getTable(t, name, false)
+	t := ls.registry.getInt(api.LUA_RIDX_GLOBALS)
+	return ls.getTable(t, stringValue(name), false)
 }
 
 func (ls *luaState) GetMetatable(idx int) bool {
 	val := ls.stack.get(idx)
 
 	if mt := getMetatable(val, ls); mt != nil {
-		ls.stack.push(mt)
+		ls.stack.push(tableValue(mt))
 		return true
 	} else {
 		return false
@@ -82,5 +88,16 @@ func (ls *luaState) RawGet(idx int) api.LuaType {
 
 func (ls *luaState) RawGetI(idx int, i int64) api.LuaType {
 	t := ls.stack.get(idx)
-	return ls.getTable(t, i, true)
+	return ls.getTable(t, integerValue(i), true)
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_getuservalue
+func (ls *luaState) GetUserValue(idx int) api.LuaType {
+	u := ls.stack.get(idx).userdata()
+	if u == nil {
+		panic("full userdata expected!")
+	}
+	ls.stack.push(u.uservalue)
+	return typeOf(u.uservalue)
 }
@@ -4,40 +4,39 @@ import "lua_go/number"
 
 func (ls *luaState) Len(idx int) {
 	val := ls.stack.get(idx)
-	if s, ok := val.(string); ok {
-		ls.stack.push(int64(len(s)))
+	if s, ok := val.str(); ok {
+		ls.stack.push(integerValue(int64(len(s))))
 	} else if result, ok := callMetamethod(val, val, "__len", ls); ok {
 		ls.stack.push(result)
-	} else if t, ok := val.(*luaTable); ok {
-		ls.stack.push(int64(t.len()))
+	} else if t := val.table(); t != nil {
+		ls.stack.push(integerValue(int64(t.len())))
 	} else {
-		panic("length error!")
+		ls.valueTypeError(val, "get length of", 0)
 	}
 }
 
 func (ls *luaState) RawLen(idx int) uint {
 	val := ls.stack.get(idx)
-	switch x := val.(type) {
-	case string:
-		return uint(len(x))
-	case *luaTable:
-		return uint(x.len())
-	default:
-		return 0
+	if s, ok := val.str(); ok {
+		return uint(len(s))
+	} else if t := val.table(); t != nil {
+		return uint(t.len())
 	}
+	return 0
 }
 
 func (ls *luaState) Concat(n int) {
 	if n == 0 {
-		ls.stack.push("")
+		ls.stack.push(stringValue(""))
 	} else if n >= 2 {
 		for i := 1; i < n; i++ {
 			if ls.IsString(-1) && ls.IsString(-2) {
 				s2 := ls.ToString(-1)
 				s1 := ls.ToString(-2)
+				ls.allocate(SIZE_STRING + int64(len(s1)+len(s2)))
 				ls.stack.pop()
 				ls.stack.pop()
-				ls.stack.push(s1 + s2)
+				ls.stack.push(stringValue(s1 + s2))
 				continue
 			}
 
@@ -48,7 +47,7 @@ func (ls *luaState) Concat(n int) {
 				continue
 			}
 
-			panic("concatenation error!")
+			ls.concatError(a, b, n-1-i)
 		}
 	}
 	// n == 1, do nothing
@@ -56,11 +55,15 @@ func (ls *luaState) Concat(n int) {
 
 func (ls *luaState) Next(idx int) bool {
 	val := ls.stack.get(idx)
-	if t, ok := val.(*luaTable); ok {
+	if t := val.table(); t != nil {
 		key := ls.stack.pop()
-		if nextKey := t.nextKey(key); nextKey != nil {
+		nextKey, nextVal, ok := t.next(key)
+		if !ok {
+			ls.runError("invalid key to 'next'")
+		}
+		if !nextKey.isNil() {
 			ls.stack.push(nextKey)
-			ls.stack.push(t.get(nextKey))
+			ls.stack.push(nextVal)
 			return true
 		}
 		return false
@@ -68,9 +71,23 @@ func (ls *luaState) Next(idx int) bool {
 	panic("table expected!")
 }
 
+// [-0, +0, m]
+// http://www.lua.org/manual/5.4/manual.html#lua_toclose
+func (ls *luaState) ToClose(idx int) {
+	ls.newTBC(ls.stack.absIndex(idx) - 1)
+}
+
+// [-0, +0, e]
+// http://www.lua.org/manual/5.4/manual.html#lua_closeslot
+func (ls *luaState) CloseSlot(idx int) {
+	level := ls.stack.absIndex(idx) - 1
+	ls.closeTBC(level)
+	ls.stack.slots[level] = nilValue
+}
+
+// 错误对象是luaValue, 即使是nil也可以被recover区分
 func (ls *luaState) Error() int {
-	err := ls.stack.pop()
-	panic(err)
+	panic(ls.stack.pop())
 }
 
 func (ls *luaState) StringToNumber(s string) bool {
@@ -84,3 +101,11 @@ func (ls *luaState) StringToNumber(s string) bool {
 	}
 	return false
 }
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#lua_newuserdata
+// 和C不同, 这里不分配内存, 而是创建一个持有data的完整userdata
+func (ls *luaState) NewUserdata(data interface{}) {
+	ls.allocate(SIZE_OBJECT)
+	ls.stack.push(userdataValue(newUserdata(data)))
+}
@@ -3,31 +3,32 @@ package state
 import "lua_go/api"
 
 func (ls *luaState) PushNil() {
-	ls.stack.push(nil)
+	ls.stack.push(nilValue)
 }
 
 func (ls *luaState) PushBoolean(b bool) {
-	ls.stack.push(b)
+	ls.stack.push(boolValue(b))
 }
 
 func (ls *luaState) PushInteger(n int64) {
-	ls.stack.push(n)
+	ls.stack.push(integerValue(n))
 }
 
 func (ls *luaState) PushNumber(n float64) {
-	ls.stack.push(n)
+	ls.stack.push(floatValue(n))
 }
 
 func (ls *luaState) PushString(s string) {
-	ls.stack.push(s)
+	ls.allocString(s)
+	ls.stack.push(stringValue(s))
 }
 
 func (ls *luaState) PushGoFunction(f api.GoFunction) {
-	ls.stack.push(newGoClosure(f, 0))
+	ls.stack.push(closureValue(newGoClosure(f, 0)))
 }
 
 func (ls *luaState) PushGlobalTable() {
-	global := ls.registry.get(api.LUA_RIDX_GLOBALS)
+	global := ls.registry.getInt(api.LUA_RIDX_GLOBALS)
 	ls.stack.push(global)
 }
 
@@ -36,12 +37,35 @@ func (ls *luaState) PushGoClosure(f api.GoFunction, n int) {
 
 	for i := n; i > 0; i-- {
 		val := ls.stack.pop()
-		closure.upvals[n-1] = &upvalue{&val}
+		closure.upvals[i-1] = &upvalue{&val}
 	}
-	ls.stack.push(closure)
+	ls.stack.push(closureValue(closure))
 }
 
 func (ls *luaState) PushThread() bool {
-	ls.stack.push(ls)
+	ls.stack.push(threadValue(ls))
 	return ls.isMainThread()
 }
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_pushlightuserdata
+// p必须是可比较的值(通常是指针), 因为轻量userdata按值比较, 也可以作为表的键
+func (ls *luaState) PushLightUserdata(p interface{}) {
+	if !isHashable(p) {
+		panic("light userdata must be comparable!")
+	}
+	ls.stack.push(lightUserdataValue(p))
+}
+
+// 类型可以比较的值仍然可能包含不能比较的值, 比如struct{ X interface{} }{[]int{1}},
+// 所以检查实际的值: 在map中查找它, 不能作为键时Go会panic
+func isHashable(p interface{}) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	var probe map[interface{}]bool
+	_ = probe[p]
+	return true
+}
@@ -1,6 +1,9 @@
 package state
 
-import "lua_go/api"
+import (
+	"lua_go/api"
+	"math"
+)
 
 func (ls *luaState) SetTable(idx int) {
 	t := ls.stack.get(idx)
@@ -9,49 +12,69 @@ func (ls *luaState) SetTable(idx int) {
 	ls.setTable(t, k, v, false)
 }
 
+// lua-5.3.4/src/lvm.c#luaV_finishset()
 func (ls *luaState) setTable(t, k, v luaValue, raw bool) {
-	if tbl, ok := t.(*luaTable); ok {
-		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
-			tbl.put(k, v)
-			return
+	for loop := 0; loop < MAXTAGLOOP; loop++ {
+		if tbl := t.table(); tbl != nil {
+			if raw || !tbl.get(k).isNil() || !tbl.hasMetafield("__newindex") {
+				ls.checkTableKey(k)
+				if ls.global.limits.MaxMemory > 0 && !v.isNil() && tbl.get(k).isNil() {
+					ls.allocate(SIZE_NODE) /* new key */
+				}
+				tbl.put(k, v)
+				return
+			}
+		} else if raw {
+			panic("table expected!")
 		}
-	}
 
-	if !raw {
-		if mf := getMetafield(t, "__newindex", ls); mf != nil {
-			switch x := mf.(type) {
-			case *luaTable:
-				ls.setTable(x, k, v, false)
-				return
-			case *closure:
-				ls.stack.push(mf)
-				ls.stack.push(t)
-				ls.stack.push(k)
-				ls.stack.push(v)
-				ls.Call(3, 0)
-				return
+		mf := getMetafield(t, "__newindex", ls)
+		if mf.isNil() {
+			operand := 0
+			if loop > 0 {
+				operand = -1
 			}
+			ls.valueTypeError(t, "index", operand) /* no metamethod */
+		}
+		if mf.tag() == api.LUA_TFUNCTION { /* is metamethod a function? */
+			ls.stack.push(mf)
+			ls.stack.push(t)
+			ls.stack.push(k)
+			ls.stack.push(v)
+			ls.Call(3, 0)
+			return
 		}
+		t = mf /* else repeat assignment over 'mf' */
 	}
+	ls.runError("'__newindex' chain too long; possibly a loop")
+}
 
-	panic("index error!")
+// lua-5.3.4/src/ltable.c#luaH_newkey()
+func (ls *luaState) checkTableKey(k luaValue) {
+	if k.isNil() {
+		ls.runError("table index is nil")
+	}
+	if k.isFloat() && math.IsNaN(k.float()) {
+		ls.runError("table index is NaN")
+	}
 }
+
 func (ls *luaState) SetField(idx int, k string) {
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
-	ls.setTable(t, k, v, false)
+	ls.setTable(t, stringValue(k), v, false)
 }
 
 func (ls *luaState) SetI(idx int, i int64) {
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
-	ls.setTable(t, i, v, false)
+	ls.setTable(t, integerValue(i), v, false)
 }
 
 func (ls *luaState) SetGlobal(name string) {
-	t := ls.registry.get(api.LUA_RIDX_GLOBALS)
+	t := ls.registry.getInt(api.LUA_RIDX_GLOBALS)
 	v := ls.stack.pop()
-	ls.setTable(t, name, v, false)
+	ls.setTable(t, stringValue(name), v, false)
 }
 
 func (ls *luaState) Register(name string, f api.GoFunction) {
@@ -63,9 +86,9 @@ func (ls *luaState) SetMetatable(idx int) {
 	val := ls.stack.get(idx)
 	mtVal := ls.stack.pop()
 
-	if mtVal == nil {
+	if mtVal.isNil() {
 		setMetatable(val, nil, ls)
-	} else if mt, ok := mtVal.(*luaTable); ok {
+	} else if mt := mtVal.table(); mt != nil {
 		setMetatable(val, mt, ls)
 	} else {
 		panic("table expected!") // todo
@@ -82,5 +105,15 @@ func (ls *luaState) RawSet(idx int) {
 func (ls *luaState) RawSetI(idx int, i int64) {
 	t := ls.stack.get(idx)
 	v := ls.stack.pop()
-	ls.setTable(t, i, v, true)
+	ls.setTable(t, integerValue(i), v, true)
+}
+
+// [-1, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#lua_setuservalue
+func (ls *luaState) SetUserValue(idx int) {
+	u := ls.stack.get(idx).userdata()
+	if u == nil {
+		panic("full userdata expected!")
+	}
+	u.uservalue = ls.stack.pop()
 }
@@ -11,8 +11,11 @@ func (ls *luaState) AbsIndex(idx int) int {
 }
 
 func (ls *luaState) CheckStack(n int) bool {
+	if free := len(ls.stack.slots) - ls.stack.top; free < n && !ls.canGrowStack(n-free) {
+		return false
+	}
 	ls.stack.check(n)
-	return true // never fails
+	return true
 }
 
 func (ls *luaState) Pop(n int) {
@@ -65,13 +68,17 @@ func (ls *luaState) SetTop(idx int) {
 	}
 
 	n := ls.stack.top - newTop
+	if n > 0 && len(ls.stack.tbc) > 0 && !ls.stack.isLua() {
+		ls.closeTBC(newTop) /* close the removed to-be-closed slots */
+		n = ls.stack.top - newTop
+	}
 	if n > 0 {
 		for i := 0; i < n; i++ {
 			ls.stack.pop()
 		}
 	} else if n < 0 {
 		for i := 0; i > n; i-- {
-			ls.stack.push(nil)
+			ls.stack.push(nilValue)
 		}
 	}
 }
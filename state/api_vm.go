@@ -15,7 +15,7 @@ func (ls *luaState) Fetch() uint32 {
 }
 
 func (ls *luaState) GetConst(idx int) {
-	c := ls.stack.closure.proto.Constants[idx]
+	c := ls.stack.closure.consts.k[idx]
 	ls.stack.push(c)
 }
 
@@ -42,8 +42,9 @@ func (ls *luaState) LoadVararg(n int) {
 func (ls *luaState) LoadProto(idx int) {
 	stack := ls.stack
 	subProto := stack.closure.proto.Protos[idx]
-	closure := newLuaClosure(subProto)
-	stack.push(closure)
+	closure := newLuaClosure(subProto, stack.closure.consts.protos[idx])
+	ls.allocate(SIZE_OBJECT * int64(1+len(closure.upvals)))
+	stack.push(closureValue(closure))
 
 	for i, uvInfo := range subProto.Upvalues {
 		uvIdx := int(uvInfo.Idx)
@@ -71,4 +72,7 @@ func (ls *luaState) CloseUpvalues(a int) {
 			delete(ls.stack.openuvs, i)
 		}
 	}
+	if len(ls.stack.tbc) > 0 {
+		ls.closeTBC(a - 1)
+	}
 }
@@ -44,6 +44,47 @@ func TestArith(t *testing.T) {
 	}
 }
 
+func TestStringToNumber(t *testing.T) {
+	tests := []struct {
+		str      string
+		expected string
+	}{
+		{"10", "[10]"},
+		{"-0x10", "[-16]"},
+		{"0xA.8", "[10.5]"},
+		{"0x1p4", "[16]"},
+		{"0xffffffffffffffff", "[-1]"},
+		{"1e2", "[100]"},
+		{"0x", ""},
+		{"0x1_0", ""},
+		{"0x_1", ""},
+		{"1_0", ""},
+		{"0x1.8p1_0", ""},
+		{"inf", ""},
+		{"nan", ""},
+		{"0x1g", ""},
+		{" 10 ", "[10]"},
+		{"0x10 ", "[16]"},
+		{" 0x10 ", "[16]"},
+		{"\t-0x10\n", "[-16]"},
+		{"\f\v1e2\r", "[100]"},
+		{" 0xA.8 ", "[10.5]"},
+		{" ", ""},
+		{"1 0", ""},
+		{"0x 10", ""},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		if ok := ls.StringToNumber(tt.str); ok != (tt.expected != "") {
+			t.Fatalf("%q: expected %v got %v", tt.str, tt.expected != "", ok)
+		}
+		if actual := stringifyStack(ls); actual != tt.expected {
+			t.Fatalf("%q: expected %q got %q", tt.str, tt.expected, actual)
+		}
+	}
+}
+
 func stringifyStack(ls LuaState) string {
 	var ans = ""
 	top := ls.GetTop()
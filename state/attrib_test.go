@@ -0,0 +1,90 @@
+package state
+
+import (
+	"lua_go/api"
+	"strings"
+	"testing"
+)
+
+const closableLib = `
+local log = {}
+local function closable(name)
+  return setmetatable({}, {__close = function(_, err)
+    log[#log+1] = name .. (err and ":" .. tostring(err):match(":%s*([^:]*)$") or "")
+  end})
+end
+local function result() return table.concat(log, ",") end
+`
+
+func TestLocalAttribs(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local x <const> = 1 local y <const> = x + 1 return y`, "2"},
+		{`local t <const> = {} t.a = 1 return t.a`, "1"},
+		{`local s <const> = "abc" return s:upper() .. #s`, "ABC3"},
+		{`do local a <close> = closable("a") local b <close> = closable("b") end return result()`, "b,a"},
+		{`local x <close> = nil local y <close> = false return "ok"`, "ok"},
+		{`for i = 1, 3 do local c <close> = closable(i) if i == 2 then break end end return result()`, "1,2"},
+		{`local i = 0 ::top:: do local c <close> = closable(i) i = i + 1 if i < 3 then goto top end end return result()`, "0,1,2"},
+		{`local function f() local c <close> = closable("f") return "r" end return f() .. result()`, "rf"},
+		{`local function f() local c <close> = closable("f") return c end return (f() ~= nil) and result()`, "f"},
+		{`local function g() return "g" end
+		  local function f() local c <close> = closable("f") return g() end return f() .. result()`, "gf"},
+		{`local ok, err = pcall(function() local a <close> = closable("a") local b <close> = closable("b") error("boom") end)
+		  return result()`, "b:boom,a:boom"},
+		{`local ok, err = pcall(function() local a <close> = closable("a") do local b <close> = setmetatable({}, {__close = function() error("close") end}) end end)
+		  return tostring(ok) .. err:match(":%s*([^:]*)$") .. result()`, "falseclosea:close"},
+		{`local ok, err = pcall(function() local x <close> = {} end) return err`, "variable 'x' got a non-closable value"},
+		{`local co = coroutine.create(function() local c <close> = closable("co") coroutine.yield() end)
+		  coroutine.resume(co) coroutine.close(co) return result()`, "co"},
+		{`local co = coroutine.wrap(function() local c <close> = closable("co") error("e") end)
+		  pcall(co) return result()`, "co:e"},
+		{`repeat local c <close> = closable("r") until true return result()`, "r"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		if ls.LoadString(closableLib+tt.chunk) != api.LUA_OK {
+			t.Fatalf("%s", ls.ToString(-1))
+		}
+		if ls.PCall(0, 1, 0) != api.LUA_OK {
+			t.Fatalf("%s: %s", tt.chunk, ls.ToString(-1))
+		}
+		if actual := ls.ToString(-1); !strings.HasSuffix(actual, tt.expected) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
+
+func TestLocalAttribErrors(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{"local x <const> = 1; x = 2", "attempt to assign to const variable 'x'"},
+		{"local x <close> = nil; x = 1", "attempt to assign to const variable 'x'"},
+		{"local x <const> = 1; function f() x = 2 end", "attempt to assign to const variable 'x'"},
+		{"local f <const> = print; function f() end", "attempt to assign to const variable 'f'"},
+		{"local x <foo> = 1", "unknown attribute 'foo'"},
+		{"local a <close>, b <close> = nil", "multiple to-be-closed variables in local list"},
+		{"local x <const> = 1; do local x = 2; x = 3 end", ""},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		actual := ""
+		if ls.LoadString(tt.chunk) != api.LUA_OK {
+			actual = ls.ToString(-1)
+		}
+		expected := tt.expected
+		if expected != "" {
+			expected = `[string "` + tt.chunk + `"]:1: ` + expected
+		}
+		if actual != expected {
+			t.Fatalf("expected %q got %q", expected, actual)
+		}
+	}
+}
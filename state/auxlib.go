@@ -1,22 +1,67 @@
 package state
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"lua_go/api"
 	"lua_go/stdlib"
 	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
 )
 
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_error
+// lua-5.3.4/src/lauxlib.c#luaL_error()
 func (ls *luaState) Error2(format string, a ...interface{}) int {
-	s := fmt.Sprintf(format, a...)
-	ls.PushString(s)
-
+	ls.Where(1)
+	ls.PushString(fmt.Sprintf(format, a...))
+	ls.Concat(2)
 	return ls.Error()
 }
 
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_argerror
+// lua-5.3.4/src/lauxlib.c#luaL_argerror()
 func (ls *luaState) ArgError(arg int, extraMsg string) int {
-	// bad argument #arg to 'funcname' (extramsg)
-	return ls.Error2("bad argument #%d (%s)", arg, extraMsg)
+	var ar api.LuaDebug
+	if !ls.GetStack(0, &ar) { /* no stack frame? */
+		return ls.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	ls.GetInfo("n", &ar)
+	if ar.NameWhat == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return ls.Error2("calling '%s' on bad self (%s)", ar.Name, extraMsg)
+		}
+	}
+	if ar.Name == "" {
+		if name, ok := ls.globalFuncName(ar.CallInfo.(*luaStack).closure); ok {
+			ar.Name = name
+		} else {
+			ar.Name = "?"
+		}
+	}
+	return ls.Error2("bad argument #%d to '%s' (%s)", arg, ar.Name, extraMsg)
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_where
+// lua-5.3.4/src/lauxlib.c#luaL_where()
+func (ls *luaState) Where(level int) {
+	var ar api.LuaDebug
+	if ls.GetStack(level, &ar) { /* check function at level */
+		ls.GetInfo("Sl", &ar)   /* get info about it */
+		if ar.CurrentLine > 0 { /* is there info? */
+			ls.PushString(fmt.Sprintf("%s:%d: ", ar.ShortSrc, ar.CurrentLine))
+			return
+		}
+	}
+	ls.PushString("") /* else, no information available... */
 }
 
 func (ls *luaState) CheckStack2(sz int, msg string) {
@@ -93,6 +138,116 @@ func (ls *luaState) OptString(arg int, def string) string {
 	return ls.CheckString(arg)
 }
 
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkoption
+func (ls *luaState) CheckOption(arg int, def string, lst []string) int {
+	name := def
+	if def == "" || !ls.IsNoneOrNil(arg) {
+		name = ls.CheckString(arg)
+	}
+	for i, s := range lst {
+		if s == name {
+			return i
+		}
+	}
+	return ls.ArgError(arg, fmt.Sprintf("invalid option '%s'", name))
+}
+
+/* index of free-list header */
+const freelist = 0
+
+// [-1, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_ref
+// lua-5.3.4/src/lauxlib.c#luaL_ref()
+func (ls *luaState) Ref(t int) int {
+	if ls.IsNil(-1) {
+		ls.Pop(1)             /* remove it from stack */
+		return api.LUA_REFNIL /* 'nil' has a unique fixed reference */
+	}
+	t = ls.AbsIndex(t)
+	ls.RawGetI(t, freelist)      /* get first free element */
+	ref := int(ls.ToInteger(-1)) /* ref = t[freelist] */
+	ls.Pop(1)                    /* remove it from stack */
+	if ref != 0 {                /* any free element? */
+		ls.RawGetI(t, int64(ref)) /* remove it from list */
+		ls.RawSetI(t, freelist)   /* (t[freelist] = t[ref]) */
+	} else { /* no free elements */
+		ref = int(ls.RawLen(t)) + 1 /* get a new reference */
+	}
+	ls.RawSetI(t, int64(ref))
+	return ref
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_unref
+// lua-5.3.4/src/lauxlib.c#luaL_unref()
+func (ls *luaState) Unref(t, ref int) {
+	if ref >= 0 {
+		t = ls.AbsIndex(t)
+		ls.RawGetI(t, freelist)
+		ls.RawSetI(t, int64(ref)) /* t[ref] = t[freelist] */
+		ls.PushInteger(int64(ref))
+		ls.RawSetI(t, freelist) /* t[freelist] = ref */
+	}
+}
+
+// [-0, +(1|3), m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_fileresult
+// err为nil时压入true, 否则压入nil, 错误信息和错误码
+func (ls *luaState) FileResult(err error, fname string) int {
+	if err == nil {
+		ls.PushBoolean(true) /* file handle already on stack top */
+		return 1
+	}
+	ls.PushNil()
+	msg, en := strError(err)
+	if fname != "" {
+		ls.PushString(fmt.Sprintf("%s: %s", fname, msg))
+	} else {
+		ls.PushString(msg)
+	}
+	ls.PushInteger(int64(en))
+	return 3
+}
+
+// [-0, +3, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_execresult
+// err是exec.Cmd.Run或Wait返回的错误
+func (ls *luaState) ExecResult(err error) int {
+	what, stat := "exit", 0 /* type of termination and its status */
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		stat = exitErr.ExitCode()
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			what, stat = "signal", int(ws.Signal())
+		}
+	} else if err != nil { /* error with an 'errno'? */
+		return ls.FileResult(err, "")
+	}
+
+	if what == "exit" && stat == 0 { /* successful termination? */
+		ls.PushBoolean(true)
+	} else {
+		ls.PushNil()
+	}
+	ls.PushString(what)
+	ls.PushInteger(int64(stat))
+	return 3 /* return true/nil,what,code */
+}
+
+// 和C的strerror一样返回首字母大写的错误描述和错误码
+func strError(err error) (string, int) {
+	var en syscall.Errno
+	if errors.As(err, &en) {
+		msg := en.Error()
+		if msg != "" {
+			msg = strings.ToUpper(msg[:1]) + msg[1:]
+		}
+		return msg, int(en)
+	}
+	return err.Error(), 0
+}
+
 func (ls *luaState) DoFile(filename string) bool {
 	return ls.LoadFile(filename) != api.LUA_OK ||
 		ls.PCall(0, api.LUA_MULTRET, 0) != api.LUA_OK
@@ -107,11 +262,33 @@ func (ls *luaState) LoadFile(filename string) int {
 	return ls.LoadFileX(filename, "bt")
 }
 
+// filename为空时从标准输入读取
+// lua-5.3.4/src/lauxlib.c#luaL_loadfilex()
 func (ls *luaState) LoadFileX(filename, mode string) int {
-	if data, err := os.ReadFile(filename); err == nil {
-		return ls.Load(data, "@"+filename, mode)
+	var data []byte
+	var err error
+	chunkName := "@" + filename
+	if filename == "" {
+		chunkName = "=stdin"
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		if pathErr, ok := err.(*os.PathError); ok {
+			err = pathErr.Err
+		}
+		ls.PushString(fmt.Sprintf("cannot open %s: %v", chunkName[1:], err))
+		return api.LUA_ERRFILE
 	}
-	return api.LUA_ERRFILE
+	if len(data) > 0 && data[0] == '#' { // 跳过第一行的注释(如 #!/usr/bin/lua)
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i:]
+		} else {
+			data = nil
+		}
+	}
+	return ls.Load(data, chunkName, mode)
 }
 
 func (ls *luaState) LoadString(s string) int {
@@ -173,6 +350,71 @@ func (ls *luaState) ToString2(idx int) string {
 	return ls.CheckString(-1)
 }
 
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_newmetatable
+func (ls *luaState) NewMetatable(tname string) bool {
+	if ls.GetMetatableByName(tname) != api.LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	ls.Pop(1)
+	ls.CreateTable(0, 2) /* create metatable */
+	ls.PushString(tname)
+	ls.SetField(-2, "__name") /* metatable.__name = tname */
+	ls.PushValue(-1)
+	ls.SetField(api.LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_getmetatable
+func (ls *luaState) GetMetatableByName(tname string) api.LuaType {
+	return ls.GetField(api.LUA_REGISTRYINDEX, tname)
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.3/manual.html#luaL_setmetatable
+func (ls *luaState) SetMetatableByName(tname string) {
+	ls.GetMetatableByName(tname)
+	ls.SetMetatable(-2)
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_testudata
+// 参数是元表名为tname的完整userdata时返回它持有的Go值, 否则返回nil
+func (ls *luaState) TestUdata(arg int, tname string) interface{} {
+	if u := ls.testUdata(arg, tname); u != nil {
+		return u.data
+	}
+	return nil
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkudata
+func (ls *luaState) CheckUdata(arg int, tname string) interface{} {
+	u := ls.testUdata(arg, tname)
+	if u == nil {
+		ls.typeError(arg, tname)
+	}
+	return u.data
+}
+
+// lua-5.3.4/src/lauxlib.c#luaL_testudata()
+func (ls *luaState) testUdata(arg int, tname string) *userdata {
+	u := ls.stack.get(arg).userdata()
+	if u == nil { /* value is not a full userdata? */
+		return nil
+	}
+	if ls.GetMetatable(arg) { /* does it have a metatable? */
+		ls.GetMetatableByName(tname) /* get correct metatable */
+		eq := ls.RawEqual(-1, -2)
+		ls.Pop(2) /* remove both metatables */
+		if eq {
+			return u
+		}
+	}
+	return nil /* value is not a userdata with a metatable */
+}
+
 func (ls *luaState) GetSubTable(idx int, fname string) bool {
 	if ls.GetField(idx, fname) == api.LUA_TTABLE {
 		return true /* table already there */
@@ -211,6 +453,126 @@ func (ls *luaState) CallMeta(obj int, event string) bool {
 	return true
 }
 
+const (
+	LEVELS1 = 10 // size of the first part of the stack
+	LEVELS2 = 11 // size of the second part of the stack
+)
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_traceback
+// msg为空时不加前缀
+// lua-5.3.4/src/lauxlib.c#luaL_traceback()
+func (ls *luaState) Traceback(l1 api.LuaState, msg string, level int) {
+	var ar api.LuaDebug
+	last := lastLevel(l1)
+	n1 := -1
+	if last-level > LEVELS1+LEVELS2 {
+		n1 = LEVELS1
+	}
+
+	var sb strings.Builder
+	if msg != "" {
+		sb.WriteString(msg)
+		sb.WriteByte('\n')
+	}
+	sb.WriteString("stack traceback:")
+	for l1.GetStack(level, &ar) {
+		level++
+		if n1 == 0 { /* too many levels? */
+			sb.WriteString("\n\t...")  /* add a '...' */
+			level = last - LEVELS2 + 1 /* and skip to last ones */
+		} else {
+			l1.GetInfo("Slnt", &ar)
+			sb.WriteString("\n\t" + ar.ShortSrc + ":")
+			if ar.CurrentLine > 0 {
+				sb.WriteString(fmt.Sprintf("%d:", ar.CurrentLine))
+			}
+			sb.WriteString(" in ")
+			sb.WriteString(ls.funcNameForTrace(&ar))
+			if ar.IsTailCall {
+				sb.WriteString("\n\t(...tail calls...)")
+			}
+		}
+		n1--
+	}
+	ls.PushString(sb.String())
+}
+
+// lua-5.3.4/src/lauxlib.c#lastlevel()
+func lastLevel(ls api.LuaState) int {
+	var ar api.LuaDebug
+	li, le := 1, 1
+	/* find an upper bound */
+	for ls.GetStack(le, &ar) {
+		li = le
+		le *= 2
+	}
+	/* do a binary search */
+	for li < le {
+		m := (li + le) / 2
+		if ls.GetStack(m, &ar) {
+			li = m + 1
+		} else {
+			le = m
+		}
+	}
+	return le - 1
+}
+
+// lua-5.3.4/src/lauxlib.c#pushfuncname()
+func (ls *luaState) funcNameForTrace(ar *api.LuaDebug) string {
+	if name, ok := ls.globalFuncName(ar.CallInfo.(*luaStack).closure); ok {
+		return fmt.Sprintf("function '%s'", name) /* try first a global name */
+	} else if ar.NameWhat != "" { /* is there a name from code? */
+		return fmt.Sprintf("%s '%s'", ar.NameWhat, ar.Name) /* use it */
+	} else if ar.What == "main" {
+		return "main chunk"
+	} else if ar.What != "C" { /* for Lua functions, use <file:line> */
+		return fmt.Sprintf("function <%s:%d>", ar.ShortSrc, ar.LineDefined)
+	}
+	return "?" /* nothing left... */
+}
+
+// 在package.loaded中查找函数的名字
+// lua-5.3.4/src/lauxlib.c#pushglobalfuncname()
+func (ls *luaState) globalFuncName(c *closure) (string, bool) {
+	loaded := ls.registry.get(stringValue("_LOADED")).table()
+	if loaded == nil {
+		return "", false
+	}
+
+	fn := closureValue(c)
+	for _, modName := range sortedStringKeys(loaded) {
+		if loaded.get(stringValue(modName)) == fn {
+			return modName, true
+		}
+		mod := loaded.get(stringValue(modName)).table()
+		if mod == nil {
+			continue
+		}
+		for _, fieldName := range sortedStringKeys(mod) {
+			if mod.get(stringValue(fieldName)) == fn {
+				if modName == "_G" {
+					return fieldName, true
+				}
+				return modName + "." + fieldName, true
+			}
+		}
+	}
+	return "", false
+}
+
+func sortedStringKeys(t *luaTable) []string {
+	keys := make([]string, 0, len(t.nodes))
+	for _, n := range t.nodes {
+		if s, ok := n.key.str(); ok && !n.val.isNil() {
+			keys = append(keys, s)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
 func (ls *luaState) OpenLibs() {
 	libs := map[string]api.GoFunction{
 		"_G":        stdlib.OpenBaseLib,
@@ -218,9 +580,11 @@ func (ls *luaState) OpenLibs() {
 		"table":     stdlib.OpenTableLib,
 		"string":    stdlib.OpenStringLib,
 		"utf8":      stdlib.OpenUTF8Lib,
+		"io":        stdlib.OpenIOLib,
 		"os":        stdlib.OpenOSLib,
 		"package":   stdlib.OpenPackageLib,
 		"coroutine": stdlib.OpenCoroutineLib,
+		"debug":     stdlib.OpenDebugLib,
 	}
 
 	for name, fun := range libs {
@@ -229,6 +593,24 @@ func (ls *luaState) OpenLibs() {
 	}
 }
 
+// 按opts把库打开到全局表中
+func (ls *luaState) OpenLibsWithOptions(opts api.LibOptions) {
+	ls.PushGlobalTable()
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, "_LOADED")
+	stdlib.OpenLibsInto(ls, opts, false)
+}
+
+// 创建一个沙箱, 把它的环境表压入栈顶. 沙箱有自己的全局变量, 库和已加载模块表,
+// 在沙箱中执行的代码只能看到opts允许的库和函数, 无法访问其他沙箱和宿主的全局变量.
+// 用SetUpvalue把环境表设置为加载的代码块的第一个上值, 就可以在沙箱中执行代码块
+// [-0, +1, e]
+func (ls *luaState) NewSandbox(opts api.LibOptions) {
+	ls.NewTable() /* environment of the sandbox */
+	ls.PushValue(-1)
+	ls.NewTable() /* modules loaded by the sandbox */
+	stdlib.OpenLibsInto(ls, opts, true)
+}
+
 func (ls *luaState) RequireF(modname string, openf api.GoFunction, glb bool) {
 	ls.GetSubTable(api.LUA_REGISTRYINDEX, "_LOADED")
 	ls.GetField(-1, modname) /* LOADED[modname] */
@@ -259,11 +641,15 @@ func (ls *luaState) NewLibTable(l api.FuncReg) {
 func (ls *luaState) SetFuncs(l api.FuncReg, nup int) {
 	ls.CheckStack2(nup, "too many upvalues")
 	for name, fun := range l { /* fill the table with given functions */
-		for i := 0; i < nup; i++ { /* copy upvalues to the top */
-			ls.PushValue(-nup)
+		if fun == nil { /* place holder? */
+			ls.PushBoolean(false)
+		} else {
+			for i := 0; i < nup; i++ { /* copy upvalues to the top */
+				ls.PushValue(-nup)
+			}
+			ls.PushGoClosure(fun, nup) /* closure with those upvalues */
 		}
 		// r[-(nup+2)][name]=fun
-		ls.PushGoClosure(fun, nup) /* closure with those upvalues */
 		ls.SetField(-(nup + 2), name)
 	}
 	ls.Pop(nup) /* remove upvalues */
@@ -0,0 +1,48 @@
+package state
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTailCall(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local function loop(n, acc)
+		    if n == 0 then return acc end
+		    return loop(n - 1, acc + 1)
+		  end
+		  return loop(300000, 0)`, "300000"},
+		{`local isEven, isOdd
+		  function isEven(n) if n == 0 then return true end return isOdd(n - 1) end
+		  function isOdd(n) if n == 0 then return false end return isEven(n - 1) end
+		  return isEven(100001)`, "false"},
+		{`local function f() return debug.getinfo(1, "t").istailcall end
+		  local function g() return f() end
+		  return tostring(g()) .. " " .. tostring(f())`, "true false"},
+		{`local function f(...) return select("#", ...) end
+		  local function g() return f(1, nil, 3) end
+		  return g()`, "3"},
+		{`local t = setmetatable({}, {__call = function(self, a) return a * 2 end})
+		  local function g(x) return t(x) end
+		  return g(21)`, "42"},
+		{`local function g(s) return string.upper(s) end
+		  return g("abc")`, "ABC"},
+		{`local s = load([[local function f() return debug.traceback("tb") end
+		  local function g() return f() end
+		  return (g())]], "=t")()
+		  return s`, "tb\nstack traceback:\n\tt:1: in function <t:1>\n\t(...tail calls...)\n\tt:3: in main chunk"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString2(-1); !strings.HasPrefix(actual, tt.expected) {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
@@ -10,13 +10,36 @@ type upvalue struct {
 }
 
 type closure struct {
+	gcHeader
 	proto  *binchunk.Prototype // lua closure
+	consts *protoConsts        // lua closure
 	goFunc api.GoFunction      // go closure
 	upvals []*upvalue
 }
 
-func newLuaClosure(proto *binchunk.Prototype) *closure {
-	c := &closure{proto: proto}
+// 原型中的常量转换成luaValue之后的形式, 和原型一样组成树.
+// 加载代码块时转换一次, 被同一个原型创建的所有闭包共享
+type protoConsts struct {
+	k      []luaValue
+	protos []*protoConsts
+}
+
+func newProtoConsts(proto *binchunk.Prototype) *protoConsts {
+	pc := &protoConsts{
+		k:      make([]luaValue, len(proto.Constants)),
+		protos: make([]*protoConsts, len(proto.Protos)),
+	}
+	for i, k := range proto.Constants {
+		pc.k[i] = valueOf(k)
+	}
+	for i, p := range proto.Protos {
+		pc.protos[i] = newProtoConsts(p)
+	}
+	return pc
+}
+
+func newLuaClosure(proto *binchunk.Prototype, consts *protoConsts) *closure {
+	c := &closure{proto: proto, consts: consts}
 	if nUpvals := len(proto.Upvalues); nUpvals > 0 {
 		c.upvals = make([]*upvalue, nUpvals)
 	}
@@ -30,3 +53,78 @@ func newGoClosure(f api.GoFunction, nUpvals int) *closure {
 	}
 	return c
 }
+
+// 把当前调用帧中索引为level(从0开始)的值登记为待关闭的变量, false和nil不需要关闭
+// lua-5.4.6/src/lfunc.c#luaF_newtbcupval()
+func (ls *luaState) newTBC(level int) {
+	frame := ls.stack
+	if frame.slots[level].isFalse() {
+		return
+	}
+	if getMetafield(frame.slots[level], "__close", ls).isNil() { /* no metamethod? */
+		name, _ := ls.findLocal(frame, level+1)
+		if name == "" {
+			name = "?"
+		}
+		ls.runError("variable '%s' got a non-closable value", name)
+	}
+	frame.tbc = append(frame.tbc, level)
+}
+
+// 关闭当前调用帧中索引不小于level的待关闭变量, 后登记的先关闭
+// lua-5.4.6/src/lfunc.c#luaF_close()
+func (ls *luaState) closeTBC(level int) {
+	frame := ls.stack
+	for n := len(frame.tbc); n > 0 && frame.tbc[n-1] >= level; n = len(frame.tbc) {
+		v := frame.slots[frame.tbc[n-1]]
+		frame.tbc = frame.tbc[:n-1] /* remove it from the list before the call */
+		ls.callCloseMethod(v, nilValue)
+	}
+}
+
+// 调用__close元方法, 正常离开作用域时err为nil; 元方法中不能让出
+// lua-5.4.6/src/lfunc.c#callclosemethod()
+func (ls *luaState) callCloseMethod(v, err luaValue) {
+	ls.stack.check(3)
+	ls.stack.push(getMetafield(v, "__close", ls))
+	ls.stack.push(v)
+	ls.stack.push(err)
+	ls.callNoYield(2, 0)
+}
+
+// 出错时收集从当前调用帧到frame之间的调用帧中的待关闭变量, 包括frame中索引不小于level的,
+// 按关闭的顺序排列; 收集到的变量不再是待关闭的
+func (ls *luaState) pendingTBC(frame *luaStack, level int) []luaValue {
+	var vals []luaValue
+	for f := ls.stack; f != nil; f = f.prev {
+		i := len(f.tbc)
+		for i > 0 && (f != frame || f.tbc[i-1] >= level) {
+			i--
+			vals = append(vals, f.slots[f.tbc[i]])
+		}
+		f.tbc = f.tbc[:i]
+		if f == frame {
+			break
+		}
+	}
+	return vals
+}
+
+// 在保护模式下用错误对象调用待关闭变量的__close元方法, 元方法出错时新的错误代替原来的错误;
+// 调用期间还没有关闭的变量留在栈上, 以免被当作垃圾
+// lua-5.4.6/src/ldo.c#luaD_closeprotected()
+func (ls *luaState) closeProtected(vals []luaValue, err luaValue, status int) (luaValue, int) {
+	ls.stack.check(len(vals))
+	ls.stack.pushN(vals, -1)
+	for _, v := range vals {
+		ls.stack.check(3)
+		ls.stack.push(getMetafield(v, "__close", ls))
+		ls.stack.push(v)
+		ls.stack.push(err)
+		if s := ls.pcall(2, 0, nilValue); s != api.LUA_OK {
+			err, status = ls.stack.pop(), s
+		}
+	}
+	ls.stack.popN(len(vals))
+	return err, status
+}
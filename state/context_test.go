@@ -0,0 +1,77 @@
+package state
+
+import (
+	"context"
+	"lua_go/api"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContext(t *testing.T) {
+	tests := []struct {
+		chunk string
+		msg   string
+	}{
+		{`while true do end`, "context deadline exceeded"},
+		{`while true do pcall(function() while true do end end) end`, "context deadline exceeded"},
+		{`local ok = pcall(function() while true do end end) return "escaped"`, "context deadline exceeded"},
+		{`local co = coroutine.wrap(function() while true do coroutine.yield() end end)
+		  while true do co() end`, "context deadline exceeded"},
+		{`local co = coroutine.wrap(function() while true do end end)
+		  while true do pcall(co) end`, "context deadline exceeded"},
+		{`os.execute("sleep 10 >/dev/null 2>&1") return "finished"`, "context deadline exceeded"},
+		{`local f = io.popen("sleep 10 2>/dev/null; echo") f:read("a") return "finished"`, "context deadline exceeded"},
+	}
+
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		ls := NewWithContext(ctx)
+		ls.OpenLibs()
+		start := time.Now()
+		if ls.LoadString(tt.chunk) != api.LUA_OK {
+			t.Fatalf("%s", ls.ToString(-1))
+		}
+		if status := ls.PCall(0, 1, 0); status != api.LUA_ERRRUN {
+			t.Fatalf("%s: expected status %d got %d (%s)", tt.chunk, api.LUA_ERRRUN, status, ls.ToString(-1))
+		}
+		if msg := ls.ToString(-1); !strings.HasSuffix(msg, tt.msg) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.msg, msg)
+		}
+		if d := time.Since(start); d > 5*time.Second {
+			t.Fatalf("%s: took %v to stop", tt.chunk, d)
+		}
+		cancel()
+	}
+}
+
+func TestSetContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ls := New()
+	ls.OpenLibs()
+	ls.SetContext(ctx)
+	cancel()
+	if ls.LoadString(`for i = 1, 1e6 do end`) != api.LUA_OK || ls.PCall(0, 0, 0) != api.LUA_ERRRUN {
+		t.Fatalf("expected the canceled context to stop the script")
+	}
+	if msg := ls.ToString(-1); !strings.HasSuffix(msg, "context canceled") {
+		t.Fatalf("expected %q got %q", "context canceled", msg)
+	}
+
+	ls.SetContext(context.Background()) /* the state is usable again */
+	if actual := doStringOn(t, ls, `local s = 0 for i = 1, 1e4 do s = s + i end return s`); actual != "50005000" {
+		t.Fatalf("expected %q got %q", "50005000", actual)
+	}
+}
+
+func TestSetNilContext(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.SetContext(nil)
+	if ls.Context() == nil {
+		t.Fatalf("expected a non-nil context")
+	}
+	if actual := doStringOn(t, ls, `local s = 0 for i = 1, 1e4 do s = s + i end return s`); actual != "50005000" {
+		t.Fatalf("expected %q got %q", "50005000", actual)
+	}
+}
@@ -0,0 +1,158 @@
+package state
+
+import (
+	"lua_go/api"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCoroutine(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local co = coroutine.create(function(a, b)
+		    local c = coroutine.yield(a + b)
+		    return c * 2
+		  end)
+		  local _, x = coroutine.resume(co, 1, 2)
+		  local _, y = coroutine.resume(co, 10)
+		  return x .. "," .. y .. "," .. coroutine.status(co)`, "3,20,dead"},
+		{`local co = coroutine.create(function()
+		    local ok, msg = pcall(function() error(coroutine.yield(1), 0) end)
+		    return tostring(ok) .. " " .. msg
+		  end)
+		  coroutine.resume(co)
+		  return select(2, coroutine.resume(co, "boom"))`, "false boom"},
+		{`local co = coroutine.create(function()
+		    return select(2, xpcall(function() error(coroutine.yield(), 0) end,
+		                            function(m) return "handled " .. m end))
+		  end)
+		  coroutine.resume(co)
+		  return select(2, coroutine.resume(co, "x"))`, "handled x"},
+		{`local mt = {__index = function(t, k) return coroutine.yield(k) end,
+		              __add = function() return coroutine.yield("add") end,
+		              __lt = function() return coroutine.yield("lt") end,
+		              __concat = function() return coroutine.yield("concat") end}
+		  local t = setmetatable({}, mt)
+		  local co = coroutine.create(function()
+		    return t.foo, t + 1, t <= t, "a" .. t .. "b"
+		  end)
+		  local r, _, v = {}, coroutine.resume(co)
+		  while coroutine.status(co) ~= "dead" do
+		    r[#r + 1] = v
+		    _, v = coroutine.resume(co, #r)
+		  end
+		  return table.concat(r, " ")`, "foo add lt concat"},
+		{`local co = coroutine.create(function()
+		    for i in function(_, i) if i < 3 then return coroutine.yield(i) end end, nil, 0 do end
+		    return "done"
+		  end)
+		  local s = ""
+		  repeat local _, v = coroutine.resume(co, (s:len() + 1)); s = s .. tostring(v) until coroutine.status(co) == "dead"
+		  return s`, "02done"},
+		{`local co = coroutine.create(function() table.sort({3, 2, 1}, function() coroutine.yield() end) end)
+		  return select(2, coroutine.resume(co))`, "attempt to yield across a C-call boundary"},
+		{`return select(2, pcall(coroutine.yield))`, "attempt to yield from outside a coroutine"},
+		{`local co = coroutine.create(function() end)
+		  coroutine.resume(co)
+		  return select(2, coroutine.resume(co))`, "cannot resume dead coroutine"},
+		{`local gen = coroutine.wrap(function(a)
+		    for i = 1, 2 do a = coroutine.yield(a + i) end
+		    return "end"
+		  end)
+		  return gen(10) .. gen(20) .. gen(30) .. " " .. select(2, pcall(gen))`, "1122end cannot resume dead coroutine"},
+		{`return load([[local f = coroutine.wrap(function() error("bad") end)
+		  local ok, msg = pcall(function() return (f()) end)
+		  return msg]], "=w")()`, "w:2: w:1: bad"},
+		{`local co
+		  co = coroutine.create(function()
+		    local inner = coroutine.create(function() return coroutine.status(co) end)
+		    coroutine.yield(coroutine.status(co), select(2, coroutine.resume(inner)), select(2, coroutine.resume(co)))
+		  end)
+		  local s = coroutine.status(co)
+		  local _, a, b, c = coroutine.resume(co)
+		  return table.concat({s, a, b, c, coroutine.status(co)}, ",")`,
+			"suspended,running,normal,cannot resume non-suspended coroutine,suspended"},
+		{`local co = coroutine.create(function() coroutine.yield() end)
+		  coroutine.resume(co)
+		  local ok = coroutine.close(co)
+		  return tostring(ok) .. " " .. coroutine.status(co) .. " " .. select(2, coroutine.resume(co))`,
+			"true dead cannot resume dead coroutine"},
+		{`local co = coroutine.create(function() error("x", 0) end)
+		  coroutine.resume(co)
+		  local ok, err = coroutine.close(co)
+		  return tostring(ok) .. " " .. err .. " " .. coroutine.status(co)`, "false x dead"},
+		{`return select(2, pcall(coroutine.close, coroutine.running()))`, "cannot close a running coroutine"},
+		{`return tostring(coroutine.isyieldable()) .. " " ..
+		    tostring(select(2, coroutine.resume(coroutine.create(coroutine.isyieldable))))`, "false true"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString2(-1); !strings.HasSuffix(actual, tt.expected) {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestCoroutineContinuation(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	// Go函数调用的Lua函数让出后, 由延续函数完成Go函数剩下的工作
+	ls.Register("twice", func(ls api.LuaState) int {
+		ls.PushValue(1)
+		ls.CallK(0, 1, 1, func(ls api.LuaState, status int, ctx api.KContext) int {
+			ls.PushValue(1)
+			ls.CallK(0, 1, 2, func(ls api.LuaState, status int, ctx api.KContext) int {
+				ls.Concat(2)
+				return 1
+			})
+			ls.Concat(2)
+			return 1
+		})
+		ls.PushValue(1)
+		ls.Call(0, 1)
+		ls.Concat(2)
+		return 1
+	})
+	ls.Register("yieldk", func(ls api.LuaState) int {
+		ls.PushString("keep")
+		ls.PushInteger(1)
+		return ls.YieldK(1, 0, func(ls api.LuaState, status int, ctx api.KContext) int {
+			ls.PushString(ls.ToString(1) + ls.ToString(2))
+			return 1
+		})
+	})
+	ls.LoadString(`local co = coroutine.create(function()
+	    local s = twice(function() return coroutine.yield("y") end)
+	    return s .. yieldk()
+	  end)
+	  coroutine.resume(co)
+	  coroutine.resume(co, "a")
+	  local _, v = coroutine.resume(co, "b")
+	  local _, w = coroutine.resume(co, "!")
+	  return v .. " " .. w`)
+	ls.Call(0, 1)
+	if actual := ls.ToString(-1); actual != "1 abkeep!" {
+		t.Fatalf("expected %q got %q", "1 abkeep!", actual)
+	}
+}
+
+func TestCoroutineNoGoroutine(t *testing.T) {
+	n := runtime.NumGoroutine()
+	ls := New()
+	ls.OpenLibs()
+	ls.LoadString(`for i = 1, 100 do
+	    local co = coroutine.create(function() coroutine.yield() end)
+	    coroutine.resume(co)
+	  end`)
+	ls.Call(0, 0)
+	if m := runtime.NumGoroutine(); m > n {
+		t.Fatalf("expected at most %d goroutines got %d", n, m)
+	}
+}
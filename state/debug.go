@@ -0,0 +1,487 @@
+package state
+
+import (
+	"fmt"
+	"lua_go/api"
+	"lua_go/binchunk"
+	"lua_go/vm"
+)
+
+const LUA_ENV = "_ENV"
+
+// 算术和位运算指令对应的元方法, 顺序和操作码一致
+var arithEvents = []string{
+	"__add", "__sub", "__mul", "__mod", "__pow", "__div",
+	"__idiv", "__band", "__bor", "__bxor", "__shl", "__shr",
+}
+
+// 当前正在执行的指令, 调用钩子执行时还没有取出第一条指令
+// lua-5.3.4/src/ldebug.c#currentpc()
+func (ls *luaStack) currentPC() int {
+	if ls.pc > 0 {
+		return ls.pc - 1
+	}
+	return 0
+}
+
+// lua-5.3.4/src/ldebug.h#getfuncline()
+func getFuncLine(p *binchunk.Prototype, pc int) int {
+	if pc < len(p.LineInfo) {
+		return int(p.LineInfo[pc])
+	}
+	return -1
+}
+
+/*
+** Look for n-th local variable at line 'line' in function 'func'.
+** Returns "" if not found.
+ */
+// lua-5.3.4/src/lfunc.c#luaF_getlocalname()
+func getLocalName(p *binchunk.Prototype, localNumber, pc int) string {
+	for _, locVar := range p.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { /* is variable active? */
+			localNumber--
+			if localNumber == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return "" /* not found */
+}
+
+// 返回局部变量的名字和所在的位置
+// lua-5.3.4/src/ldebug.c#findlocal()
+func (ls *luaState) findLocal(frame *luaStack, n int) (string, *luaValue) {
+	var name string
+	if c := frame.closure; c.proto != nil {
+		if n < 0 { /* access to vararg values? */
+			return findVararg(frame, -n)
+		}
+		name = getLocalName(c.proto, n, frame.currentPC())
+	}
+	if name == "" { /* no 'standard' name? */
+		if n > 0 && n <= frame.top { /* is 'n' inside 'ci' stack? */
+			name = "(*temporary)" /* generic name for any valid slot */
+		} else {
+			return "", nil /* no name */
+		}
+	}
+	return name, &frame.slots[n-1]
+}
+
+// lua-5.3.4/src/ldebug.c#findvararg()
+func findVararg(frame *luaStack, n int) (string, *luaValue) {
+	if n > len(frame.varargs) {
+		return "", nil /* no such vararg */
+	}
+	return "(*vararg)", &frame.varargs[n-1] /* generic name for any vararg */
+}
+
+// 根据调用者正在执行的指令推测函数的名字
+// lua-5.3.4/src/ldebug.c#getfuncname()
+func (ls *luaState) getFuncName(frame *luaStack) (nameWhat, name string) {
+	if frame == nil {
+		return "", ""
+	}
+	if caller := frame.prev; !frame.isTailCall && caller != nil &&
+		caller.closure != nil && caller.closure.proto != nil {
+		return funcNameFromCode(caller)
+	}
+	return "", "" /* no way to determine the name */
+}
+
+// lua-5.3.4/src/ldebug.c#funcnamefromcode()
+func funcNameFromCode(frame *luaStack) (nameWhat, name string) {
+	p := frame.closure.proto
+	pc := frame.currentPC()
+	i := vm.Instruction(p.Code[pc])
+	if frame.hooked { /* was it called inside a hook? */
+		return "hook", "?"
+	}
+	switch op := i.Opcode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		a, _, _ := i.ABC()
+		return getObjName(p, pc, a) /* get function name */
+	case vm.OP_TFORCALL: /* for iterator */
+		return "for iterator", "for iterator"
+	/* other instructions can do calls through metamethods */
+	case vm.OP_SELF, vm.OP_GETTABUP, vm.OP_GETTABLE:
+		name = "__index"
+	case vm.OP_SETTABUP, vm.OP_SETTABLE:
+		name = "__newindex"
+	case vm.OP_ADD, vm.OP_SUB, vm.OP_MUL, vm.OP_MOD,
+		vm.OP_POW, vm.OP_DIV, vm.OP_IDIV, vm.OP_BAND,
+		vm.OP_BOR, vm.OP_BXOR, vm.OP_SHL, vm.OP_SHR:
+		name = arithEvents[op-vm.OP_ADD]
+	case vm.OP_UNM:
+		name = "__unm"
+	case vm.OP_BNOT:
+		name = "__bnot"
+	case vm.OP_LEN:
+		name = "__len"
+	case vm.OP_CONCAT:
+		name = "__concat"
+	case vm.OP_EQ:
+		name = "__eq"
+	case vm.OP_LT:
+		name = "__lt"
+	case vm.OP_LE:
+		name = "__le"
+	default: /* other instructions cannot call a function */
+		return "", ""
+	}
+	return "metamethod", name
+}
+
+/*
+** {======================================================
+** Symbolic Execution
+** =======================================================
+ */
+
+// lua-5.3.4/src/ldebug.c#getobjname()
+func getObjName(p *binchunk.Prototype, lastPC, reg int) (nameWhat, name string) {
+	if name = getLocalName(p, reg+1, lastPC); name != "" { /* is a local? */
+		return "local", name
+	}
+	/* else try symbolic execution */
+	pc := findSetReg(p, lastPC, reg)
+	if pc == -1 { /* could not find instruction? */
+		return "", ""
+	}
+	i := vm.Instruction(p.Code[pc])
+	switch op := i.Opcode(); op {
+	case vm.OP_MOVE:
+		a, b, _ := i.ABC() /* move from 'b' to 'a' */
+		if b < a {
+			return getObjName(p, pc, b) /* get name for 'b' */
+		}
+	case vm.OP_GETTABUP, vm.OP_GETTABLE:
+		_, t, k := i.ABC() /* table index and key index */
+		var vn string      /* name of indexed variable */
+		if op == vm.OP_GETTABLE {
+			vn = getLocalName(p, t+1, pc)
+		} else {
+			vn = upvalName(p, t)
+		}
+		name = kName(p, pc, k)
+		if vn == LUA_ENV {
+			return "global", name
+		}
+		return "field", name
+	case vm.OP_GETUPVAL:
+		_, b, _ := i.ABC()
+		return "upvalue", upvalName(p, b)
+	case vm.OP_LOADK, vm.OP_LOADKX:
+		_, b := i.ABx()
+		if op == vm.OP_LOADKX {
+			b = vm.Instruction(p.Code[pc+1]).Ax()
+		}
+		if s, ok := p.Constants[b].(string); ok {
+			return "constant", s
+		}
+	case vm.OP_SELF:
+		_, _, k := i.ABC() /* key index */
+		return "method", kName(p, pc, k)
+	}
+	return "", "" /* could not find reasonable name */
+}
+
+// lua-5.3.4/src/ldebug.c#kname()
+func kName(p *binchunk.Prototype, pc, c int) string {
+	if c > 0xFF { /* is 'c' a constant? */
+		if s, ok := p.Constants[c&0xFF].(string); ok { /* literal constant? */
+			return s /* it is its own name */
+		}
+		/* else no reasonable name found */
+	} else { /* 'c' is a register */
+		if what, name := getObjName(p, pc, c); what == "constant" { /* found a constant name? */
+			return name /* 'name' already filled */
+		}
+		/* else no reasonable name found */
+	}
+	return "?" /* no reasonable name found */
+}
+
+// lua-5.3.4/src/ldebug.c#upvalname()
+func upvalName(p *binchunk.Prototype, uv int) string {
+	if uv < len(p.UpvalueNames) && p.UpvalueNames[uv] != "" {
+		return p.UpvalueNames[uv]
+	}
+	return "?"
+}
+
+// lua-5.3.4/src/ldebug.c#filterpc()
+func filterPC(pc, jmpTarget int) int {
+	if pc < jmpTarget { /* is code conditional (inside a jump)? */
+		return -1 /* cannot know who sets that register */
+	}
+	return pc /* current position sets that register */
+}
+
+/*
+** try to find last instruction before 'lastpc' that modified register 'reg'
+ */
+// lua-5.3.4/src/ldebug.c#findsetreg()
+func findSetReg(p *binchunk.Prototype, lastPC, reg int) int {
+	setReg := -1   /* keep last instruction that changed 'reg' */
+	jmpTarget := 0 /* any code before this address is conditional */
+	for pc := 0; pc < lastPC; pc++ {
+		i := vm.Instruction(p.Code[pc])
+		a, b, _ := i.ABC()
+		switch i.Opcode() {
+		case vm.OP_LOADNIL:
+			if a <= reg && reg <= a+b { /* set registers from 'a' to 'a+b' */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_TFORCALL:
+			if reg >= a+2 { /* affect all regs above its base */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_CALL, vm.OP_TAILCALL:
+			if reg >= a { /* affect all registers above base */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		case vm.OP_JMP:
+			_, sBx := i.AsBx()
+			dest := pc + 1 + sBx
+			/* jump is forward and do not skip 'lastpc'? */
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest /* update 'jmptarget' */
+			}
+		default:
+			if i.TestAMode() && reg == a { /* any instruction that set A */
+				setReg = filterPC(pc, jmpTarget)
+			}
+		}
+	}
+	return setReg
+}
+
+/*
+** {======================================================
+** Hooks
+** =======================================================
+ */
+
+// 在当前函数上调用钩子, 钩子执行期间不会再触发其他钩子
+// lua-5.3.4/src/ldo.c#luaD_hook()
+func (ls *luaState) callHook(event, line int) {
+	if ls.hook == nil || !ls.allowHook {
+		return
+	}
+	frame := ls.stack
+	top := frame.top
+	ar := &api.LuaDebug{Event: event, CurrentLine: line, CallInfo: frame}
+	frame.check(api.LUA_MINSTACK) /* ensure minimum stack size */
+	ls.allowHook = false          /* cannot call hooks inside a hook */
+	frame.hooked = true
+	ls.hook(ls, ar)
+	frame.hooked = false
+	ls.allowHook = true
+	ls.SetTop(top)
+}
+
+// 每条指令执行前调用, 处理行钩子和计数钩子
+// lua-5.3.4/src/ldebug.c#luaG_traceexec()
+func (ls *luaState) traceExec() {
+	frame := ls.stack
+	mask := ls.hookMask
+	ls.hookCount--
+	countHook := ls.hookCount == 0 && mask&api.LUA_MASKCOUNT != 0
+	if countHook {
+		ls.hookCount = ls.baseHookCount /* reset count */
+	} else if mask&api.LUA_MASKLINE == 0 {
+		return /* no line hook and count != 0; nothing to be done */
+	}
+	if countHook {
+		ls.callHook(api.LUA_HOOKCOUNT, -1) /* call count hook */
+	}
+	npc := frame.currentPC()
+	if mask&api.LUA_MASKLINE != 0 {
+		p := frame.closure.proto
+		newLine := getFuncLine(p, npc)
+		if npc == 0 || /* call linehook when enter a new function, */
+			npc <= frame.oldPC || /* when jump back (loop), or when */
+			newLine != getFuncLine(p, frame.oldPC) { /* enter a new line */
+			ls.callHook(api.LUA_HOOKLINE, newLine) /* call line hook */
+		}
+	}
+	frame.oldPC = npc
+}
+
+/*
+** {======================================================
+** Runtime errors
+** =======================================================
+ */
+
+const MAXTAGLOOP = 2000 /* limit for table tag-method chains (to avoid loops) */
+
+// 抛出运行时错误, 在Lua函数中出错时加上源文件和行号
+// lua-5.3.4/src/ldebug.c#luaG_runerror()
+func (ls *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if frame := ls.stack; frame.closure != nil && frame.closure.proto != nil {
+		/* if Lua function, add source:line information */
+		p := frame.closure.proto
+		line := getFuncLine(p, frame.currentPC())
+		msg = fmt.Sprintf("%s:%d: %s", binchunk.ChunkID(p.Source), line, msg)
+	}
+	panic(msg)
+}
+
+// operand是出错的值在当前指令中的操作数序号, 小于0表示不是指令的操作数
+// lua-5.3.4/src/ldebug.c#luaG_typeerror()
+func (ls *luaState) valueTypeError(o luaValue, op string, operand int) {
+	t := ls.objTypeName(o)
+	ls.runError("attempt to %s a %s value%s", op, t, ls.varInfo(operand))
+}
+
+// lua-5.3.4/src/ltm.c#luaT_objtypename()
+func (ls *luaState) objTypeName(o luaValue) string {
+	var mt *luaTable
+	switch o.tag() {
+	case api.LUA_TTABLE:
+		mt = o.table().metatable
+	case api.LUA_TUSERDATA:
+		mt = o.userdata().metatable
+	}
+	if mt != nil {
+		if name, ok := mt.get(stringValue("__name")).str(); ok {
+			return name
+		}
+	}
+	return ls.TypeName(typeOf(o))
+}
+
+// 根据当前执行的指令找出操作数对应的变量
+// lua-5.3.4/src/ldebug.c#varinfo()
+func (ls *luaState) varInfo(operand int) string {
+	frame := ls.stack
+	if operand < 0 || frame.closure == nil || frame.closure.proto == nil {
+		return ""
+	}
+	p := frame.closure.proto
+	pc := frame.currentPC()
+	i := vm.Instruction(p.Code[pc])
+	a, b, c := i.ABC()
+
+	var kind, name string
+	switch op := i.Opcode(); op {
+	case vm.OP_CALL, vm.OP_TAILCALL:
+		kind, name = getObjName(p, pc, a)
+	case vm.OP_GETTABUP: /* check whether 'o' is an upvalue */
+		kind, name = "upvalue", upvalName(p, b)
+	case vm.OP_SETTABUP:
+		kind, name = "upvalue", upvalName(p, a)
+	case vm.OP_GETTABLE, vm.OP_SELF, vm.OP_UNM, vm.OP_BNOT, vm.OP_LEN:
+		kind, name = getObjName(p, pc, b)
+	case vm.OP_SETTABLE:
+		kind, name = getObjName(p, pc, a)
+	case vm.OP_CONCAT:
+		kind, name = getObjName(p, pc, b+operand)
+	default:
+		if op >= vm.OP_ADD && op <= vm.OP_SHR {
+			rk := b
+			if operand == 1 {
+				rk = c
+			}
+			if rk <= 0xFF { /* constants are not variables */
+				kind, name = getObjName(p, pc, rk)
+			}
+		}
+	}
+	if kind == "" || kind == "constant" { /* constants only name table keys (see kName) */
+		return ""
+	}
+	return fmt.Sprintf(" (%s '%s')", kind, name)
+}
+
+// lua-5.3.4/src/ltm.c#luaT_trybinTM()
+func (ls *luaState) arithError(p1, p2 luaValue, op api.ArithOp) {
+	ls.checkForLoop()
+	switch op {
+	case api.LUA_OPBAND, api.LUA_OPBOR, api.LUA_OPBXOR,
+		api.LUA_OPSHL, api.LUA_OPSHR, api.LUA_OPBNOT:
+		_, ok1 := convertToFloat(p1)
+		_, ok2 := convertToFloat(p2)
+		if ok1 && ok2 {
+			ls.toIntError(p1, p2)
+		} else {
+			ls.opIntError(p1, p2, "perform bitwise operation on")
+		}
+	default:
+		ls.opIntError(p1, p2, "perform arithmetic on")
+	}
+}
+
+/*
+** Error when both values are convertible to numbers, but not to integers
+ */
+// lua-5.3.4/src/ldebug.c#luaG_opinterror()
+func (ls *luaState) opIntError(p1, p2 luaValue, msg string) {
+	operand := 1
+	if _, ok := convertToFloat(p1); !ok { /* first operand is wrong? */
+		p2, operand = p1, 0 /* now second is wrong too */
+	}
+	ls.valueTypeError(p2, msg, operand)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_tointerror()
+func (ls *luaState) toIntError(p1, p2 luaValue) {
+	operand := 1
+	if _, ok := convertToInteger(p1); !ok {
+		operand = 0
+	}
+	ls.runError("number%s has no integer representation", ls.varInfo(operand))
+}
+
+// operand是左操作数在连接的操作数中的序号
+// lua-5.3.4/src/ldebug.c#luaG_concaterror()
+func (ls *luaState) concatError(p1, p2 luaValue, operand int) {
+	switch typeOf(p1) {
+	case api.LUA_TSTRING, api.LUA_TNUMBER:
+		p1 = p2
+		operand++
+	}
+	ls.valueTypeError(p1, "concatenate", operand)
+}
+
+// lua-5.3.4/src/ldebug.c#luaG_ordererror()
+func (ls *luaState) orderError(p1, p2 luaValue) {
+	ls.checkForLoop()
+	t1 := ls.objTypeName(p1)
+	t2 := ls.objTypeName(p2)
+	if t1 == t2 {
+		ls.runError("attempt to compare two %s values", t1)
+	} else {
+		ls.runError("attempt to compare %s with %s", t1, t2)
+	}
+}
+
+// 数值for循环的初值, 限制和步长都必须是数字
+// lua-5.3.4/src/lvm.c#luaV_execute()
+func (ls *luaState) checkForLoop() {
+	frame := ls.stack
+	if frame.closure == nil || frame.closure.proto == nil {
+		return
+	}
+	i := vm.Instruction(frame.closure.proto.Code[frame.currentPC()])
+	if op := i.Opcode(); op != vm.OP_FORPREP && op != vm.OP_FORLOOP {
+		return
+	}
+	a, _ := i.AsBx()
+	if _, ok := convertToFloat(frame.slots[a+1]); !ok {
+		ls.runError("'for' limit must be a number")
+	}
+	if _, ok := convertToFloat(frame.slots[a+2]); !ok {
+		ls.runError("'for' step must be a number")
+	}
+	if _, ok := convertToFloat(frame.slots[a]); !ok {
+		ls.runError("'for' initial value must be a number")
+	}
+}
@@ -0,0 +1,137 @@
+package state
+
+import (
+	"lua_go/api"
+	"strings"
+	"testing"
+)
+
+func TestDebugLib(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local function f(a, ...)
+		    local i = debug.getinfo(1, "nSlu")
+		    return table.concat({i.name, i.namewhat, i.what, i.currentline,
+		      i.linedefined, i.nparams, tostring(i.isvararg)}, ",")
+		  end
+		  local s = f()
+		  return s`, "f,local,Lua,2,1,1,true"},
+		{`local t = {}
+		  function t.m() return debug.getinfo(1, "n") end
+		  local i = t.m()
+		  return i.namewhat .. " " .. i.name`, "field m"},
+		{`local t = setmetatable({}, {__index = function()
+		    return debug.getinfo(1, "n").name
+		  end})
+		  return t.x`, "__index"},
+		{`return debug.getinfo(print, "S").what .. debug.getinfo(1, "S").what`, "Cmain"},
+		{`local function f() end
+		  local i, p = debug.getinfo(f), debug.getinfo(print, "t")
+		  return i.what .. tostring(i.istailcall) .. tostring(p.istailcall) .. tostring(i.func == f)`, "Luafalsefalsetrue"},
+		{`local function f(a, b, ...)
+		    local x = 1
+		    debug.setlocal(1, 3, 42)
+		    local n1, v1 = debug.getlocal(1, 1)
+		    local n2, v2 = debug.getlocal(1, -1)
+		    return table.concat({n1, v1, n2, v2, x}, ",")
+		  end
+		  return f(7, 8, 9)`, "a,7,(*vararg),9,42"},
+		{`return debug.getlocal(function(p, q) end, 2)`, "q"},
+		{`local up = 1
+		  local function g() return up end
+		  local name = debug.getupvalue(g, 1)
+		  debug.setupvalue(g, 1, 5)
+		  return name .. g()`, "up5"},
+		{`local a, b = 1, 2
+		  local function f() return a end
+		  local function g() return b end
+		  debug.upvaluejoin(f, 1, g, 1)
+		  return tostring(f()) .. tostring(debug.upvalueid(f, 1) == debug.upvalueid(g, 1))`, "2true"},
+		{`local lines = {}
+		  debug.sethook(function(ev, line) lines[#lines + 1] = line end, "l")
+		  local x = 1
+		  x = x + 1
+		  debug.sethook()
+		  return table.concat(lines, ",")`, "3,4,5"},
+		{`local n = 0
+		  debug.sethook(function() n = n + 1 end, "", 1)
+		  for i = 1, 10 do end
+		  debug.sethook()
+		  local _, mask, count = debug.gethook()
+		  return tostring(n > 10) .. mask .. count`, "true0"},
+		{`local calls = 0
+		  debug.sethook(function(ev) if ev == "call" then calls = calls + 1 end end, "c")
+		  local function f() end
+		  f() f()
+		  debug.sethook()
+		  return calls`, "3"},
+		{`local co = coroutine.create(function(x) local y = x * 2; coroutine.yield() end)
+		  coroutine.resume(co, 4)
+		  local _, v = debug.getlocal(co, 1, 2)
+		  return v`, "8"},
+		{`return debug.getmetatable("").__index == string`, "true"},
+		{`return (debug.getregistry()._LOADED.debug == debug)`, "true"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString2(-1); actual != tt.expected {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestTraceback(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	ls.LoadString(`local function lvl(n)
+	  if n == 0 then local s = debug.traceback("msg"); return s end
+	  return (lvl(n - 1))
+	end
+	local t = {f = function() return (lvl(30)) end}
+	local s = t.f()
+	return s`)
+	ls.Call(0, 1)
+	tb := ls.ToString(-1)
+	expected := []string{
+		"msg\nstack traceback:\n\t[string \"local function lvl(n)...\"]:2: in upvalue 'lvl'",
+		"\n\t...\n",
+		"in field 'f'",
+		"in main chunk",
+	}
+	for _, s := range expected {
+		if !strings.Contains(tb, s) {
+			t.Fatalf("%q not found in traceback:\n%s", s, tb)
+		}
+	}
+	if n := strings.Count(tb, "\n\t"); n != 10+11+1 {
+		t.Fatalf("expected %d levels got %d:\n%s", 10+11+1, n, tb)
+	}
+}
+
+func TestGoHook(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	var events []int
+	ls.SetHook(func(l api.LuaState, ar *api.LuaDebug) {
+		events = append(events, ar.Event)
+	}, api.LUA_MASKCALL|api.LUA_MASKRET, 0)
+	ls.LoadString(`local function f() end f()`)
+	ls.Call(0, 0)
+	ls.SetHook(nil, 0, 0)
+
+	expected := []int{api.LUA_HOOKCALL, api.LUA_HOOKCALL, api.LUA_HOOKRET, api.LUA_HOOKRET}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %v got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Fatalf("expected %v got %v", expected, events)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"bytes"
+	"lua_go/binchunk"
+	"lua_go/compiler"
+	"reflect"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	chunk := `
+local t = {1, 2.5, "str", true, nil, string.rep("x", 50)}
+local function f(a, ...)
+  local n = select("#", ...)
+  return function() return a + n end
+end
+return f(40, 1, 2)()`
+
+	proto, err := compiler.Compile(chunk, "@test.lua")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := binchunk.Dump(proto, false)
+	if !reflect.DeepEqual(binchunk.Undump(data), proto) {
+		t.Fatalf("undump(dump(proto)) differs from proto")
+	}
+	if !bytes.Equal(binchunk.Dump(binchunk.Undump(data), false), data) {
+		t.Fatalf("dump is not stable")
+	}
+
+	stripped := binchunk.Undump(binchunk.Dump(proto, true))
+	if len(stripped.LineInfo) != 0 || len(stripped.LocVars) != 0 ||
+		len(stripped.UpvalueNames) != 0 || stripped.Source != "" {
+		t.Fatalf("debug info not stripped")
+	}
+}
+
+func TestStringDump(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local f = load(string.dump(function(a) return a * 2 end)); return f(21)`, "42"},
+		{`local f = load(string.dump(function(s) return s:upper() end, true)); return f("ok")`, "OK"},
+		{`local ok = pcall(string.dump, print); return tostring(ok)`, "false"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString(-1); actual != tt.expected {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
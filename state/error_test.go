@@ -0,0 +1,57 @@
+package state
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRuntimeError(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local t; t.x = 1`, `:1: attempt to index a nil value (local 't')`},
+		{`foo()`, `:1: attempt to call a nil value (global 'foo')`},
+		{`("x")()`, `:1: attempt to call a string value`},
+		{`local a = {}; return a.bar.baz`, `:1: attempt to index a nil value (field 'bar')`},
+		{`local a = {}; a:m()`, `:1: attempt to call a nil value (method 'm')`},
+		{`local x; return x + 1`, `:1: attempt to perform arithmetic on a nil value (local 'x')`},
+		{`local x = {}; return 1 + x`, `:1: attempt to perform arithmetic on a table value (local 'x')`},
+		{`local z = 0; return 1 // z`, `:1: attempt to perform 'n//0'`},
+		{`local z = 0; return 1 % z`, `:1: attempt to perform 'n%0'`},
+		{`return 1 // 0`, `:1: attempt to perform 'n//0'`},
+		{`local z = 0.0; return 1 // z == math.huge and 1 % z ~= 1 % z`, ``},
+		{`return tonumber(" 10 ") == 10 and tonumber("0x10 ") == 16 and " 0x10 " + 0 == 16`, ``},
+		{`return 1.5 | 1`, `:1: number has no integer representation`},
+		{`local s; return "a" .. s`, `:1: attempt to concatenate a nil value (local 's')`},
+		{`return {} < {}`, `:1: attempt to compare two table values`},
+		{`return 1 < "x"`, `:1: attempt to compare number with string`},
+		{`return #nil`, `:1: attempt to get length of a nil value`},
+		{`local t = {}; t[nil] = 1`, `:1: table index is nil`},
+		{`local t = setmetatable({}, {__index = "abc"}); return t.len == string.len`, ``},
+		{`local t = setmetatable({}, {}); getmetatable(t).__index = t; return t.x`, `'__index' chain too long; possibly a loop`},
+		{`for i = 1, nil do end`, `:1: 'for' limit must be a number`},
+		{`local t = setmetatable({}, {__name = "Point"}); return t()`, `:1: attempt to call a Point value (local 't')`},
+		{`error("boom")`, `:1: boom`},
+		{`error("boom", 0)`, `boom`},
+		{"local function f()\n error('deep', 2)\nend\nf()", `:4: deep`},
+		{`return table.concat({}, ",", 1, 1 << 62)`, `:1: invalid value (nil) at index 1 in table for 'concat'`},
+		{`string.rep()`, `:1: bad argument #1 to 'rep' (string expected, got no value)`},
+		{`("x"):rep({})`, `:1: bad argument #1 to 'rep' (number expected, got table)`},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.PCall(0, 1, 0)
+		actual := ls.ToString2(-1)
+		if tt.expected == "" {
+			if actual != "true" {
+				t.Fatalf("%s: expected true got %q", tt.chunk, actual)
+			}
+		} else if !strings.HasSuffix(actual, tt.expected) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,460 @@
+package state
+
+import (
+	"lua_go/api"
+	"lua_go/vm"
+	"runtime/metrics"
+	"strings"
+)
+
+/*
+** {======================================================
+** Garbage collection
+** =======================================================
+ */
+
+/*
+对象占用的内存由Go的垃圾收集器回收, 这里只实现Lua语义上的部分:
+从根出发标记可达的对象, 清除弱表中不可达的键和值, 找出不可达的需要终结的对象.
+收集只在安全点(两条指令之间)进行, 此时所有活动的值都在栈上或者可以从注册表到达,
+终结器在触发收集的线程上执行.
+*/
+
+const (
+	LUAI_GCPAUSE   = 200         // gcPause的默认值
+	LUAI_GCMUL     = 200         // gcStepMul的默认值
+	LUAI_GCMINHEAP = 1024 * 1024 // 开始收集时内存估计值的下限
+)
+
+// 表, 闭包, userdata和线程的头部. 每次标记使用新的编号, 对象的mark等于它时已经被标记,
+// 所以不需要清除上一次标记留下的值
+type gcHeader struct {
+	mark uint32
+}
+
+type gcMarker struct {
+	epoch     uint32           // 这次标记的编号
+	strings   map[uintptr]bool // 已经统计过的字符串的底层数组的地址, 只用于统计内存
+	gray      []luaValue       // 已经标记但还没有遍历的对象
+	weak      []*luaTable      // 只有值是弱引用的表
+	ephemeron []*luaTable      // 只有键是弱引用的表
+	allweak   []*luaTable      // 键和值都是弱引用的表
+	dead      []*luaTable      // 可能有死键的表
+	total     int64            // 标记的对象大约占用的字节数
+}
+
+// 标记的状态保存在globalState中, 每次标记重复使用上次分配的空间; 同一时间只有一次标记
+func newGCMarker(g *globalState) *gcMarker {
+	g.gcEpoch++
+	m := &g.marker
+	m.epoch = g.gcEpoch
+	m.total = 0
+	return m
+}
+
+// 标记结束后调用, 清空各个列表但保留它们的容量, 不再引用任何对象
+func (m *gcMarker) finish() {
+	for p := range m.strings {
+		delete(m.strings, p)
+	}
+	gray := m.gray[:cap(m.gray)]
+	for i := range gray {
+		gray[i] = nilValue
+	}
+	m.gray = gray[:0]
+	m.weak = clearTables(m.weak)
+	m.ephemeron = clearTables(m.ephemeron)
+	m.allweak = clearTables(m.allweak)
+	m.dead = clearTables(m.dead)
+}
+
+func clearTables(tables []*luaTable) []*luaTable {
+	for i := range tables {
+		tables[i] = nil
+	}
+	return tables[:0]
+}
+
+// 对象的头部, 不是对象时返回nil
+func (v luaValue) header() *gcHeader {
+	switch v.tag() {
+	case api.LUA_TTABLE:
+		return &v.table().gcHeader
+	case api.LUA_TFUNCTION:
+		return &v.closure().gcHeader
+	case api.LUA_TUSERDATA:
+		return &v.userdata().gcHeader
+	case api.LUA_TTHREAD:
+		return &v.thread().gcHeader
+	}
+	return nil
+}
+
+// lua-5.3.4/src/lgc.c#markobject()
+func (m *gcMarker) mark(o luaValue) {
+	switch o.tag() {
+	case api.LUA_TSTRING:
+		if o.p == nil { /* empty string */
+			return
+		}
+		if m.strings == nil {
+			m.strings = map[uintptr]bool{}
+		}
+		if p := uintptr(o.p); !m.strings[p] { // 相同内容的字符串可能共享底层数组
+			m.strings[p] = true
+			m.total += SIZE_STRING + int64(o.n>>8)
+		}
+	case api.LUA_TTABLE, api.LUA_TFUNCTION, api.LUA_TUSERDATA, api.LUA_TTHREAD:
+		if h := o.header(); h.mark != m.epoch {
+			h.mark = m.epoch
+			m.gray = append(m.gray, o)
+		}
+	}
+}
+
+// 字符串和非对象的值永远不会从弱表中清除
+// lua-5.3.4/src/lgc.c#iscleared()
+func (m *gcMarker) isMarked(o luaValue) bool {
+	if h := o.header(); h != nil {
+		return h.mark == m.epoch
+	}
+	return true
+}
+
+// 标记注册表, 当前线程和等待终结的对象
+// lua-5.3.4/src/lgc.c#restartcollection()
+func (ls *luaState) markRoots(m *gcMarker) {
+	m.mark(tableValue(ls.registry))
+	m.mark(threadValue(ls))
+	for _, o := range ls.global.tobefnz {
+		m.mark(o)
+	}
+}
+
+// lua-5.3.4/src/lgc.c#propagateall()
+func (m *gcMarker) propagateAll() {
+	for len(m.gray) > 0 {
+		n := len(m.gray) - 1
+		o := m.gray[n]
+		m.gray = m.gray[:n]
+		m.traverse(o)
+	}
+}
+
+// lua-5.3.4/src/lgc.c#propagatemark()
+func (m *gcMarker) traverse(o luaValue) {
+	switch o.tag() {
+	case api.LUA_TTABLE:
+		m.traverseTable(o.table())
+	case api.LUA_TFUNCTION:
+		x := o.closure()
+		m.total += SIZE_OBJECT * int64(1+len(x.upvals))
+		for _, uv := range x.upvals {
+			if uv != nil {
+				m.mark(*uv.val)
+			}
+		}
+	case api.LUA_TUSERDATA:
+		x := o.userdata()
+		m.total += SIZE_OBJECT
+		if x.metatable != nil {
+			m.mark(tableValue(x.metatable))
+		}
+		m.mark(x.uservalue)
+	case api.LUA_TTHREAD:
+		m.traverseThread(o.thread())
+	}
+}
+
+// lua-5.3.4/src/lgc.c#traversetable()
+func (m *gcMarker) traverseTable(t *luaTable) {
+	m.total += SIZE_TABLE + SIZE_TVALUE*int64(cap(t.arr)) +
+		SIZE_NODE*int64(len(t.nodes))
+	if t.metatable != nil {
+		m.mark(tableValue(t.metatable))
+	}
+	if t.nDead > 0 {
+		m.dead = append(m.dead, t)
+	}
+	weakKey, weakValue := t.weakMode()
+	switch {
+	case weakKey && weakValue: /* nothing to traverse now */
+		m.allweak = append(m.allweak, t)
+	case weakValue: /* keys are strong */
+		for _, n := range t.nodes {
+			if !n.val.isNil() {
+				m.mark(n.key)
+			}
+		}
+		m.weak = append(m.weak, t)
+	case weakKey:
+		m.traverseEphemeron(t)
+		m.ephemeron = append(m.ephemeron, t)
+	default: /* strong table */
+		for _, v := range t.arr {
+			m.mark(v)
+		}
+		for _, n := range t.nodes {
+			if !n.val.isNil() { /* dead keys are not marked */
+				m.mark(n.key)
+				m.mark(n.val)
+			}
+		}
+	}
+}
+
+// lua-5.3.4/src/lgc.c#traversethread()
+func (m *gcMarker) traverseThread(th *luaState) {
+	for frame := th.stack; frame != nil; frame = frame.prev {
+		m.total += SIZE_TVALUE * int64(len(frame.slots)+len(frame.varargs))
+		if frame.isLua() {
+			m.markRegisters(frame)
+		} else {
+			for _, v := range frame.slots[:frame.top] {
+				m.mark(v)
+			}
+		}
+		for _, v := range frame.varargs {
+			m.mark(v)
+		}
+		for _, v := range frame.yieldBelow {
+			m.mark(v)
+		}
+		if frame.closure != nil {
+			m.mark(closureValue(frame.closure))
+		}
+		m.mark(frame.msgh)
+	}
+}
+
+// 一般不知道Lua函数的哪些寄存器是活的, 只能标记所有的寄存器. 但是上一条指令是
+// OP_CALL时(正在调用或者刚刚返回), 从R(A)开始除了保存返回值的寄存器都是死的;
+// 执行钩子时pc指向的是还没有执行的指令, 所以不适用
+func (m *gcMarker) markRegisters(frame *luaStack) {
+	nRegs := int(frame.closure.proto.MaxStackSize)
+	live := nRegs
+	if pc := frame.pc - 1; pc >= 0 && !frame.hooked {
+		if i := vm.Instruction(frame.closure.proto.Code[pc]); i.Opcode() == vm.OP_CALL {
+			a, _, c := i.ABC()
+			if live = a; c > 1 {
+				live += c - 1 /* registers for the results */
+			}
+		}
+	}
+	for _, v := range frame.slots[:live] {
+		m.mark(v)
+	}
+	if frame.top > nRegs { /* values above the registers */
+		for _, v := range frame.slots[nRegs:frame.top] {
+			m.mark(v)
+		}
+	}
+}
+
+// 弱键表中只有键已经标记的项的值才被标记, 返回是否标记了新的对象
+// lua-5.3.4/src/lgc.c#traverseephemeron()
+func (m *gcMarker) traverseEphemeron(t *luaTable) bool {
+	n := len(m.gray)
+	for _, v := range t.arr { /* integer keys are never cleared */
+		m.mark(v)
+	}
+	for _, n := range t.nodes {
+		if !n.val.isNil() && m.isMarked(n.key) {
+			m.mark(n.val)
+		}
+	}
+	return len(m.gray) > n
+}
+
+// 反复遍历弱键表, 直到不再有新的对象被标记
+// lua-5.3.4/src/lgc.c#convergeephemerons()
+func (m *gcMarker) convergeEphemerons() {
+	for changed := true; changed; {
+		changed = false
+		for i := 0; i < len(m.ephemeron); i++ { /* propagation may add new tables */
+			if m.traverseEphemeron(m.ephemeron[i]) {
+				m.propagateAll()
+				changed = true
+			}
+		}
+	}
+}
+
+// 清除值没有被标记的项
+// lua-5.3.4/src/lgc.c#clearvalues()
+func (m *gcMarker) clearByValues(tables []*luaTable) {
+	for _, t := range tables {
+		for i, v := range t.arr {
+			if !m.isMarked(v) {
+				t.arr[i] = nilValue
+			}
+		}
+		t.trimArray()
+		nDead := t.nDead
+		for i, n := range t.nodes {
+			if !m.isMarked(n.val) {
+				t.setNode(i, nilValue)
+			}
+		}
+		if nDead == 0 && t.nDead > 0 {
+			m.dead = append(m.dead, t)
+		}
+	}
+}
+
+// 清除键没有被标记的项
+// lua-5.3.4/src/lgc.c#clearkeys()
+func (m *gcMarker) clearByKeys(tables []*luaTable) {
+	for _, t := range tables {
+		nDead := t.nDead
+		for i, n := range t.nodes {
+			if !m.isMarked(n.key) {
+				t.setNode(i, nilValue)
+			}
+		}
+		if nDead == 0 && t.nDead > 0 {
+			m.dead = append(m.dead, t)
+		}
+	}
+}
+
+// 去掉键不可达的死键, 不可达的键不可能再被传给next. 可达的死键要保留
+func (m *gcMarker) removeDeadKeys() {
+	for _, t := range m.dead {
+		if t.nDead > 0 {
+			t.compact(m.isMarked)
+		}
+	}
+}
+
+// 元表中__mode字段包含'k'时键是弱引用, 包含'v'时值是弱引用
+func (lt *luaTable) weakMode() (weakKey, weakValue bool) {
+	if lt.metatable == nil {
+		return false, false
+	}
+	mode, ok := lt.metatable.get(stringValue("__mode")).str()
+	if !ok {
+		return false, false
+	}
+	return strings.IndexByte(mode, 'k') >= 0, strings.IndexByte(mode, 'v') >= 0
+}
+
+// 完整的收集周期. 不可达的需要终结的对象被移到tobefnz中, 在终结之前它们和
+// 从它们可达的对象仍然被当作可达的
+// lua-5.3.4/src/lgc.c#atomic()
+func (ls *luaState) fullGC() {
+	g := ls.global
+	m := newGCMarker(g)
+	ls.markRoots(m)
+	m.propagateAll()
+	m.convergeEphemerons()
+	/* at this point, all strongly accessible objects are marked. */
+	/* Clear values from weak tables, before checking finalizers */
+	m.clearByValues(m.weak)
+	m.clearByValues(m.allweak)
+	origWeak, origAll := len(m.weak), len(m.allweak)
+	g.separateToBeFnz(m)
+	for _, o := range g.tobefnz { /* mark objects that will be finalized */
+		m.mark(o)
+	}
+	m.propagateAll()
+	m.convergeEphemerons()
+	/* at this point, all resurrected objects are marked. */
+	/* remove dead objects from weak tables */
+	m.clearByKeys(m.ephemeron)
+	m.clearByKeys(m.allweak)
+	/* clear values from resurrected weak tables */
+	m.clearByValues(m.weak[origWeak:])
+	m.clearByValues(m.allweak[origAll:])
+	m.removeDeadKeys()
+	g.inUse, g.allocated = m.total, 0
+	g.setPause(m.total)
+	m.finish()
+}
+
+// 把finobj中没有被标记的对象按登记的相反顺序移到tobefnz的末尾
+// lua-5.3.4/src/lgc.c#separatetobefnz()
+func (g *globalState) separateToBeFnz(m *gcMarker) {
+	n := 0
+	var dead []luaValue
+	for _, o := range g.finobj {
+		if m.isMarked(o) {
+			g.finobj[n] = o
+			n++
+		} else {
+			dead = append(dead, o)
+		}
+	}
+	for i := n; i < len(g.finobj); i++ {
+		g.finobj[i] = nilValue
+	}
+	g.finobj = g.finobj[:n]
+	for i := len(dead) - 1; i >= 0; i-- {
+		g.tobefnz = append(g.tobefnz, dead[i])
+	}
+}
+
+// 内存估计值增长到estimate的gcPause%时开始下一次收集
+// lua-5.3.4/src/lgc.c#setpause()
+func (g *globalState) setPause(estimate int64) {
+	threshold := estimate / 100 * int64(g.gcPause)
+	if threshold < LUAI_GCMINHEAP {
+		threshold = LUAI_GCMINHEAP
+	}
+	g.gcDebt = estimate - threshold
+}
+
+// 在安全点调用, 分配的内存估计值达到阈值时进行一次收集, 然后调用终结器
+// lua-5.3.4/src/lgc.c#luaC_step()
+func (ls *luaState) checkGC() {
+	g := ls.global
+	if g.gcStopped { /* avoid being called too often */
+		g.gcDebt = -LUAI_GCMINHEAP
+		return
+	}
+	ls.collect()
+}
+
+// 收集一次然后调用终结器, 已经在收集或者调用终结器时什么也不做并返回false
+// lua-5.3.4/src/lgc.c#luaC_fullgc()
+func (ls *luaState) collect() bool {
+	g := ls.global
+	if g.gcRunning {
+		return false
+	}
+	g.gcRunning = true
+	defer func() { g.gcRunning = false }()
+	ls.fullGC()
+	ls.callAllPendingFinalizers(true)
+	return true
+}
+
+// 状态占用的内存估计值: 上次统计时可达的对象加上之后分配的对象.
+// 估计值按对象的近似大小计算, 不会超过Go的堆上实际分配的内存.
+// 堆的大小从runtime/metrics读取, 不像runtime.ReadMemStats那样暂停整个进程
+func (ls *luaState) totalBytes() int64 {
+	g := ls.global
+	total := g.inUse + g.allocated
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		if heap := int64(sample[0].Value.Uint64()); total > heap {
+			total = heap
+		}
+	}
+	return total
+}
+
+// 终结器出错时剩下的对象留在tobefnz中, 下次收集时再调用它们的终结器
+// lua-5.3.4/src/lgc.c#callallpendingfinalizers()
+func (ls *luaState) callAllPendingFinalizers(propagateErrors bool) {
+	g := ls.global
+	for len(g.tobefnz) > 0 {
+		o := g.tobefnz[0]
+		g.tobefnz[0] = nilValue
+		g.tobefnz = g.tobefnz[1:]
+		ls.callFinalizer(o, propagateErrors)
+	}
+}
+
+/* }====================================================== */
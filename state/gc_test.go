@@ -0,0 +1,259 @@
+package state
+
+import (
+	"lua_go/api"
+	"strings"
+	"testing"
+)
+
+func TestWeakTablesAndFinalizers(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local t = setmetatable({}, {__mode = "v"})
+		  local keep = {}
+		  local function fill() t[1] = {} t[2] = keep t.a = {} t.s = "str" end
+		  fill() collect()
+		  return t[1] == nil, t[2] == keep, t.a == nil, t.s`, "true,true,true,str"},
+		{`local t = setmetatable({}, {__mode = "k"})
+		  local keep = {}
+		  local function fill() local k = {} t[k] = {ref = k} t[keep] = 1 t.x = {} end
+		  fill() collect()
+		  local n = 0 for _ in pairs(t) do n = n + 1 end
+		  return n, t[keep], type(t.x)`, "2,1,table"},
+		{`local t = setmetatable({}, {__mode = "k"})
+		  local function fill() local k = {} t[k] = {ref = k} end
+		  fill() collect()
+		  return next(t) == nil`, "true"},
+		{`local t = setmetatable({}, {__mode = "kv"})
+		  local function fill() t[{}] = 1 t[1] = {} t.x = "s" end
+		  fill() collect()
+		  local n = 0 for _ in pairs(t) do n = n + 1 end
+		  return n`, "1"},
+		{`local log = {}
+		  local function mk(name) setmetatable({}, {__gc = function() log[#log+1] = name end}) end
+		  mk("a") mk("b") mk("c") collect()
+		  return table.concat(log, ",")`, "c,b,a"},
+		{`local log = {}
+		  local mt = {}
+		  local function fill() setmetatable({}, mt) end
+		  fill()
+		  mt.__gc = function() log[#log+1] = "x" end
+		  collect()
+		  return #log`, "0"},
+		{`local saved, n = nil, 0
+		  local function fill() setmetatable({x = 1}, {__gc = function(o) saved = o n = n + 1 end}) end
+		  fill() collect() collect()
+		  local x = saved.x saved = nil collect()
+		  return x, n`, "1,1"},
+		{`local wk = setmetatable({}, {__mode = "k"})
+		  local wv = setmetatable({}, {__mode = "v"})
+		  local function fill() local o = setmetatable({}, {__gc = function() end}) wk[o] = 1 wv[1] = o end
+		  fill() collect()
+		  local before = next(wk) ~= nil
+		  collect()
+		  return before, wv[1] == nil, next(wk) == nil`, "true,true,true"},
+		{`local t = setmetatable({}, {__mode = "v"})
+		  for i = 1, 100000 do t[i] = {} end
+		  local n = 0 for _ in pairs(t) do n = n + 1 end
+		  return n < 100000`, "true"},
+		{`local t = {a = 1, b = 2, c = 3}
+		  for k in pairs(t) do t[k] = nil end
+		  return next(t) == nil`, "true"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.Register("collect", func(ls api.LuaState) int {
+			ls.(*luaState).global.gcDebt = 1 /* collect at the next safe point */
+			return 0
+		})
+		if actual := doStringOn(t, ls, tt.chunk); actual != tt.expected {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
+
+func TestUserdataFinalizerAtSafePoint(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	collected := 0
+	ls.NewMetatable("res")
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		collected++
+		return 0
+	})
+	ls.SetField(-2, "__gc")
+	ls.Pop(1)
+	ls.Register("newres", func(ls api.LuaState) int {
+		ls.NewUserdata(struct{}{})
+		ls.SetMetatableByName("res")
+		return 1
+	})
+
+	doStringOn(t, ls, `for i = 1, 100000 do newres() local t = {} end`)
+	if collected == 0 {
+		t.Fatal("finalizers were not called during execution")
+	}
+	ls.Close()
+	if collected != 100000 {
+		t.Fatalf("expected 100000 finalizers got %d", collected)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`return collectgarbage(), collectgarbage("collect")`, "0,0"},
+		{`return math.type(collectgarbage("count")), collectgarbage("count") > 0`, "float,true"},
+		{`local t = {} for i = 1, 10000 do t[i] = {} end
+		  local before = collectgarbage("count")
+		  t = nil collectgarbage()
+		  return collectgarbage("count") < before`, "true"},
+		{`collectgarbage("stop") local a = collectgarbage("isrunning")
+		  collectgarbage("restart") return a, collectgarbage("isrunning")`, "false,true"},
+		{`local t = setmetatable({}, {__mode = "v"})
+		  collectgarbage("stop")
+		  for i = 1, 100000 do t[i] = {} end
+		  local n = #t collectgarbage("restart") collectgarbage()
+		  return n, #t`, "100000,0"},
+		{`local log = {}
+		  local function fill() setmetatable({}, {__gc = function() log[#log+1] = collectgarbage() end}) end
+		  fill() collectgarbage()
+		  return #log, log[1]`, "1,0"},
+		{`for i = 1, 1e5 do local x = {} end
+		  local t = setmetatable({}, {__mode = "k"})
+		  local function fill() t[{}] = 1 end
+		  fill() for i = 1, 1e5 do local x = {} end
+		  return next(t) == nil`, "true"},
+		{`local n = 0
+		  local function fill() setmetatable({}, {__gc = function() n = n + 1 end}) end
+		  fill() for i = 1, 1e5 do local x = {} end
+		  return n`, "1"},
+		{`return collectgarbage("step"), collectgarbage("step", 1 << 20)`, "true,true"},
+		{`return collectgarbage("setpause", 100), collectgarbage("setpause", 200)`, "200,100"},
+		{`return collectgarbage("setstepmul", 400), collectgarbage("setstepmul")`, "200,400"},
+		{`return collectgarbage("generational"), collectgarbage("incremental", 150, 300),
+		  collectgarbage("incremental"), collectgarbage("setpause", 200), collectgarbage("setstepmul", 200)`,
+			"incremental,generational,incremental,150,300"},
+		{`return pcall(collectgarbage, "foo")`,
+			"false,bad argument #1 to 'collectgarbage' (invalid option 'foo')"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		if actual := doStringOn(t, ls, tt.chunk); actual != tt.expected {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+
+	ls := New()
+	ls.OpenLibs()
+	if ls.GC(api.LUA_GCCOUNT, 0) <= 0 || ls.GC(api.LUA_GCISRUNNING, 0) != 1 {
+		t.Fatal("wrong GC state")
+	}
+	if ls.GC(-1, 0) != -1 {
+		t.Fatal("invalid option accepted")
+	}
+}
+
+// 自动收集在只打开基础库(没有带__gc的io标准流)和打开所有库时都要清除弱表, 统计内存
+func TestAutomaticCollection(t *testing.T) {
+	tests := []string{
+		`local t = setmetatable({}, {__mode = "k"})
+		 for i = 1, 2e5 do t[{}] = i end
+		 local n = 0 for _ in pairs(t) do n = n + 1 end
+		 return n < 1e5`,
+		`local mt = {}
+		 local t = setmetatable({}, mt)
+		 mt.__mode = "k" -- set after the metatable
+		 for i = 1, 2e5 do t[{}] = i end
+		 local n = 0 for _ in pairs(t) do n = n + 1 end
+		 return n < 1e5`,
+		`for i = 1, 2e5 do local x = {} end
+		 return collectgarbage("count") < 2048`,
+	}
+
+	for _, chunk := range tests {
+		for _, all := range []bool{false, true} {
+			ls := New()
+			if all {
+				ls.OpenLibs()
+			} else {
+				ls.OpenLibsWithOptions(api.LibOptions{Libs: map[string][]string{"_G": nil}})
+			}
+			if actual := doStringOn(t, ls, chunk); actual != "true" {
+				t.Fatalf("%s (all libs: %v): expected true got %q", chunk, all, actual)
+			}
+		}
+	}
+}
+
+func TestFinalizerErrors(t *testing.T) {
+	const chunk = `local log = {}
+		local function fill()
+		  setmetatable({}, {__gc = function() log[#log+1] = "a" end})
+		  setmetatable({}, {__gc = function() error("boom") end})
+		  setmetatable({}, {__gc = function() error({}) end})
+		end
+		fill()
+		local ok1, msg1 = pcall(collectgarbage)
+		local ok2, msg2 = pcall(collectgarbage)
+		collectgarbage()
+		return tostring(ok1) .. " " .. msg1 .. "|" .. tostring(ok2) .. " " .. msg2 .. "|" .. #log`
+	ls := New()
+	ls.OpenLibs()
+	if actual := doStringOn(t, ls, chunk); !strings.HasPrefix(actual, "false error in __gc metamethod (no message)|false error in __gc metamethod (") ||
+		!strings.HasSuffix(actual, ":4: boom)|1") {
+		t.Fatalf("unexpected %q", actual)
+	}
+
+	ls.LoadString(`setmetatable({}, {__gc = function() error("boom") end}) collectgarbage()`)
+	if status := ls.PCall(0, 0, 0); status != api.LUA_ERRGCMM {
+		t.Fatalf("expected status %d got %d", api.LUA_ERRGCMM, status)
+	}
+	if msg := ls.ToString(-1); !strings.HasPrefix(msg, "error in __gc metamethod (") {
+		t.Fatalf("unexpected %q", msg)
+	}
+	ls.Pop(1)
+
+	doStringOn(t, ls, `local function fill() setmetatable({}, {__gc = function() error("ignored") end}) end fill()`)
+	ls.Close() /* errors in finalizers are ignored when closing the state */
+}
+
+const benchAlloc = `
+local keep = {}
+for i = 1, 20000 do keep[i] = {i} end
+for i = 1, 20000 do
+  keep[i] = {i, "s" .. i}
+end`
+
+// 没有弱表和终结器时, 自动收集不应该比停止收集慢很多.
+// 打开标准库后io的标准流总是带有__gc, 所以这是通常的情况
+func BenchmarkAllocWithoutWeakOrFinalizers(b *testing.B) {
+	for _, stopped := range []bool{false, true} {
+		name := "auto"
+		if stopped {
+			name = "stopped"
+		}
+		b.Run(name, func(b *testing.B) {
+			ls := New()
+			ls.OpenLibs()
+			if stopped {
+				ls.GC(api.LUA_GCSTOP, 0)
+			}
+			ls.LoadString(benchAlloc)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ls.PushValue(-1)
+				ls.Call(0, 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package state
+
+import (
+	"lua_go/api"
+	"testing"
+)
+
+func TestGoto(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{
+			chunk: `
+local s = 0
+for i = 1, 10 do
+  if i % 2 == 0 then goto continue end
+  local x = i
+  s = s + x
+  ::continue::
+end
+return s`,
+			expected: "25",
+		},
+		{
+			chunk: `
+local n = 0
+::top::
+n = n + 1
+if n < 5 then goto top end
+return n`,
+			expected: "5",
+		},
+		{
+			chunk: `
+local fs = {}
+do
+  local i = 1
+  ::again::
+  local j = i
+  fs[#fs+1] = function() return j end
+  i = i + 1
+  if i <= 3 then goto again end
+end
+return fs[1]() .. fs[2]() .. fs[3]()`,
+			expected: "123",
+		},
+		{
+			chunk: `
+local fs = {}
+for i = 1, 3 do
+  do
+    local m = i
+    fs[#fs+1] = function() return m end
+    if i == 3 then break end
+  end
+end
+return fs[1]() .. fs[2]() .. fs[3]()`,
+			expected: "123",
+		},
+		{
+			chunk: `
+for a = 1, 3 do
+  for b = 1, 3 do
+    if a * b == 4 then goto done end
+  end
+end
+do return "not reached" end
+::done::
+return "done"`,
+			expected: "done",
+		},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString(-1); actual != tt.expected {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestGotoErrors(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{"goto l; local x; ::l:: print(x)", "<goto l> at line 1 jumps into the scope of local 'x'"},
+		{"do ::a:: ::a:: end", "label 'a' already defined on line 1"},
+		{"goto nowhere", "no visible label 'nowhere' for <goto> at line 1"},
+		{"do goto l end ::l::", ""},
+		{"break", "<break> at line 1 not inside a loop"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		actual := ""
+		if ls.LoadString(tt.chunk) != api.LUA_OK {
+			actual = ls.ToString(-1)
+		}
+		expected := tt.expected
+		if expected != "" {
+			expected = `[string "` + tt.chunk + `"]:1: ` + expected
+		}
+		if actual != expected {
+			t.Fatalf("expected %q got %q", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"lua_go/stdlib"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIOLib(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "io.txt")
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local f = assert(io.open(NAME, "w"))
+		  f:write("hello\n", 42, " ", 1.5, "\n0x10 rest")
+		  f:close()
+		  return io.type(f)`, "closed file"},
+		{`local f = io.open(NAME)
+		  local a, b = f:read("l", "L")
+		  local n = f:read("n")
+		  local r = f:read("a")
+		  f:close()
+		  return table.concat({a, b, math.type(n), n, r}, "|")`, "hello|42 1.5\n|integer|16| rest"},
+		{`local t = {}
+		  for l in io.lines(NAME) do t[#t + 1] = l end
+		  return table.concat(t, ",")`, "hello,42 1.5,0x10 rest"},
+		{`local f = io.open(NAME, "r+")
+		  f:seek("set", 1)
+		  f:write("E")
+		  local pos = f:seek("cur")
+		  f:seek("set")
+		  local s = f:read(5)
+		  f:close()
+		  return s .. pos`, "hEllo2"},
+		{`local f = io.open(NAME)
+		  return tostring(f:read(0)) .. tostring(f:seek("end")) .. tostring(f:read(0))`, "22nil"},
+		{`local f, msg = io.open(NAME .. ".missing")
+		  return tostring(f) .. " " .. tostring(msg:find("missing", 1, true) ~= nil)`, "nil true"},
+		{`return tostring(pcall(io.open, NAME, "rw"))`, "false"},
+		{`local f = io.tmpfile()
+		  f:write("tmp")
+		  f:seek("set")
+		  return f:read("a")`, "tmp"},
+		{`local f = io.tmpfile()
+		  f:write("tmp")
+		  f:seek("set")
+		  return f:read(1 << 62) .. tostring(f:read(1 << 62))`, "tmpnil"},
+		{`local f = io.popen("echo popen")
+		  local s = f:read("l")
+		  return s .. tostring(f:close())`, "popentrue"},
+		{`local ok, msg = pcall(function() for l in io.lines(NAME) do io.close(io.input()) end end)
+		  return tostring(io.close())`, "nil"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.PushString(name)
+		ls.SetGlobal("NAME")
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString(-1); actual != tt.expected {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestSetStdStream(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	out := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(in, []byte("line1\nline2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fin, _ := os.Open(in)
+	defer fin.Close()
+	fout, _ := os.Create(out)
+
+	ls := New()
+	ls.OpenLibs()
+	stdlib.SetStdStream(ls, "stdin", fin)
+	stdlib.SetStdStream(ls, "stdout", fout)
+	if ls.DoString(`for l in io.lines() do io.write(l:upper(), ";") end
+	             io.stdout:write("end")`) {
+		t.Fatal(ls.ToString(-1))
+	}
+	fout.Close()
+
+	data, _ := os.ReadFile(out)
+	if actual := string(data); !strings.EqualFold(actual, "LINE1;LINE2;end") {
+		t.Fatalf("unexpected output %q", actual)
+	}
+}
@@ -0,0 +1,176 @@
+package state
+
+import (
+	"context"
+	"lua_go/api"
+)
+
+/*
+** {======================================================
+** Execution limits
+** =======================================================
+ */
+
+const EXTRA_CALLS = 200 // 处理栈溢出错误时消息处理函数额外可用的调用深度
+
+// [-0, +0, –]
+// 设置执行限制, 同时把已经执行的指令数清零
+func (ls *luaState) SetLimits(limits api.Limits) {
+	g := ls.global
+	g.limits = limits
+	g.instructions = 0
+}
+
+// [-0, +0, –]
+func (ls *luaState) GetLimits() api.Limits {
+	return ls.global.limits
+}
+
+// 每执行一条指令调用一次, 超出指令限制后每条指令都会出错, 所以无法用pcall绕过
+func (ls *luaState) countInstruction() {
+	g := ls.global
+	if g.instructions++; g.instructions > g.limits.MaxInstructions {
+		ls.runError("instruction limit exceeded")
+	}
+}
+
+func (ls *luaState) maxCalls() int {
+	if max := ls.global.limits.MaxCallDepth; max > 0 && max < api.LUAI_MAXCALLS {
+		return max
+	}
+	return api.LUAI_MAXCALLS
+}
+
+// lua-5.3.4/src/ldo.c#luaD_call()
+func (ls *luaState) checkCallDepth() {
+	max := ls.maxCalls()
+	if ls.errorDepth > 0 { /* error while handling stack error? */
+		max += EXTRA_CALLS
+	}
+	if ls.baseCalls+ls.nCalls >= max {
+		ls.runError("stack overflow")
+	}
+}
+
+func (ls *luaState) canGrowStack(n int) bool {
+	max := ls.global.limits.MaxStackSlots
+	return max <= 0 || ls.nSlots+n <= max
+}
+
+// 调用帧的栈槽增加n个
+// lua-5.3.4/src/ldo.c#luaD_growstack()
+func (ls *luaState) growStack(n int) {
+	if !ls.canGrowStack(n) && ls.errorDepth == 0 {
+		ls.runError("stack overflow")
+	}
+	ls.nSlots += n
+}
+
+const CONTEXT_CHECK_INTERVAL = 1000 // 每执行多少条指令检查一次ctx
+
+// [-0, +0, –]
+// 设置控制状态的所有线程的ctx, ctx被取消或者超时后虚拟机会抛出错误;
+// 错误被pcall捕获后下一条指令还会再次出错, 所以错误会一直传播到最外层的调用者.
+// ctx为nil时等同于context.Background(), 即不再限制执行时间
+func (ls *luaState) SetContext(ctx context.Context) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	g := ls.global
+	g.ctx, g.done = ctx, ctx.Done()
+	g.ctxCount = CONTEXT_CHECK_INTERVAL
+}
+
+// [-0, +0, –]
+func (ls *luaState) Context() context.Context {
+	return ls.global.ctx
+}
+
+func (ls *luaState) checkContext() {
+	g := ls.global
+	select {
+	case <-g.done:
+		g.ctxCount = 0 /* check again before the next instruction */
+		ls.runError("%s", g.ctx.Err().Error())
+	default:
+		g.ctxCount = CONTEXT_CHECK_INTERVAL
+	}
+}
+
+/* }====================================================== */
+
+/*
+** {======================================================
+** Memory accounting
+** =======================================================
+ */
+
+// 估计的对象大小, 用于内存限制和决定什么时候收集垃圾
+const (
+	SIZE_TABLE  = 64 // 空表
+	SIZE_TVALUE = 32 // 数组部分的一个元素, 即一个luaValue
+	SIZE_NODE   = 80 // 哈希部分的一个键值对和它的索引
+	SIZE_STRING = 16 // 字符串头部, 不包括内容
+	SIZE_OBJECT = 32 // 闭包, userdata, 上值等其他对象
+)
+
+// 一次最多分配的字节数, 即使没有设置内存限制, 更大的分配也直接作为内存错误.
+// Go分配失败时整个进程会退出, 无法被pcall捕获, 所以不能把超出物理内存的请求交给运行时
+const MAX_ALLOC = 1 << 30
+
+// 超出内存限制或者分配过大时抛出, 被转换为LUA_ERRMEM.
+// Go函数可以直接panic它或者包装了它的错误
+type memError struct{}
+
+func (memError) Error() string {
+	return MEMERRMSG
+}
+
+// [-0, +0, m]
+// 即将分配size字节时调用, 超出内存限制或者size过大时抛出内存错误.
+// 只做检查, 不记录这次分配, 分配出的字符串等对象被压入栈时才计入
+func (ls *luaState) CheckMemory(size int64) {
+	ls.checkMemory(size)
+}
+
+// 记录一次分配, 同时增加收集垃圾的债务
+func (ls *luaState) allocate(size int64) {
+	ls.checkMemory(size)
+	g := ls.global
+	g.gcDebt += size
+	g.allocated += size
+}
+
+// 估计值加上size超出限制时, 先重新统计所有可达对象占用的内存,
+// 丢弃已经成为垃圾的分配, 仍然超出时才抛出内存错误
+func (ls *luaState) checkMemory(size int64) {
+	if size < 0 || size > MAX_ALLOC {
+		panic(memError{})
+	}
+	g := ls.global
+	max := g.limits.MaxMemory
+	if max <= 0 || g.inUse+g.allocated+size <= max {
+		return
+	}
+	g.inUse = ls.memoryInUse()
+	g.allocated = 0
+	if g.inUse+size > max {
+		panic(memError{})
+	}
+}
+
+func (ls *luaState) allocString(s string) {
+	ls.allocate(SIZE_STRING + int64(len(s)))
+}
+
+// 从根出发, 统计所有可达的表和字符串等对象大约占用的字节数, 不清除弱表
+func (ls *luaState) memoryInUse() int64 {
+	m := newGCMarker(ls.global)
+	ls.markRoots(m)
+	m.propagateAll()
+	m.convergeEphemerons()
+	m.finish()
+	return m.total
+}
+
+/* }====================================================== */
@@ -0,0 +1,89 @@
+package state
+
+import (
+	"lua_go/api"
+	"strings"
+	"testing"
+)
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits api.Limits
+		chunk  string
+		status int
+		msg    string
+	}{
+		{api.Limits{MaxInstructions: 10000}, `while true do end`,
+			api.LUA_ERRRUN, "instruction limit exceeded"},
+		{api.Limits{MaxInstructions: 10000}, `pcall(function() while true do end end) return "escaped"`,
+			api.LUA_ERRRUN, "instruction limit exceeded"},
+		{api.Limits{MaxInstructions: 10000}, `local s = 0 for i = 1, 100 do s = s + i end return s`,
+			api.LUA_OK, "5050"},
+		{api.Limits{}, `local function f() return 1 + f() end return f()`,
+			api.LUA_ERRRUN, "stack overflow"},
+		{api.Limits{MaxCallDepth: 100}, `local function f(n) if n > 0 then return 1 + f(n - 1) end return 0 end
+		  return select(2, pcall(f, 200)), f(50)`, api.LUA_OK, "stack overflow"},
+		{api.Limits{MaxCallDepth: 100}, `local function f() return 1 + f() end
+		  return select(2, xpcall(f, function(m) return "handled " .. m end))`, api.LUA_OK, "handled "},
+		{api.Limits{MaxCallDepth: 100}, `local co = coroutine.wrap(function() local function f() return 1 + f() end return f() end)
+		  return co()`, api.LUA_ERRRUN, "stack overflow"},
+		{api.Limits{MaxStackSlots: 5000}, `local function f() return 1 + f() end return f()`,
+			api.LUA_ERRRUN, "stack overflow"},
+		{api.Limits{MaxStackSlots: 5000}, `return select("#", table.unpack({}, 1, 10000))`,
+			api.LUA_ERRRUN, "too many results to unpack"},
+		{api.Limits{}, `return string.rep("x", 1e10)`,
+			api.LUA_ERRMEM, "not enough memory"},
+		{api.Limits{}, `local ok, msg = pcall(string.rep, "x", 1 << 31) return #string.rep("x", 1 << 20) .. msg`,
+			api.LUA_OK, "1048576not enough memory"},
+		{api.Limits{MaxMemory: 1 << 20}, `return string.rep("x", 1e10)`,
+			api.LUA_ERRMEM, "not enough memory"},
+		{api.Limits{MaxMemory: 1 << 20}, `return string.rep("x", 1 << 62, "yy")`,
+			api.LUA_ERRRUN, "resulting string too large"},
+		{api.Limits{MaxMemory: 1 << 20}, `return #string.rep("", 1e10) .. string.rep("ab", 3, ",")`,
+			api.LUA_OK, "0ab,ab,ab"},
+		{api.Limits{MaxMemory: 1 << 20}, `return string.rep("", 1e10, "x")`,
+			api.LUA_ERRMEM, "not enough memory"},
+		{api.Limits{MaxMemory: 1 << 20}, `local t, s = {}, string.rep("x", 1000) for i = 1, 2000 do t[i] = s end
+		  return table.concat(t)`, api.LUA_ERRMEM, "not enough memory"},
+		{api.Limits{}, `collectgarbage("stop") local before = collectgarbage("count")
+		  local s = string.rep("x", 1 << 20, "") s = table.concat({s, s})
+		  return tostring(collectgarbage("count") - before < 3 * 1024 + 64)`, api.LUA_OK, "true"},
+		{api.Limits{MaxMemory: 1 << 20}, `local t = {} for i = 1, 1e7 do t[i] = {} end`,
+			api.LUA_ERRMEM, "not enough memory"},
+		{api.Limits{MaxMemory: 1 << 20}, `local s = "x" while true do s = s .. s end`,
+			api.LUA_ERRMEM, "not enough memory"},
+		{api.Limits{MaxMemory: 1 << 20}, `local ok, msg = pcall(string.rep, "x", 1 << 30)
+		  for i = 1, 1e4 do local t = {string.rep("x", 1000)} end
+		  return tostring(ok) .. " " .. msg`, api.LUA_OK, "false not enough memory"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.SetLimits(tt.limits)
+		if ls.LoadString(tt.chunk) != api.LUA_OK {
+			t.Fatalf("%s", ls.ToString(-1))
+		}
+		if status := ls.PCall(0, 1, 0); status != tt.status {
+			t.Fatalf("%s: expected status %d got %d (%s)", tt.chunk, tt.status, status, ls.ToString(-1))
+		}
+		if msg := ls.ToString(-1); !strings.Contains(msg, tt.msg) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.msg, msg)
+		}
+	}
+}
+
+func TestResetInstructionLimit(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+	limits := api.Limits{MaxInstructions: 1000}
+	for i := 0; i < 3; i++ {
+		ls.SetLimits(limits) /* restart the budget for each request */
+		if ls.LoadString(`for i = 1, 100 do end`) != api.LUA_OK || ls.PCall(0, 0, 0) != api.LUA_OK {
+			t.Fatalf("%s", ls.ToString(-1))
+		}
+	}
+	if ls.GetLimits() != limits {
+		t.Fatalf("expected %v got %v", limits, ls.GetLimits())
+	}
+}
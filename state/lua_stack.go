@@ -11,6 +11,28 @@ type luaStack struct {
 	pc      int
 	state   *luaState
 	openuvs map[int]*upvalue
+	tbc     []int // 待关闭变量的索引(从0开始), 按登记的顺序排列
+	oldPC   int   // 上一次跟踪的指令, 用于行钩子
+	hooked  bool  // 是否正在执行钩子
+
+	isTailCall bool // 是否由尾调用产生
+	nResults   int  // 调用者期望的返回值数量
+	leq        bool // 正在用__lt代替__le, 元方法的结果需要取反
+
+	/* only for Go functions */
+	k          api.KFunction // 让出后恢复执行时代替Go函数的延续函数
+	ctx        api.KContext
+	yieldBelow []luaValue // 让出时保存的被让出的值下面的栈值
+
+	/* 可以让出的保护调用(PCallK)的错误恢复信息 */
+	isYieldPCall bool
+	extra        int      // 出错时恢复到的栈顶
+	msgh         luaValue // 消息处理函数
+	oldAllowHook bool
+}
+
+func (ls *luaStack) isLua() bool {
+	return ls.closure != nil && ls.closure.proto != nil
 }
 
 func newLuaStack(size int, state *luaState) *luaStack {
@@ -23,8 +45,11 @@ func newLuaStack(size int, state *luaState) *luaStack {
 
 func (ls *luaStack) check(n int) {
 	free := len(ls.slots) - ls.top
+	if free < n {
+		ls.state.growStack(n - free)
+	}
 	for i := free; i < n; i++ {
-		ls.slots = append(ls.slots, nil)
+		ls.slots = append(ls.slots, nilValue)
 	}
 }
 
@@ -42,7 +67,7 @@ func (ls *luaStack) pop() luaValue {
 	}
 	ls.top--
 	val := ls.slots[ls.top]
-	ls.slots[ls.top] = nil
+	ls.slots[ls.top] = nilValue
 	return val
 }
 
@@ -75,18 +100,18 @@ func (ls *luaStack) get(idx int) luaValue {
 		uvIdx := api.LUA_REGISTRYINDEX - idx - 1
 		c := ls.closure
 		if c == nil || uvIdx >= len(c.upvals) {
-			return nil
+			return nilValue
 		}
 		return *(c.upvals[uvIdx].val)
 	}
 	if idx == api.LUA_REGISTRYINDEX {
-		return ls.state.registry
+		return tableValue(ls.state.registry)
 	}
 	absIdx := ls.absIndex(idx)
 	if absIdx > 0 && absIdx <= ls.top {
 		return ls.slots[absIdx-1]
 	}
-	return nil
+	return nilValue
 }
 
 func (ls *luaStack) set(idx int, val luaValue) {
@@ -99,7 +124,7 @@ func (ls *luaStack) set(idx int, val luaValue) {
 		return
 	}
 	if idx == api.LUA_REGISTRYINDEX {
-		ls.state.registry = val.(*luaTable)
+		ls.state.registry = val.table()
 		return
 	}
 	absIdx := ls.absIndex(idx)
@@ -136,7 +161,7 @@ func (ls *luaStack) pushN(vals []luaValue, n int) {
 		if i < nVals {
 			ls.push(vals[i])
 		} else {
-			ls.push(nil)
+			ls.push(nilValue)
 		}
 	}
 }
@@ -1,21 +1,72 @@
 package state
 
-import "lua_go/api"
+import (
+	"context"
+	"lua_go/api"
+)
+
+// 所有线程共享的状态
+// lua-5.3.4/src/lstate.h#global_State
+type globalState struct {
+	/* garbage collection */
+	finobj    []luaValue // 带有__gc元方法的表和userdata, 按登记的顺序排列
+	tobefnz   []luaValue // 不可达的等待调用终结器的对象
+	gcDebt    int64      // 大于0时在下一个安全点收集垃圾
+	gcRunning bool       // 正在收集垃圾或者调用终结器
+	gcStopped bool       // 被collectgarbage("stop")停止, 不自动收集
+	gcPause   int        // 内存估计值增长到上次收集后的百分之多少时开始下一次收集
+	gcStepMul int        // 只是记录下来, 每次收集都是完整的
+	gcMode    int        // api.LUA_GCINC或者api.LUA_GCGEN, 只是记录下来
+	gcEpoch   uint32     // 最近一次标记的编号
+	marker    gcMarker   // 重复使用的标记状态
+	/* execution limits */
+	limits       api.Limits
+	instructions int64 // 已经执行的指令数
+	allocated    int64 // 上次统计内存以来估计分配的字节数
+	inUse        int64 // 上次统计时可达的表和字符串大约占用的字节数
+	/* cancellation */
+	ctx      context.Context
+	done     <-chan struct{} // ctx.Done(), 为nil时不检查
+	ctxCount int             // 还要执行多少条指令才检查ctx
+}
 
 type luaState struct {
-	registry *luaTable // 注册表
-	stack    *luaStack
-	coStatus int
-	coCaller *luaState
-	coChan   chan int
+	gcHeader
+	registry   *luaTable // 注册表
+	global     *globalState
+	stack      *luaStack
+	coStatus   int
+	nny        int // 调用栈上不能让出的调用的数量
+	nCalls     int // 调用帧的数量
+	baseCalls  int // 恢复当前协程的线程的调用深度
+	nSlots     int // 所有调用帧占用的栈槽数
+	errorDepth int // 正在执行的消息处理函数的数量
+	/* hooks */
+	hook          api.LuaHook
+	hookMask      int
+	baseHookCount int
+	hookCount     int
+	allowHook     bool
 }
 
 func New() *luaState {
-	ls := &luaState{}
+	return NewWithContext(context.Background())
+}
+
+// 创建状态, ctx被取消或者超时后正在执行的脚本会抛出错误; ctx为nil时等同于context.Background()
+func NewWithContext(ctx context.Context) *luaState {
+	g := &globalState{
+		gcDebt:    -LUAI_GCMINHEAP,
+		gcPause:   LUAI_GCPAUSE,
+		gcStepMul: LUAI_GCMUL,
+		gcMode:    api.LUA_GCINC,
+	}
+	ls := &luaState{global: g, nny: 1, allowHook: true}
+	ls.SetContext(ctx)
 
 	registry := newLuaTable(8, 0)
-	registry.put(api.LUA_RIDX_MAINTHREAD, ls)
-	registry.put(api.LUA_RIDX_GLOBALS, newLuaTable(0, 20))
+	registry.put(integerValue(api.LUA_RIDX_MAINTHREAD), threadValue(ls))
+	registry.put(integerValue(api.LUA_RIDX_GLOBALS), tableValue(newLuaTable(0, 20)))
 
 	ls.registry = registry
 	ls.pushLuaStack(newLuaStack(api.LUA_MINSTACK, ls))
@@ -23,16 +74,21 @@ func New() *luaState {
 }
 
 func (ls *luaState) pushLuaStack(stack *luaStack) {
+	ls.checkCallDepth()
+	ls.growStack(len(stack.slots))
 	stack.prev = ls.stack
 	ls.stack = stack
+	ls.nCalls++
 }
 
 func (ls *luaState) popLuaStack() {
 	stack := ls.stack
 	ls.stack = stack.prev
 	stack.prev = nil
+	ls.nCalls--
+	ls.nSlots -= len(stack.slots)
 }
 
 func (ls *luaState) isMainThread() bool {
-	return ls.registry.get(api.LUA_RIDX_MAINTHREAD) == ls
+	return ls.registry.getInt(api.LUA_RIDX_MAINTHREAD).thread() == ls
 }
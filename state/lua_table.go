@@ -1,16 +1,37 @@
 package state
 
 import (
+	"lua_go/api"
 	"lua_go/number"
 	"math"
 )
 
+/*
+表由数组部分和哈希部分组成.
+数组部分保存键为1到len(arr)的值, 其中可以有nil. 数组部分预留了空间时(比如表构造器),
+nil也会追加到数组部分, 和C实现一样#{1, nil, 3}是3.
+哈希部分的键值对按插入的顺序保存在nodes中, strIndex, udIndex和index分别记录字符串键,
+轻量userdata键和其他键在nodes中的位置(字符串和轻量userdata的luaValue相等时结构不一定相等).
+给已有的键赋值nil时保留它的节点(死键), 这样遍历时清除字段之后next仍然可以找到下一个键;
+死键在插入新键时(死键足够多的话)或者收集垃圾时才被去掉.
+数组部分总是吸收哈希部分中紧接着的整数键, 所以哈希部分中没有键len(arr)+1.
+*/
+
 type luaTable struct {
+	gcHeader
 	metatable *luaTable
 	arr       []luaValue
-	_map      map[luaValue]luaValue
-	keys      map[luaValue]luaValue
-	changed   bool
+	nodes     []node
+	strIndex  map[string]int      // 字符串键在nodes中的位置, 比用luaValue作键查找得快
+	udIndex   map[interface{}]int // 轻量userdata键在nodes中的位置, 用它们持有的Go值作键
+	index     map[luaValue]int    // 其他键在nodes中的位置
+	nDead     int                 // nodes中值为nil的节点的数量
+	fin       bool                // 已经登记为需要终结的对象
+}
+
+type node struct {
+	key luaValue // 节点被移除后为nil
+	val luaValue // 死键的值为nil
 }
 
 func newLuaTable(nArr, nRec int) *luaTable {
@@ -19,117 +40,250 @@ func newLuaTable(nArr, nRec int) *luaTable {
 		t.arr = make([]luaValue, 0, nArr)
 	}
 	if nRec > 0 {
-		t._map = make(map[luaValue]luaValue, nRec)
+		t.nodes = make([]node, 0, nRec)
+		t.strIndex = make(map[string]int, nRec)
 	}
 	return t
 }
 
 func (lt *luaTable) get(key luaValue) luaValue {
 	key = _floatToInteger(key)
-	if idx, ok := key.(int64); ok {
-		if idx >= 1 && idx <= int64(len(lt.arr)) {
+	if key.isInteger() {
+		if idx := key.integer(); idx >= 1 && idx <= int64(len(lt.arr)) {
 			return lt.arr[idx-1]
 		}
 	}
+	if i, found := lt.lookup(key); found {
+		return lt.nodes[i].val
+	}
+	return nilValue
+}
 
-	return lt._map[key]
+// 整数键的快速路径, 不需要构造luaValue
+func (lt *luaTable) getInt(idx int64) luaValue {
+	if idx >= 1 && idx <= int64(len(lt.arr)) {
+		return lt.arr[idx-1]
+	}
+	return lt.get(integerValue(idx))
 }
 
 func _floatToInteger(key luaValue) luaValue {
-	if f, ok := key.(float64); ok {
-		if i, ok := number.FloatToInteger(f); ok {
-			return i
+	if key.isFloat() {
+		if i, ok := number.FloatToInteger(key.float()); ok {
+			return integerValue(i)
 		}
 	}
 	return key
 }
 
 func (lt *luaTable) put(key, val luaValue) {
-	if key == nil {
+	if key.isNil() {
 		panic("table index is nil!")
 	}
-	if f, ok := key.(float64); ok && math.IsNaN(f) {
+	if key.isFloat() && math.IsNaN(key.float()) {
 		panic("table index is NaN!")
 	}
 	key = _floatToInteger(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
+	if idx := key.integer(); key.isInteger() && idx >= 1 {
 		arrLen := int64(len(lt.arr))
 		if idx <= arrLen {
 			lt.arr[idx-1] = val
-			if idx == arrLen && val == nil {
-				lt._shrinkArray()
+			if idx == arrLen && val.isNil() {
+				lt.trimArray()
 			}
 			return
 		}
-		if idx == arrLen+1 {
-			delete(lt._map, key)
-			if val != nil {
-				lt.arr = append(lt.arr, val)
-				lt._expandArray()
+		if idx == arrLen+1 && (!val.isNil() || len(lt.arr) < cap(lt.arr)) {
+			if i, found := lt.lookup(key); found { /* a dead key */
+				lt.removeNode(i)
 			}
+			lt.arr = append(lt.arr, val)
+			lt.expandArray()
 			return
 		}
 	}
-	if val != nil {
-		if lt._map == nil {
-			lt._map = make(map[luaValue]luaValue, 8)
-		}
-		lt._map[key] = val
-	} else {
-		delete(lt._map, key)
+	if i, found := lt.lookup(key); found {
+		lt.setNode(i, val)
+	} else if !val.isNil() {
+		lt.newKey(key, val)
 	}
 }
 
-func (lt *luaTable) _shrinkArray() {
-	for i := len(lt.arr) - 1; i >= 0; i-- {
-		if lt.arr[i] == nil {
-			lt.arr = lt.arr[0:i]
-		}
+// 去掉数组部分末尾的nil
+func (lt *luaTable) trimArray() {
+	n := len(lt.arr)
+	for n > 0 && lt.arr[n-1].isNil() {
+		n--
 	}
+	lt.arr = lt.arr[:n]
 }
 
-func (lt *luaTable) _expandArray() {
-	for idx := int64(len(lt.arr)) + 1; true; idx++ {
-		if val, found := lt._map[idx]; found {
-			delete(lt._map, idx)
-			lt.arr = append(lt.arr, val)
-		} else {
+// 把哈希部分中紧接着数组部分的整数键移到数组部分
+func (lt *luaTable) expandArray() {
+	for idx := int64(len(lt.arr)) + 1; len(lt.nodes) > lt.nDead; idx++ {
+		i, found := lt.lookup(integerValue(idx))
+		if !found || lt.nodes[i].val.isNil() {
 			break
 		}
+		lt.arr = append(lt.arr, lt.nodes[i].val)
+		lt.removeNode(i)
 	}
 }
 
-func (lt *luaTable) len() int {
-	return len(lt.arr)
+func (lt *luaTable) setNode(i int, val luaValue) {
+	n := &lt.nodes[i]
+	if n.val.isNil() && !val.isNil() {
+		lt.nDead--
+	} else if !n.val.isNil() && val.isNil() {
+		lt.nDead++
+	}
+	n.val = val
 }
 
-func (lt *luaTable) hasMetafield(fieldName string) bool {
-	return lt.metatable != nil && lt.metatable.get(fieldName) != nil
+// 从哈希部分移除节点, 它的键不能再用于next
+func (lt *luaTable) removeNode(i int) {
+	n := &lt.nodes[i]
+	lt.unindex(n.key)
+	if !n.val.isNil() {
+		lt.nDead++
+	}
+	*n = node{}
+}
+
+func (lt *luaTable) newKey(key, val luaValue) {
+	if lt.nDead > 0 && lt.nDead >= len(lt.nodes)/2 {
+		lt.compact(nil) /* inserting new keys during traversal is undefined */
+	}
+	lt.reindex(key, len(lt.nodes))
+	lt.nodes = append(lt.nodes, node{key, val})
+}
+
+// 返回键在nodes中的位置
+func (lt *luaTable) lookup(key luaValue) (int, bool) {
+	if s, ok := key.str(); ok {
+		i, found := lt.strIndex[s]
+		return i, found
+	}
+	if key.tag() == api.LUA_TLIGHTUSERDATA {
+		i, found := lt.udIndex[key.lightUserdata()]
+		return i, found
+	}
+	if len(lt.index) == 0 {
+		return 0, false
+	}
+	i, found := lt.index[key]
+	return i, found
+}
+
+func (lt *luaTable) reindex(key luaValue, i int) {
+	if s, ok := key.str(); ok {
+		if lt.strIndex == nil {
+			lt.strIndex = make(map[string]int, 4)
+		}
+		lt.strIndex[s] = i
+	} else if key.tag() == api.LUA_TLIGHTUSERDATA {
+		if lt.udIndex == nil {
+			lt.udIndex = make(map[interface{}]int, 4)
+		}
+		lt.udIndex[key.lightUserdata()] = i
+	} else {
+		if lt.index == nil {
+			lt.index = make(map[luaValue]int, 4)
+		}
+		lt.index[key] = i
+	}
 }
 
-func (lt *luaTable) nextKey(key luaValue) luaValue {
-	if lt.keys == nil || key == nil {
-		lt.initKeys()
-		lt.changed = false
+func (lt *luaTable) unindex(key luaValue) {
+	if s, ok := key.str(); ok {
+		delete(lt.strIndex, s)
+	} else if key.tag() == api.LUA_TLIGHTUSERDATA {
+		delete(lt.udIndex, key.lightUserdata())
+	} else {
+		delete(lt.index, key)
 	}
+}
 
-	return lt.keys[key]
+// 去掉死键和移除的节点, 其余节点保持原来的顺序.
+// keep不为nil时保留keep返回true的死键, 它们可能正在被用于遍历
+func (lt *luaTable) compact(keep func(key luaValue) bool) {
+	j := 0
+	for _, n := range lt.nodes {
+		if n.val.isNil() && (n.key.isNil() || keep == nil || !keep(n.key)) {
+			if !n.key.isNil() {
+				lt.unindex(n.key)
+			}
+			continue
+		}
+		lt.reindex(n.key, j)
+		lt.nodes[j] = n
+		j++
+	}
+	for i := j; i < len(lt.nodes); i++ {
+		lt.nodes[i] = node{}
+	}
+	lt.nDead -= len(lt.nodes) - j
+	lt.nodes = lt.nodes[:j]
 }
 
-func (lt *luaTable) initKeys() {
-	lt.keys = make(map[luaValue]luaValue)
-	var key luaValue = nil
-	for i, v := range lt.arr {
-		if v != nil {
-			lt.keys[key] = int64(i + 1)
-			key = int64(i + 1)
+/*
+** Try to find a boundary in table 't'. A 'boundary' is an integer index
+** such that t[i] is non-nil and t[i+1] is nil (and 0 if t[1] is nil).
+ */
+// lua-5.3.4/src/ltable.c#luaH_getn()
+func (lt *luaTable) len() int {
+	j := len(lt.arr)
+	if j > 0 && lt.arr[j-1].isNil() {
+		/* there is a boundary in the array part: (binary) search for it */
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if lt.arr[m-1].isNil() {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	}
+	return j /* the hash part never has the key len(arr)+1 */
+}
+
+func (lt *luaTable) hasMetafield(fieldName string) bool {
+	return lt.metatable != nil && !lt.metatable.get(stringValue(fieldName)).isNil()
+}
+
+// 返回key之后的下一个键值对, 没有更多的键值对时返回的键是nil; key无效时ok为false.
+// 先按顺序遍历数组部分, 再按插入的顺序遍历哈希部分
+// lua-5.3.4/src/ltable.c#luaH_next()
+func (lt *luaTable) next(key luaValue) (nextKey, nextVal luaValue, ok bool) {
+	i := 0 /* start from the first element of the array part */
+	if !key.isNil() {
+		key = _floatToInteger(key)
+		idx, isInt := key.integer(), key.isInteger()
+		if isInt && idx >= 1 && idx <= int64(len(lt.arr)) {
+			i = int(idx)
+		} else if n, found := lt.lookup(key); found {
+			return lt.nextNode(n + 1)
+		} else if isInt && idx >= 1 && idx <= int64(cap(lt.arr)) { /* array part shrank during traversal */
+			i = len(lt.arr)
+		} else {
+			return nilValue, nilValue, false
 		}
 	}
+	for ; i < len(lt.arr); i++ {
+		if !lt.arr[i].isNil() {
+			return integerValue(int64(i + 1)), lt.arr[i], true
+		}
+	}
+	return lt.nextNode(0)
+}
 
-	for k, v := range lt._map {
-		if v != nil {
-			lt.keys[key] = k
-			key = k
+func (lt *luaTable) nextNode(i int) (luaValue, luaValue, bool) {
+	for ; i < len(lt.nodes); i++ {
+		if n := lt.nodes[i]; !n.val.isNil() {
+			return n.key, n.val, true
 		}
 	}
+	return nilValue, nilValue, true
 }
@@ -4,52 +4,238 @@ import (
 	"fmt"
 	"lua_go/api"
 	"lua_go/number"
+	"math"
+	"unsafe"
 )
 
-type luaValue interface{}
+/*
+值用16字节的结构表示, p是指针, n是64位的数据, 数字, 布尔值和字符串都不需要在堆上分配:
+  - 整数和浮点数的位模式保存在n中, p指向它们的类型标记intTag或者floatTag
+  - 其他值的类型标记保存在n的低8位, 布尔值保存在n的第8位
+  - 字符串的长度保存在n的高位, p指向底层数组, 空字符串的p是nil
+  - 表, 闭包, userdata和线程的p是对象的指针
+  - 轻量userdata持有的Go值装箱后由p指向
 
-func typeOf(val luaValue) api.LuaType {
-	switch val.(type) {
+零值就是nil. 结构是可以比较的, 除了字符串和轻量userdata以外, 相等的值的结构也相等,
+所以可以直接作为map的键. 浮点数比较的是位模式, 不能用==代替Lua的相等比较.
+*/
+type luaValue struct {
+	p unsafe.Pointer // 对象的指针, 字符串的底层数组或者数字的类型标记
+	n uint64         // 整数, 浮点数的位模式, 或者类型标记和布尔值, 字符串的长度
+}
+
+/* Variant tags for numbers */
+// lua-5.3.4/src/lobject.h
+const (
+	LUA_TNUMFLT = api.LUA_TNUMBER | (0 << 4) /* float numbers */
+	LUA_TNUMINT = api.LUA_TNUMBER | (1 << 4) /* integer numbers */
+)
+
+// 数字的p指向的类型标记, 只比较地址
+var numberTags = [2]uint8{LUA_TNUMFLT, LUA_TNUMINT}
+
+var (
+	floatTag = unsafe.Pointer(&numberTags[0])
+	intTag   = unsafe.Pointer(&numberTags[1])
+)
+
+var (
+	nilValue   = luaValue{}
+	falseValue = luaValue{n: api.LUA_TBOOLEAN}
+	trueValue  = luaValue{n: api.LUA_TBOOLEAN | 1<<8}
+)
+
+func boolValue(b bool) luaValue {
+	if b {
+		return trueValue
+	}
+	return falseValue
+}
+
+func integerValue(i int64) luaValue {
+	return luaValue{p: intTag, n: uint64(i)}
+}
+
+func floatValue(f float64) luaValue {
+	return luaValue{p: floatTag, n: math.Float64bits(f)}
+}
+
+func stringValue(s string) luaValue {
+	if len(s) == 0 { /* the data pointer of an empty string may point past an allocation */
+		return luaValue{n: api.LUA_TSTRING}
+	}
+	return luaValue{p: unsafe.Pointer(unsafe.StringData(s)), n: uint64(len(s))<<8 | api.LUA_TSTRING}
+}
+
+func tableValue(t *luaTable) luaValue {
+	return luaValue{p: unsafe.Pointer(t), n: api.LUA_TTABLE}
+}
+
+func closureValue(c *closure) luaValue {
+	return luaValue{p: unsafe.Pointer(c), n: api.LUA_TFUNCTION}
+}
+
+func userdataValue(u *userdata) luaValue {
+	return luaValue{p: unsafe.Pointer(u), n: api.LUA_TUSERDATA}
+}
+
+func threadValue(ls *luaState) luaValue {
+	return luaValue{p: unsafe.Pointer(ls), n: api.LUA_TTHREAD}
+}
+
+// 轻量userdata只是一个Go值(通常是指针), 按值比较, 所有轻量userdata共享一个元表
+func lightUserdataValue(p interface{}) luaValue {
+	box := new(interface{})
+	*box = p
+	return luaValue{p: unsafe.Pointer(box), n: api.LUA_TLIGHTUSERDATA}
+}
+
+// 把常量或者Go的值转换成luaValue
+func valueOf(x interface{}) luaValue {
+	switch y := x.(type) {
 	case nil:
-		return api.LUA_TNIL
+		return nilValue
 	case bool:
-		return api.LUA_TBOOLEAN
-	case int64, float64:
-		return api.LUA_TNUMBER
+		return boolValue(y)
+	case int64:
+		return integerValue(y)
+	case float64:
+		return floatValue(y)
 	case string:
-		return api.LUA_TSTRING
+		return stringValue(y)
 	case *luaTable:
-		return api.LUA_TTABLE
+		return tableValue(y)
 	case *closure:
-		return api.LUA_TFUNCTION
+		return closureValue(y)
+	case *userdata:
+		return userdataValue(y)
 	case *luaState:
-		return api.LUA_TTHREAD
+		return threadValue(y)
 	default:
 		panic("todo!")
 	}
 }
 
+// 类型标记, 低4位是基本类型, 高4位区分变体
+func (v luaValue) tag() uint8 {
+	switch v.p {
+	case intTag:
+		return LUA_TNUMINT
+	case floatTag:
+		return LUA_TNUMFLT
+	}
+	return uint8(v.n)
+}
+
+func (v luaValue) isNil() bool {
+	return v == nilValue
+}
+
+// nil和false是假, 其他值都是真
+// lua-5.3.4/src/lobject.h#l_isfalse()
+func (v luaValue) isFalse() bool {
+	return v == nilValue || v == falseValue
+}
+
+func (v luaValue) isInteger() bool {
+	return v.p == intTag
+}
+
+func (v luaValue) isFloat() bool {
+	return v.p == floatTag
+}
+
+// 只能用于整数
+func (v luaValue) integer() int64 {
+	return int64(v.n)
+}
+
+// 只能用于浮点数
+func (v luaValue) float() float64 {
+	return math.Float64frombits(v.n)
+}
+
+func (v luaValue) str() (string, bool) {
+	if v.tag() != api.LUA_TSTRING {
+		return "", false
+	}
+	return unsafe.String((*byte)(v.p), int(v.n>>8)), true
+}
+
+// 下面几个方法在值不是对应的类型时返回nil
+
+func (v luaValue) table() *luaTable {
+	if v.tag() != api.LUA_TTABLE {
+		return nil
+	}
+	return (*luaTable)(v.p)
+}
+
+func (v luaValue) closure() *closure {
+	if v.tag() != api.LUA_TFUNCTION {
+		return nil
+	}
+	return (*closure)(v.p)
+}
+
+func (v luaValue) userdata() *userdata {
+	if v.tag() != api.LUA_TUSERDATA {
+		return nil
+	}
+	return (*userdata)(v.p)
+}
+
+func (v luaValue) thread() *luaState {
+	if v.tag() != api.LUA_TTHREAD {
+		return nil
+	}
+	return (*luaState)(v.p)
+}
+
+// 轻量userdata持有的Go值
+func (v luaValue) lightUserdata() interface{} {
+	if v.tag() != api.LUA_TLIGHTUSERDATA {
+		return nil
+	}
+	return *(*interface{})(v.p)
+}
+
+// 对象(表, 闭包, userdata和线程)的指针, 其他值返回nil
+func (v luaValue) object() unsafe.Pointer {
+	switch v.tag() {
+	case api.LUA_TTABLE, api.LUA_TFUNCTION, api.LUA_TUSERDATA, api.LUA_TTHREAD:
+		return v.p
+	}
+	return nil
+}
+
+func typeOf(val luaValue) api.LuaType {
+	return api.LuaType(val.tag() & 0x0F)
+}
+
 func convertToFloat(val luaValue) (float64, bool) {
-	switch x := val.(type) {
-	case float64:
-		return x, true
-	case int64:
-		return float64(x), true
-	case string:
-		return number.ParseFloat(x)
+	switch val.tag() {
+	case LUA_TNUMFLT:
+		return val.float(), true
+	case LUA_TNUMINT:
+		return float64(val.integer()), true
+	case api.LUA_TSTRING:
+		s, _ := val.str()
+		return number.ParseFloat(s)
 	default:
 		return 0, false
 	}
 }
 
 func convertToInteger(val luaValue) (int64, bool) {
-	switch x := val.(type) {
-	case int64:
-		return x, true
-	case float64:
-		return number.FloatToInteger(x)
-	case string:
-		return _stringToInteger(x)
+	switch val.tag() {
+	case LUA_TNUMINT:
+		return val.integer(), true
+	case LUA_TNUMFLT:
+		return number.FloatToInteger(val.float())
+	case api.LUA_TSTRING:
+		s, _ := val.str()
+		return _stringToInteger(s)
 	default:
 		return 0, false
 	}
@@ -68,30 +254,40 @@ func _stringToInteger(s string) (int64, bool) {
 }
 
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch val.tag() {
+	case api.LUA_TTABLE:
+		val.table().metatable = mt
+		ls.checkFinalizer(val, mt)
+		return
+	case api.LUA_TUSERDATA:
+		val.userdata().metatable = mt
+		ls.checkFinalizer(val, mt)
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
-	ls.registry.put(key, mt)
+	if mt != nil {
+		ls.registry.put(stringValue(key), tableValue(mt))
+	} else {
+		ls.registry.put(stringValue(key), nilValue)
+	}
 }
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch val.tag() {
+	case api.LUA_TTABLE:
+		return val.table().metatable
+	case api.LUA_TUSERDATA:
+		return val.userdata().metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
-	if mt := ls.registry.get(key); mt != nil {
-		return mt.(*luaTable)
-	}
-	return nil
+	return ls.registry.get(stringValue(key)).table()
 }
 
 func callMetamethod(a, b luaValue, mmName string, ls *luaState) (luaValue, bool) {
 	var mm luaValue
-	if mm = getMetafield(a, mmName, ls); mm == nil {
-		if mm = getMetafield(b, mmName, ls); mm == nil {
-			return nil, false
+	if mm = getMetafield(a, mmName, ls); mm.isNil() {
+		if mm = getMetafield(b, mmName, ls); mm.isNil() {
+			return nilValue, false
 		}
 	}
 
@@ -105,7 +301,7 @@ func callMetamethod(a, b luaValue, mmName string, ls *luaState) (luaValue, bool)
 
 func getMetafield(val luaValue, fieldName string, ls *luaState) luaValue {
 	if mt := getMetatable(val, ls); mt != nil {
-		return mt.get(fieldName)
+		return mt.get(stringValue(fieldName))
 	}
-	return nil
+	return nilValue
 }
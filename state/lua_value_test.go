@@ -0,0 +1,106 @@
+package state
+
+import (
+	. "lua_go/api"
+	"math"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestNumbersDoNotAllocate(t *testing.T) {
+	ls := New()
+	tbl := newLuaTable(0, 0)
+	allocs := testing.AllocsPerRun(100, func() {
+		ls.PushInteger(1 << 40)
+		ls.PushNumber(0.5)
+		ls.Arith(LUA_OPMUL)
+		ls.PushInteger(1 << 40)
+		ls.Arith(LUA_OPADD)
+		tbl.put(floatValue(-1.5), ls.stack.pop())
+		tbl.get(floatValue(-1.5))
+	})
+	if allocs != 0 {
+		t.Fatalf("number operations allocated %v times", allocs)
+	}
+}
+
+func TestValueLayout(t *testing.T) {
+	if size := unsafe.Sizeof(luaValue{}); size != 16 {
+		t.Fatalf("luaValue is %d bytes", size)
+	}
+	s := strings.Repeat("k", 8)
+	tbl := newLuaTable(0, 0)
+	allocs := testing.AllocsPerRun(100, func() {
+		v := stringValue(s)
+		tbl.put(v, trueValue)
+		if k, _, _ := tbl.next(nilValue); k != v {
+			panic("wrong key")
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("string values allocated %v times", allocs)
+	}
+}
+
+func TestValueEquality(t *testing.T) {
+	tbl0 := newLuaTable(0, 0)
+	tests := []struct {
+		a, b     luaValue
+		expected bool
+	}{
+		{integerValue(1), floatValue(1), true},
+		{floatValue(0), floatValue(math.Copysign(0, -1)), true},
+		{floatValue(math.NaN()), floatValue(math.NaN()), false},
+		{stringValue(strings.Repeat("a", 2)), stringValue("aa"), true},
+		{boolValue(false), nilValue, false},
+		{lightUserdataValue("x"), stringValue("x"), false},
+		{lightUserdataValue(&point{}), lightUserdataValue(&point{}), false},
+		{lightUserdataValue(tbl0), lightUserdataValue(tbl0), true},
+		{stringValue(""), stringValue(strings.Repeat("a", 0)), true},
+		{stringValue("a"), lightUserdataValue("a"), false},
+	}
+	for _, tt := range tests {
+		if actual := _eq(tt.a, tt.b, nil); actual != tt.expected {
+			t.Fatalf("%v == %v: expected %v got %v", tt.a, tt.b, tt.expected, actual)
+		}
+	}
+}
+
+const (
+	benchArith = `
+local s, f = 0, 0.5
+for i = 1, 1000 do
+  s = s + i * 3 - (i // 2)
+  f = f * 1.0001 + 0.25
+end
+return s, f`
+	benchTable = `
+local t = {}
+for i = 1, 1000 do t[i] = i end
+for i = 1, 1000 do t[i] = t[i] * 2 + 0.5 end
+local h = {}
+for i = 1, 100 do h[i * 1.5] = i; h["k" .. i % 10] = h[i * 1.5] end
+return #t`
+)
+
+func benchmarkChunk(b *testing.B, chunk string) {
+	ls := New()
+	if ls.LoadString(chunk) != LUA_OK {
+		b.Fatalf("%s", ls.ToString(-1))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ls.PushValue(-1)
+		ls.Call(0, 0)
+	}
+}
+
+func BenchmarkArithLoop(b *testing.B) {
+	benchmarkChunk(b, benchArith)
+}
+
+func BenchmarkTableAccess(b *testing.B) {
+	benchmarkChunk(b, benchTable)
+}
@@ -0,0 +1,61 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestStringPack(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`return string.pack("<i4", 100) == "d\0\0\0", string.pack(">h", -2) == "\255\254"`, "true,true"},
+		{`return string.unpack("i16", string.pack("i16", -3))`, "-3,17"},
+		{`return string.unpack(">I3", "\1\2\3")`, "66051,4"},
+		{`return string.unpack("z B s1", string.pack("z B s1", "hi", 255, "abc"))`, "hi,255,abc,9"},
+		{`return string.unpack("<d f", string.pack("<d f", 3.5, 0.25))`, "3.5,0.25,13"},
+		{`return string.unpack("c3", "abcdef", 2)`, "bcd,5"},
+		{`return #string.pack("!4 b Xi4 i4", 1, 2)`, "8"},
+		{`return string.packsize("i4i8"), string.packsize("!i4i8"), string.packsize("!4 i2 d")`, "12,16,12"},
+		{`return pcall(string.pack, "b", 200)`, "false,bad argument #2 to 'string.pack' (integer overflow)"},
+		{`return pcall(string.pack, "i17", 1)`, "false,integral size (17) out of limits [1,16]"},
+		{`return pcall(string.packsize, "s")`, "false,bad argument #1 to 'string.packsize' (variable-length format)"},
+		{`return pcall(string.unpack, "i4", "abc")`, "false,bad argument #2 to 'string.unpack' (data string too short)"},
+		{`return pcall(string.pack, "!3 i4", 1)`, "false,bad argument #1 to 'string.pack' (format asks for alignment not power of 2)"},
+		{`return pcall(string.unpack, "i9", string.rep("\255", 8) .. "\1")`, "false,9-byte integer does not fit into Lua Integer"},
+	}
+
+	for _, tt := range tests {
+		if actual := doStringResults(t, tt.chunk); actual != tt.expected {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
+
+// 执行chunk, 把所有返回值用逗号连接起来
+func doStringResults(t *testing.T, chunk string) string {
+	ls := New()
+	ls.OpenLibs()
+	return doStringOn(t, ls, chunk)
+}
+
+func doStringOn(t *testing.T, ls *luaState, chunk string) string {
+	top := ls.GetTop()
+	if ls.LoadString(chunk) != 0 {
+		t.Fatalf("%s", ls.ToString(-1))
+	}
+	ls.Call(0, -1)
+	actual := ""
+	for i := top + 1; i <= ls.GetTop(); i++ {
+		if i > top+1 {
+			actual += ","
+		}
+		if ls.IsBoolean(i) {
+			actual += fmt.Sprint(ls.ToBoolean(i))
+		} else {
+			actual += ls.ToString(i)
+		}
+	}
+	return actual
+}
@@ -0,0 +1,58 @@
+package state
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestPatternMatching(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`return string.find("hello world", "l+")`, "3,4"},
+		{`return string.find("a.b", ".", 1, true)`, "2,2"},
+		{`return string.find("key = val", "(%w+)%s*=%s*(%w+)")`, "1,9,key,val"},
+		{`return string.match("  trim  ", "^%s*(.-)%s*$")`, "trim"},
+		{`return string.match("f(a(b)c)d", "%b()")`, "(a(b)c)"},
+		{`return string.match("THE (quick) fox", "%f[%a]%a+", 5)`, "quick"},
+		{`return string.match("hello", "()ll()")`, "3,5"},
+		{`return string.match("abcabc", "(abc)%1")`, "abc"},
+		{`return string.match("x]", "[]]")`, "]"},
+		{`return string.match("[%w_]", "[%[%]]")`, "["},
+		{`return string.gsub("hello world", "(%w+)", "<%1>")`, "<hello> <world>,2"},
+		{`return string.gsub("hello world", "%w+", "%0 %0", 1)`, "hello hello world,1"},
+		{`return string.gsub("abc", "", "-")`, "-a-b-c-,4"},
+		{`return string.gsub("hello", "l*", "x")`, "xhxexox,4"},
+		{`return string.gsub("$a $b", "%$(%w+)", {a = "x"})`, "x $b,2"},
+		{`return string.gsub("1 2", "%d", function(d) return d * 2 end)`, "2 4,2"},
+		{`return string.gsub("abc", "^a", "x")`, "xbc,1"},
+		{`local t = {} for k, v in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do t[#t+1] = k .. v end
+		  return table.concat(t, ";")`, "a1;b2"},
+		{`return pcall(string.find, "a", "[a")`, "false,malformed pattern (missing ']')"},
+		{`return pcall(string.gsub, "a", "a", "%2")`, "false,invalid capture index %2"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		if ls.LoadString(tt.chunk) != 0 {
+			t.Fatalf("%s", ls.ToString(-1))
+		}
+		ls.Call(0, -1)
+		actual := ""
+		for i := 1; i <= ls.GetTop(); i++ {
+			if i > 1 {
+				actual += ","
+			}
+			if ls.IsBoolean(i) {
+				actual += fmt.Sprint(ls.ToBoolean(i))
+			} else {
+				actual += ls.ToString(i)
+			}
+		}
+		if actual != tt.expected {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
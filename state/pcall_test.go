@@ -0,0 +1,80 @@
+package state
+
+import (
+	"fmt"
+	"lua_go/api"
+	"strings"
+	"testing"
+)
+
+func TestXPCall(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local ok, msg = xpcall(error, debug.traceback, "boom")
+		  return tostring(ok) .. " " .. msg`,
+			"false boom\nstack traceback:\n\t[C]: in function 'error'"},
+		{`return select(2, xpcall(error, function(e) return type(e) end, {}))`, "table"},
+		{`return select(2, xpcall(error, function(e) error(e) end, "x"))`, "error in error handling"},
+		{`return table.concat({xpcall(function(a, b) return a, b end, print, 1, 2)}, ",", 2)`, "1,2"},
+		{`return select("#", pcall(error))`, "2"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		ls.LoadString(tt.chunk)
+		ls.Call(0, 1)
+		if actual := ls.ToString2(-1); !strings.HasPrefix(actual, tt.expected) {
+			t.Fatalf("expected %q got %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestPCallStatus(t *testing.T) {
+	var nilTable map[string][]int
+	tests := []struct {
+		f      api.GoFunction
+		msgh   api.GoFunction
+		status int
+		msg    string
+	}{
+		{func(ls api.LuaState) int { return ls.Error2("oops") }, nil, api.LUA_ERRRUN, "oops"},
+		{func(ls api.LuaState) int { return nilTable["x"][1] }, nil, api.LUA_ERRRUN, "runtime error: index out of range"},
+		{func(ls api.LuaState) int { return *(*int)(nil) }, nil, api.LUA_ERRRUN, "runtime error: invalid memory address"},
+		{func(ls api.LuaState) int {
+			n := -int(ls.GetTop()) - 1
+			return len(make([]byte, n))
+		}, nil, api.LUA_ERRRUN, "runtime error: makeslice"},
+		{func(ls api.LuaState) int { ls.CheckMemory(1 << 62); return 0 }, nil, api.LUA_ERRMEM, "not enough memory"},
+		{func(ls api.LuaState) int { panic(fmt.Errorf("alloc: %w", memError{})) }, nil, api.LUA_ERRMEM, "not enough memory"},
+		{func(ls api.LuaState) int { return ls.Error2("oops") },
+			func(ls api.LuaState) int { return ls.Error2("again") }, api.LUA_ERRERR, "error in error handling"},
+		{func(ls api.LuaState) int { return ls.Error2("oops") },
+			func(ls api.LuaState) int {
+				ls.PushString("handled: " + ls.ToString(1))
+				return 1
+			}, api.LUA_ERRRUN, "handled: oops"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		msgh := 0
+		if tt.msgh != nil {
+			ls.PushGoFunction(tt.msgh)
+			msgh = 1
+		}
+		ls.PushGoFunction(tt.f)
+		ls.PushInteger(1)
+		if status := ls.PCall(1, 0, msgh); status != tt.status {
+			t.Fatalf("expected status %d got %d", tt.status, status)
+		}
+		if top := ls.GetTop(); top != msgh+1 {
+			t.Fatalf("expected top %d got %d", msgh+1, top)
+		}
+		if msg := ls.ToString(-1); !strings.HasPrefix(msg, tt.msg) {
+			t.Fatalf("expected %q got %q", tt.msg, msg)
+		}
+	}
+}
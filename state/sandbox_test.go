@@ -0,0 +1,98 @@
+package state
+
+import (
+	"lua_go/api"
+	"lua_go/stdlib"
+	"strings"
+	"testing"
+)
+
+// 在env指向的沙箱中执行chunk, 返回第一个返回值或者错误信息
+func doStringInSandbox(t *testing.T, ls *luaState, env int, chunk string) string {
+	if ls.Load([]byte(chunk), "=sandbox", "t") != api.LUA_OK {
+		t.Fatalf("%s", ls.ToString(-1))
+	}
+	ls.PushValue(env)
+	ls.SetUpvalue(-2, 1)
+	ls.PCall(0, 1, 0)
+	defer ls.Pop(1)
+	if ls.IsBoolean(-1) {
+		return map[bool]string{true: "true", false: "false"}[ls.ToBoolean(-1)]
+	}
+	return ls.ToString(-1)
+}
+
+func TestSandbox(t *testing.T) {
+	opts := api.LibOptions{
+		Libs:     stdlib.SafeLibs,
+		TextOnly: true,
+		Modules: map[string]string{
+			"greet": `local M = {} function M.hello(n) return "hello " .. n end VISIBLE = true return M`,
+		},
+	}
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`x = 1 string.rep = nil return x`, "1"},
+		{`return type(io) .. type(debug) .. type(dofile) .. type(loadfile) .. type(getmetatable)`,
+			"nilnilnilnilnil"},
+		{`return tostring(os.execute) .. tostring(os.remove) .. tostring(os.exit) .. type(os.time)`,
+			"nilnilnilfunction"},
+		{`return select(2, load(string.dump(function() end)))`, "attempt to load a binary chunk (mode is 't')"},
+		{`load("y = 2")() return y`, "2"},
+		{`local env = {} load("z = 3", "c", "t", env)() return env.z .. tostring(z)`, "3nil"},
+		{`local g = require("greet") return g.hello("sandbox") .. tostring(require("greet") == g) .. tostring(VISIBLE)`,
+			"hello sandboxtruetrue"},
+		{`return select(2, pcall(require, "os")) == os`, "true"},
+		{`return select(2, pcall(require, "lib_basic"))`, "module 'lib_basic' not found"},
+		{`package.path = "./?.go" return select(2, pcall(require, "state"))`, "module 'state' not found"},
+		{`return ("x"):rep(3) .. _G.string.upper("a") .. tostring(_G == _ENV)`, "xxxAtrue"},
+	}
+
+	ls := New()
+	ls.OpenLibs()
+	ls.NewSandbox(opts)
+	ls.NewSandbox(opts) /* a second tenant */
+	for _, tt := range tests {
+		if actual := doStringInSandbox(t, ls, 1, tt.chunk); !strings.Contains(actual, tt.expected) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+
+	/* the other tenant and the host see none of the changes */
+	if actual := doStringInSandbox(t, ls, 2, `return tostring(x) .. tostring(y) .. tostring(VISIBLE) .. type(string.rep)`); actual != "nilnilnilfunction" {
+		t.Fatalf("expected %q got %q", "nilnilnilfunction", actual)
+	}
+	if actual := doStringOn(t, ls, `return tostring(x) .. tostring(VISIBLE) .. type(string.rep) .. type(io)`); actual != "nilnilfunctiontable" {
+		t.Fatalf("expected %q got %q", "nilnilfunctiontable", actual)
+	}
+}
+
+func TestOpenLibsWithOptions(t *testing.T) {
+	tests := []struct {
+		opts     api.LibOptions
+		chunk    string
+		expected string
+	}{
+		{api.LibOptions{Libs: map[string][]string{"_G": {"type", "tostring"}, "string": nil}},
+			`return type(print) .. type(io) .. type(string.format) .. ("a"):upper()`, "nilnilfunctionA"},
+		{api.LibOptions{Libs: map[string][]string{"_G": nil, "os": {"time"}}},
+			`return type(os.time) .. type(os.execute) .. type(pcall)`, "functionnilfunction"},
+		{api.LibOptions{Libs: map[string][]string{"_G": nil, "string": nil}, TextOnly: true},
+			`return select(2, load(string.dump(function() end)))`, "attempt to load a binary chunk (mode is 't')"},
+		{api.LibOptions{Modules: map[string]string{"m": `return {v = ...}`}},
+			`return require("m").v .. tostring(package.loaded.m ~= nil) .. select(2, pcall(require, "x"))`,
+			"mtrue"},
+		{api.LibOptions{Libs: map[string][]string{"_G": nil, "package": {}}},
+			`return type(require) .. type(package.searchpath) .. type(package.loaded)`, "nilniltable"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibsWithOptions(tt.opts)
+		if actual := doStringOn(t, ls, tt.chunk); !strings.HasPrefix(actual, tt.expected) {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package state
+
+import "testing"
+
+func TestTableTraversal(t *testing.T) {
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local t = {10, 20, 30, x = 1, y = 2, z = 3} t.w = 4
+		  local s = "" for k, v in pairs(t) do s = s .. k .. "=" .. v .. " " end
+		  return s`, "1=10 2=20 3=30 x=1 y=2 z=3 w=4 "},
+		{`local t = {1, 2, 3, a = 1, b = 2, c = 3}
+		  for k in pairs(t) do t[k] = nil end
+		  return next(t) == nil`, "true"},
+		{`local t = {a = 1, b = 2, c = 3, d = 4}
+		  local n = 0 for k, v in pairs(t) do t[k] = v * 10 n = n + 1 end
+		  return n, t.a + t.b + t.c + t.d`, "4,100"},
+		{`local t = {a = 1, b = 2} t.a = nil t.a = 3
+		  local s = "" for k, v in pairs(t) do s = s .. k .. v end
+		  return s`, "a3b2"},
+		{`local t = {} for i = 1, 1000 do t["k" .. i] = i t["k" .. i] = nil end
+		  t.x = 1 return next(t)`, "x,1"},
+		{`return pcall(next, {}, "nokey")`, "false,invalid key to 'next'"},
+		{`return pcall(next, {1, 2}, 10)`, "false,invalid key to 'next'"},
+		{`local t = {1, 2, 3} local n = 0
+		  for k in pairs(t) do t[k] = nil n = n + 1 end
+		  return n, next(t) == nil`, "3,true"},
+		{`return #{1, 2, nil, 4}, #{nil, nil, 3}`, "4,3"},
+		{`local t = {1, 2, 3, 4} t[2] = nil t[4] = nil return #t`, "3"},
+		{`local t = {1, 2, 3} t[1] = nil t[3] = nil return #t, t[#t] ~= nil`, "2,true"},
+		{`local t = {1, 2} t[4] = 4 t[3] = 3 return #t, t[4]`, "4,4"},
+		{`local t = {1, 2} t[4] = 4 t[4] = nil t[3] = 3 t[4] = 5
+		  local s = "" for k, v in pairs(t) do s = s .. k .. "=" .. v .. " " end
+		  return #t, s`, "4,1=1 2=2 3=3 4=5 "},
+		{`local t = {} t[1.0] = "a" t[2] = "b" return #t, t[1], next(t, 1.0)`, "2,a,2,b"},
+	}
+
+	for _, tt := range tests {
+		ls := New()
+		ls.OpenLibs()
+		if actual := doStringOn(t, ls, tt.chunk); actual != tt.expected {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+}
+
+func TestTableNextDoesNotAllocate(t *testing.T) {
+	tbl := newLuaTable(4, 4)
+	for i := int64(1); i <= 4; i++ {
+		tbl.put(integerValue(i), integerValue(i))
+	}
+	for _, k := range []string{"a", "b", "c", "d"} {
+		tbl.put(stringValue(k), stringValue(k))
+	}
+	tbl.put(stringValue("b"), nilValue)
+
+	n := 0
+	allocs := testing.AllocsPerRun(100, func() {
+		n = 0
+		for k, _, _ := tbl.next(nilValue); !k.isNil(); k, _, _ = tbl.next(k) {
+			n++
+		}
+	})
+	if n != 7 {
+		t.Fatalf("expected 7 keys got %d", n)
+	}
+	if allocs != 0 {
+		t.Fatalf("next allocated %v times per traversal", allocs)
+	}
+}
@@ -0,0 +1,15 @@
+package state
+
+// 完整的userdata, 持有一个任意的Go值, 有自己的元表和user value
+// lua-5.3.4/src/lobject.h#Udata
+type userdata struct {
+	gcHeader
+	metatable *luaTable
+	uservalue luaValue
+	data      interface{}
+	fin       bool // 已经登记为需要终结的对象
+}
+
+func newUserdata(data interface{}) *userdata {
+	return &userdata{data: data}
+}
@@ -0,0 +1,128 @@
+package state
+
+import (
+	"fmt"
+	"lua_go/api"
+	"testing"
+)
+
+type point struct{ x, y int64 }
+
+func TestUserdata(t *testing.T) {
+	ls := New()
+	ls.OpenLibs()
+
+	var collected []int64
+	if !ls.NewMetatable("point") {
+		t.Fatal("NewMetatable returned false for a new name")
+	}
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		p := ls.CheckUdata(1, "point").(*point)
+		switch ls.CheckString(2) {
+		case "x":
+			ls.PushInteger(p.x)
+		case "y":
+			ls.PushInteger(p.y)
+		default:
+			ls.PushNil()
+		}
+		return 1
+	})
+	ls.SetField(-2, "__index")
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		p := ls.CheckUdata(1, "point").(*point)
+		ls.PushString(fmt.Sprintf("point(%d,%d)", p.x, p.y))
+		return 1
+	})
+	ls.SetField(-2, "__tostring")
+	ls.PushGoFunction(func(ls api.LuaState) int {
+		collected = append(collected, ls.CheckUdata(1, "point").(*point).x)
+		return 0
+	})
+	ls.SetField(-2, "__gc")
+	ls.Pop(1)
+	if ls.NewMetatable("point") {
+		t.Fatal("NewMetatable returned true for an existing name")
+	}
+	ls.Pop(1)
+
+	ls.Register("newpoint", func(ls api.LuaState) int {
+		ls.NewUserdata(&point{ls.CheckInteger(1), ls.CheckInteger(2)})
+		ls.SetMetatableByName("point")
+		return 1
+	})
+
+	tests := []struct {
+		chunk    string
+		expected string
+	}{
+		{`local p = newpoint(3, 4) return p.x + p.y`, "7"},
+		{`return tostring(newpoint(1, 2))`, "point(1,2)"},
+		{`return type(newpoint(1, 2))`, "userdata"},
+		{`local p = newpoint(1, 2) return p == p, p == newpoint(1, 2)`, "true,false"},
+		{`return getmetatable(newpoint(0, 0)).__name`, "point"},
+	}
+	for _, tt := range tests {
+		ls.SetTop(0)
+		if actual := doStringOn(t, ls, tt.chunk); actual != tt.expected {
+			t.Fatalf("%s: expected %q got %q", tt.chunk, tt.expected, actual)
+		}
+	}
+
+	/* CheckUdata rejects other userdata and light userdata */
+	ls.SetTop(0)
+	ls.NewUserdata(&point{})
+	ls.PushLightUserdata(&point{})
+	if ls.TestUdata(1, "point") != nil || ls.TestUdata(2, "point") != nil {
+		t.Fatal("TestUdata accepted a userdata without the metatable")
+	}
+	if !ls.IsUserdata(2) || !ls.IsLightUserdata(2) || ls.Type(1) != api.LUA_TUSERDATA {
+		t.Fatal("wrong userdata type")
+	}
+
+	/* light userdata must be comparable */
+	func() {
+		defer func() {
+			if r := recover(); r != "light userdata must be comparable!" {
+				t.Fatalf("PushLightUserdata accepted a slice: %v", r)
+			}
+		}()
+		ls.PushLightUserdata([]int{1})
+	}()
+	func() {
+		defer func() {
+			if r := recover(); r != "light userdata must be comparable!" {
+				t.Fatalf("PushLightUserdata accepted a struct holding a slice: %v", r)
+			}
+		}()
+		ls.PushLightUserdata(struct{ X interface{} }{[]int{1}})
+	}()
+	if ls.GetTop() != 2 {
+		t.Fatal("non-comparable light userdata pushed")
+	}
+
+	/* light userdata are compared and used as keys by their Go value */
+	key := &point{}
+	ls.NewTable()
+	ls.PushLightUserdata(key)
+	ls.PushString("v")
+	ls.SetTable(-3)
+	ls.PushLightUserdata(key)
+	ls.PushLightUserdata(key)
+	if !ls.RawEqual(-1, -2) || ls.GetTable(-3) != api.LUA_TSTRING {
+		t.Fatal("light userdata with the same value are different keys")
+	}
+	ls.SetTop(2)
+
+	/* user value */
+	ls.PushString("uv")
+	ls.SetUserValue(1)
+	if ls.GetUserValue(1) != api.LUA_TSTRING || ls.ToString(-1) != "uv" {
+		t.Fatal("user value not stored")
+	}
+
+	ls.Close()
+	if len(collected) != 6 || collected[0] != 0 || collected[5] != 3 {
+		t.Fatalf("__gc not called in reverse order: %v", collected)
+	}
+}
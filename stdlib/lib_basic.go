@@ -8,27 +8,28 @@ import (
 )
 
 var baseFuncs = map[string]api.GoFunction{
-	"print":        basePrint,
-	"assert":       baseAssert,
-	"error":        baseError,
-	"select":       baseSelect,
-	"ipairs":       baseIPairs,
-	"pairs":        basePairs,
-	"next":         baseNext,
-	"load":         baseLoad,
-	"loadfile":     baseLoadFile,
-	"dofile":       baseDoFile,
-	"pcall":        basePCall,
-	"xpcall":       baseXPCall,
-	"getmetatable": baseGetMetatable,
-	"setmetatable": baseSetMetatable,
-	"rawequal":     baseRawEqual,
-	"rawlen":       baseRawLen,
-	"rawget":       baseRawGet,
-	"rawset":       baseRawSet,
-	"type":         baseType,
-	"tostring":     baseToString,
-	"tonumber":     baseToNumber,
+	"print":          basePrint,
+	"assert":         baseAssert,
+	"error":          baseError,
+	"select":         baseSelect,
+	"ipairs":         baseIPairs,
+	"pairs":          basePairs,
+	"next":           baseNext,
+	"load":           baseLoad,
+	"loadfile":       baseLoadFile,
+	"dofile":         baseDoFile,
+	"pcall":          basePCall,
+	"xpcall":         baseXPCall,
+	"getmetatable":   baseGetMetatable,
+	"setmetatable":   baseSetMetatable,
+	"rawequal":       baseRawEqual,
+	"rawlen":         baseRawLen,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"collectgarbage": baseCollectGarbage,
+	"type":           baseType,
+	"tostring":       baseToString,
+	"tonumber":       baseToNumber,
 	/* placeholders */
 	"_G":       nil,
 	"_VERSION": nil,
@@ -47,9 +48,30 @@ func OpenBaseLib(ls api.LuaState) int {
 	return 1
 }
 
+// OpenLibsWithOptions打开的基础库函数用第一个上值保存环境, 第二个上值表示是否只能加载文本代码块
+func _pushEnv(ls api.LuaState) bool {
+	if ls.Type(api.LuaUpvalueIndex(1)) != api.LUA_TTABLE {
+		return false
+	}
+	ls.PushValue(api.LuaUpvalueIndex(1))
+	return true
+}
+
+func _loadMode(ls api.LuaState, mode string) string {
+	if ls.ToBoolean(api.LuaUpvalueIndex(2)) { /* only text chunks? */
+		return "t"
+	}
+	return mode
+}
+
 func basePrint(ls api.LuaState) int {
 	n := ls.GetTop() /* number of arguments */
-	ls.GetGlobal("tostring")
+	if _pushEnv(ls) {
+		ls.GetField(-1, "tostring")
+		ls.Remove(-2)
+	} else {
+		ls.GetGlobal("tostring")
+	}
 	for i := 1; i <= n; i++ {
 		ls.PushValue(-1) /* function to be called */
 		ls.PushValue(i)  /* value to print */
@@ -81,13 +103,13 @@ func baseAssert(ls api.LuaState) int {
 }
 
 func baseError(ls api.LuaState) int {
-	// level := int(ls.OptInteger(2, 1))
+	level := int(ls.OptInteger(2, 1))
 	ls.SetTop(1)
-	// if ls.Type(1) == api.LUA_TSTRING && level > 0 {
-	// 	ls.Where(level) /* add extra information */
-	// 	ls.PushValue(1)
-	// 	ls.Concat(2)
-	// }
+	if ls.Type(1) == api.LUA_TSTRING && level > 0 {
+		ls.Where(level) /* add extra information */
+		ls.PushValue(1)
+		ls.Concat(2)
+	}
 	return ls.Error()
 }
 
@@ -159,7 +181,7 @@ func baseNext(ls api.LuaState) int {
 func baseLoad(ls api.LuaState) int {
 	var status int
 	chunk, isStr := ls.ToStringX(1)
-	mode := ls.OptString(3, "bt")
+	mode := _loadMode(ls, ls.OptString(3, "bt"))
 	env := 0 /* 'env' index or 0 if no 'env' */
 	if !ls.IsNone(4) {
 		env = 4
@@ -177,7 +199,12 @@ func baseLoad(ls api.LuaState) int {
 func loadAux(ls api.LuaState, status, envIdx int) int {
 	if status == api.LUA_OK {
 		if envIdx != 0 { /* 'env' parameter? */
-			panic("todo!")
+			ls.PushValue(envIdx) /* environment for loaded function */
+		} else if !_pushEnv(ls) {
+			return 1
+		}
+		if _, ok := ls.SetUpvalue(-2, 1); !ok { /* set it as 1st upvalue */
+			ls.Pop(1) /* remove 'env' if not used by previous call */
 		}
 		return 1
 	} else { /* error (message is on top of the stack) */
@@ -192,7 +219,7 @@ func loadAux(ls api.LuaState, status, envIdx int) int {
 // lua-5.3.4/src/lbaselib.c#luaB_loadfile()
 func baseLoadFile(ls api.LuaState) int {
 	fname := ls.OptString(1, "")
-	mode := ls.OptString(1, "bt")
+	mode := _loadMode(ls, ls.OptString(2, "bt"))
 	env := 0 /* 'env' index or 0 if no 'env' */
 	if !ls.IsNone(3) {
 		env = 3
@@ -205,29 +232,64 @@ func baseLoadFile(ls api.LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-dofile
 // lua-5.3.4/src/lbaselib.c#luaB_dofile()
 func baseDoFile(ls api.LuaState) int {
-	fname := ls.OptString(1, "bt")
+	fname := ls.OptString(1, "")
 	ls.SetTop(1)
-	if ls.LoadFile(fname) != api.LUA_OK {
+	if ls.LoadFileX(fname, _loadMode(ls, "bt")) != api.LUA_OK {
 		return ls.Error()
 	}
-	ls.Call(0, api.LUA_MULTRET)
+	if _pushEnv(ls) {
+		if _, ok := ls.SetUpvalue(-2, 1); !ok {
+			ls.Pop(1)
+		}
+	}
+	ls.CallK(0, api.LUA_MULTRET, 0, doFileCont)
+	return doFileCont(ls, 0, 0)
+}
+
+// lua-5.3.4/src/lbaselib.c#dofilecont()
+func doFileCont(ls api.LuaState, d1 int, d2 api.KContext) int {
 	return ls.GetTop() - 1
 }
 
 // pcall (f [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-pcall
+// lua-5.3.4/src/lbaselib.c#luaB_pcall()
 func basePCall(ls api.LuaState) int {
-	nArgs := ls.GetTop() - 1
-	status := ls.PCall(nArgs, -1, 0)
-	ls.PushBoolean(status == api.LUA_OK)
-	ls.Insert(1)
-	return ls.GetTop()
+	ls.CheckAny(1)
+	ls.PushBoolean(true) /* first result if no errors */
+	ls.Insert(1)         /* put it in place */
+	status := ls.PCallK(ls.GetTop()-2, api.LUA_MULTRET, 0, 0, finishPCall)
+	return finishPCall(ls, status, 0)
 }
 
 // xpcall (f, msgh [, arg1, ···])
 // http://www.lua.org/manual/5.3/manual.html#pdf-xpcall
+// lua-5.3.4/src/lbaselib.c#luaB_xpcall()
 func baseXPCall(ls api.LuaState) int {
-	panic("todo!")
+	n := ls.GetTop()
+	ls.CheckType(2, api.LUA_TFUNCTION) /* check error function */
+	ls.PushBoolean(true)               /* first result */
+	ls.PushValue(1)                    /* function */
+	ls.Rotate(3, 2)                    /* move them below function's arguments */
+	status := ls.PCallK(n-2, api.LUA_MULTRET, 2, 2, finishPCall)
+	return finishPCall(ls, status, 2)
+}
+
+/*
+** Continuation function for 'pcall' and 'xpcall'. Both functions
+** already pushed a 'true' before doing the call, so in case of success
+** 'finishpcall' only has to return everything in the stack minus
+** 'extra' values (where 'extra' is exactly the number of items to be
+** ignored).
+ */
+// lua-5.3.4/src/lbaselib.c#finishpcall()
+func finishPCall(ls api.LuaState, status int, extra api.KContext) int {
+	if status != api.LUA_OK && status != api.LUA_YIELD { /* error? */
+		ls.PushBoolean(false) /* first result (false) */
+		ls.PushValue(-2)      /* error message */
+		return 2              /* return false, msg */
+	}
+	return ls.GetTop() - int(extra) /* return all results */
 }
 
 // getmetatable (object)
@@ -304,6 +366,58 @@ func baseRawSet(ls api.LuaState) int {
 	return 1
 }
 
+var gcOpts = []string{"stop", "restart", "collect",
+	"count", "step", "setpause", "setstepmul",
+	"isrunning", "generational", "incremental"}
+var gcOptsNum = []int{api.LUA_GCSTOP, api.LUA_GCRESTART, api.LUA_GCCOLLECT,
+	api.LUA_GCCOUNT, api.LUA_GCSTEP, api.LUA_GCSETPAUSE, api.LUA_GCSETSTEPMUL,
+	api.LUA_GCISRUNNING, api.LUA_GCGEN, api.LUA_GCINC}
+
+// collectgarbage ([opt [, arg]])
+// http://www.lua.org/manual/5.4/manual.html#pdf-collectgarbage
+// lua-5.4.6/src/lbaselib.c#luaB_collectgarbage()
+func baseCollectGarbage(ls api.LuaState) int {
+	o := gcOptsNum[ls.CheckOption(1, "collect", gcOpts)]
+	switch o {
+	case api.LUA_GCCOUNT:
+		k := ls.GC(o, 0)
+		b := ls.GC(api.LUA_GCCOUNTB, 0)
+		ls.PushNumber(float64(k) + float64(b)/1024)
+	case api.LUA_GCSTEP:
+		step := int(ls.OptInteger(2, 0))
+		ls.PushBoolean(ls.GC(o, step) != 0)
+	case api.LUA_GCSETPAUSE, api.LUA_GCSETSTEPMUL:
+		p := int(ls.OptInteger(2, 0))
+		ls.PushInteger(int64(ls.GC(o, p)))
+	case api.LUA_GCISRUNNING:
+		ls.PushBoolean(ls.GC(o, 0) != 0)
+	case api.LUA_GCGEN:
+		ls.OptInteger(2, 0) /* minormul */
+		ls.OptInteger(3, 0) /* majormul */
+		_pushGCMode(ls, ls.GC(o, 0))
+	case api.LUA_GCINC:
+		pause := int(ls.OptInteger(2, 0))
+		stepmul := int(ls.OptInteger(3, 0))
+		ls.OptInteger(4, 0) /* stepsize */
+		_pushGCMode(ls, ls.GC(o, pause))
+		if stepmul != 0 {
+			ls.GC(api.LUA_GCSETSTEPMUL, stepmul)
+		}
+	default:
+		ls.PushInteger(int64(ls.GC(o, 0)))
+	}
+	return 1
+}
+
+// lua-5.4.6/src/lbaselib.c#pushmode()
+func _pushGCMode(ls api.LuaState, oldMode int) {
+	if oldMode == api.LUA_GCINC {
+		ls.PushString("incremental")
+	} else {
+		ls.PushString("generational")
+	}
+}
+
 // type (v)
 // http://www.lua.org/manual/5.3/manual.html#pdf-type
 // lua-5.3.4/src/lbaselib.c#luaB_type()
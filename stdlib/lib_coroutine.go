@@ -10,6 +10,7 @@ var coFuncs = map[string]api.GoFunction{
 	"isyieldable": coYieldable,
 	"running":     coRunning,
 	"wrap":        coWrap,
+	"close":       coClose,
 }
 
 func OpenCoroutineLib(ls api.LuaState) int {
@@ -32,9 +33,7 @@ func coCreate(ls api.LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.resume
 // lua-5.3.4/src/lcorolib.c#luaB_coresume()
 func coResume(ls api.LuaState) int {
-	co := ls.ToThread(1)
-	ls.ArgCheck(co != nil, 1, "thread expected")
-
+	co := getCo(ls)
 	if r := _auxResume(ls, co, ls.GetTop()-1); r < 0 {
 		ls.PushBoolean(false)
 		ls.Insert(-2)
@@ -46,6 +45,13 @@ func coResume(ls api.LuaState) int {
 	}
 }
 
+// lua-5.3.4/src/lcorolib.c#getco()
+func getCo(ls api.LuaState) api.LuaState {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	return co
+}
+
 func _auxResume(ls, co api.LuaState, narg int) int {
 	if !ls.CheckStack(narg) {
 		ls.PushString("too many arguments to resume")
@@ -79,31 +85,43 @@ func coYield(ls api.LuaState) int {
 	return ls.Yield(ls.GetTop())
 }
 
-// coroutine.status (co)
-// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
-// lua-5.3.4/src/lcorolib.c#luaB_costatus()
-func coStatus(ls api.LuaState) int {
-	co := ls.ToThread(1)
-	ls.ArgCheck(co != nil, 1, "thread expected")
+const (
+	COS_RUN   = 0
+	COS_DEAD  = 1
+	COS_YIELD = 2
+	COS_NORM  = 3
+)
+
+var statName = []string{"running", "dead", "suspended", "normal"}
+
+// lua-5.4.6/src/lcorolib.c#auxstatus()
+func _auxStatus(ls, co api.LuaState) int {
 	if ls == co {
-		ls.PushString("running")
-	} else {
-		switch co.Status() {
-		case api.LUA_YIELD:
-			ls.PushString("suspended")
-		case api.LUA_OK:
-			if co.GetStack() { /* does it have frames? */
-				ls.PushString("normal") /* it is running */
-			} else if co.GetTop() == 0 {
-				ls.PushString("dead")
-			} else {
-				ls.PushString("suspended")
-			}
-		default: /* some error occurred */
-			ls.PushString("dead")
+		return COS_RUN
+	}
+	switch co.Status() {
+	case api.LUA_YIELD:
+		return COS_YIELD
+	case api.LUA_OK:
+		var ar api.LuaDebug
+		if co.GetStack(0, &ar) { /* does it have frames? */
+			return COS_NORM /* it is running */
+		} else if co.GetTop() == 0 {
+			return COS_DEAD
+		} else {
+			return COS_YIELD /* initial state */
 		}
+	default: /* some error occurred */
+		return COS_DEAD
 	}
+}
 
+// coroutine.status (co)
+// http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.status
+// lua-5.3.4/src/lcorolib.c#luaB_costatus()
+func coStatus(ls api.LuaState) int {
+	co := getCo(ls)
+	ls.PushString(statName[_auxStatus(ls, co)])
 	return 1
 }
 
@@ -124,6 +142,49 @@ func coRunning(ls api.LuaState) int {
 
 // coroutine.wrap (f)
 // http://www.lua.org/manual/5.3/manual.html#pdf-coroutine.wrap
+// lua-5.3.4/src/lcorolib.c#luaB_cowrap()
 func coWrap(ls api.LuaState) int {
-	panic("todo: coWrap!")
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+// lua-5.4.6/src/lcorolib.c#auxwrap()
+func auxWrap(ls api.LuaState) int {
+	co := ls.ToThread(api.LuaUpvalueIndex(1))
+	r := _auxResume(ls, co, ls.GetTop())
+	if r < 0 { /* error? */
+		stat := co.Status()
+		if stat != api.LUA_OK && stat != api.LUA_YIELD { /* error in the coroutine? */
+			stat = co.CloseThread(ls) /* close the coroutine */
+			co.XMove(ls, 1)           /* move error message to the caller */
+		}
+		if stat != api.LUA_ERRMEM && /* not a memory error and ... */
+			ls.Type(-1) == api.LUA_TSTRING { /* ... error object is a string? */
+			ls.Where(1) /* get extra info, if available */
+			ls.Insert(-2)
+			ls.Concat(2)
+		}
+		return ls.Error() /* propagate error */
+	}
+	return r
+}
+
+// coroutine.close (co)
+// http://www.lua.org/manual/5.4/manual.html#pdf-coroutine.close
+// lua-5.4.6/src/lcorolib.c#luaB_close()
+func coClose(ls api.LuaState) int {
+	co := getCo(ls)
+	switch status := _auxStatus(ls, co); status {
+	case COS_DEAD, COS_YIELD:
+		if co.CloseThread(ls) == api.LUA_OK {
+			ls.PushBoolean(true)
+			return 1
+		}
+		ls.PushBoolean(false)
+		co.XMove(ls, 1) /* move error message */
+		return 2
+	default: /* normal or running coroutine */
+		return ls.Error2("cannot close a %s coroutine", statName[status])
+	}
 }
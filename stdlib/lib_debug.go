@@ -0,0 +1,502 @@
+package stdlib
+
+import (
+	"bufio"
+	"fmt"
+	"lua_go/api"
+	"os"
+	"reflect"
+	"strings"
+)
+
+/*
+** The hook table at registry[HOOKKEY] maps threads to their current
+** hook function. (We only need the unique address of 'HOOKKEY'.)
+ */
+const HOOKKEY = "_HKEY"
+
+var dbLib = map[string]api.GoFunction{
+	"debug":        dbDebug,
+	"getuservalue": dbGetUserValue,
+	"gethook":      dbGetHook,
+	"getinfo":      dbGetInfo,
+	"getlocal":     dbGetLocal,
+	"getregistry":  dbGetRegistry,
+	"getmetatable": dbGetMetatable,
+	"getupvalue":   dbGetUpvalue,
+	"upvaluejoin":  dbUpvalueJoin,
+	"upvalueid":    dbUpvalueId,
+	"setuservalue": dbSetUserValue,
+	"sethook":      dbSetHook,
+	"setlocal":     dbSetLocal,
+	"setmetatable": dbSetMetatable,
+	"setupvalue":   dbSetUpvalue,
+	"traceback":    dbTraceback,
+}
+
+func OpenDebugLib(ls api.LuaState) int {
+	ls.NewLib(dbLib)
+	return 1
+}
+
+// debug.getregistry ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getregistry
+func dbGetRegistry(ls api.LuaState) int {
+	ls.PushValue(api.LUA_REGISTRYINDEX)
+	return 1
+}
+
+// debug.getmetatable (value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getmetatable
+func dbGetMetatable(ls api.LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil() /* no metatable */
+	}
+	return 1
+}
+
+// debug.setmetatable (value, table)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setmetatable
+func dbSetMetatable(ls api.LuaState) int {
+	t := ls.Type(2)
+	ls.ArgCheck(t == api.LUA_TNIL || t == api.LUA_TTABLE, 2,
+		"nil or table expected")
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1 /* return 1st argument */
+}
+
+// debug.getuservalue (u)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getuservalue
+func dbGetUserValue(ls api.LuaState) int {
+	if ls.Type(1) != api.LUA_TUSERDATA {
+		ls.PushNil()
+	} else {
+		ls.GetUserValue(1)
+	}
+	return 1
+}
+
+// debug.setuservalue (udata, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setuservalue
+func dbSetUserValue(ls api.LuaState) int {
+	ls.CheckType(1, api.LUA_TUSERDATA)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.SetUserValue(1)
+	return 1
+}
+
+/*
+** Auxiliary function used by several library functions: check for
+** an optional thread as function's first argument and set 'arg' with
+** 1 if this argument is present (so that functions can skip it to
+** access their other arguments)
+ */
+// lua-5.3.4/src/ldblib.c#getthread()
+func getThread(ls api.LuaState) (api.LuaState, int) {
+	if ls.Type(1) == api.LUA_TTHREAD {
+		return ls.ToThread(1), 1
+	}
+	return ls, 0 /* function will operate over current thread */
+}
+
+/*
+** If L1 != L, L1 can be in any state, and therefore there are no
+** guarantees about its stack space; any push in L1 must be
+** checked.
+ */
+// lua-5.3.4/src/ldblib.c#checkstack()
+func checkStack(ls, l1 api.LuaState, n int) {
+	if ls != l1 && !l1.CheckStack(n) {
+		ls.Error2("stack overflow")
+	}
+}
+
+/*
+** Variations of 'lua_settable', used by 'db_getinfo' to put results
+** from 'lua_getinfo' into result table. Key is always a string;
+** value can be a string, an int, or a boolean.
+ */
+func setTabSS(ls api.LuaState, k, v string) {
+	if v == "" {
+		ls.PushNil()
+	} else {
+		ls.PushString(v)
+	}
+	ls.SetField(-2, k)
+}
+
+func setTabSI(ls api.LuaState, k string, v int) {
+	ls.PushInteger(int64(v))
+	ls.SetField(-2, k)
+}
+
+func setTabSB(ls api.LuaState, k string, v bool) {
+	ls.PushBoolean(v)
+	ls.SetField(-2, k)
+}
+
+/*
+** In function 'db_getinfo', the call to 'lua_getinfo' may push
+** results on the stack; later it creates the result table to put
+** these objects. Function 'treatstackoption' puts the result from
+** 'lua_getinfo' on top of the result table so that it can call
+** 'lua_setfield'.
+ */
+// lua-5.3.4/src/ldblib.c#treatstackoption()
+func treatStackOption(ls, l1 api.LuaState, fname string) {
+	if ls == l1 {
+		ls.Rotate(-2, 1) /* exchange object and table */
+	} else {
+		l1.XMove(ls, 1) /* move object to the "main" stack */
+	}
+	ls.SetField(-2, fname) /* put object into table */
+}
+
+// debug.getinfo ([thread,] f [, what])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getinfo
+// lua-5.3.4/src/ldblib.c#db_getinfo()
+func dbGetInfo(ls api.LuaState) int {
+	var ar api.LuaDebug
+	l1, arg := getThread(ls)
+	options := ls.OptString(arg+2, "flnStu")
+	ls.ArgCheck(!strings.HasPrefix(options, ">"), arg+2, "invalid option '>'")
+	checkStack(ls, l1, 3)
+	if ls.IsFunction(arg + 1) { /* info about a function? */
+		options = ">" + options /* add '>' to 'options' */
+		ls.PushValue(arg + 1)   /* move function to 'L1' stack */
+		ls.XMove(l1, 1)
+	} else { /* stack level */
+		if !l1.GetStack(int(ls.CheckInteger(arg+1)), &ar) {
+			ls.PushNil() /* level out of range */
+			return 1
+		}
+	}
+	if !l1.GetInfo(options, &ar) {
+		return ls.ArgError(arg+2, "invalid option")
+	}
+	ls.NewTable() /* table to collect results */
+	if strings.IndexByte(options, 'S') >= 0 {
+		setTabSS(ls, "source", ar.Source)
+		setTabSS(ls, "short_src", ar.ShortSrc)
+		setTabSI(ls, "linedefined", ar.LineDefined)
+		setTabSI(ls, "lastlinedefined", ar.LastLineDefined)
+		setTabSS(ls, "what", ar.What)
+	}
+	if strings.IndexByte(options, 'l') >= 0 {
+		setTabSI(ls, "currentline", ar.CurrentLine)
+	}
+	if strings.IndexByte(options, 'u') >= 0 {
+		setTabSI(ls, "nups", ar.NUps)
+		setTabSI(ls, "nparams", ar.NParams)
+		setTabSB(ls, "isvararg", ar.IsVararg)
+	}
+	if strings.IndexByte(options, 'n') >= 0 {
+		setTabSS(ls, "name", ar.Name)
+		setTabSS(ls, "namewhat", ar.NameWhat)
+	}
+	if strings.IndexByte(options, 't') >= 0 {
+		setTabSB(ls, "istailcall", ar.IsTailCall)
+	}
+	if strings.IndexByte(options, 'L') >= 0 {
+		treatStackOption(ls, l1, "activelines")
+	}
+	if strings.IndexByte(options, 'f') >= 0 {
+		treatStackOption(ls, l1, "func")
+	}
+	return 1 /* return table */
+}
+
+// debug.getlocal ([thread,] f, local)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getlocal
+// lua-5.3.4/src/ldblib.c#db_getlocal()
+func dbGetLocal(ls api.LuaState) int {
+	var ar api.LuaDebug
+	l1, arg := getThread(ls)
+	nvar := int(ls.CheckInteger(arg + 2)) /* local-variable index */
+	if ls.IsFunction(arg + 1) {           /* function argument? */
+		ls.PushValue(arg + 1) /* push function */
+		if name := ls.GetLocal(nil, nvar); name != "" {
+			ls.PushString(name) /* push local name */
+		} else {
+			ls.PushNil()
+		}
+		return 1 /* return only name (there is no value) */
+	}
+	/* stack-level argument */
+	level := int(ls.CheckInteger(arg + 1))
+	if !l1.GetStack(level, &ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	checkStack(ls, l1, 1)
+	if name := l1.GetLocal(&ar, nvar); name != "" {
+		l1.XMove(ls, 1)     /* move local value */
+		ls.PushString(name) /* push name */
+		ls.Rotate(-2, 1)    /* re-order */
+		return 2
+	}
+	ls.PushNil() /* no name (nor value) */
+	return 1
+}
+
+// debug.setlocal ([thread,] level, local, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setlocal
+// lua-5.3.4/src/ldblib.c#db_setlocal()
+func dbSetLocal(ls api.LuaState) int {
+	var ar api.LuaDebug
+	l1, arg := getThread(ls)
+	level := int(ls.CheckInteger(arg + 1))
+	nvar := int(ls.CheckInteger(arg + 2))
+	if !l1.GetStack(level, &ar) { /* out of range? */
+		return ls.ArgError(arg+1, "level out of range")
+	}
+	ls.CheckAny(arg + 3)
+	ls.SetTop(arg + 3)
+	checkStack(ls, l1, 1)
+	ls.XMove(l1, 1)
+	name := l1.SetLocal(&ar, nvar)
+	if name == "" {
+		l1.Pop(1) /* pop value (if not popped by 'lua_setlocal') */
+		ls.PushNil()
+	} else {
+		ls.PushString(name)
+	}
+	return 1
+}
+
+/*
+** get (if 'get' is true) or set an upvalue from a closure
+ */
+// lua-5.3.4/src/ldblib.c#auxupvalue()
+func auxUpvalue(ls api.LuaState, get bool) int {
+	n := int(ls.CheckInteger(2))       /* upvalue index */
+	ls.CheckType(1, api.LUA_TFUNCTION) /* closure */
+	var name string
+	var ok bool
+	if get {
+		name, ok = ls.GetUpvalue(1, n)
+	} else {
+		name, ok = ls.SetUpvalue(1, n)
+	}
+	if !ok {
+		return 0
+	}
+	ls.PushString(name)
+	if get {
+		ls.Insert(-2)
+		return 2
+	}
+	return 1
+}
+
+// debug.getupvalue (f, up)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.getupvalue
+func dbGetUpvalue(ls api.LuaState) int {
+	return auxUpvalue(ls, true)
+}
+
+// debug.setupvalue (f, up, value)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.setupvalue
+func dbSetUpvalue(ls api.LuaState) int {
+	ls.CheckAny(3)
+	return auxUpvalue(ls, false)
+}
+
+/*
+** Check whether a given upvalue from a given closure exists and
+** returns its index
+ */
+// lua-5.3.4/src/ldblib.c#checkupval()
+func checkUpval(ls api.LuaState, argf, argnup int) int {
+	nup := int(ls.CheckInteger(argnup))   /* upvalue index */
+	ls.CheckType(argf, api.LUA_TFUNCTION) /* closure */
+	_, ok := ls.GetUpvalue(argf, nup)
+	ls.ArgCheck(ok, argnup, "invalid upvalue index")
+	ls.Pop(1) /* remove upvalue */
+	return nup
+}
+
+// debug.upvalueid (f, n)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvalueid
+func dbUpvalueId(ls api.LuaState) int {
+	n := checkUpval(ls, 1, 2)
+	ls.PushLightUserdata(ls.UpvalueId(1, n))
+	return 1
+}
+
+// debug.upvaluejoin (f1, n1, f2, n2)
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.upvaluejoin
+func dbUpvalueJoin(ls api.LuaState) int {
+	n1 := checkUpval(ls, 1, 2)
+	n2 := checkUpval(ls, 3, 4)
+	ls.ArgCheck(!ls.IsGoFunction(1), 1, "Lua function expected")
+	ls.ArgCheck(!ls.IsGoFunction(3), 3, "Lua function expected")
+	ls.UpvalueJoin(1, n1, 3, n2)
+	return 0
+}
+
+/*
+** Call hook function registered at hook table for the current
+** thread (if there is one)
+ */
+// lua-5.3.4/src/ldblib.c#hookf()
+func hookF(ls api.LuaState, ar *api.LuaDebug) {
+	hookNames := []string{"call", "return", "line", "count", "tail call"}
+	ls.PushString(HOOKKEY)
+	ls.RawGet(api.LUA_REGISTRYINDEX)
+	ls.PushThread()
+	if ls.RawGet(-2) == api.LUA_TFUNCTION { /* is there a hook function? */
+		ls.PushString(hookNames[ar.Event]) /* push event name */
+		if ar.CurrentLine >= 0 {
+			ls.PushInteger(int64(ar.CurrentLine)) /* push current line */
+		} else {
+			ls.PushNil()
+		}
+		ls.GetInfo("lS", ar)
+		ls.Call(2, 0) /* call hook function */
+	}
+}
+
+/*
+** Convert a string mask (for 'sethook') into a bit mask
+ */
+// lua-5.3.4/src/ldblib.c#makemask()
+func makeMask(smask string, count int) int {
+	mask := 0
+	if strings.IndexByte(smask, 'c') >= 0 {
+		mask |= api.LUA_MASKCALL
+	}
+	if strings.IndexByte(smask, 'r') >= 0 {
+		mask |= api.LUA_MASKRET
+	}
+	if strings.IndexByte(smask, 'l') >= 0 {
+		mask |= api.LUA_MASKLINE
+	}
+	if count > 0 {
+		mask |= api.LUA_MASKCOUNT
+	}
+	return mask
+}
+
+/*
+** Convert a bit mask (for 'gethook') into a string mask
+ */
+// lua-5.3.4/src/ldblib.c#unmakemask()
+func unmakeMask(mask int) string {
+	smask := ""
+	if mask&api.LUA_MASKCALL != 0 {
+		smask += "c"
+	}
+	if mask&api.LUA_MASKRET != 0 {
+		smask += "r"
+	}
+	if mask&api.LUA_MASKLINE != 0 {
+		smask += "l"
+	}
+	return smask
+}
+
+// debug.sethook ([thread,] hook, mask [, count])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.sethook
+// lua-5.3.4/src/ldblib.c#db_sethook()
+func dbSetHook(ls api.LuaState) int {
+	var mask, count int
+	var fn api.LuaHook
+	l1, arg := getThread(ls)
+	if ls.IsNoneOrNil(arg + 1) { /* no hook? */
+		ls.SetTop(arg + 1)
+		fn, mask, count = nil, 0, 0 /* turn off hooks */
+	} else {
+		smask := ls.CheckString(arg + 2)
+		ls.CheckType(arg+1, api.LUA_TFUNCTION)
+		count = int(ls.OptInteger(arg+3, 0))
+		fn, mask = hookF, makeMask(smask, count)
+	}
+	ls.PushString(HOOKKEY)
+	if ls.RawGet(api.LUA_REGISTRYINDEX) == api.LUA_TNIL {
+		ls.Pop(1)
+		ls.CreateTable(0, 2) /* create a hook table */
+		ls.PushString(HOOKKEY)
+		ls.PushValue(-2)
+		ls.RawSet(api.LUA_REGISTRYINDEX) /* set it in position */
+		ls.PushString("k")
+		ls.SetField(-2, "__mode") /** hooktable.__mode = "k" */
+		ls.PushValue(-1)
+		ls.SetMetatable(-2) /* setmetatable(hooktable) = hooktable */
+	}
+	checkStack(ls, l1, 1)
+	l1.PushThread()
+	l1.XMove(ls, 1)       /* key (thread) */
+	ls.PushValue(arg + 1) /* value (hook function) */
+	ls.RawSet(-3)         /* hooktable[L1] = new Lua hook */
+	l1.SetHook(fn, mask, count)
+	return 0
+}
+
+// debug.gethook ([thread])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.gethook
+// lua-5.3.4/src/ldblib.c#db_gethook()
+func dbGetHook(ls api.LuaState) int {
+	l1, _ := getThread(ls)
+	mask := l1.GetHookMask()
+	hook := l1.GetHook()
+	if hook == nil { /* no hook? */
+		ls.PushNil()
+	} else if reflect.ValueOf(hook).Pointer() != reflect.ValueOf(hookF).Pointer() {
+		ls.PushString("external hook") /* external hook? */
+	} else { /* hook table must exist */
+		ls.PushString(HOOKKEY)
+		ls.RawGet(api.LUA_REGISTRYINDEX)
+		checkStack(ls, l1, 1)
+		l1.PushThread()
+		l1.XMove(ls, 1)
+		ls.RawGet(-2) /* 1st result = hooktable[L1] */
+		ls.Remove(-2) /* remove hook table */
+	}
+	ls.PushString(unmakeMask(mask))          /* 2nd result = mask */
+	ls.PushInteger(int64(l1.GetHookCount())) /* 3rd result = count */
+	return 3
+}
+
+var debugIn *bufio.Reader
+
+// debug.debug ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.debug
+// lua-5.3.4/src/ldblib.c#db_debug()
+func dbDebug(ls api.LuaState) int {
+	if debugIn == nil {
+		debugIn = bufio.NewReader(os.Stdin)
+	}
+	for {
+		fmt.Fprint(os.Stderr, "lua_debug> ")
+		line, err := debugIn.ReadString('\n')
+		if err != nil && line == "" || line == "cont\n" {
+			return 0
+		}
+		if ls.Load([]byte(line), "=(debug command)", "bt") != api.LUA_OK ||
+			ls.PCall(0, 0, 0) != api.LUA_OK {
+			fmt.Fprintf(os.Stderr, "%s\n", ls.ToString(-1))
+		}
+		ls.SetTop(0) /* remove eventual returns */
+	}
+}
+
+// debug.traceback ([thread,] [message [, level]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-debug.traceback
+// lua-5.3.4/src/ldblib.c#db_traceback()
+func dbTraceback(ls api.LuaState) int {
+	l1, arg := getThread(ls)
+	msg, ok := ls.ToStringX(arg + 1)
+	if !ok && !ls.IsNoneOrNil(arg+1) { /* non-string 'msg'? */
+		ls.PushValue(arg + 1) /* return it untouched */
+	} else {
+		level := 0
+		if ls == l1 {
+			level = 1
+		}
+		ls.Traceback(l1, msg, int(ls.OptInteger(arg+2, int64(level))))
+	}
+	return 1
+}
@@ -0,0 +1,909 @@
+package stdlib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"lua_go/api"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const (
+	LUA_FILEHANDLE   = "FILE*"
+	IO_PREFIX        = "_IO_"
+	IO_INPUT         = IO_PREFIX + "input"
+	IO_OUTPUT        = IO_PREFIX + "output"
+	LUAL_BUFFERSIZE  = 4096
+	L_MAXLENNUM      = 200 /* maximum length of a numeral */
+	MAXARGLINE       = 250 /* maximum number of arguments to 'lines' */
+	LUA_INTEGER_FMT  = "%d"
+	LUA_NUMBER_FMT   = "%.14g"
+	LUA_IO_SHELL     = "/bin/sh"
+	LUA_IO_SHELL_WIN = "cmd"
+)
+
+/* buffering modes for setvbuf */
+const (
+	_IOFBF = iota /* full buffering */
+	_IOLBF        /* line buffering */
+	_IONBF        /* no buffering */
+)
+
+var ioLib = map[string]api.GoFunction{
+	"close":   ioClose,
+	"flush":   ioFlush,
+	"input":   ioInput,
+	"lines":   ioLines,
+	"open":    ioOpen,
+	"output":  ioOutput,
+	"popen":   ioPopen,
+	"read":    ioRead,
+	"tmpfile": ioTmpFile,
+	"type":    ioType,
+	"write":   ioWrite,
+}
+
+/* methods for file handles */
+var fileMethods = map[string]api.GoFunction{
+	"close":      ioClose,
+	"flush":      fFlush,
+	"lines":      fLines,
+	"read":       fRead,
+	"seek":       fSeek,
+	"setvbuf":    fSetvbuf,
+	"write":      fWrite,
+	"__gc":       fGC,
+	"__close":    fGC,
+	"__tostring": fToString,
+}
+
+func OpenIOLib(ls api.LuaState) int {
+	ls.NewLib(ioLib) /* new module */
+	createMeta(ls)
+	/* create (and set) default files */
+	createStdFile(ls, os.Stdin, IO_INPUT, "stdin")
+	createStdFile(ls, os.Stdout, IO_OUTPUT, "stdout")
+	createStdFile(ls, os.Stderr, "", "stderr")
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#createmeta()
+func createMeta(ls api.LuaState) {
+	ls.NewMetatable(LUA_FILEHANDLE) /* create metatable for file handles */
+	ls.PushValue(-1)                /* push metatable */
+	ls.SetField(-2, "__index")      /* metatable.__index = metatable */
+	ls.SetFuncs(fileMethods, 0)     /* add file methods to new metatable */
+	ls.Pop(1)                       /* pop new metatable */
+}
+
+// lua-5.3.4/src/liolib.c#createstdfile()
+func createStdFile(ls api.LuaState, f *os.File, k, fname string) {
+	p := newPreFile(ls)
+	p.f = f
+	p.closef = ioNoClose
+	if f != os.Stdin {
+		p.vbuf = _IONBF // 可能和print共用输出, 不缓冲以免输出乱序
+	}
+	if k != "" {
+		ls.PushValue(-1)
+		ls.SetField(api.LUA_REGISTRYINDEX, k) /* add file to registry */
+	}
+	ls.SetField(-2, fname) /* add file to module */
+}
+
+// 替换ls中io库的标准流, 必须在打开io库之后调用
+// name是"stdin", "stdout"或"stderr", 替换stdin和stdout时也会改变默认输入输出文件
+func SetStdStream(ls api.LuaState, name string, f *os.File) {
+	var k string
+	switch name {
+	case "stdin":
+		k = IO_INPUT
+	case "stdout":
+		k = IO_OUTPUT
+	case "stderr":
+	default:
+		panic("invalid standard stream: " + name)
+	}
+	ls.GetSubTable(api.LUA_REGISTRYINDEX, "_LOADED")
+	if ls.GetField(-1, "io") != api.LUA_TTABLE {
+		panic("io library is not opened!")
+	}
+	createStdFile(ls, f, k, name)
+	ls.Pop(2)
+}
+
+/*
+** {======================================================
+** File handles
+** =======================================================
+ */
+
+// Lua文件句柄, 相当于C中的luaL_Stream
+// closef为nil表示文件已经关闭
+type luaStream struct {
+	f      *os.File
+	r      *bufio.Reader // 读缓冲, 需要时才创建
+	w      *bufio.Writer // 写缓冲, 需要时才创建
+	vbuf   int           // 缓冲模式
+	size   int           // 缓冲区大小
+	err    error         // 最近一次读写错误, 相当于ferror()
+	cmd    *exec.Cmd     // io.popen启动的进程
+	closef api.GoFunction
+}
+
+func (p *luaStream) isClosed() bool {
+	return p.closef == nil
+}
+
+func (p *luaStream) reader() *bufio.Reader {
+	if p.w != nil && p.w.Buffered() > 0 { // 读之前先把写缓冲刷新到文件
+		if err := p.w.Flush(); err != nil {
+			p.err = err
+		}
+	}
+	if p.r == nil {
+		p.r = bufio.NewReaderSize(p.f, p.bufSize())
+	}
+	return p.r
+}
+
+func (p *luaStream) bufSize() int {
+	if p.size > 0 {
+		return p.size
+	}
+	return LUAL_BUFFERSIZE
+}
+
+// 丢弃读缓冲中预读的数据, 把文件位置调整回逻辑位置
+func (p *luaStream) discardReadBuffer() {
+	if p.r == nil || p.r.Buffered() == 0 {
+		return
+	}
+	if _, err := p.f.Seek(-int64(p.r.Buffered()), io.SeekCurrent); err == nil {
+		p.r.Reset(p.f)
+	} /* else 管道和终端无法回退, 保留预读的数据 */
+}
+
+func (p *luaStream) write(s string) error {
+	p.discardReadBuffer()
+	if p.vbuf == _IONBF {
+		if p.w != nil && p.w.Buffered() > 0 {
+			if err := p.w.Flush(); err != nil {
+				return err
+			}
+		}
+		_, err := p.f.WriteString(s)
+		return err
+	}
+
+	if p.w == nil {
+		p.w = bufio.NewWriterSize(p.f, p.bufSize())
+	}
+	if _, err := p.w.WriteString(s); err != nil {
+		return err
+	}
+	if p.vbuf == _IOLBF && strings.IndexByte(s, '\n') >= 0 {
+		return p.w.Flush()
+	}
+	return nil
+}
+
+func (p *luaStream) flush() error {
+	if p.w != nil {
+		return p.w.Flush()
+	}
+	return nil
+}
+
+func (p *luaStream) seek(offset int64, whence int) (int64, error) {
+	if err := p.flush(); err != nil {
+		return 0, err
+	}
+	if p.r != nil {
+		if whence == io.SeekCurrent { // 文件的实际位置超前了预读的数据
+			offset -= int64(p.r.Buffered())
+		}
+		pos, err := p.f.Seek(offset, whence)
+		if err == nil {
+			p.r.Reset(p.f)
+		}
+		return pos, err
+	}
+	return p.f.Seek(offset, whence)
+}
+
+func (p *luaStream) setvbuf(mode, size int) error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+	p.vbuf = mode
+	if size != p.size && size > 0 {
+		p.size = size
+		p.w = nil // 下次写的时候按新的大小创建
+	}
+	return nil
+}
+
+// 关闭文件, 先把写缓冲刷新到文件
+func (p *luaStream) close() error {
+	err := p.flush()
+	if cerr := p.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// lua-5.3.4/src/liolib.c#io_type()
+func ioType(ls api.LuaState) int {
+	ls.CheckAny(1)
+	p, ok := ls.TestUdata(1, LUA_FILEHANDLE).(*luaStream)
+	if !ok {
+		ls.PushNil() /* not a file */
+	} else if p.isClosed() {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#f_tostring()
+func fToString(ls api.LuaState) int {
+	p := toStream(ls, 1)
+	if p.isClosed() {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", p))
+	}
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#tolstream()
+func toStream(ls api.LuaState, idx int) *luaStream {
+	return ls.CheckUdata(idx, LUA_FILEHANDLE).(*luaStream)
+}
+
+// lua-5.3.4/src/liolib.c#tofile()
+func toFile(ls api.LuaState) *luaStream {
+	p := toStream(ls, 1)
+	if p.isClosed() {
+		ls.Error2("attempt to use a closed file")
+	}
+	return p
+}
+
+/*
+** When creating file handles, always creates a 'closed' file handle
+** before opening the actual file; so, if there is a memory error, the
+** handle is in a consistent state.
+ */
+// lua-5.3.4/src/liolib.c#newprefile()
+func newPreFile(ls api.LuaState) *luaStream {
+	p := &luaStream{} /* mark file handle as 'closed' */
+	ls.NewUserdata(p)
+	ls.SetMetatableByName(LUA_FILEHANDLE)
+	return p
+}
+
+/*
+** Calls the 'close' function from a file handle. The 'volatile' avoids
+** a bug in some versions of the Clang compiler (e.g., clang 3.0 for
+** 32 bits).
+ */
+// lua-5.3.4/src/liolib.c#aux_close()
+func auxClose(ls api.LuaState) int {
+	p := toStream(ls, 1)
+	cf := p.closef
+	p.closef = nil /* mark stream as closed */
+	return cf(ls)  /* close it */
+}
+
+// lua-5.3.4/src/liolib.c#io_close()
+func ioClose(ls api.LuaState) int {
+	if ls.IsNone(1) { /* no argument? */
+		ls.GetField(api.LUA_REGISTRYINDEX, IO_OUTPUT) /* use standard output */
+	}
+	toFile(ls) /* make sure argument is an open stream */
+	return auxClose(ls)
+}
+
+// lua-5.3.4/src/liolib.c#f_gc()
+func fGC(ls api.LuaState) int {
+	p := toStream(ls, 1)
+	if !p.isClosed() && p.f != nil {
+		auxClose(ls) /* ignore closed and incompletely open files */
+	}
+	return 0
+}
+
+/*
+** function to close regular files
+ */
+// lua-5.3.4/src/liolib.c#io_fclose()
+func ioFClose(ls api.LuaState) int {
+	p := toStream(ls, 1)
+	return ls.FileResult(p.close(), "")
+}
+
+// lua-5.3.4/src/liolib.c#newfile()
+func newFile(ls api.LuaState) *luaStream {
+	p := newPreFile(ls)
+	p.closef = ioFClose
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#opencheckfile()
+func openCheckFile(ls api.LuaState, fname, mode string) {
+	p := newFile(ls)
+	f, err := openFile(fname, mode)
+	if err != nil {
+		ls.FileResult(err, "") /* 借用FileResult生成strerror的信息 */
+		ls.Error2("cannot open file '%s' (%s)", fname, ls.ToString(-2))
+	}
+	p.f = f
+}
+
+/*
+** Check whether 'mode' matches '[rwa]%+?b*'.
+ */
+// lua-5.3.4/src/liolib.c#l_checkmode()
+func checkMode(mode string) bool {
+	if mode == "" || strings.IndexByte("rwa", mode[0]) < 0 {
+		return false
+	}
+	mode = mode[1:]
+	if mode != "" && mode[0] == '+' {
+		mode = mode[1:] /* skip if char is '+' */
+	}
+	return strings.Trim(mode, "b") == "" /* check extensions */
+}
+
+// 按照fopen的模式打开文件
+func openFile(fname, mode string) (*os.File, error) {
+	var flag int
+	switch strings.TrimRight(mode, "b") {
+	case "r":
+		flag = os.O_RDONLY
+	case "w":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	case "a":
+		flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	case "r+":
+		flag = os.O_RDWR
+	case "w+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	case "a+":
+		flag = os.O_RDWR | os.O_CREATE | os.O_APPEND
+	}
+	return os.OpenFile(fname, flag, 0666)
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.open
+// lua-5.3.4/src/liolib.c#io_open()
+func ioOpen(ls api.LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	p := newFile(ls)
+	ls.ArgCheck(checkMode(mode), 2, "invalid mode")
+	f, err := openFile(filename, mode)
+	if err != nil {
+		return ls.FileResult(err, filename)
+	}
+	p.f = f
+	return 1
+}
+
+/*
+** function to close 'popen' files
+ */
+// lua-5.3.4/src/liolib.c#io_pclose()
+func ioPClose(ls api.LuaState) int {
+	p := toStream(ls, 1)
+	p.close()
+	err := p.cmd.Wait()
+	checkContext(ls)
+	return ls.ExecResult(err)
+}
+
+// io.popen (prog [, mode])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.popen
+// lua-5.3.4/src/liolib.c#io_popen()
+func ioPopen(ls api.LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	p := newPreFile(ls)
+	ls.ArgCheck(mode == "r" || mode == "w", 2, "invalid mode")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return ls.FileResult(err, filename)
+	}
+	cmd := shellCommand(ls, filename)
+	if mode == "r" {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, w, os.Stderr
+		p.f = r
+	} else {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = r, os.Stdout, os.Stderr
+		p.f = w
+	}
+	f := p.f
+	cmd.Cancel = func() error { // 命令启动的进程可能还持有管道, 关闭管道让阻塞的读写返回
+		f.Close()
+		return cmd.Process.Kill()
+	}
+	err = cmd.Start()
+	if mode == "r" { // 子进程持有另一端
+		w.Close()
+	} else {
+		r.Close()
+	}
+	if err != nil {
+		p.f.Close()
+		p.f = nil
+		return ls.FileResult(err, filename)
+	}
+	p.cmd = cmd
+	p.closef = ioPClose
+	return 1
+}
+
+// 和C的system/popen一样通过shell执行命令, 状态的ctx被取消时命令会被杀死
+func shellCommand(ls api.LuaState, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ls.Context(), LUA_IO_SHELL_WIN, "/C", command)
+	}
+	return exec.CommandContext(ls.Context(), LUA_IO_SHELL, "-c", command)
+}
+
+// 阻塞的调用返回后检查状态的ctx, 被取消或者超时则抛出错误.
+// 注意: 读标准输入, 管道等阻塞的读操作本身无法被ctx打断, 只有在读操作返回后才会出错;
+// io.popen打开的命令会在ctx取消时被杀死, 所以从它读取不会一直阻塞
+func checkContext(ls api.LuaState) {
+	if err := ls.Context().Err(); err != nil {
+		ls.Error2("%s", err.Error())
+	}
+}
+
+// io.tmpfile ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.tmpfile
+// lua-5.3.4/src/liolib.c#io_tmpfile()
+func ioTmpFile(ls api.LuaState) int {
+	p := newFile(ls)
+	f, err := os.CreateTemp("", "lua_")
+	if err != nil {
+		return ls.FileResult(err, "")
+	}
+	os.Remove(f.Name()) // 和tmpfile()一样, 关闭后自动删除
+	p.f = f
+	return 1
+}
+
+// lua-5.3.4/src/liolib.c#getiofile()
+func getIOFile(ls api.LuaState, findex string) *luaStream {
+	ls.GetField(api.LUA_REGISTRYINDEX, findex)
+	p := ls.ToUserdata(-1).(*luaStream)
+	if p.isClosed() {
+		ls.Error2("standard %s file is closed", findex[len(IO_PREFIX):])
+	}
+	return p
+}
+
+// lua-5.3.4/src/liolib.c#g_iofile()
+func gIOFile(ls api.LuaState, f, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if filename, ok := ls.ToStringX(1); ok {
+			openCheckFile(ls, filename, mode)
+		} else {
+			toFile(ls) /* check that it's a valid file handle */
+			ls.PushValue(1)
+		}
+		ls.SetField(api.LUA_REGISTRYINDEX, f)
+	}
+	/* return current value */
+	ls.GetField(api.LUA_REGISTRYINDEX, f)
+	return 1
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.input
+func ioInput(ls api.LuaState) int {
+	return gIOFile(ls, IO_INPUT, "r")
+}
+
+// io.output ([file])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.output
+func ioOutput(ls api.LuaState) int {
+	return gIOFile(ls, IO_OUTPUT, "w")
+}
+
+/*
+** maximum number of arguments to 'f:lines'/'io.lines' (it + 3 must fit
+** in the limit for upvalues of a closure)
+ */
+// lua-5.3.4/src/liolib.c#aux_lines()
+func auxLines(ls api.LuaState, toClose bool) {
+	n := ls.GetTop() - 1 /* number of arguments to read */
+	ls.ArgCheck(n <= MAXARGLINE, MAXARGLINE+2, "too many arguments")
+	ls.PushInteger(int64(n)) /* number of arguments to read */
+	ls.PushBoolean(toClose)  /* close/not close file when finished */
+	ls.Rotate(2, 2)          /* move 'n' and 'toclose' to their positions */
+	ls.PushGoClosure(ioReadline, 3+n)
+}
+
+// file:lines (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:lines
+func fLines(ls api.LuaState) int {
+	toFile(ls) /* check that it's a valid file handle */
+	auxLines(ls, false)
+	return 1
+}
+
+// io.lines ([filename ···])
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.lines
+// lua-5.3.4/src/liolib.c#io_lines()
+func ioLines(ls api.LuaState) int {
+	toClose := false
+	if ls.IsNone(1) {
+		ls.PushNil() /* at least one argument */
+	}
+	if ls.IsNil(1) { /* no file name? */
+		ls.GetField(api.LUA_REGISTRYINDEX, IO_INPUT) /* get default input */
+		ls.Replace(1)                                /* put it at index 1 */
+		toFile(ls)                                   /* check that it's a valid file handle */
+	} else { /* open a new file */
+		filename := ls.CheckString(1)
+		openCheckFile(ls, filename, "r")
+		ls.Replace(1) /* put file at index 1 */
+		toClose = true
+	}
+	auxLines(ls, toClose) /* push iteration function */
+	return 1
+}
+
+/*
+** {======================================================
+** READ
+** =======================================================
+ */
+
+/* auxiliary structure used by 'read_number' */
+type rn struct {
+	r    *bufio.Reader
+	c    int /* current character (look ahead) */
+	buff []byte
+}
+
+// 读取下一个字符, 文件结束时返回-1
+func getc(r *bufio.Reader) int {
+	c, err := r.ReadByte()
+	if err != nil {
+		return -1
+	}
+	return int(c)
+}
+
+/*
+** Add current char to buffer (if not out of space) and read next one
+ */
+// lua-5.3.4/src/liolib.c#nextc()
+func (rn *rn) nextc() bool {
+	if len(rn.buff) >= L_MAXLENNUM { /* buffer overflow? */
+		rn.buff = rn.buff[:0] /* invalidate result */
+		return false          /* fail */
+	}
+	rn.buff = append(rn.buff, byte(rn.c)) /* save current char */
+	rn.c = getc(rn.r)                     /* read next one */
+	return true
+}
+
+/*
+** Accept current char if it is in 'set' (of size 2)
+ */
+// lua-5.3.4/src/liolib.c#test2()
+func (rn *rn) test2(set string) bool {
+	if rn.c == int(set[0]) || rn.c == int(set[1]) {
+		return rn.nextc()
+	}
+	return false
+}
+
+/*
+** Read a sequence of (hex)digits
+ */
+// lua-5.3.4/src/liolib.c#readdigits()
+func (rn *rn) readDigits(hex bool) int {
+	count := 0
+	for rn.c >= 0 && (hex && isXDigit(byte(rn.c)) || !hex && isDigit(byte(rn.c))) && rn.nextc() {
+		count++
+	}
+	return count
+}
+
+func isXDigit(c byte) bool {
+	return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+/*
+** Read a number: first reads a valid prefix of a numeral into a buffer.
+** Then it calls 'lua_stringtonumber' to check whether the format is
+** correct and to convert it to a Lua number
+ */
+// lua-5.3.4/src/liolib.c#read_number()
+func readNumber(ls api.LuaState, p *luaStream) bool {
+	rn := &rn{r: p.reader()}
+	count := 0
+	hex := false
+	for { /* skip spaces */
+		rn.c = getc(rn.r)
+		if rn.c < 0 || !matchClass(byte(rn.c), 's') {
+			break
+		}
+	}
+	rn.test2("-+") /* optional signal */
+	if rn.test2("00") {
+		if rn.test2("xX") {
+			hex = true /* numeral is hexadecimal */
+		} else {
+			count = 1 /* count initial '0' as a valid digit */
+		}
+	}
+	count += rn.readDigits(hex) /* integral part */
+	if rn.test2("..") {         /* decimal point? */
+		count += rn.readDigits(hex) /* fractional part */
+	}
+	expo := "eE"
+	if hex {
+		expo = "pP"
+	}
+	if count > 0 && rn.test2(expo) { /* exponent mark? */
+		rn.test2("-+")       /* exponent signal */
+		rn.readDigits(false) /* exponent digits */
+	}
+	if rn.c >= 0 {
+		rn.r.UnreadByte() /* unread look-ahead char */
+	}
+	if ls.StringToNumber(string(rn.buff)) {
+		return true /* ok */
+	}
+	/* invalid format */
+	ls.PushNil() /* "result" to be removed */
+	return false /* read fails */
+}
+
+// lua-5.3.4/src/liolib.c#test_eof()
+func testEOF(ls api.LuaState, p *luaStream) bool {
+	_, err := p.reader().Peek(1)
+	ls.PushString("")
+	return err == nil
+}
+
+// lua-5.3.4/src/liolib.c#read_line()
+func readLine(ls api.LuaState, p *luaStream, chop bool) bool {
+	line, err := p.reader().ReadString('\n')
+	if err != nil && err != io.EOF {
+		p.err = err
+	}
+	ok := err == nil || len(line) > 0 /* read a newline or something */
+	if chop && err == nil {
+		line = line[:len(line)-1] /* remove '\n' */
+	}
+	ls.PushString(line)
+	return ok
+}
+
+// lua-5.3.4/src/liolib.c#read_all()
+func readAll(ls api.LuaState, p *luaStream) {
+	data, err := io.ReadAll(p.reader())
+	if err != nil {
+		p.err = err
+	}
+	ls.PushString(string(data))
+}
+
+// lua-5.3.4/src/liolib.c#read_chars()
+func readChars(ls api.LuaState, p *luaStream, n int64) bool {
+	var b strings.Builder /* grows with the data actually read, not with 'n' */
+	nr, err := io.CopyN(&b, p.reader(), n)
+	if err != nil && err != io.EOF {
+		p.err = err
+	}
+	ls.PushString(b.String())
+	return nr > 0 /* true iff read something */
+}
+
+// lua-5.3.4/src/liolib.c#g_read()
+func gRead(ls api.LuaState, p *luaStream, first int) int {
+	nArgs := ls.GetTop() - 1
+	var n int
+	success := true
+	p.err = nil     // clearerr
+	if nArgs == 0 { /* no arguments? */
+		success = readLine(ls, p, true)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results and for auxlib's buffer */
+		ls.CheckStack2(nArgs+api.LUA_MINSTACK, "too many arguments")
+		for n = first; nArgs > 0 && success; n++ {
+			nArgs--
+			if ls.Type(n) == api.LUA_TNUMBER {
+				l := ls.CheckInteger(n)
+				if l == 0 {
+					success = testEOF(ls, p)
+				} else {
+					success = readChars(ls, p, l)
+				}
+			} else {
+				format := ls.CheckString(n)
+				if format != "" && format[0] == '*' {
+					format = format[1:] /* skip optional '*' (for compatibility) */
+				}
+				switch {
+				case strings.HasPrefix(format, "n"): /* number */
+					success = readNumber(ls, p)
+				case strings.HasPrefix(format, "l"): /* line */
+					success = readLine(ls, p, true)
+				case strings.HasPrefix(format, "L"): /* line with end-of-line */
+					success = readLine(ls, p, false)
+				case strings.HasPrefix(format, "a"): /* file */
+					readAll(ls, p) /* read entire file */
+					success = true /* always success */
+				default:
+					return ls.ArgError(n, "invalid format")
+				}
+			}
+		}
+	}
+	checkContext(ls) /* the read may have blocked */
+	if p.err != nil {
+		return ls.FileResult(p.err, "")
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.read
+func ioRead(ls api.LuaState) int {
+	return gRead(ls, getIOFile(ls, IO_INPUT), 1)
+}
+
+// file:read (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:read
+func fRead(ls api.LuaState) int {
+	return gRead(ls, toFile(ls), 2)
+}
+
+// lua-5.3.4/src/liolib.c#io_readline()
+func ioReadline(ls api.LuaState) int {
+	p := ls.ToUserdata(api.LuaUpvalueIndex(1)).(*luaStream)
+	n := int(ls.ToInteger(api.LuaUpvalueIndex(2)))
+	if p.isClosed() { /* file is already closed? */
+		return ls.Error2("file is already closed")
+	}
+	ls.SetTop(1)
+	ls.CheckStack2(n, "too many arguments")
+	for i := 1; i <= n; i++ { /* push arguments to 'g_read' */
+		ls.PushValue(api.LuaUpvalueIndex(3 + i))
+	}
+	n = gRead(ls, p, 2)   /* 'n' is number of results */
+	if ls.ToBoolean(-n) { /* read at least one value? */
+		return n /* return them */
+	}
+	/* first result is nil: EOF or error */
+	if n > 1 { /* is there error information? */
+		/* 2nd result is error message */
+		return ls.Error2("%s", ls.ToString(-n+1))
+	}
+	if ls.ToBoolean(api.LuaUpvalueIndex(3)) { /* generator created file? */
+		ls.SetTop(0)
+		ls.PushValue(api.LuaUpvalueIndex(1))
+		auxClose(ls) /* close it */
+	}
+	return 0
+}
+
+/*
+** {======================================================
+** WRITE
+** =======================================================
+ */
+
+// lua-5.3.4/src/liolib.c#g_write()
+func gWrite(ls api.LuaState, p *luaStream, arg int) int {
+	nArgs := ls.GetTop() - arg
+	var err error
+	for ; nArgs > 0; nArgs-- {
+		var s string
+		if ls.Type(arg) == api.LUA_TNUMBER {
+			/* optimization: could be done exactly as for strings */
+			if ls.IsInteger(arg) {
+				s = fmt.Sprintf(LUA_INTEGER_FMT, ls.ToInteger(arg))
+			} else {
+				s = fmt.Sprintf(LUA_NUMBER_FMT, ls.ToNumber(arg))
+			}
+		} else {
+			s = ls.CheckString(arg)
+		}
+		if err == nil {
+			err = p.write(s)
+		}
+		arg++
+	}
+	if err == nil {
+		return 1 /* file handle already on stack top */
+	}
+	return ls.FileResult(err, "")
+}
+
+// io.write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.write
+func ioWrite(ls api.LuaState) int {
+	return gWrite(ls, getIOFile(ls, IO_OUTPUT), 1)
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:write
+func fWrite(ls api.LuaState) int {
+	p := toFile(ls)
+	ls.PushValue(1) /* push file at the stack top (to be returned) */
+	return gWrite(ls, p, 2)
+}
+
+// file:seek ([whence [, offset]])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:seek
+// lua-5.3.4/src/liolib.c#f_seek()
+func fSeek(ls api.LuaState) int {
+	mode := []int{io.SeekStart, io.SeekCurrent, io.SeekEnd}
+	modeNames := []string{"set", "cur", "end"}
+	p := toFile(ls)
+	op := ls.CheckOption(2, "cur", modeNames)
+	offset := ls.OptInteger(3, 0)
+	pos, err := p.seek(offset, mode[op])
+	if err != nil {
+		return ls.FileResult(err, "") /* error */
+	}
+	ls.PushInteger(pos)
+	return 1
+}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:setvbuf
+// lua-5.3.4/src/liolib.c#f_setvbuf()
+func fSetvbuf(ls api.LuaState) int {
+	mode := []int{_IONBF, _IOFBF, _IOLBF}
+	modeNames := []string{"no", "full", "line"}
+	p := toFile(ls)
+	op := ls.CheckOption(2, "", modeNames)
+	size := ls.OptInteger(3, LUAL_BUFFERSIZE)
+	return ls.FileResult(p.setvbuf(mode[op], int(size)), "")
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-io.flush
+func ioFlush(ls api.LuaState) int {
+	return ls.FileResult(getIOFile(ls, IO_OUTPUT).flush(), "")
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.3/manual.html#pdf-file:flush
+func fFlush(ls api.LuaState) int {
+	return ls.FileResult(toFile(ls).flush(), "")
+}
+
+/*
+** function to (not) close the standard files stdin, stdout, and stderr
+ */
+// lua-5.3.4/src/liolib.c#io_noclose()
+func ioNoClose(ls api.LuaState) int {
+	p := toStream(ls, 1)
+	p.closef = ioNoClose /* keep file opened */
+	ls.PushNil()
+	ls.PushString("cannot close standard file")
+	return 2
+}
@@ -6,6 +6,7 @@ package stdlib
 import (
 	"lua_go/api"
 	"os"
+	"os/exec"
 	"time"
 )
 
@@ -177,27 +178,39 @@ func osGetEnv(ls api.LuaState) int {
 
 // os.execute ([command])
 // http://www.lua.org/manual/5.3/manual.html#pdf-os.execute
+// lua-5.3.4/src/loslib.c#os_execute()
 func osExecute(ls api.LuaState) int {
-	panic("todo: osExecute!")
+	if ls.IsNoneOrNil(1) { /* no command: is there a shell? */
+		cmd := shellCommand(ls, "")
+		_, err := exec.LookPath(cmd.Path)
+		ls.PushBoolean(err == nil)
+		return 1
+	}
+	cmd := shellCommand(ls, ls.CheckString(1))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err := cmd.Run()
+	checkContext(ls)
+	return ls.ExecResult(err)
 }
 
 // os.exit ([code [, close]])
 // http://www.lua.org/manual/5.3/manual.html#pdf-os.exit
 // lua-5.3.4/src/loslib.c#os_exit()
 func osExit(ls api.LuaState) int {
+	var status int
 	if ls.IsBoolean(1) {
 		if ls.ToBoolean(1) {
-			os.Exit(0)
+			status = 0 // EXIT_SUCCESS
 		} else {
-			os.Exit(1) // todo
+			status = 1 // EXIT_FAILURE
 		}
 	} else {
-		code := ls.OptInteger(1, 1)
-		os.Exit(int(code))
+		status = int(ls.OptInteger(1, 0))
+	}
+	if ls.ToBoolean(2) {
+		ls.Close()
 	}
-	// if ls.ToBoolean(2) {
-	//ls.Close()
-	// }
+	os.Exit(status)
 	return 0
 }
 
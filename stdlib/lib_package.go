@@ -20,6 +20,8 @@ const (
 	LUA_IGMARK    = "-"
 )
 
+const LUA_PATH_DEFAULT = "./?.lua;./?/init.lua"
+
 var pkgFuncs = map[string]api.GoFunction{
 	"searchpath": pkgSearchPath,
 	/* placeholders */
@@ -38,8 +40,7 @@ func OpenPackageLib(ls api.LuaState) int {
 	ls.NewLib(pkgFuncs) /* create 'package' table */
 	createSearchersTable(ls)
 	/* set paths */
-	ls.PushString("./?.lua;./?/init.lua")
-	ls.SetField(-2, "path")
+	setPath(ls, "path", "LUA_PATH", LUA_PATH_DEFAULT)
 	/* store config information */
 	ls.PushString(LUA_DIRSEP + "\n" + LUA_PATH_SEP + "\n" +
 		LUA_PATH_MARK + "\n" + LUA_EXEC_DIR + "\n" + LUA_IGMARK + "\n")
@@ -57,6 +58,31 @@ func OpenPackageLib(ls api.LuaState) int {
 	return 1                /* return 'package' table */
 }
 
+// 优先使用环境变量中的路径, ";;"会被替换成默认路径
+// lua-5.3.4/src/loadlib.c#setpath()
+func setPath(ls api.LuaState, fieldName, envName, dft string) {
+	path, ok := os.LookupEnv(envName + "_5_3") /* use versioned name */
+	if !ok {
+		path, ok = os.LookupEnv(envName) /* try unversioned name */
+	}
+	if !ok || noEnv(ls) { /* no environment variable? */
+		path = dft /* use default */
+	} else {
+		sep := LUA_PATH_SEP
+		path = strings.Replace(path, sep+sep, sep+dft+sep, -1)
+	}
+	ls.PushString(path)
+	ls.SetField(-2, fieldName) /* package[fieldname] = path value */
+}
+
+/* check whether the host asked to ignore environment variables (lua -E) */
+func noEnv(ls api.LuaState) bool {
+	ls.GetField(api.LUA_REGISTRYINDEX, "LUA_NOENV")
+	b := ls.ToBoolean(-1)
+	ls.Pop(1) /* remove value */
+	return b
+}
+
 func createSearchersTable(ls api.LuaState) {
 	searchers := []api.GoFunction{
 		preloadSearcher,
@@ -73,9 +99,14 @@ func createSearchersTable(ls api.LuaState) {
 	ls.SetField(-2, "searchers") /* put it in field 'searchers' */
 }
 
+// 沙箱的搜索器用第二个上值保存沙箱自己的preload表
 func preloadSearcher(ls api.LuaState) int {
 	name := ls.CheckString(1)
-	ls.GetField(api.LUA_REGISTRYINDEX, "_PRELOAD")
+	if ls.Type(api.LuaUpvalueIndex(2)) == api.LUA_TTABLE {
+		ls.PushValue(api.LuaUpvalueIndex(2))
+	} else {
+		ls.GetField(api.LUA_REGISTRYINDEX, "_PRELOAD")
+	}
 	if ls.GetField(-1, name) == api.LUA_TNIL { /* not found? */
 		ls.PushString("\n\tno field package.preload['" + name + "']")
 	}
@@ -139,12 +170,32 @@ func _searchPath(name, path, sep, dirSep string) (filename, errMsg string) {
 	return "", errMsg
 }
 
+// 在LibOptions.Modules给出的源代码中查找模块, 模块在沙箱的环境中运行
+func moduleSearcher(ls api.LuaState) int {
+	name := ls.CheckString(1)
+	if ls.GetField(api.LuaUpvalueIndex(1), name) != api.LUA_TSTRING {
+		ls.PushString("\n\tno module '" + name + "' in the allowed modules")
+		return 1
+	}
+	if ls.Load([]byte(ls.ToString(-1)), "="+name, "t") != api.LUA_OK {
+		return ls.Error2("error loading module '%s':\n\t%s", name, ls.ToString(-1))
+	}
+	ls.PushValue(api.LuaUpvalueIndex(2)) /* environment of the module */
+	ls.SetUpvalue(-2, 1)
+	return 1
+}
+
 // require (modname)
 // http://www.lua.org/manual/5.3/manual.html#pdf-require
+// 沙箱的require用第二个上值保存沙箱自己的已加载模块表
 func pkgRequire(ls api.LuaState) int {
 	name := ls.CheckString(1)
 	ls.SetTop(1) /* LOADED table will be at index 2 */
-	ls.GetField(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	if ls.Type(api.LuaUpvalueIndex(2)) == api.LUA_TTABLE {
+		ls.PushValue(api.LuaUpvalueIndex(2))
+	} else {
+		ls.GetField(api.LUA_REGISTRYINDEX, LUA_LOADED_TABLE)
+	}
 	ls.GetField(2, name)  /* LOADED[name] */
 	if ls.ToBoolean(-1) { /* is it there? */
 		return 1 /* package is already loaded */
@@ -66,12 +66,25 @@ func strRep(ls api.LuaState) int {
 		ls.PushString("")
 	} else if n == 1 {
 		ls.PushString(s)
+	} else if l, lsep := int64(len(s)), int64(len(sep)); l+lsep < l || l+lsep > MAXSIZE/n {
+		return ls.Error2("resulting string too large")
 	} else {
-		a := make([]string, n)
-		for i := 0; i < int(n); i++ {
-			a[i] = s
+		totalLen := l*n + lsep*(n-1)
+		ls.CheckMemory(totalLen) /* may raise a memory error */
+		if totalLen == 0 {
+			ls.PushString("")
+		} else if lsep == 0 {
+			ls.PushString(strings.Repeat(s, int(n)))
+		} else {
+			var b strings.Builder
+			b.Grow(int(totalLen))
+			for ; n > 1; n-- { /* first n-1 copies (followed by separator) */
+				b.WriteString(s)
+				b.WriteString(sep)
+			}
+			b.WriteString(s) /* last copy (not followed by separator) */
+			ls.PushString(b.String())
 		}
-		ls.PushString(strings.Join(a, sep))
 	}
 
 	return 1
@@ -190,38 +203,144 @@ func strChar(ls api.LuaState) int {
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.dump
 // lua-5.3.4/src/lstrlib.c#str_dump()
 func strDump(ls api.LuaState) int {
-	// strip := ls.ToBoolean(2)
-	// ls.CheckType(1, LUA_TFUNCTION)
-	// ls.SetTop(1)
-	// ls.PushString(string(ls.Dump(strip)))
-	// return 1
-	panic("todo: strDump!")
+	strip := ls.ToBoolean(2)
+	ls.CheckType(1, api.LUA_TFUNCTION)
+	ls.SetTop(1)
+	chunk := ls.Dump(strip)
+	if chunk == nil {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(string(chunk))
+	return 1
 }
 
 /* PACK/UNPACK */
 
+// string.pack (fmt, v1, v2, ···)
+// http://www.lua.org/manual/5.3/manual.html#pdf-string.pack
+// lua-5.3.4/src/lstrlib.c#str_pack()
+func strPack(ls api.LuaState) int {
+	h := newPackHeader(ls, ls.CheckString(1))
+	var b strings.Builder
+	arg := 1       /* current argument to pack */
+	totalSize := 0 /* accumulate total size of result */
+	for h.fmt != "" {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		totalSize += nToAlign + size
+		for ; nToAlign > 0; nToAlign-- {
+			b.WriteByte(LUAL_PACKPADBYTE) /* fill alignment */
+		}
+		arg++
+		switch opt {
+		case kInt: /* signed integers */
+			n := ls.CheckInteger(arg)
+			if size < SZINT { /* need overflow check? */
+				lim := int64(1) << (size*NB - 1)
+				ls.ArgCheck(-lim <= n && n < lim, arg, "integer overflow")
+			}
+			packInt(&b, uint64(n), h.isLittle, size, n < 0)
+		case kUint: /* unsigned integers */
+			n := ls.CheckInteger(arg)
+			if size < SZINT { /* need overflow check? */
+				ls.ArgCheck(uint64(n) < uint64(1)<<(size*NB), arg, "unsigned overflow")
+			}
+			packInt(&b, uint64(n), h.isLittle, size, false)
+		case kFloat: /* floating-point options */
+			packFloat(&b, ls.CheckNumber(arg), h.isLittle, size)
+		case kChar: /* fixed-size string */
+			s := ls.CheckString(arg)
+			ls.ArgCheck(len(s) <= size, arg, "string longer than given size")
+			b.WriteString(s)                 /* add string */
+			for i := len(s); i < size; i++ { /* pad extra space */
+				b.WriteByte(LUAL_PACKPADBYTE)
+			}
+		case kString: /* strings with length count */
+			s := ls.CheckString(arg)
+			ls.ArgCheck(size >= 8 /* sizeof(size_t) */ ||
+				uint64(len(s)) < uint64(1)<<(size*NB),
+				arg, "string length does not fit in given size")
+			packInt(&b, uint64(len(s)), h.isLittle, size, false) /* pack length */
+			b.WriteString(s)
+			totalSize += len(s)
+		case kZstr: /* zero-terminated string */
+			s := ls.CheckString(arg)
+			ls.ArgCheck(strings.IndexByte(s, 0) < 0, arg, "string contains zeros")
+			b.WriteString(s)
+			b.WriteByte(0) /* add zero at the end */
+			totalSize += len(s) + 1
+		case kPadding:
+			b.WriteByte(LUAL_PACKPADBYTE)
+			arg-- /* undo increment */
+		case kPaddAlign, kNop:
+			arg-- /* undo increment */
+		}
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
 // string.packsize (fmt)
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.packsize
+// lua-5.3.4/src/lstrlib.c#str_packsize()
 func strPackSize(ls api.LuaState) int {
-	fmt := ls.CheckString(1)
-	if fmt == "j" {
-		ls.PushInteger(8) // todo
-	} else {
-		panic("todo: strPackSize!")
+	h := newPackHeader(ls, ls.CheckString(1))
+	totalSize := 0 /* accumulate total size of result */
+	for h.fmt != "" {
+		opt, size, nToAlign := h.getDetails(totalSize)
+		size += nToAlign /* total space used by option */
+		ls.ArgCheck(totalSize <= MAXSIZE-size, 1, "format result too large")
+		totalSize += size
+		if opt == kString || opt == kZstr {
+			ls.ArgError(1, "variable-length format")
+		}
 	}
+	ls.PushInteger(int64(totalSize))
 	return 1
 }
 
-// string.pack (fmt, v1, v2, ···)
-// http://www.lua.org/manual/5.3/manual.html#pdf-string.pack
-func strPack(ls api.LuaState) int {
-	panic("todo: strPack!")
-}
-
 // string.unpack (fmt, s [, pos])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.unpack
+// lua-5.3.4/src/lstrlib.c#str_unpack()
 func strUnpack(ls api.LuaState) int {
-	panic("todo: strUnpack!")
+	h := newPackHeader(ls, ls.CheckString(1))
+	data := ls.CheckString(2)
+	ld := len(data)
+	pos := posRelat(ls.OptInteger(3, 1), ld) - 1
+	n := 0 /* number of results */
+	ls.ArgCheck(pos >= 0 && pos <= ld, 3, "initial position out of string")
+	for h.fmt != "" {
+		opt, size, nToAlign := h.getDetails(pos)
+		if nToAlign+size > ld-pos {
+			ls.ArgError(2, "data string too short")
+		}
+		pos += nToAlign /* skip alignment */
+		ls.CheckStack2(2, "too many results")
+		n++
+		switch opt {
+		case kInt, kUint:
+			res := unpackInt(ls, data[pos:], h.isLittle, size, opt == kInt)
+			ls.PushInteger(res)
+		case kFloat:
+			ls.PushNumber(unpackFloat(data[pos:], h.isLittle, size))
+		case kChar:
+			ls.PushString(data[pos : pos+size])
+		case kString:
+			l := uint64(unpackInt(ls, data[pos:], h.isLittle, size, false))
+			ls.ArgCheck(l <= uint64(ld-pos-size), 2, "data string too short")
+			ls.PushString(data[pos+size : pos+size+int(l)])
+			pos += int(l) /* skip string */
+		case kZstr:
+			l := strings.IndexByte(data[pos:], 0)
+			ls.ArgCheck(l >= 0, 2, "unfinished string for format 'z'")
+			ls.PushString(data[pos : pos+l])
+			pos += l + 1 /* skip string plus final '\0' */
+		case kPaddAlign, kPadding, kNop:
+			n-- /* undo increment */
+		}
+		pos += size
+	}
+	ls.PushInteger(int64(pos + 1)) /* next position */
+	return n + 1
 }
 
 /* STRING FORMAT */
@@ -279,33 +398,20 @@ func _fmtArg(tag string, ls api.LuaState, argIdx int) string {
 
 // string.find (s, pattern [, init [, plain]])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.find
+// lua-5.3.4/src/lstrlib.c#str_find()
 func strFind(ls api.LuaState) int {
-	s := ls.CheckString(1)
-	sLen := len(s)
-	pattern := ls.CheckString(2)
-	init := posRelat(ls.OptInteger(3, 1), sLen)
-	if init < 1 {
-		init = 1
-	} else if init > sLen+1 { /* start after string's end? */
-		ls.PushNil()
-		return 1
-	}
-	plain := ls.ToBoolean(4)
-
-	start, end := find(s, pattern, init, plain)
-
-	if start < 0 {
-		ls.PushNil()
-		return 1
-	}
-	ls.PushInteger(int64(start))
-	ls.PushInteger(int64(end))
-	return 2
+	return strFindAux(ls, true)
 }
 
 // string.match (s, pattern [, init])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.match
+// lua-5.3.4/src/lstrlib.c#str_match()
 func strMatch(ls api.LuaState) int {
+	return strFindAux(ls, false)
+}
+
+// lua-5.3.4/src/lstrlib.c#str_find_aux()
+func strFindAux(ls api.LuaState, find bool) int {
 	s := ls.CheckString(1)
 	sLen := len(s)
 	pattern := ls.CheckString(2)
@@ -313,56 +419,110 @@ func strMatch(ls api.LuaState) int {
 	if init < 1 {
 		init = 1
 	} else if init > sLen+1 { /* start after string's end? */
-		ls.PushNil()
+		ls.PushNil() /* cannot find anything */
 		return 1
 	}
 
-	captures := match(s, pattern, init)
-
-	if captures == nil {
-		ls.PushNil()
-		return 1
+	/* explicit request or no special characters? */
+	if find && (ls.ToBoolean(4) || noSpecials(pattern)) {
+		/* do a plain search */
+		if idx := strings.Index(s[init-1:], pattern); idx >= 0 {
+			ls.PushInteger(int64(init + idx))
+			ls.PushInteger(int64(init + idx + len(pattern) - 1))
+			return 2
+		}
 	} else {
-		for i := 0; i < len(captures); i += 2 {
-			capture := s[captures[i]:captures[i+1]]
-			ls.PushString(capture)
+		s1 := init - 1
+		anchor := pattern != "" && pattern[0] == '^'
+		if anchor {
+			pattern = pattern[1:] /* skip anchor character */
+		}
+		ms := newMatchState(ls, s, pattern)
+		for {
+			ms.reprep()
+			if e := ms.doMatch(s1, 0); e != -1 {
+				if find {
+					ls.PushInteger(int64(s1 + 1)) /* start */
+					ls.PushInteger(int64(e))      /* end */
+					return ms.pushCaptures(-1, 0) + 2
+				}
+				return ms.pushCaptures(s1, e)
+			}
+			s1++
+			if s1 > sLen || anchor {
+				break
+			}
 		}
-		return len(captures) / 2
 	}
+	ls.PushNil() /* not found */
+	return 1
 }
 
 // string.gsub (s, pattern, repl [, n])
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.gsub
+// lua-5.3.4/src/lstrlib.c#str_gsub()
 func strGsub(ls api.LuaState) int {
-	s := ls.CheckString(1)
+	src := ls.CheckString(1)
 	pattern := ls.CheckString(2)
-	repl := ls.CheckString(3) // todo
-	n := int(ls.OptInteger(4, -1))
+	lastMatch := -1 /* end of last match */
+	tr := ls.Type(3)
+	maxS := ls.OptInteger(4, int64(len(src)+1)) /* max replacements */
+	anchor := pattern != "" && pattern[0] == '^'
+	n := int64(0) /* replacement count */
+	ls.ArgCheck(tr == api.LUA_TNUMBER || tr == api.LUA_TSTRING ||
+		tr == api.LUA_TFUNCTION || tr == api.LUA_TTABLE, 3,
+		"string/function/table expected")
+	if anchor {
+		pattern = pattern[1:] /* skip anchor character */
+	}
 
-	newStr, nMatches := gsub(s, pattern, repl, n)
-	ls.PushString(newStr)
-	ls.PushInteger(int64(nMatches))
+	var b strings.Builder
+	ms := newMatchState(ls, src, pattern)
+	s := 0
+	for n < maxS {
+		ms.reprep() /* (re)prepare state for new match */
+		e := ms.doMatch(s, 0)
+		if e != -1 && e != lastMatch { /* match? */
+			n++
+			ms.addValue(&b, s, e, tr) /* add replacement to buffer */
+			s, lastMatch = e, e
+		} else if s < len(src) { /* otherwise, skip one character */
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break /* end of subject */
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	ls.PushString(b.String())
+	ls.PushInteger(n) /* number of substitutions */
 	return 2
 }
 
 // string.gmatch (s, pattern)
 // http://www.lua.org/manual/5.3/manual.html#pdf-string.gmatch
+// lua-5.3.4/src/lstrlib.c#gmatch()
 func strGmatch(ls api.LuaState) int {
 	s := ls.CheckString(1)
 	pattern := ls.CheckString(2)
+	ms := newMatchState(ls, s, pattern)
+	src, lastMatch := 0, -1
 
+	// lua-5.3.4/src/lstrlib.c#gmatch_aux()
 	gmatchAux := func(ls api.LuaState) int {
-		captures := match(s, pattern, 1)
-		if captures != nil {
-			for i := 0; i < len(captures); i += 2 {
-				capture := s[captures[i]:captures[i+1]]
-				ls.PushString(capture)
+		ms.ls = ls
+		for ; src <= len(s); src++ {
+			ms.reprep()
+			if e := ms.doMatch(src, 0); e != -1 && e != lastMatch {
+				start := src
+				src, lastMatch = e, e
+				return ms.pushCaptures(start, e)
 			}
-			s = s[captures[len(captures)-1]:]
-			return len(captures) / 2
-		} else {
-			return 0
 		}
+		return 0 /* not found */
 	}
 
 	ls.PushGoFunction(gmatchAux)
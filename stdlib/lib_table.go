@@ -126,21 +126,33 @@ func tabConcat(ls api.LuaState) int {
 		return 1
 	}
 
-	buf := make([]string, j-i+1)
-	for k := i; k > 0 && k <= j; k++ {
-		ls.GetI(1, k)
-		if !ls.IsString(-1) {
-			ls.Error2("invalid value (%s) at index %d in table for 'concat'",
-				ls.TypeName2(-1), i)
-		}
-		buf[k-i] = ls.ToString(-1)
-		ls.Pop(1)
+	var b strings.Builder
+	for ; i < j; i++ {
+		_addField(ls, &b, i)
+		ls.CheckMemory(int64(b.Len() + len(sep))) /* may raise a memory error */
+		b.WriteString(sep)
+	}
+	if i == j { /* add last value (if interval was not empty) */
+		_addField(ls, &b, i)
 	}
-	ls.PushString(strings.Join(buf, sep))
+	ls.PushString(b.String())
 
 	return 1
 }
 
+// lua-5.3.4/src/ltablib.c#addfield()
+func _addField(ls api.LuaState, b *strings.Builder, i int64) {
+	ls.GetI(1, i)
+	if !ls.IsString(-1) {
+		ls.Error2("invalid value (%s) at index %d in table for 'concat'",
+			ls.TypeName2(-1), i)
+	}
+	s := ls.ToString(-1)
+	ls.CheckMemory(int64(b.Len() + len(s))) /* may raise a memory error */
+	b.WriteString(s)
+	ls.Pop(1)
+}
+
 func _auxGetN(ls api.LuaState, n, w int) int64 {
 	_checkTab(ls, n, w|TAB_L)
 	return ls.Len2(n)
@@ -0,0 +1,189 @@
+package stdlib
+
+import "lua_go/api"
+
+// 适合运行不受信任的代码的库和函数.
+// 不包括getmetatable, 因为通过getmetatable("")可以修改所有线程共享的字符串元表
+var SafeLibs = map[string][]string{
+	"_G": {"assert", "error", "ipairs", "load", "next", "pairs", "pcall", "print",
+		"rawequal", "rawget", "rawlen", "rawset", "select", "setmetatable",
+		"tonumber", "tostring", "type", "xpcall"},
+	"coroutine": nil,
+	"math":      nil,
+	"os":        {"clock", "date", "difftime", "time"},
+	"package":   {"require"},
+	"string":    nil,
+	"table":     nil,
+	"utf8":      nil,
+}
+
+// 按打开的顺序排列的库, 基础库和package库单独处理
+// lua-5.3.4/src/linit.c#loadedlibs
+var loadedLibs = []struct {
+	name string
+	open api.GoFunction
+}{
+	{"_G", nil},
+	{"package", nil},
+	{"coroutine", OpenCoroutineLib},
+	{"table", OpenTableLib},
+	{"io", OpenIOLib},
+	{"os", OpenOSLib},
+	{"string", OpenStringLib},
+	{"math", OpenMathLib},
+	{"utf8", OpenUTF8Lib},
+	{"debug", OpenDebugLib},
+}
+
+// 按opts把库打开到环境表中, 库同时登记在已加载模块表中.
+// 调用前栈顶依次是环境表和已加载模块表, 调用后它们被弹出.
+// sandbox为true时环境表属于一个沙箱: package.preload也是沙箱自己的,
+// require只能加载opts.Modules中的模块, 打开string库也不会改变字符串元表
+// [-2, +0, e]
+func OpenLibsInto(ls api.LuaState, opts api.LibOptions, sandbox bool) {
+	env, loaded := ls.AbsIndex(-2), ls.AbsIndex(-1)
+	for _, lib := range loadedLibs {
+		allowed, ok := opts.Libs[lib.name]
+		if opts.Libs != nil && !ok {
+			continue
+		}
+		switch {
+		case lib.name == "_G":
+			_openBaseLibInto(ls, env, opts.TextOnly, allowed)
+		case lib.name == "package":
+			_openPackageLibInto(ls, env, loaded, opts, sandbox)
+		case lib.name == "string" && sandbox:
+			_openStringLibInto(ls)
+		default:
+			ls.PushGoFunction(lib.open)
+			ls.PushString(lib.name)
+			ls.Call(1, 1)
+		}
+		if lib.name != "_G" {
+			_filterFuncs(ls, allowed)
+			ls.PushValue(-1)
+			ls.SetField(env, lib.name) /* env[name] = module */
+		}
+		ls.SetField(loaded, lib.name) /* loaded[name] = module */
+	}
+	ls.Pop(2)
+}
+
+// 基础库的函数用环境表和opts.TextOnly做上值, 让load等函数使用这个环境
+func _openBaseLibInto(ls api.LuaState, env int, textOnly bool, allowed []string) {
+	funcs := api.FuncReg{}
+	for name, f := range baseFuncs {
+		if f != nil && (allowed == nil || _contains(allowed, name)) {
+			funcs[name] = f
+		}
+	}
+	ls.PushValue(env)
+	ls.PushValue(env)
+	ls.PushBoolean(textOnly)
+	ls.SetFuncs(funcs, 2)
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G")
+	ls.PushString("Lua 5.3")
+	ls.SetField(-2, "_VERSION")
+}
+
+func _openPackageLibInto(ls api.LuaState, env, loaded int, opts api.LibOptions, sandbox bool) {
+	if !sandbox {
+		ls.PushGoFunction(OpenPackageLib)
+		ls.PushString("package")
+		ls.Call(1, 1)
+	} else {
+		ls.NewLib(pkgFuncs)
+		ls.PushString(LUA_DIRSEP + "\n" + LUA_PATH_SEP + "\n" +
+			LUA_PATH_MARK + "\n" + LUA_EXEC_DIR + "\n" + LUA_IGMARK + "\n")
+		ls.SetField(-2, "config")
+		ls.PushString("")
+		ls.SetField(-2, "path")
+		ls.PushValue(loaded)
+		ls.SetField(-2, "loaded")
+		ls.NewTable()
+		ls.SetField(-2, "preload")
+		ls.PushValue(-1) /* 'package' and the sandbox's LOADED as upvalues of 'require' */
+		ls.PushValue(loaded)
+		ls.PushGoClosure(pkgRequire, 2)
+		ls.SetField(env, "require")
+	}
+	if sandbox || opts.Modules != nil { /* replace the file searchers */
+		ls.CreateTable(2, 0)
+		ls.PushValue(-2)
+		if sandbox {
+			ls.GetField(-3, "preload")
+			ls.PushGoClosure(preloadSearcher, 2)
+		} else {
+			ls.PushGoClosure(preloadSearcher, 1)
+		}
+		ls.RawSetI(-2, 1)
+		ls.CreateTable(0, len(opts.Modules))
+		for name, source := range opts.Modules {
+			ls.PushString(source)
+			ls.SetField(-2, name)
+		}
+		ls.PushValue(env)
+		ls.PushGoClosure(moduleSearcher, 2)
+		ls.RawSetI(-2, 2)
+		ls.SetField(-2, "searchers")
+	}
+	if allowed := opts.Libs["package"]; allowed != nil && !_contains(allowed, "require") {
+		ls.PushNil()
+		ls.SetField(env, "require")
+	}
+}
+
+// 沙箱有自己的string库, 字符串元表仍然指向原来的string库;
+// 还没有字符串元表时另外打开一个只能通过字符串元表访问的string库
+func _openStringLibInto(ls api.LuaState) {
+	ls.PushString("")
+	hasMeta := ls.GetMetatable(-1)
+	ls.PushGoFunction(OpenStringLib)
+	ls.PushString("string")
+	ls.Call(1, 1)
+	if hasMeta { /* restore the metatable of strings */
+		ls.PushString("")
+		ls.PushValue(-3)
+		ls.SetMetatable(-2)
+		ls.Pop(1)
+		ls.Replace(-3) /* replace the dummy string */
+		ls.Pop(1)      /* pop metatable */
+	} else { /* give strings a private copy of the library */
+		ls.PushGoFunction(OpenStringLib)
+		ls.PushString("string")
+		ls.Call(1, 1)
+		ls.Pop(1)
+		ls.Replace(-2) /* replace the dummy string */
+	}
+}
+
+// 删除栈顶的库中不允许的函数, 其他字段保留
+func _filterFuncs(ls api.LuaState, allowed []string) {
+	if allowed == nil {
+		return
+	}
+	var names []string
+	ls.PushNil()
+	for ls.Next(-2) {
+		if ls.Type(-2) == api.LUA_TSTRING && ls.IsFunction(-1) {
+			if name := ls.ToString(-2); !_contains(allowed, name) {
+				names = append(names, name)
+			}
+		}
+		ls.Pop(1)
+	}
+	for _, name := range names {
+		ls.PushNil()
+		ls.SetField(-2, name)
+	}
+}
+
+func _contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
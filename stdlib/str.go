@@ -1,8 +1,11 @@
 package stdlib
 
 import (
+	"lua_go/api"
+	"math"
 	"regexp"
 	"strings"
+	"unsafe"
 )
 
 // tag = %[flags][width][.precision]specifier
@@ -38,80 +41,723 @@ func parseFmtStr(fmt string) []string {
 	return parsed
 }
 
-func find(s, pattern string, init int, plain bool) (start, end int) {
-	tail := s
-	if init > 1 {
-		tail = s[init-1:]
+/* PATTERN MATCHING */
+// lua-5.3.4/src/lstrlib.c
+
+const (
+	LUA_MAXCAPTURES = 32
+	CAP_UNFINISHED  = -1
+	CAP_POSITION    = -2
+	MAXCCALLS       = 200 /* maximum recursion depth for 'match' */
+	L_ESC           = '%'
+	SPECIALS        = "^$*+?.([%-"
+)
+
+type capture struct {
+	init int // 在src中的起始位置
+	len  int // 长度, 或者CAP_UNFINISHED, CAP_POSITION
+}
+
+// src和pat中的位置都用下标表示, -1相当于C实现中的NULL
+type matchState struct {
+	matchdepth int    /* control for recursive depth (to avoid C stack overflow) */
+	src        string /* the whole subject */
+	pat        string /* pattern */
+	ls         api.LuaState
+	level      int /* total number of captures (finished or unfinished) */
+	capture    [LUA_MAXCAPTURES]capture
+}
+
+// lua-5.3.4/src/lstrlib.c#prepstate()
+func newMatchState(ls api.LuaState, src, pat string) *matchState {
+	return &matchState{ls: ls, src: src, pat: pat, matchdepth: MAXCCALLS}
+}
+
+// lua-5.3.4/src/lstrlib.c#reprepstate()
+func (ms *matchState) reprep() {
+	ms.level = 0
+	ms.matchdepth = MAXCCALLS
+}
+
+/* 越界时返回0, 和C实现中读到字符串末尾的'\0'一致 */
+func (ms *matchState) srcAt(s int) byte {
+	if s < len(ms.src) {
+		return ms.src[s]
 	}
+	return 0
+}
 
-	if plain {
-		start = strings.Index(tail, pattern)
-		end = start + len(pattern) - 1
-	} else {
-		re, err := _compile(pattern)
-		if err != "" {
-			panic(err) // todo
+func (ms *matchState) patAt(p int) byte {
+	if p < len(ms.pat) {
+		return ms.pat[p]
+	}
+	return 0
+}
+
+// lua-5.3.4/src/lstrlib.c#check_capture()
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == CAP_UNFINISHED {
+		return ms.ls.Error2("invalid capture index %%%d", i+1)
+	}
+	return i
+}
+
+// lua-5.3.4/src/lstrlib.c#capture_to_close()
+func (ms *matchState) captureToClose() int {
+	for level := ms.level - 1; level >= 0; level-- {
+		if ms.capture[level].len == CAP_UNFINISHED {
+			return level
+		}
+	}
+	return ms.ls.Error2("invalid pattern capture")
+}
+
+// lua-5.3.4/src/lstrlib.c#classEnd()
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat[p]
+	p++
+	switch c {
+	case L_ESC:
+		if p == len(ms.pat) {
+			ms.ls.Error2("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if ms.patAt(p) == '^' {
+			p++
+		}
+		for { /* look for a ']' */
+			if p == len(ms.pat) {
+				ms.ls.Error2("malformed pattern (missing ']')")
+			}
+			c := ms.pat[p]
+			p++
+			if c == L_ESC && p < len(ms.pat) {
+				p++ /* skip escapes (e.g. '%]') */
+			}
+			if ms.patAt(p) == ']' {
+				break
+			}
+		}
+		return p + 1
+	default:
+		return p
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#match_class()
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch cl | 0x20 { // tolower
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = c < 0x20 || c == 0x7F
+	case 'd':
+		res = isDigit(c)
+	case 'g':
+		res = c > 0x20 && c < 0x7F
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 0x20 && c < 0x7F && !isAlpha(c) && !isDigit(c)
+	case 's':
+		res = c == ' ' || c >= '\t' && c <= '\r'
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+func isAlpha(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// p指向'[', ec指向']'
+// lua-5.3.4/src/lstrlib.c#matchbracketclass()
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat[p+1] == '^' {
+		sig = false
+		p++ /* skip the '^' */
+	}
+	for p++; p < ec; p++ {
+		if ms.pat[p] == L_ESC {
+			p++
+			if matchClass(c, ms.pat[p]) {
+				return sig
+			}
+		} else if ms.pat[p+1] == '-' && p+2 < ec {
+			p += 2
+			if ms.pat[p-2] <= c && c <= ms.pat[p] {
+				return sig
+			}
+		} else if ms.pat[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+// lua-5.3.4/src/lstrlib.c#singlematch()
+func (ms *matchState) singleMatch(s, p, ep int) bool {
+	if s >= len(ms.src) {
+		return false
+	}
+	c := ms.src[s]
+	switch ms.pat[p] {
+	case '.':
+		return true /* matches any char */
+	case L_ESC:
+		return matchClass(c, ms.pat[p+1])
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pat[p] == c
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#matchbalance()
+func (ms *matchState) matchBalance(s, p int) int {
+	if p >= len(ms.pat)-1 {
+		ms.ls.Error2("malformed pattern (missing arguments to '%%b')")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pat[p] {
+		return -1
+	}
+	b, e := ms.pat[p], ms.pat[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1 /* string ends out of balance */
+}
+
+// lua-5.3.4/src/lstrlib.c#max_expand()
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 /* counts maximum expand for item */
+	for ms.singleMatch(s+i, p, ep) {
+		i++
+	}
+	/* keeps trying to match with the maximum repetitions */
+	for ; i >= 0; i-- {
+		if res := ms.doMatch(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+// lua-5.3.4/src/lstrlib.c#min_expand()
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.doMatch(s, ep+1); res != -1 {
+			return res
+		} else if ms.singleMatch(s, p, ep) {
+			s++ /* try with one more repetition */
 		} else {
-			loc := re.FindStringIndex(tail)
-			if loc == nil {
-				start, end = -1, -1
+			return -1
+		}
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#start_capture()
+func (ms *matchState) startCapture(s, p, what int) int {
+	level := ms.level
+	if level >= LUA_MAXCAPTURES {
+		ms.ls.Error2("too many captures")
+	}
+	ms.capture[level].init = s
+	ms.capture[level].len = what
+	ms.level = level + 1
+	res := ms.doMatch(s, p)
+	if res == -1 { /* match failed? */
+		ms.level-- /* undo capture */
+	}
+	return res
+}
+
+// lua-5.3.4/src/lstrlib.c#end_capture()
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init /* close capture */
+	res := ms.doMatch(s, p)
+	if res == -1 { /* match failed? */
+		ms.capture[l].len = CAP_UNFINISHED /* undo capture */
+	}
+	return res
+}
+
+// lua-5.3.4/src/lstrlib.c#match_capture()
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	str := ms.src[ms.capture[i].init : ms.capture[i].init+ms.capture[i].len]
+	if strings.HasPrefix(ms.src[s:], str) {
+		return s + len(str)
+	}
+	return -1
+}
+
+// 从src[s:]开始匹配pat[p:], 返回匹配结束的位置, 不匹配时返回-1
+// lua-5.3.4/src/lstrlib.c#match()
+func (ms *matchState) doMatch(s, p int) int {
+	if ms.matchdepth == 0 {
+		ms.ls.Error2("pattern too complex")
+	}
+	ms.matchdepth--
+
+	for p != len(ms.pat) { /* end of pattern? */
+		cont := false // 相当于C实现中的goto init
+		switch ms.pat[p] {
+		case '(': /* start capture */
+			if ms.patAt(p+1) == ')' { /* position capture? */
+				s = ms.startCapture(s, p+2, CAP_POSITION)
 			} else {
-				start, end = loc[0], loc[1]-1
+				s = ms.startCapture(s, p+1, CAP_UNFINISHED)
+			}
+		case ')': /* end capture */
+			s = ms.endCapture(s, p+1)
+		case '$':
+			if p+1 != len(ms.pat) { /* is the '$' the last char in pattern? */
+				s, p, cont = ms.matchDefault(s, p) /* no; go to default */
+			} else if s != len(ms.src) { /* check end of string */
+				s = -1
+			}
+		case L_ESC: /* escaped sequences not in the format class[*+?-]? */
+			switch ms.patAt(p + 1) {
+			case 'b': /* balanced string? */
+				if s = ms.matchBalance(s, p+2); s != -1 {
+					p += 4
+					cont = true /* return match(ms, s, p + 4); */
+				} /* else fail (s == NULL) */
+			case 'f': /* frontier? */
+				p += 2
+				if ms.patAt(p) != '[' {
+					ms.ls.Error2("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p) /* points to what is next */
+				var previous byte
+				if s > 0 {
+					previous = ms.src[s-1]
+				}
+				if !ms.matchBracketClass(previous, p, ep-1) &&
+					ms.matchBracketClass(ms.srcAt(s), p, ep-1) {
+					p = ep
+					cont = true /* return match(ms, s, ep); */
+				} else {
+					s = -1 /* match failed */
+				}
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9': /* capture results (%0-%9)? */
+				if s = ms.matchCapture(s, ms.pat[p+1]); s != -1 {
+					p += 2
+					cont = true /* return match(ms, s, p + 2) */
+				}
+			default:
+				s, p, cont = ms.matchDefault(s, p)
 			}
+		default:
+			s, p, cont = ms.matchDefault(s, p)
+		}
+		if !cont {
+			break
 		}
-	}
-	if start >= 0 {
-		start += len(s) - len(tail) + 1
-		end += len(s) - len(tail) + 1
 	}
 
-	return
+	ms.matchdepth++
+	return s
 }
 
-func match(s, pattern string, init int) []int {
-	tail := s
-	if init > 1 {
-		tail = s[init-1:]
+// 匹配单个字符类及其后面的重复符号('*', '+', '-', '?')
+// 返回值cont为true时需要继续从(s, p)开始匹配
+func (ms *matchState) matchDefault(s, p int) (_s, _p int, cont bool) {
+	ep := ms.classEnd(p) /* points to optional suffix */
+	/* does not match at least once? */
+	if !ms.singleMatch(s, p, ep) {
+		switch ms.patAt(ep) {
+		case '*', '?', '-': /* accept empty? */
+			return s, ep + 1, true
+		default: /* '+' or no suffix */
+			return -1, p, false /* fail */
+		}
 	}
+	/* matched once */
+	switch ms.patAt(ep) { /* handle optional suffix */
+	case '?': /* optional */
+		if res := ms.doMatch(s+1, ep+1); res != -1 {
+			return res, p, false
+		}
+		return s, ep + 1, true /* else return match(ms, s, ep + 1); */
+	case '+': /* 1 or more repetitions */
+		return ms.maxExpand(s+1, p, ep), p, false
+	case '*': /* 0 or more repetitions */
+		return ms.maxExpand(s, p, ep), p, false
+	case '-': /* 0 or more repetitions (minimum) */
+		return ms.minExpand(s, p, ep), p, false
+	default: /* no suffix */
+		return s + 1, ep, true /* return match(ms, s + 1, ep); */
+	}
+}
 
-	re, err := _compile(pattern)
-	if err != "" {
-		panic(err) // todo
+// s为-1时表示没有整个匹配可用
+// lua-5.3.4/src/lstrlib.c#push_onecapture()
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i == 0 { /* ms->level == 0, too */
+			ms.ls.PushString(ms.src[s:e]) /* add whole match */
+		} else {
+			ms.ls.Error2("invalid capture index %%%d", i+1)
+		}
 	} else {
-		found := re.FindStringSubmatchIndex(tail)
-		if len(found) > 2 {
-			return found[2:]
+		l := ms.capture[i].len
+		if l == CAP_UNFINISHED {
+			ms.ls.Error2("unfinished capture")
+		}
+		if l == CAP_POSITION {
+			ms.ls.PushInteger(int64(ms.capture[i].init + 1))
+		} else {
+			ms.ls.PushString(ms.src[ms.capture[i].init : ms.capture[i].init+l])
+		}
+	}
+}
+
+// lua-5.3.4/src/lstrlib.c#push_captures()
+func (ms *matchState) pushCaptures(s, e int) int {
+	nLevels := ms.level
+	if nLevels == 0 && s != -1 {
+		nLevels = 1
+	}
+	ms.ls.CheckStack2(nLevels, "too many captures")
+	for i := 0; i < nLevels; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return nLevels /* number of strings pushed */
+}
+
+// lua-5.3.4/src/lstrlib.c#add_s()
+func (ms *matchState) addS(b *strings.Builder, s, e int) {
+	news := ms.ls.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != L_ESC {
+			b.WriteByte(news[i])
+			continue
+		}
+		i++ /* skip ESC */
+		if i == len(news) || !(news[i] >= '0' && news[i] <= '9') {
+			if i == len(news) || news[i] != L_ESC {
+				ms.ls.Error2("invalid use of '%c' in replacement string", L_ESC)
+			}
+			b.WriteByte(news[i])
+		} else if news[i] == '0' {
+			b.WriteString(ms.src[s:e])
 		} else {
-			return found
+			ms.pushOneCapture(int(news[i]-'1'), s, e)
+			b.WriteString(ms.ls.ToString2(-1)) /* if number, convert it to string */
+			ms.ls.Pop(2)                       /* remove original value and its string */
 		}
 	}
 }
 
-// todo
-func gsub(s, pattern, repl string, n int) (string, int) {
-	re, err := _compile(pattern)
-	if err != "" {
-		panic(err) // todo
+// lua-5.3.4/src/lstrlib.c#add_value()
+func (ms *matchState) addValue(b *strings.Builder, s, e int, tr api.LuaType) {
+	ls := ms.ls
+	switch tr {
+	case api.LUA_TFUNCTION: /* call the function */
+		ls.PushValue(3)
+		n := ms.pushCaptures(s, e)
+		ls.Call(n, 1)
+	case api.LUA_TTABLE: /* index the table */
+		ms.pushOneCapture(0, s, e)
+		ls.GetTable(3)
+	default: /* LUA_TNUMBER or LUA_TSTRING */
+		ms.addS(b, s, e)
+		return
+	}
+	if !ls.ToBoolean(-1) { /* nil or false? */
+		b.WriteString(ms.src[s:e]) /* keep original text */
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
 	} else {
-		indexes := re.FindAllStringIndex(s, n)
-		if indexes == nil {
-			return s, 0
+		b.WriteString(ls.ToString(-1)) /* add result to accumulator */
+	}
+	ls.Pop(1)
+}
+
+// lua-5.3.4/src/lstrlib.c#nospecials()
+func noSpecials(pattern string) bool {
+	return !strings.ContainsAny(pattern, SPECIALS)
+}
+
+/* PACK/UNPACK */
+// lua-5.3.4/src/lstrlib.c
+
+const (
+	MAXINTSIZE = 16   /* maximum size for the binary representation of an integer */
+	NB         = 8    /* number of bits in a character */
+	MC         = 0xFF /* mask for one character */
+	SZINT      = 8    /* size of a lua_Integer */
+	MAXALIGN   = 8    /* maximum alignment, 和C实现中的offsetof(struct cD, u)一致 */
+	MAXSIZE    = math.MaxInt64
+
+	LUAL_PACKPADBYTE = 0x00 /* value used for padding */
+)
+
+/* options for pack/unpack */
+const (
+	kInt       = iota /* signed integers */
+	kUint             /* unsigned integers */
+	kFloat            /* floating-point numbers */
+	kChar             /* fixed-length strings */
+	kString           /* strings with prefixed length */
+	kZstr             /* zero-terminated strings */
+	kPadding          /* padding */
+	kPaddAlign        /* padding for alignment */
+	kNop              /* no-op (configuration or spaces) */
+)
+
+var nativeIsLittle = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 1
+}()
+
+/* information to pack/unpack stuff */
+type packHeader struct {
+	ls       api.LuaState
+	fmt      string // 尚未解析的格式字符串
+	isLittle bool
+	maxAlign int
+}
+
+// lua-5.3.4/src/lstrlib.c#initheader()
+func newPackHeader(ls api.LuaState, fmt string) *packHeader {
+	return &packHeader{ls: ls, fmt: fmt, isLittle: nativeIsLittle, maxAlign: 1}
+}
+
+/* read an integer numeral from string 'fmt' or return 'df' if there is no numeral */
+// lua-5.3.4/src/lstrlib.c#getnum()
+func (h *packHeader) getNum(df int) int {
+	if h.fmt == "" || !isDigit(h.fmt[0]) { /* no number? */
+		return df /* return default value */
+	}
+	a := 0
+	for h.fmt != "" && isDigit(h.fmt[0]) && a <= (math.MaxInt32-9)/10 {
+		a = a*10 + int(h.fmt[0]-'0')
+		h.fmt = h.fmt[1:]
+	}
+	return a
+}
+
+/* read an integer numeral and raises an error if it is larger than the maximum size for integers */
+// lua-5.3.4/src/lstrlib.c#getnumlimit()
+func (h *packHeader) getNumLimit(df int) int {
+	sz := h.getNum(df)
+	if sz > MAXINTSIZE || sz <= 0 {
+		h.ls.Error2("integral size (%d) out of limits [1,%d]", sz, MAXINTSIZE)
+	}
+	return sz
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+/* read and classify next option. 'size' is filled with option's size */
+// lua-5.3.4/src/lstrlib.c#getoption()
+func (h *packHeader) getOption() (opt, size int) {
+	c := h.fmt[0]
+	h.fmt = h.fmt[1:]
+	switch c {
+	case 'b':
+		return kInt, 1
+	case 'B':
+		return kUint, 1
+	case 'h':
+		return kInt, 2
+	case 'H':
+		return kUint, 2
+	case 'l', 'j':
+		return kInt, 8
+	case 'L', 'J', 'T':
+		return kUint, 8
+	case 'f':
+		return kFloat, 4
+	case 'd', 'n':
+		return kFloat, 8
+	case 'i':
+		return kInt, h.getNumLimit(4)
+	case 'I':
+		return kUint, h.getNumLimit(4)
+	case 's':
+		return kString, h.getNumLimit(8)
+	case 'c':
+		size = h.getNum(-1)
+		if size == -1 {
+			h.ls.Error2("missing size for format option 'c'")
+		}
+		return kChar, size
+	case 'z':
+		return kZstr, 0
+	case 'x':
+		return kPadding, 1
+	case 'X':
+		return kPaddAlign, 0
+	case ' ':
+	case '<':
+		h.isLittle = true
+	case '>':
+		h.isLittle = false
+	case '=':
+		h.isLittle = nativeIsLittle
+	case '!':
+		h.maxAlign = h.getNumLimit(MAXALIGN)
+	default:
+		h.ls.Error2("invalid format option '%c'", c)
+	}
+	return kNop, 0
+}
+
+/*
+** Read, classify, and fill other details about the next option.
+** 'size' is filled with option's size.
+** 'ntoalign' is filled with how many padding bytes are needed to align
+** the option.
+ */
+// lua-5.3.4/src/lstrlib.c#getdetails()
+func (h *packHeader) getDetails(totalSize int) (opt, size, nToAlign int) {
+	opt, size = h.getOption()
+	align := size          /* usually, alignment follows size */
+	if opt == kPaddAlign { /* 'X' gets alignment from following option */
+		if h.fmt == "" {
+			h.ls.ArgError(1, "invalid next option for option 'X'")
+		} else {
+			var nextOpt int
+			if nextOpt, align = h.getOption(); nextOpt == kChar || align == 0 {
+				h.ls.ArgError(1, "invalid next option for option 'X'")
+			}
+		}
+	}
+	if align <= 1 || opt == kChar { /* need no alignment? */
+		return opt, size, 0
+	}
+	if align > h.maxAlign { /* enforce maximum alignment */
+		align = h.maxAlign
+	}
+	if align&(align-1) != 0 { /* is 'align' not a power of 2? */
+		h.ls.ArgError(1, "format asks for alignment not power of 2")
+	}
+	nToAlign = (align - totalSize&(align-1)) & (align - 1)
+	return
+}
+
+/*
+** Pack integer 'n' with 'size' bytes and 'islittle' endianness.
+** The final 'if' handles the case when 'size' is larger than
+** the size of a Lua integer, correcting the extra sign-extension
+** bytes if necessary (by default they would be zeros).
+ */
+// lua-5.3.4/src/lstrlib.c#packint()
+func packInt(b *strings.Builder, n uint64, isLittle bool, size int, neg bool) {
+	buff := make([]byte, size)
+	for i := 0; i < size; i++ {
+		c := byte(n & MC)
+		if i >= SZINT { // 超出lua_Integer的部分
+			c = 0
+			if neg { /* negative number need sign extension? */
+				c = MC
+			}
+		}
+		if isLittle {
+			buff[i] = c
+		} else {
+			buff[size-1-i] = c
 		}
+		n >>= NB
+	}
+	b.Write(buff)
+}
 
-		nMatches := len(indexes)
-		lastEnd := indexes[nMatches-1][1]
-		head, tail := s[:lastEnd], s[lastEnd:]
+/*
+** Unpack an integer with 'size' bytes and 'islittle' endianness.
+** If size is smaller than the size of a Lua integer and integer
+** is signed, must do sign extension (propagating the sign to the
+** higher bits); if size is larger than the size of a Lua integer,
+** it must check the unread bytes to see whether they do not cause an
+** overflow.
+ */
+// lua-5.3.4/src/lstrlib.c#unpackint()
+func unpackInt(ls api.LuaState, str string, isLittle bool, size int, isSigned bool) int64 {
+	byteAt := func(i int) byte {
+		if isLittle {
+			return str[i]
+		}
+		return str[size-1-i]
+	}
 
-		newHead := re.ReplaceAllString(head, repl)
-		return newHead + tail, nMatches
+	var res uint64
+	limit := size
+	if limit > SZINT {
+		limit = SZINT
+	}
+	for i := limit - 1; i >= 0; i-- {
+		res <<= NB
+		res |= uint64(byteAt(i))
 	}
+	if size < SZINT { /* real size smaller than lua_Integer? */
+		if isSigned { /* needs sign extension? */
+			mask := uint64(1) << (size*NB - 1)
+			res = (res ^ mask) - mask /* do sign extension */
+		}
+	} else if size > SZINT { /* must check unread bytes */
+		var mask byte
+		if isSigned && int64(res) < 0 {
+			mask = MC
+		}
+		for i := limit; i < size; i++ {
+			if byteAt(i) != mask {
+				ls.Error2("%d-byte integer does not fit into Lua Integer", size)
+			}
+		}
+	}
+	return int64(res)
 }
 
-func _compile(pattern string) (*regexp.Regexp, string) {
-	re, err := regexp.Compile(pattern)
-	if err != nil {
-		return nil, err.Error() // todo
+// lua-5.3.4/src/lstrlib.c#copywithendian()
+func packFloat(b *strings.Builder, n float64, isLittle bool, size int) {
+	var bits uint64
+	if size == 4 {
+		bits = uint64(math.Float32bits(float32(n)))
 	} else {
-		return re, ""
+		bits = math.Float64bits(n)
+	}
+	packInt(b, bits, isLittle, size, false)
+}
+
+func unpackFloat(str string, isLittle bool, size int) float64 {
+	var bits uint64
+	for i := 0; i < size; i++ {
+		c := str[i]
+		if isLittle {
+			c = str[size-1-i]
+		}
+		bits = bits<<NB | uint64(c)
+	}
+	if size == 4 {
+		return float64(math.Float32frombits(uint32(bits)))
 	}
+	return math.Float64frombits(bits)
 }
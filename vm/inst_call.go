@@ -83,11 +83,11 @@ func vararg(i Instruction, vm api.LuaVM) {
 func tailCall(i Instruction, vm api.LuaVM) {
 	a, b, _ := i.ABC()
 	a += 1
-	c := 0
 
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	_popResults(a, c, vm)
+	if !vm.TailCall(nArgs) { // Go函数的结果已经在栈顶, 交给后面的RETURN指令
+		_popResults(a, 0, vm)
+	}
 }
 
 func self(i Instruction, vm api.LuaVM) {
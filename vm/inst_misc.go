@@ -16,3 +16,9 @@ func jmp(i Instruction, vm api.LuaVM) {
 		vm.CloseUpvalues(a)
 	}
 }
+
+// lua-5.4.6/src/lvm.c#luaV_execute() OP_TBC
+func tbc(i Instruction, vm api.LuaVM) {
+	a, _, _ := i.ABC()
+	vm.ToClose(a + 1)
+}
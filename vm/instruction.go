@@ -64,6 +64,44 @@ func (ls Instruction) CMode() byte {
 	return opcodes[ls.Opcode()].argCMode
 }
 
+// 指令是否会修改寄存器A
+func (ls Instruction) TestAMode() bool {
+	return opcodes[ls.Opcode()].setAFlag == 1
+}
+
+// 协程恢复运行时完成被中断的指令, 被调用函数的结果已经在栈顶
+// lua-5.3.4/src/lvm.c#luaV_finishOp()
+func FinishOp(i Instruction, vm api.LuaVM) {
+	a, _, c := i.ABC()
+	switch i.Opcode() {
+	/* finish its execution */
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_IDIV,
+		OP_BAND, OP_BOR, OP_BXOR, OP_SHL, OP_SHR,
+		OP_MOD, OP_POW, OP_UNM, OP_BNOT, OP_LEN,
+		OP_GETTABUP, OP_GETTABLE, OP_SELF:
+		vm.Replace(a + 1)
+	case OP_LE, OP_LT, OP_EQ:
+		res := vm.ToBoolean(-1)
+		vm.Pop(1)
+		if res != (a != 0) { /* condition failed? */
+			vm.AddPC(1) /* skip jump instruction */
+		}
+		vm.Pop(2)
+	case OP_CONCAT:
+		n := vm.GetTop() - vm.RegisterCount() /* yet to concatenate */
+		vm.Concat(n)
+		vm.Replace(a + 1)
+	case OP_TFORCALL:
+		_popResults(a+4, c+1, vm)
+	case OP_CALL:
+		_popResults(a+1, c, vm)
+	case OP_TAILCALL:
+		_popResults(a+1, 0, vm)
+	case OP_SETTABUP, OP_SETTABLE:
+		/* no actions */
+	}
+}
+
 func (ls Instruction) Execute(vm api.LuaVM) {
 	action := opcodes[ls.Opcode()].action
 	if action != nil {
@@ -68,6 +68,7 @@ const (
 	OP_CLOSURE
 	OP_VARARG
 	OP_EXTRAARG
+	OP_TBC
 )
 
 type opcode struct {
@@ -129,4 +130,5 @@ var opcodes = []opcode{
 	{0, 1, OpArgU, OpArgN, IABx /* */, "CLOSURE ", closure},  // R(A) := closure(KPROTO[Bx])
 	{0, 1, OpArgU, OpArgN, IABC /* */, "VARARG  ", vararg},   // R(A), R(A+1), ..., R(A+B-2) = vararg
 	{0, 0, OpArgU, OpArgU, IAx /*  */, "EXTRAARG", nil},      // extra (larger) argument for previous opcode
+	{0, 0, OpArgN, OpArgN, IABC /* */, "TBC     ", tbc},      // mark R(A) "to be closed"
 }